@@ -3,22 +3,35 @@ module github.com/jakeslee/aliyundrive-webdav
 go 1.17
 
 require (
+	github.com/BurntSushi/toml v1.2.0
 	github.com/alexflint/go-arg v1.4.2
 	github.com/antonfisher/nested-logrus-formatter v1.3.1
 	github.com/asaskevich/EventBus v0.0.0-20200907212545-49d423059eef // indirect
 	github.com/go-resty/resty/v2 v2.6.0 // indirect
 	github.com/jakeslee/aliyundrive v1.0.2
 	github.com/jinzhu/copier v0.3.2 // indirect
+	github.com/prometheus/client_golang v1.11.0
 	github.com/sirupsen/logrus v1.8.1
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	golang.org/x/net v0.0.0-20210924151903-3ad01bbaa167
 	golang.org/x/sys v0.0.0-20210915083310-ed5796bab164 // indirect
-	golang.org/x/time v0.0.0-20210723032227-1f47c861a9ac // indirect
+	golang.org/x/time v0.0.0-20210723032227-1f47c861a9ac
+	gopkg.in/natefinch/lumberjack.v2 v2.0.0
+	gopkg.in/yaml.v2 v2.3.0
 )
 
 require (
 	github.com/alexflint/go-scalar v1.0.0 // indirect
 	github.com/allegro/bigcache/v3 v3.0.0 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.1.1 // indirect
+	github.com/golang/protobuf v1.4.3 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/prometheus/client_model v0.2.0 // indirect
+	github.com/prometheus/common v0.26.0 // indirect
+	github.com/prometheus/procfs v0.6.0 // indirect
 	github.com/robfig/cron/v3 v3.0.1 // indirect
+	google.golang.org/protobuf v1.26.0-rc.1 // indirect
 )
 
 // replace github.com/jakeslee/aliyundrive => /Users/jakes/Develop/Go/projects/aliyundrive
@@ -0,0 +1,261 @@
+// Package access 用户、限流和路径规则，配置文件修改后整体替换，不影响正在进行的请求
+package access
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	ErrForbidden = errors.New("access: forbidden")
+	ErrReadOnly  = errors.New("access: read only")
+)
+
+// User WebDAV 用户
+type User struct {
+	Name         string     `yaml:"name"`
+	Password     string     `yaml:"password"`
+	ReadOnly     bool       `yaml:"read_only"`     // 禁止全部写操作
+	EventsScopes []string   `yaml:"events_scopes"` // 在 /events 只能收到这些路径下的事件
+	RateLimit    *RateLimit `yaml:"rate_limit"`    // 为空时使用全局限流
+}
+
+// RateLimit 每个用户（未认证时按客户端地址）的请求速率限制
+type RateLimit struct {
+	Requests float64 `yaml:"requests"` // 每秒请求数，0 为不限制
+	Burst    int     `yaml:"burst"`    // 允许的突发请求数，默认等于 Requests
+}
+
+// Rule 路径规则，按最长前缀匹配
+type Rule struct {
+	Path     string   `yaml:"path"`
+	Users    []string `yaml:"users"`     // 只允许这些用户访问，为空时不限制
+	ReadOnly bool     `yaml:"read_only"` // 禁止写操作
+}
+
+// Options 创建 Policy 的配置
+type Options struct {
+	Users     []User
+	RateLimit RateLimit
+	Rules     []Rule
+
+	// EventsScopes 命令行参数设置的用户事件范围，和 User.EventsScopes 合并
+	EventsScopes map[string][]string
+}
+
+// Policy 一份不可变的访问策略，限流器按用户保存状态
+type Policy struct {
+	users  map[string]*User
+	rules  []*Rule
+	limit  RateLimit
+	scopes map[string][]string
+
+	limiters *Limiters
+}
+
+// NewPolicy 校验配置并创建策略
+func NewPolicy(options *Options) (*Policy, error) {
+	p := &Policy{
+		users:    make(map[string]*User),
+		limit:    options.RateLimit,
+		scopes:   make(map[string][]string),
+		limiters: NewLimiters(),
+	}
+
+	if err := validateLimit(&p.limit); err != nil {
+		return nil, fmt.Errorf("rate_limit: %s", err)
+	}
+
+	for i := range options.Users {
+		user := options.Users[i]
+
+		if user.Name == "" {
+			return nil, fmt.Errorf("users[%d]: name is required", i)
+		}
+		if _, ok := p.users[user.Name]; ok {
+			return nil, fmt.Errorf("users[%d]: duplicate user %s", i, user.Name)
+		}
+		for j, scope := range user.EventsScopes {
+			if !strings.HasPrefix(scope, "/") {
+				return nil, fmt.Errorf("users[%d].events_scopes[%d]: path %q must start with /", i, j, scope)
+			}
+		}
+		if user.RateLimit != nil {
+			limit := *user.RateLimit
+			if err := validateLimit(&limit); err != nil {
+				return nil, fmt.Errorf("users[%d].rate_limit: %s", i, err)
+			}
+			user.RateLimit = &limit
+		}
+
+		p.users[user.Name] = &user
+		p.scopes[user.Name] = append(p.scopes[user.Name], user.EventsScopes...)
+	}
+
+	for name, scopes := range options.EventsScopes {
+		p.scopes[name] = append(p.scopes[name], scopes...)
+	}
+
+	for i := range options.Rules {
+		rule := options.Rules[i]
+
+		if !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("rules[%d]: path %q must start with /", i, rule.Path)
+		}
+		rule.Path = "/" + strings.Trim(rule.Path, "/")
+
+		for _, name := range rule.Users {
+			if _, ok := p.users[name]; !ok {
+				return nil, fmt.Errorf("rules[%d]: unknown user %s", i, name)
+			}
+		}
+
+		p.rules = append(p.rules, &rule)
+	}
+
+	sort.SliceStable(p.rules, func(i, j int) bool {
+		return len(p.rules[i].Path) > len(p.rules[j].Path)
+	})
+
+	return p, nil
+}
+
+func validateLimit(limit *RateLimit) error {
+	if limit.Requests < 0 {
+		return errors.New("requests must not be negative")
+	}
+	if limit.Burst < 0 {
+		return errors.New("burst must not be negative")
+	}
+	if limit.Burst == 0 && limit.Requests > 0 {
+		limit.Burst = int(limit.Requests)
+		if limit.Burst < 1 {
+			limit.Burst = 1
+		}
+	}
+
+	return nil
+}
+
+// Authenticate 校验用户名和密码
+func (p *Policy) Authenticate(name, password string) bool {
+	user, ok := p.users[name]
+	if !ok {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(password), []byte(user.Password)) == 1
+}
+
+// Authorize 检查用户能否读取或修改路径
+func (p *Policy) Authorize(name string, write bool, path string) error {
+	if write {
+		if user, ok := p.users[name]; ok && user.ReadOnly {
+			return ErrReadOnly
+		}
+	}
+
+	rule := p.match(path)
+	if rule == nil {
+		return nil
+	}
+
+	if len(rule.Users) > 0 && !contains(rule.Users, name) {
+		return ErrForbidden
+	}
+	if write && rule.ReadOnly {
+		return ErrReadOnly
+	}
+
+	return nil
+}
+
+// AuthorizeTree 检查 path 以及 path 下的全部规则，用于删除、移动目录等会修改子路径的请求
+func (p *Policy) AuthorizeTree(name string, write bool, path string) error {
+	if err := p.Authorize(name, write, path); err != nil {
+		return err
+	}
+
+	for _, rule := range p.rules {
+		if path == "/" || strings.HasPrefix(rule.Path, path+"/") {
+			if err := p.Authorize(name, write, rule.Path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *Policy) match(path string) *Rule {
+	for _, rule := range p.rules {
+		if rule.Path == "/" || path == rule.Path || strings.HasPrefix(path, rule.Path+"/") {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// Allow 检查 key 对应的用户或客户端地址是否超过请求速率
+func (p *Policy) Allow(name, key string) bool {
+	limit := p.limit
+	if user, ok := p.users[name]; ok && user.RateLimit != nil {
+		limit = *user.RateLimit
+	}
+
+	return p.limiters.Allow(key, limit)
+}
+
+// EventsScopes 返回用户在 /events 可见的路径，未限制时返回 false
+func (p *Policy) EventsScopes(name string) ([]string, bool) {
+	scopes := p.scopes[name]
+	if len(scopes) == 0 {
+		return nil, false
+	}
+
+	return scopes, true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Store 保存当前生效的策略，重新加载时原子替换
+type Store struct {
+	v atomic.Value
+}
+
+func NewStore(p *Policy) *Store {
+	s := &Store{}
+	s.v.Store(p)
+
+	return s
+}
+
+func (s *Store) Load() *Policy {
+	return s.v.Load().(*Policy)
+}
+
+func (s *Store) Store(p *Policy) {
+	s.v.Store(p)
+}
+
+// Authorize 使用当前策略检查权限，实现 webdav.Authorizer
+func (s *Store) Authorize(name string, write bool, path string) error {
+	return s.Load().Authorize(name, write, path)
+}
+
+func (s *Store) AuthorizeTree(name string, write bool, path string) error {
+	return s.Load().AuthorizeTree(name, write, path)
+}
@@ -0,0 +1,155 @@
+package access
+
+import (
+	"testing"
+)
+
+func newTestPolicy(t *testing.T) *Policy {
+	p, err := NewPolicy(&Options{
+		Users: []User{
+			{Name: "alice", Password: "a"},
+			{Name: "bob", Password: "b"},
+			{Name: "reader", Password: "r", ReadOnly: true},
+		},
+		Rules: []Rule{
+			{Path: "/secret/", Users: []string{"alice"}},
+			{Path: "/public/archive", ReadOnly: true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestAuthenticate(t *testing.T) {
+	p := newTestPolicy(t)
+
+	if !p.Authenticate("alice", "a") {
+		t.Error("valid password rejected")
+	}
+	if p.Authenticate("alice", "b") || p.Authenticate("nobody", "") {
+		t.Error("invalid password accepted")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	p := newTestPolicy(t)
+
+	tests := []struct {
+		user  string
+		write bool
+		path  string
+		err   error
+	}{
+		{"alice", false, "/secret", nil},
+		{"alice", true, "/secret/a.txt", nil},
+		{"bob", false, "/secret", ErrForbidden},
+		{"bob", false, "/secret/dir/a.txt", ErrForbidden},
+		{"bob", false, "/secretary", nil},
+		{"bob", false, "/public/archive/a.txt", nil},
+		{"bob", true, "/public/archive/a.txt", ErrReadOnly},
+		{"bob", true, "/public/a.txt", nil},
+		{"reader", false, "/public/a.txt", nil},
+		{"reader", true, "/public/a.txt", ErrReadOnly},
+	}
+
+	for _, tt := range tests {
+		if err := p.Authorize(tt.user, tt.write, tt.path); err != tt.err {
+			t.Errorf("Authorize(%s, %v, %s) = %v, expected %v", tt.user, tt.write, tt.path, err, tt.err)
+		}
+	}
+}
+
+func TestAuthorizeTree(t *testing.T) {
+	p := newTestPolicy(t)
+
+	tests := []struct {
+		user  string
+		write bool
+		path  string
+		err   error
+	}{
+		// 删除、移动上级目录会影响规则保护的子路径，先检查较长的规则
+		{"bob", true, "/", ErrReadOnly},
+		{"bob", false, "/", ErrForbidden},
+		{"bob", true, "/public", ErrReadOnly},
+		{"bob", false, "/public", nil},
+		{"bob", true, "/other", nil},
+		{"alice", true, "/", ErrReadOnly},
+		{"alice", false, "/", nil},
+	}
+
+	for _, tt := range tests {
+		if err := p.AuthorizeTree(tt.user, tt.write, tt.path); err != tt.err {
+			t.Errorf("AuthorizeTree(%s, %v, %s) = %v, expected %v", tt.user, tt.write, tt.path, err, tt.err)
+		}
+	}
+}
+
+func TestNewPolicyValidation(t *testing.T) {
+	tests := []*Options{
+		{Users: []User{{Name: ""}}},
+		{Users: []User{{Name: "a"}, {Name: "a"}}},
+		{Users: []User{{Name: "a", EventsScopes: []string{"dir"}}}},
+		{Users: []User{{Name: "a", RateLimit: &RateLimit{Requests: -1}}}},
+		{RateLimit: RateLimit{Burst: -1}},
+		{Rules: []Rule{{Path: "dir"}}},
+		{Rules: []Rule{{Path: "/dir", Users: []string{"nobody"}}}},
+	}
+
+	for i, options := range tests {
+		if _, err := NewPolicy(options); err == nil {
+			t.Errorf("options %d accepted", i)
+		}
+	}
+}
+
+func TestAllow(t *testing.T) {
+	p, err := NewPolicy(&Options{
+		Users:     []User{{Name: "fast", RateLimit: &RateLimit{Requests: 100}}},
+		RateLimit: RateLimit{Requests: 1, Burst: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if !p.Allow("", "10.0.0.1") {
+			t.Fatalf("request %d rejected", i)
+		}
+	}
+	if p.Allow("", "10.0.0.1") {
+		t.Fatal("request over burst allowed")
+	}
+
+	// 每个 key 单独限流
+	if !p.Allow("", "10.0.0.2") {
+		t.Fatal("other client rejected")
+	}
+
+	// 用户自己的限流覆盖全局限流
+	for i := 0; i < 10; i++ {
+		if !p.Allow("fast", "fast") {
+			t.Fatalf("user request %d rejected", i)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	s := NewStore(newTestPolicy(t))
+	if err := s.Authorize("bob", false, "/secret"); err != ErrForbidden {
+		t.Fatalf("authorize: %v", err)
+	}
+
+	p, err := NewPolicy(&Options{Users: []User{{Name: "bob"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Store(p)
+
+	if err := s.AuthorizeTree("bob", true, "/"); err != nil {
+		t.Fatalf("authorize after reload: %v", err)
+	}
+}
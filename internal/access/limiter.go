@@ -0,0 +1,67 @@
+package access
+
+import (
+	"golang.org/x/time/rate"
+	"sync"
+	"time"
+)
+
+// limiterIdle 超过这段时间没有请求，并且令牌已经恢复满的限流器会被清理，之后重新创建和继续使用没有区别
+const limiterIdle = 10 * time.Minute
+
+// Limiters 按 key 保存限流器，定期清理空闲的限流器，避免按客户端地址限流时无限增长
+type Limiters struct {
+	mu    sync.Mutex
+	items map[string]*limiterEntry
+	swept time.Time
+}
+
+type limiterEntry struct {
+	limiter *rate.Limiter
+	last    time.Time
+	idle    time.Duration
+}
+
+func NewLimiters() *Limiters {
+	return &Limiters{items: make(map[string]*limiterEntry), swept: time.Now()}
+}
+
+// Allow 检查 key 是否超过 limit，limit.Requests 为 0 时不限制
+func (l *Limiters) Allow(key string, limit RateLimit) bool {
+	if limit.Requests == 0 {
+		return true
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) >= limiterIdle {
+		l.sweep(now)
+	}
+
+	e, ok := l.items[key]
+	if !ok {
+		// 令牌桶从空到满需要的时间，清理前必须等待令牌恢复满
+		idle := time.Duration(float64(limit.Burst) / limit.Requests * float64(time.Second))
+		if idle < limiterIdle {
+			idle = limiterIdle
+		}
+
+		e = &limiterEntry{limiter: rate.NewLimiter(rate.Limit(limit.Requests), limit.Burst), idle: idle}
+		l.items[key] = e
+	}
+	e.last = now
+
+	return e.limiter.AllowN(now, 1)
+}
+
+func (l *Limiters) sweep(now time.Time) {
+	for key, e := range l.items {
+		if now.Sub(e.last) >= e.idle {
+			delete(l.items, key)
+		}
+	}
+	l.swept = now
+}
@@ -0,0 +1,50 @@
+package access
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimitersUnlimited(t *testing.T) {
+	l := NewLimiters()
+
+	for i := 0; i < 100; i++ {
+		if !l.Allow("key", RateLimit{}) {
+			t.Fatal("unlimited request rejected")
+		}
+	}
+	if len(l.items) != 0 {
+		t.Fatalf("%d limiters created without limit", len(l.items))
+	}
+}
+
+func TestLimitersSweep(t *testing.T) {
+	l := NewLimiters()
+	limit := RateLimit{Requests: 1, Burst: 1}
+
+	l.Allow("idle", limit)
+	l.Allow("active", limit)
+
+	now := time.Now()
+	l.items["idle"].last = now.Add(-limiterIdle)
+
+	// 按小时恢复的限流器在令牌恢复满之前不能清理，否则重新创建后可以立即通过
+	slow := RateLimit{Requests: 1.0 / 3600, Burst: 1}
+	l.Allow("slow", slow)
+	l.items["slow"].last = now.Add(-limiterIdle)
+
+	l.sweep(now)
+
+	if _, ok := l.items["idle"]; ok {
+		t.Error("idle limiter not removed")
+	}
+	if _, ok := l.items["active"]; !ok {
+		t.Error("active limiter removed")
+	}
+	if _, ok := l.items["slow"]; !ok {
+		t.Error("limiter removed before refilled")
+	}
+	if l.Allow("slow", slow) {
+		t.Error("slow limiter reset by sweep")
+	}
+}
@@ -0,0 +1,169 @@
+// Package admin 管理端口，提供健康检查、监控指标和 pprof，和 WebDAV 使用不同的监听地址
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// readyTimeout 就绪检查的超时时间
+const readyTimeout = 10 * time.Second
+
+// Options 管理端口配置，Username 为空时不认证，并且不提供 pprof 和会修改状态的接口
+type Options struct {
+	Addr     string
+	Username string
+	Password string
+	Pprof    bool
+
+	// Ready 就绪检查，返回错误或超时时 /readyz 返回 503
+	Ready func() error
+}
+
+// Server 管理端口的处理器，/healthz 和 /readyz 不需要认证，便于探针访问
+type Server struct {
+	mux     *http.ServeMux
+	options *Options
+}
+
+func New(options *Options) *Server {
+	s := &Server{
+		mux:     http.NewServeMux(),
+		options: options,
+	}
+
+	s.mux.HandleFunc("/healthz", s.handleHealthz)
+	s.mux.HandleFunc("/readyz", s.handleReadyz)
+
+	if options.Pprof && options.Username == "" {
+		logrus.Warnf("admin pprof is disabled, admin username is not configured")
+	} else if options.Pprof {
+		s.mux.HandleFunc("/debug/pprof/", pprof.Index)
+		s.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		s.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		s.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		s.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	return s
+}
+
+// Handle 注册需要认证的只读管理接口
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// HandleProtected 注册会修改状态的管理接口，没有配置帐号时不注册并返回 false
+// 请求的 Host 必须是 IP 地址、localhost 或监听地址，Origin 必须和 Host 一致，防止跨站请求和 DNS 重绑定
+func (s *Server) HandleProtected(pattern string, handler http.Handler) bool {
+	if s.options.Username == "" {
+		logrus.Warnf("admin %s is disabled, admin username is not configured", pattern)
+		return false
+	}
+
+	s.mux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowedHost(r.Host) {
+			http.Error(w, "admin: host not allowed", http.StatusForbidden)
+			return
+		}
+
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || !strings.EqualFold(u.Host, r.Host) {
+				http.Error(w, "admin: cross-origin request", http.StatusForbidden)
+				return
+			}
+		}
+
+		handler.ServeHTTP(w, r)
+	}))
+
+	return true
+}
+
+func (s *Server) allowedHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+
+	if strings.EqualFold(host, "localhost") || net.ParseIP(host) != nil {
+		return true
+	}
+
+	h, _, err := net.SplitHostPort(s.options.Addr)
+	return err == nil && h != "" && strings.EqualFold(h, host)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/healthz" && r.URL.Path != "/readyz" && !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Admin"`)
+		http.Error(w, "admin: need authorized!", http.StatusUnauthorized)
+		return
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.options.Username == "" {
+		return true
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	userOk := subtle.ConstantTimeCompare([]byte(username), []byte(s.options.Username)) == 1
+	passOk := subtle.ConstantTimeCompare([]byte(password), []byte(s.options.Password)) == 1
+
+	if !userOk || !passOk {
+		logrus.Warnf("admin authentication error, un: %s, ip: %s", username, r.RemoteAddr)
+		return false
+	}
+
+	return true
+}
+
+// handleHealthz 进程存活即返回 200
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// handleReadyz 检查凭证和存储后端是否可用
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if s.options.Ready != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+
+		// 网盘接口不支持取消，超时后不再等待结果
+		done := make(chan error, 1)
+		go func() {
+			done <- s.options.Ready()
+		}()
+
+		var err error
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		if err != nil {
+			logrus.Warnf("readiness check error %s", err)
+			http.Error(w, "not ready: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,94 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	notReady := errors.New("credential expired")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	tests := []struct {
+		name     string
+		options  *Options
+		path     string
+		auth     bool // 使用正确的帐号
+		host     string
+		origin   string
+		status   int
+		contains string
+	}{
+		// /healthz 和 /readyz 不需要认证
+		{name: "healthz", options: &Options{Username: "admin"}, path: "/healthz", status: http.StatusOK, contains: "ok"},
+		{name: "ready", options: &Options{Username: "admin", Ready: func() error { return nil }}, path: "/readyz", status: http.StatusOK},
+		{name: "not ready", options: &Options{Ready: func() error { return notReady }}, path: "/readyz", status: http.StatusServiceUnavailable, contains: "credential expired"},
+		{name: "metrics without auth", options: &Options{Username: "admin", Password: "secret"}, path: "/metrics", status: http.StatusUnauthorized},
+		{name: "metrics", options: &Options{Username: "admin", Password: "secret"}, path: "/metrics", auth: true, status: http.StatusOK},
+		{name: "metrics without username", options: &Options{}, path: "/metrics", status: http.StatusOK},
+		// 没有配置帐号时不提供 pprof
+		{name: "pprof", options: &Options{Username: "admin", Password: "secret", Pprof: true}, path: "/debug/pprof/", auth: true, status: http.StatusOK},
+		{name: "pprof without username", options: &Options{Pprof: true}, path: "/debug/pprof/", status: http.StatusNotFound},
+		{name: "pprof disabled", options: &Options{Username: "admin", Password: "secret"}, path: "/debug/pprof/", auth: true, status: http.StatusNotFound},
+		{name: "protected", options: &Options{Username: "admin", Password: "secret"}, path: "/protected", auth: true, status: http.StatusOK},
+		{name: "protected without username", options: &Options{}, path: "/protected", status: http.StatusNotFound},
+		{name: "protected localhost", options: &Options{Username: "admin", Password: "secret"}, path: "/protected", auth: true, host: "localhost:18081", status: http.StatusOK},
+		{name: "protected listen host", options: &Options{Addr: "admin.lan:18081", Username: "admin", Password: "secret"}, path: "/protected", auth: true, host: "admin.lan:18081", status: http.StatusOK},
+		{name: "protected rebinding", options: &Options{Addr: "127.0.0.1:18081", Username: "admin", Password: "secret"}, path: "/protected", auth: true, host: "evil.com", status: http.StatusForbidden},
+		{name: "protected same origin", options: &Options{Username: "admin", Password: "secret"}, path: "/protected", auth: true, origin: "http://127.0.0.1:18081", status: http.StatusOK},
+		{name: "protected cross origin", options: &Options{Username: "admin", Password: "secret"}, path: "/protected", auth: true, origin: "http://evil.com", status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		s := New(tt.options)
+		s.Handle("/metrics", ok)
+		s.HandleProtected("/protected", ok)
+
+		r := httptest.NewRequest("GET", tt.path, nil)
+		r.Host = "127.0.0.1:18081"
+		if tt.host != "" {
+			r.Host = tt.host
+		}
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if tt.auth {
+			r.SetBasicAuth("admin", "secret")
+		}
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.contains) {
+			t.Errorf("%s: status %d, expected %d, %s", tt.name, w.Code, tt.status, w.Body.String())
+		}
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	s := New(&Options{Username: "admin", Password: "secret"})
+
+	for _, tt := range []struct {
+		username, password string
+		ok                 bool
+	}{
+		{"admin", "secret", true},
+		{"admin", "wrong", false},
+		{"other", "secret", false},
+		{"", "", false},
+	} {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		if tt.username != "" {
+			r.SetBasicAuth(tt.username, tt.password)
+		}
+
+		if got := s.authorized(r); got != tt.ok {
+			t.Errorf("authorized(%q, %q) = %v", tt.username, tt.password, got)
+		}
+	}
+}
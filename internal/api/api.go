@@ -0,0 +1,53 @@
+// Package api 补充 aliyundrive 未提供的阿里云盘接口
+package api
+
+import (
+	"github.com/jakeslee/aliyundrive"
+	"github.com/jakeslee/aliyundrive/http"
+	"github.com/jakeslee/aliyundrive/models"
+	"reflect"
+	"sync"
+)
+
+var (
+	client = http.NewClient()
+
+	// tokens Token 创建的 Credential 副本对应的 Token
+	tokens sync.Map
+)
+
+// refresh 刷新失效的 AccessToken stale，返回刷新后使用的 Credential
+// Token 创建的副本通过 Token 在锁内刷新，其他 Credential 直接调用 drive.RefreshToken
+func refresh(drive *aliyundrive.AliyunDrive, credential *aliyundrive.Credential, stale string) (*aliyundrive.Credential, error) {
+	if t, ok := tokens.Load(credential); ok {
+		return t.(*Token).refreshStale(stale)
+	}
+
+	_, err := drive.RefreshToken(credential)
+	return credential, err
+}
+
+// Send 使用 Credential 发送请求，AccessToken 失效时刷新后重试
+func Send(drive *aliyundrive.AliyunDrive, credential *aliyundrive.Credential, request http.Request, response http.Response) error {
+	token := credential.AccessToken
+	models.WithToken(request, token)
+
+	err := client.Send(request, response)
+
+	if e, ok := err.(*http.AliyunDriveError); ok && e.Code == models.CodeAccessTokenInvalid {
+		credential, err := refresh(drive, credential, token)
+		if err != nil {
+			return err
+		}
+
+		// 没有返回的字段会保留第一次响应的错误码，重试前清空
+		v := reflect.ValueOf(response).Elem()
+		v.Set(reflect.Zero(v.Type()))
+
+		models.WithToken(request, credential.AccessToken)
+
+		return client.Send(request, response)
+	}
+
+	return err
+}
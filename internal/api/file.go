@@ -0,0 +1,39 @@
+package api
+
+import (
+	"github.com/jakeslee/aliyundrive"
+	"github.com/jakeslee/aliyundrive/models"
+)
+
+// Search 使用阿里云盘的查询语句搜索文件，例如 name match "foo" and size > 1024
+func Search(drive *aliyundrive.AliyunDrive, credential *aliyundrive.Credential, query, marker string, limit int) (*models.SearchResponse, error) {
+	request := models.NewSearchRequest()
+
+	request.DriveId = credential.DefaultDriveId
+	request.Query = query
+	request.Marker = marker
+
+	if limit > 0 {
+		request.Limit = limit
+	}
+
+	var resp models.SearchResponse
+
+	err := Send(drive, credential, request, &resp)
+
+	return &resp, err
+}
+
+// GetPath 获取文件所在的路径，返回从文件到根目录的各级目录
+func GetPath(drive *aliyundrive.AliyunDrive, credential *aliyundrive.Credential, fileId string) (*models.GetPathResponse, error) {
+	request := models.NewGetPathRequest()
+
+	request.DriveId = credential.DefaultDriveId
+	request.FileId = fileId
+
+	var resp models.GetPathResponse
+
+	err := Send(drive, credential, request, &resp)
+
+	return &resp, err
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"github.com/jakeslee/aliyundrive"
+	"github.com/jakeslee/aliyundrive/http"
+	"github.com/jakeslee/aliyundrive/models"
+)
+
+type RecycleBinListRequest struct {
+	http.BaseRequest
+
+	DriveId        string `json:"drive_id"`
+	Limit          int    `json:"limit"`
+	Marker         string `json:"marker,omitempty"`
+	OrderBy        string `json:"order_by"`
+	OrderDirection string `json:"order_direction"`
+}
+
+type RecycleBinListResponse struct {
+	http.BaseResponse
+
+	models.Files
+}
+
+func NewRecycleBinListRequest() *RecycleBinListRequest {
+	r := &RecycleBinListRequest{
+		Limit:          100,
+		OrderBy:        "updated_at",
+		OrderDirection: models.OrderDirectionTypeDescend,
+	}
+
+	r.Init(models.AliyunDriveEndpoint).
+		SetHttpMethod(http.Post).
+		SetUrl("/v2/recyclebin/list")
+
+	return r
+}
+
+// FileIdRequest 只需要 drive_id 和 file_id 的请求
+type FileIdRequest struct {
+	http.BaseRequest
+
+	DriveId string `json:"drive_id"`
+	FileId  string `json:"file_id"`
+}
+
+func newFileIdRequest(url, driveId, fileId string) *FileIdRequest {
+	r := &FileIdRequest{
+		DriveId: driveId,
+		FileId:  fileId,
+	}
+
+	r.Init(models.AliyunDriveEndpoint).
+		SetHttpMethod(http.Post).
+		SetUrl(url)
+
+	return r
+}
+
+// ListRecycleBin 列出回收站中的文件
+func ListRecycleBin(drive *aliyundrive.AliyunDrive, credential *aliyundrive.Credential, marker string) (*RecycleBinListResponse, error) {
+	request := NewRecycleBinListRequest()
+
+	request.DriveId = credential.DefaultDriveId
+	request.Marker = marker
+
+	var resp RecycleBinListResponse
+
+	err := Send(drive, credential, request, &resp)
+
+	return &resp, err
+}
+
+// RestoreFile 把回收站中的文件恢复到原来的位置
+func RestoreFile(drive *aliyundrive.AliyunDrive, credential *aliyundrive.Credential, fileId string) error {
+	var resp http.BaseResponse
+
+	return Send(drive, credential, newFileIdRequest("/v2/recyclebin/restore", credential.DefaultDriveId, fileId), &resp)
+}
+
+// DeleteFile 彻底删除文件，不经过回收站
+func DeleteFile(drive *aliyundrive.AliyunDrive, credential *aliyundrive.Credential, fileId string) error {
+	var resp http.BaseResponse
+
+	return Send(drive, credential, newFileIdRequest("/v2/file/delete", credential.DefaultDriveId, fileId), &resp)
+}
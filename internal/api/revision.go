@@ -0,0 +1,113 @@
+package api
+
+import (
+	"github.com/jakeslee/aliyundrive"
+	"github.com/jakeslee/aliyundrive/http"
+	"github.com/jakeslee/aliyundrive/models"
+	"time"
+)
+
+// Revision 文件的历史版本
+type Revision struct {
+	RevisionId  string    `json:"revision_id"`
+	FileId      string    `json:"file_id"`
+	Size        int64     `json:"size"`
+	ContentHash string    `json:"content_hash"`
+	IsLatest    bool      `json:"is_latest"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type RevisionListRequest struct {
+	http.BaseRequest
+
+	DriveId string `json:"drive_id"`
+	FileId  string `json:"file_id"`
+	Limit   int    `json:"limit"`
+	Marker  string `json:"marker,omitempty"`
+}
+
+type RevisionListResponse struct {
+	http.BaseResponse
+
+	Items      []*Revision `json:"items"`
+	NextMarker string      `json:"next_marker"`
+}
+
+func NewRevisionListRequest() *RevisionListRequest {
+	r := &RevisionListRequest{
+		Limit: 100,
+	}
+
+	r.Init(models.AliyunDriveEndpoint).
+		SetHttpMethod(http.Post).
+		SetUrl("/v2/file/list_revisions")
+
+	return r
+}
+
+type RevisionRequest struct {
+	http.BaseRequest
+
+	DriveId    string `json:"drive_id"`
+	FileId     string `json:"file_id"`
+	RevisionId string `json:"revision_id"`
+}
+
+func newRevisionRequest(url, driveId, fileId, revisionId string) *RevisionRequest {
+	r := &RevisionRequest{
+		DriveId:    driveId,
+		FileId:     fileId,
+		RevisionId: revisionId,
+	}
+
+	r.Init(models.AliyunDriveEndpoint).
+		SetHttpMethod(http.Post).
+		SetUrl(url)
+
+	return r
+}
+
+// ListRevisions 列出文件的全部历史版本
+func ListRevisions(drive *aliyundrive.AliyunDrive, credential *aliyundrive.Credential, fileId string) ([]*Revision, error) {
+	var revisions []*Revision
+	marker := ""
+
+	for {
+		request := NewRevisionListRequest()
+
+		request.DriveId = credential.DefaultDriveId
+		request.FileId = fileId
+		request.Marker = marker
+
+		var resp RevisionListResponse
+
+		if err := Send(drive, credential, request, &resp); err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, resp.Items...)
+
+		if resp.NextMarker == "" {
+			return revisions, nil
+		}
+
+		marker = resp.NextMarker
+	}
+}
+
+// RestoreRevision 把文件恢复为指定的历史版本
+func RestoreRevision(drive *aliyundrive.AliyunDrive, credential *aliyundrive.Credential, fileId, revisionId string) error {
+	var resp http.BaseResponse
+
+	return Send(drive, credential, newRevisionRequest("/v2/file/restore_revision", credential.DefaultDriveId, fileId, revisionId), &resp)
+}
+
+// GetRevisionDownloadURL 获取历史版本的下载地址
+func GetRevisionDownloadURL(drive *aliyundrive.AliyunDrive, credential *aliyundrive.Credential, fileId, revisionId string) (*models.DownloadURLResponse, error) {
+	var resp models.DownloadURLResponse
+
+	err := Send(drive, credential, newRevisionRequest("/v2/file/get_download_url", credential.DefaultDriveId, fileId, revisionId), &resp)
+
+	return &resp, err
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"github.com/jakeslee/aliyundrive"
+	"sync"
+	"time"
+)
+
+// tokenRefreshAhead AccessToken 过期前提前刷新的时间
+const tokenRefreshAhead = 10 * time.Minute
+
+// Token 管理 Credential 的刷新，定时刷新、更换 RefreshToken 和请求发现 AccessToken 失效时的刷新共用同一把锁
+// aliyundrive 发现 AccessToken 失效时会不加锁地自行刷新，因此调用 aliyundrive 时只传入不含 RefreshToken 的副本，
+// 由 Token 在 AccessToken 过期前主动刷新
+type Token struct {
+	drive      *aliyundrive.AliyunDrive
+	credential *aliyundrive.Credential
+
+	mu       sync.Mutex
+	current  *aliyundrive.Credential // 当前 AccessToken 的副本，还没有登录成功时为空
+	previous *aliyundrive.Credential // 上一个副本，刷新时可能还有请求在使用
+	expires  time.Time
+}
+
+func NewToken(drive *aliyundrive.AliyunDrive, credential *aliyundrive.Credential) *Token {
+	return &Token{
+		drive:      drive,
+		credential: credential,
+	}
+}
+
+// Refresh 使用 refreshToken 刷新，为空时使用当前的 RefreshToken，失败时保留原来的 Token
+func (t *Token) Refresh(refreshToken string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.refreshLocked(refreshToken)
+}
+
+func (t *Token) refreshLocked(refreshToken string) error {
+	saved := *t.credential
+	if refreshToken != "" {
+		t.credential.RefreshToken = refreshToken
+	}
+
+	resp, err := t.drive.RefreshToken(t.credential)
+	if err != nil {
+		// 网络错误时 aliyundrive 也会清空 Credential
+		*t.credential = saved
+		return err
+	}
+
+	t.expires = time.Time{}
+	if resp.ExpiresIn > 0 {
+		t.expires = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+
+	if t.previous != nil {
+		tokens.Delete(t.previous)
+	}
+	t.previous = t.current
+
+	c := t.credential
+	t.current = aliyundrive.NewCredential(&aliyundrive.Credential{
+		UserId:         c.UserId,
+		Name:           c.Name,
+		AccessToken:    c.AccessToken,
+		RootFolder:     c.RootFolder,
+		DefaultDriveId: c.DefaultDriveId,
+	})
+	tokens.Store(t.current, t)
+
+	return nil
+}
+
+// Credential 返回调用 aliyundrive 时使用的副本，AccessToken 快过期时先刷新
+// 刷新失败时在过期前仍然返回原来的副本
+func (t *Token) Credential() (*aliyundrive.Credential, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.current != nil && (t.expires.IsZero() || time.Until(t.expires) > tokenRefreshAhead) {
+		return t.current, nil
+	}
+
+	if err := t.refreshLocked(""); err != nil {
+		if t.current != nil && time.Now().Before(t.expires) {
+			return t.current, nil
+		}
+		return nil, err
+	}
+
+	return t.current, nil
+}
+
+// refreshStale 请求发现 stale 失效时刷新，已被其他请求或定时任务刷新时直接返回新的副本
+func (t *Token) refreshStale(stale string) (*aliyundrive.Credential, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.current != nil && t.current.AccessToken != stale {
+		return t.current, nil
+	}
+
+	if err := t.refreshLocked(""); err != nil {
+		return nil, err
+	}
+
+	return t.current, nil
+}
+
+// Flush 在锁内把当前的 RefreshToken 交给 save，还没有登录成功时不调用
+func (t *Token) Flush(save func(refreshToken string)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.current != nil {
+		save(t.credential.RefreshToken)
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"github.com/jakeslee/aliyundrive"
+	"github.com/jakeslee/aliyundrive/http"
+	"github.com/jakeslee/aliyundrive/models"
+)
+
+type PersonalInfoRequest struct {
+	http.BaseRequest
+}
+
+// PersonalSpaceInfo 网盘空间信息，单位字节
+type PersonalSpaceInfo struct {
+	UsedSize  int64 `json:"used_size"`
+	TotalSize int64 `json:"total_size"`
+}
+
+type PersonalInfoResponse struct {
+	http.BaseResponse
+
+	PersonalSpaceInfo PersonalSpaceInfo `json:"personal_space_info"`
+}
+
+func NewPersonalInfoRequest() *PersonalInfoRequest {
+	r := &PersonalInfoRequest{}
+
+	r.Init(models.AliyunDriveEndpoint).
+		SetHttpMethod(http.Post).
+		SetUrl("/v2/databox/get_personal_info")
+
+	return r
+}
+
+// GetPersonalInfo 获取网盘容量信息
+func GetPersonalInfo(drive *aliyundrive.AliyunDrive, credential *aliyundrive.Credential) (*PersonalInfoResponse, error) {
+	var resp PersonalInfoResponse
+
+	err := Send(drive, credential, NewPersonalInfoRequest(), &resp)
+
+	return &resp, err
+}
+
+type UserInfoRequest struct {
+	http.BaseRequest
+}
+
+// UserInfoResponse 用户信息，包含备份盘和资源库的 drive_id
+type UserInfoResponse struct {
+	http.BaseResponse
+
+	UserId          string `json:"user_id"`
+	UserName        string `json:"user_name"`
+	NickName        string `json:"nick_name"`
+	DefaultDriveId  string `json:"default_drive_id"`
+	BackupDriveId   string `json:"backup_drive_id"`
+	ResourceDriveId string `json:"resource_drive_id"`
+}
+
+func NewUserInfoRequest() *UserInfoRequest {
+	r := &UserInfoRequest{}
+
+	r.Init(models.AliyunDriveEndpoint).
+		SetHttpMethod(http.Post).
+		SetUrl("/v2/user/get")
+
+	return r
+}
+
+// GetUserInfo 获取用户信息
+func GetUserInfo(drive *aliyundrive.AliyunDrive, credential *aliyundrive.Credential) (*UserInfoResponse, error) {
+	var resp UserInfoResponse
+
+	err := Send(drive, credential, NewUserInfoRequest(), &resp)
+
+	return &resp, err
+}
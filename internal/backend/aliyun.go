@@ -0,0 +1,381 @@
+package backend
+
+import (
+	"fmt"
+	"github.com/jakeslee/aliyundrive"
+	"github.com/jakeslee/aliyundrive-webdav/internal/api"
+	"github.com/jakeslee/aliyundrive-webdav/internal/metrics"
+	driveHttp "github.com/jakeslee/aliyundrive/http"
+	"github.com/jakeslee/aliyundrive/models"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// aliyunBackend 阿里云盘后端，文件列表和文件信息由 aliyundrive 缓存
+type aliyunBackend struct {
+	driver *aliyundrive.AliyunDrive
+	token  *api.Token
+}
+
+// observe 记录接口调用，路径不存在不算错误
+func observe(operation string, start time.Time, err *error) {
+	e := *err
+	if e == ErrPartialFoundPath {
+		e = nil
+	}
+
+	metrics.ObserveBackend(operation, start, e)
+}
+
+func NewAliyun(drive *aliyundrive.AliyunDrive, token *api.Token) Backend {
+	return &aliyunBackend{
+		driver: drive,
+		token:  token,
+	}
+}
+
+// call 使用 Token 的 Credential 副本调用接口，AccessToken 失效时刷新后重试一次
+func (b *aliyunBackend) call(fn func(credential *aliyundrive.Credential) error) error {
+	credential, err := b.token.Credential()
+	if err != nil {
+		return err
+	}
+
+	if err = fn(credential); err == nil {
+		return nil
+	}
+
+	if fresh := b.renew(credential, err); fresh != nil {
+		return fn(fresh)
+	}
+
+	return err
+}
+
+// renew 副本不含 RefreshToken，aliyundrive 发现 AccessToken 失效时自行刷新会失败，返回的不是 AliyunDriveError，
+// 这时通过 api 确认 AccessToken 是否失效，失效时在 Token 的锁内刷新并返回新的副本，否则返回 nil
+func (b *aliyunBackend) renew(credential *aliyundrive.Credential, err error) *aliyundrive.Credential {
+	if _, ok := err.(*driveHttp.AliyunDriveError); ok || err == ErrPartialFoundPath {
+		return nil
+	}
+
+	_, _ = api.GetUserInfo(b.driver, credential)
+
+	fresh, err := b.token.Credential()
+	if err != nil || fresh == credential {
+		return nil
+	}
+
+	return fresh
+}
+
+func (b *aliyunBackend) ResolvePathToFileId(fullPath string) (fileId string, found string, err error) {
+	defer observe("resolve_path", time.Now(), &err)
+
+	err = b.call(func(credential *aliyundrive.Credential) error {
+		fileId, found, err = b.driver.ResolvePathToFileId(credential, fullPath)
+		return err
+	})
+
+	return fileId, found, err
+}
+
+func (b *aliyunBackend) GetFile(fileId string) (file *models.File, err error) {
+	defer observe("get_file", time.Now(), &err)
+
+	err = b.call(func(credential *aliyundrive.Credential) error {
+		resp, err := b.driver.GetFile(credential, fileId)
+		if err != nil {
+			return err
+		}
+
+		file = resp.File
+		return nil
+	})
+
+	return file, err
+}
+
+func (b *aliyunBackend) GetFolderFiles(options *aliyundrive.FolderFilesOptions) (files *models.Files, err error) {
+	defer observe("list_folder", time.Now(), &err)
+
+	err = b.call(func(credential *aliyundrive.Credential) error {
+		resp, err := b.driver.GetFolderFiles(credential, options)
+		if err != nil {
+			return err
+		}
+
+		files = &resp.Files
+		return nil
+	})
+
+	return files, err
+}
+
+func (b *aliyunBackend) GetPath(fileId string) (items []*models.File, err error) {
+	defer observe("get_path", time.Now(), &err)
+
+	err = b.call(func(credential *aliyundrive.Credential) error {
+		resp, err := api.GetPath(b.driver, credential, fileId)
+		if err != nil {
+			return err
+		}
+
+		items = resp.Items
+		return nil
+	})
+
+	return items, err
+}
+
+func (b *aliyunBackend) CreateDirectory(parentFileId, name string) (file *models.File, err error) {
+	defer observe("create_directory", time.Now(), &err)
+
+	err = b.call(func(credential *aliyundrive.Credential) error {
+		file, err = b.driver.CreateDirectory(credential, parentFileId, name)
+		return err
+	})
+
+	return file, err
+}
+
+func (b *aliyunBackend) MoveFile(fileId, toParentFileId string) (err error) {
+	defer observe("move", time.Now(), &err)
+
+	return b.call(func(credential *aliyundrive.Credential) error {
+		_, err := b.driver.MoveFile(credential, fileId, toParentFileId)
+		return err
+	})
+}
+
+func (b *aliyunBackend) RenameFile(fileId, name string) (err error) {
+	defer observe("rename", time.Now(), &err)
+
+	return b.call(func(credential *aliyundrive.Credential) error {
+		_, err := b.driver.RenameFile(credential, fileId, name)
+		return err
+	})
+}
+
+func (b *aliyunBackend) RemoveFile(fileId string) (err error) {
+	defer observe("trash", time.Now(), &err)
+
+	return b.call(func(credential *aliyundrive.Credential) error {
+		_, err := b.driver.RemoveFile(credential, fileId)
+		return err
+	})
+}
+
+func (b *aliyunBackend) UploadFile(options *aliyundrive.UploadFileOptions) (file *models.File, err error) {
+	defer observe("upload", time.Now(), &err)
+
+	return b.upload(options)
+}
+
+func (b *aliyunBackend) UploadFileRapid(options *aliyundrive.UploadFileRapidOptions) (file *models.File, rapid bool, err error) {
+	defer observe("upload_rapid", time.Now(), &err)
+
+	// 秒传从文件开头读取，可以重试
+	err = b.call(func(credential *aliyundrive.Credential) error {
+		file, rapid, err = b.driver.UploadFileRapid(credential, options)
+		return err
+	})
+
+	return file, rapid, err
+}
+
+func (b *aliyunBackend) Download(fileId string, offset int64) (body io.ReadCloser, err error) {
+	defer observe("download", time.Now(), &err)
+
+	err = b.call(func(credential *aliyundrive.Credential) error {
+		response, err := b.driver.Download(credential, fileId, fmt.Sprintf("bytes=%d-", offset))
+		if err != nil {
+			return err
+		}
+
+		body = response.Body
+		return nil
+	})
+
+	return body, err
+}
+
+func (b *aliyunBackend) EvictCacheWithPrefix(prefix string) {
+	b.driver.EvictCacheWithPrefix(prefix)
+}
+
+// Check 获取用户信息，同时检查凭证和接口是否可用
+func (b *aliyunBackend) Check() (err error) {
+	defer observe("check", time.Now(), &err)
+
+	credential, err := b.token.Credential()
+	if err != nil {
+		return err
+	}
+
+	_, err = api.GetUserInfo(b.driver, credential)
+	return err
+}
+
+func (b *aliyunBackend) Quota() (used, total int64, err error) {
+	defer observe("quota", time.Now(), &err)
+
+	credential, err := b.token.Credential()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	info, err := api.GetPersonalInfo(b.driver, credential)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return info.PersonalSpaceInfo.UsedSize, info.PersonalSpaceInfo.TotalSize, nil
+}
+
+func (b *aliyunBackend) ListRecycleBin(marker string) (files *models.Files, err error) {
+	defer observe("list_recyclebin", time.Now(), &err)
+
+	err = b.call(func(credential *aliyundrive.Credential) error {
+		resp, err := api.ListRecycleBin(b.driver, credential, marker)
+		if err != nil {
+			return err
+		}
+
+		files = &resp.Files
+		return nil
+	})
+
+	return files, err
+}
+
+func (b *aliyunBackend) RestoreFile(fileId string) (err error) {
+	defer observe("restore", time.Now(), &err)
+
+	return b.call(func(credential *aliyundrive.Credential) error {
+		return api.RestoreFile(b.driver, credential, fileId)
+	})
+}
+
+func (b *aliyunBackend) DeleteFile(fileId string) (err error) {
+	defer observe("delete", time.Now(), &err)
+
+	return b.call(func(credential *aliyundrive.Credential) error {
+		return api.DeleteFile(b.driver, credential, fileId)
+	})
+}
+
+func (b *aliyunBackend) Search(query, marker string, limit int) (files *models.Files, err error) {
+	defer observe("search", time.Now(), &err)
+
+	err = b.call(func(credential *aliyundrive.Credential) error {
+		resp, err := api.Search(b.driver, credential, query, marker, limit)
+		if err != nil {
+			return err
+		}
+
+		files = &resp.Files
+		return nil
+	})
+
+	return files, err
+}
+
+func (b *aliyunBackend) ListRevisions(fileId string) (revisions []*api.Revision, err error) {
+	defer observe("list_revisions", time.Now(), &err)
+
+	err = b.call(func(credential *aliyundrive.Credential) error {
+		revisions, err = api.ListRevisions(b.driver, credential, fileId)
+		return err
+	})
+
+	return revisions, err
+}
+
+func (b *aliyunBackend) RestoreRevision(fileId, revisionId string) (err error) {
+	defer observe("restore_revision", time.Now(), &err)
+
+	return b.call(func(credential *aliyundrive.Credential) error {
+		return api.RestoreRevision(b.driver, credential, fileId, revisionId)
+	})
+}
+
+// DownloadRevision 通过历史版本的下载地址读取，地址需要带 Referer
+func (b *aliyunBackend) DownloadRevision(fileId, revisionId string, offset int64) (body io.ReadCloser, err error) {
+	defer observe("download_revision", time.Now(), &err)
+
+	var resp *models.DownloadURLResponse
+	err = b.call(func(credential *aliyundrive.Credential) error {
+		resp, err = api.GetRevisionDownloadURL(b.driver, credential, fileId, revisionId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Url == nil {
+		return nil, os.ErrNotExist
+	}
+
+	request, err := http.NewRequest(http.MethodGet, *resp.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("referer", "https://www.aliyundrive.com/")
+	request.Header.Set("range", fmt.Sprintf("bytes=%d-", offset))
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusPartialContent {
+		_ = response.Body.Close()
+		return nil, fmt.Errorf("download revision %s error, status %d", revisionId, response.StatusCode)
+	}
+
+	return response.Body, nil
+}
+
+// upload 上传文件，数据只能读取一次，不能整体重试
+// 上传时间较长时 AccessToken 可能在合并分片前失效，这时刷新后重新合并分片
+func (b *aliyunBackend) upload(options *aliyundrive.UploadFileOptions) (*models.File, error) {
+	credential, err := b.token.Credential()
+	if err != nil {
+		return nil, err
+	}
+
+	var started *aliyundrive.ProgressInfo
+	progressStart := options.ProgressStart
+	options.ProgressStart = func(info *aliyundrive.ProgressInfo) {
+		started = info
+		if progressStart != nil {
+			progressStart(info)
+		}
+	}
+	defer func() { options.ProgressStart = progressStart }()
+
+	file, err := b.driver.UploadFile(credential, options)
+	if err == nil || started == nil {
+		return file, err
+	}
+
+	fresh := b.renew(credential, err)
+	if fresh == nil {
+		return nil, err
+	}
+
+	resp, completeErr := b.driver.CompleteUpload(fresh, started.FileId, started.UploadId)
+	if completeErr != nil || resp.Code != "" || resp.Status != models.FileStatusAvailable {
+		return nil, err
+	}
+
+	b.driver.EvictCacheWithPrefix(options.ParentFileId)
+	if options.ProgressDone != nil {
+		options.ProgressDone(started)
+	}
+
+	return &resp.File, nil
+}
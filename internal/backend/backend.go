@@ -0,0 +1,91 @@
+// Package backend 文件系统使用的存储后端，除阿里云盘外还提供内存和本地目录实现，便于离线运行和调试
+package backend
+
+import (
+	"github.com/jakeslee/aliyundrive"
+	"github.com/jakeslee/aliyundrive-webdav/internal/api"
+	"github.com/jakeslee/aliyundrive/models"
+	"io"
+)
+
+// RootFileId 根目录的 fileId，和阿里云盘保持一致
+const RootFileId = aliyundrive.DefaultRootFileId
+
+// ErrPartialFoundPath 只找到路径的前一部分
+var ErrPartialFoundPath = aliyundrive.ErrPartialFoundPath
+
+// Backend 存储后端，文件和目录通过 fileId 访问，文件信息使用阿里云盘的 models.File
+type Backend interface {
+	// ResolvePathToFileId 通过路径查找 fileId
+	// 只找到前一部分时，返回已找到的 fileId、路径和 ErrPartialFoundPath
+	ResolvePathToFileId(fullPath string) (string, string, error)
+
+	GetFile(fileId string) (*models.File, error)
+
+	// GetFolderFiles 分页列出目录，NextMarker 为空时没有更多
+	GetFolderFiles(options *aliyundrive.FolderFilesOptions) (*models.Files, error)
+
+	// GetPath 返回从文件到根目录的各级目录，不包含根目录
+	GetPath(fileId string) ([]*models.File, error)
+
+	CreateDirectory(parentFileId, name string) (*models.File, error)
+	MoveFile(fileId, toParentFileId string) error
+	RenameFile(fileId, name string) error
+
+	// RemoveFile 删除文件，支持回收站的后端移动到回收站
+	RemoveFile(fileId string) error
+
+	UploadFile(options *aliyundrive.UploadFileOptions) (*models.File, error)
+
+	// Download 从 offset 开始读取文件内容
+	Download(fileId string, offset int64) (io.ReadCloser, error)
+
+	// EvictCacheWithPrefix 清除 key 以 prefix 开头的缓存，没有缓存的后端可以忽略
+	EvictCacheWithPrefix(prefix string)
+}
+
+// RapidUploader 支持秒传的后端
+type RapidUploader interface {
+	UploadFileRapid(options *aliyundrive.UploadFileRapidOptions) (*models.File, bool, error)
+}
+
+// QuotaReporter 可以提供容量信息的后端，单位字节
+type QuotaReporter interface {
+	Quota() (used, total int64, err error)
+}
+
+// RecycleBin 支持回收站的后端
+type RecycleBin interface {
+	ListRecycleBin(marker string) (*models.Files, error)
+	RestoreFile(fileId string) error
+
+	// DeleteFile 彻底删除文件，不经过回收站
+	DeleteFile(fileId string) error
+}
+
+// Searcher 支持阿里云盘查询语句的后端
+type Searcher interface {
+	Search(query, marker string, limit int) (*models.Files, error)
+}
+
+// RevisionStore 保存文件历史版本的后端
+type RevisionStore interface {
+	ListRevisions(fileId string) ([]*api.Revision, error)
+	RestoreRevision(fileId, revisionId string) error
+	DownloadRevision(fileId, revisionId string, offset int64) (io.ReadCloser, error)
+}
+
+// HealthChecker 可以检查凭证和服务是否可用的后端
+type HealthChecker interface {
+	Check() error
+}
+
+// Check 检查后端是否可用，没有实现 HealthChecker 的后端读取根目录
+func Check(b Backend) error {
+	if c, ok := b.(HealthChecker); ok {
+		return c.Check()
+	}
+
+	_, err := b.GetFile(RootFileId)
+	return err
+}
@@ -0,0 +1,232 @@
+package backend
+
+import (
+	"github.com/jakeslee/aliyundrive"
+	"github.com/jakeslee/aliyundrive/models"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testBackends 返回内存和本地目录后端，两者的行为应该一致
+func testBackends(t *testing.T) map[string]Backend {
+	local, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]Backend{
+		"memory": NewMemory(),
+		"local":  local,
+	}
+}
+
+func mustDir(t *testing.T, b Backend, parentFileId, name string) string {
+	file, err := b.CreateDirectory(parentFileId, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return file.FileId
+}
+
+func mustUpload(t *testing.T, b Backend, parentFileId, name, content string) *models.File {
+	file, err := b.UploadFile(&aliyundrive.UploadFileOptions{
+		Name:         name,
+		Size:         int64(len(content)),
+		ParentFileId: parentFileId,
+		Reader:       strings.NewReader(content),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func read(t *testing.T, b Backend, fileId string, offset int64) string {
+	body, err := b.Download(fileId, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(content)
+}
+
+func TestValidName(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		ok   bool
+	}{
+		{"a.txt", true},
+		{".hidden", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"a/b", false},
+		{`a\b`, false},
+	} {
+		if err := validName(tt.name); (err == nil) != tt.ok {
+			t.Errorf("validName(%q) = %v", tt.name, err)
+		}
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	for name, b := range testBackends(t) {
+		dir := mustDir(t, b, RootFileId, "dir")
+		file := mustUpload(t, b, dir, "a.txt", "hello")
+
+		tests := []struct {
+			path, fileId, found string
+			err                 error
+		}{
+			{"/", RootFileId, "/", nil},
+			{"", RootFileId, "/", nil},
+			{"/dir", dir, "/dir", nil},
+			{"/dir/a.txt", file.FileId, "/dir/a.txt", nil},
+			{"dir/./a.txt", file.FileId, "/dir/a.txt", nil},
+			// 只找到前一部分时返回已找到的部分
+			{"/dir/missing/b.txt", dir, "/dir", ErrPartialFoundPath},
+			{"/missing", RootFileId, "/", ErrPartialFoundPath},
+			{"/dir/a.txt/b.txt", file.FileId, "/dir/a.txt", ErrPartialFoundPath},
+		}
+
+		for _, tt := range tests {
+			fileId, found, err := b.ResolvePathToFileId(tt.path)
+			if fileId != tt.fileId || found != tt.found || err != tt.err {
+				t.Errorf("%s: ResolvePathToFileId(%q) = %s, %s, %v", name, tt.path, fileId, found, err)
+			}
+		}
+	}
+}
+
+func TestBackendFiles(t *testing.T) {
+	for name, b := range testBackends(t) {
+		dir := mustDir(t, b, RootFileId, "dir")
+
+		// 已存在的目录返回原来的目录
+		if again := mustDir(t, b, RootFileId, "dir"); again != dir {
+			t.Errorf("%s: CreateDirectory existing returned %s, expected %s", name, again, dir)
+		}
+		if _, err := b.CreateDirectory(RootFileId, "a/b"); err == nil {
+			t.Errorf("%s: invalid directory name accepted", name)
+		}
+
+		var started string
+		file, err := b.UploadFile(&aliyundrive.UploadFileOptions{
+			Name:          "b.txt",
+			Size:          5,
+			ParentFileId:  dir,
+			Reader:        strings.NewReader("hello"),
+			ProgressStart: func(info *aliyundrive.ProgressInfo) { started = info.FileId },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if started != file.FileId || file.Size != 5 || file.ParentFileId != dir ||
+			file.ContentHashName != "sha1" || file.ContentHash != "AAF4C61DDCC5E8A2DABEDE0F3B482CD9AEA9434D" {
+			t.Errorf("%s: uploaded file %+v, progress fileId %s", name, file, started)
+		}
+
+		if got := read(t, b, file.FileId, 0); got != "hello" {
+			t.Errorf("%s: content %q", name, got)
+		}
+		if got := read(t, b, file.FileId, 3); got != "lo" {
+			t.Errorf("%s: content from offset %q", name, got)
+		}
+
+		// 大小不一致时上传失败，同名文件会被覆盖
+		if _, err := b.UploadFile(&aliyundrive.UploadFileOptions{
+			Name: "b.txt", Size: 10, ParentFileId: dir, Reader: strings.NewReader("short"),
+		}); err != errSizeMismatch {
+			t.Errorf("%s: size mismatch error %v", name, err)
+		}
+		overwritten := mustUpload(t, b, dir, "b.txt", "world!")
+		if got := read(t, b, overwritten.FileId, 0); got != "world!" {
+			t.Errorf("%s: overwritten content %q", name, got)
+		}
+
+		mustUpload(t, b, dir, "a.txt", "a")
+		mustDir(t, b, dir, "c")
+
+		files, err := b.GetFolderFiles(&aliyundrive.FolderFilesOptions{FolderFileId: dir, OrderBy: "name", OrderDirection: "DESC"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, item := range files.Items {
+			names = append(names, item.Name)
+		}
+		if strings.Join(names, " ") != "c b.txt a.txt" || files.NextMarker != "" {
+			t.Errorf("%s: listing %v", name, names)
+		}
+
+		items, err := b.GetPath(overwritten.FileId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 2 || items[0].Name != "b.txt" || items[1].Name != "dir" {
+			t.Errorf("%s: GetPath %v", name, items)
+		}
+
+		if err := Check(b); err != nil {
+			t.Errorf("%s: Check %s", name, err)
+		}
+	}
+}
+
+func TestBackendMoves(t *testing.T) {
+	for name, b := range testBackends(t) {
+		dir := mustDir(t, b, RootFileId, "dir")
+		sub := mustDir(t, b, dir, "sub")
+		file := mustUpload(t, b, dir, "a.txt", "hello")
+		mustUpload(t, b, sub, "a.txt", "other")
+
+		tests := []struct {
+			desc string
+			fn   func() error
+			err  error
+		}{
+			{"move onto existing name", func() error { return b.MoveFile(file.FileId, sub) }, os.ErrExist},
+			{"move into itself", func() error { return b.MoveFile(dir, sub) }, os.ErrInvalid},
+			{"rename to existing name", func() error { return b.RenameFile(sub, "a.txt") }, os.ErrExist},
+			{"invalid name", func() error { return b.RenameFile(file.FileId, "x/y") }, errInvalidName},
+			{"remove root", func() error { return b.RemoveFile(RootFileId) }, os.ErrPermission},
+			{"rename", func() error { return b.RenameFile(file.FileId, "b.txt") }, nil},
+			{"move", func() error { return b.MoveFile(file.FileId, RootFileId) }, nil},
+		}
+
+		for _, tt := range tests {
+			if err := tt.fn(); err != tt.err {
+				t.Errorf("%s: %s error %v, expected %v", name, tt.desc, err, tt.err)
+			}
+		}
+
+		// 移动、重命名后 fileId 不变
+		if fileId, _, err := b.ResolvePathToFileId("/b.txt"); err != nil || fileId != file.FileId {
+			t.Errorf("%s: moved file %s, %v", name, fileId, err)
+		}
+		if got := read(t, b, file.FileId, 0); got != "hello" {
+			t.Errorf("%s: moved content %q", name, got)
+		}
+
+		// 删除目录同时删除子文件
+		if err := b.RemoveFile(dir); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := b.ResolvePathToFileId("/dir/sub/a.txt"); err != ErrPartialFoundPath {
+			t.Errorf("%s: removed path resolved, %v", name, err)
+		}
+		if _, err := b.GetFile(sub); err == nil {
+			t.Errorf("%s: removed sub directory still exists", name)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"errors"
+	"github.com/jakeslee/aliyundrive"
+	"github.com/jakeslee/aliyundrive/models"
+	"path"
+	"sort"
+	"strings"
+)
+
+var (
+	errInvalidName  = errors.New("backend: invalid file name")
+	errNotDirectory = errors.New("backend: not a directory")
+	errSizeMismatch = errors.New("backend: uploaded size mismatch")
+)
+
+// validName 文件名不能为空，不能包含路径分隔符
+func validName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return errInvalidName
+	}
+
+	return nil
+}
+
+// resolvePath 逐级查找路径，lookup 返回目录下指定名称的 fileId，语义同 Backend.ResolvePathToFileId
+func resolvePath(fullPath string, lookup func(parentFileId, name string) (string, bool, error)) (string, string, error) {
+	p := path.Clean("/" + fullPath)
+
+	fileId, foundPath := RootFileId, "/"
+	if p == "/" {
+		return fileId, foundPath, nil
+	}
+
+	for _, name := range strings.Split(p[1:], "/") {
+		id, ok, err := lookup(fileId, name)
+		if err != nil {
+			return "", "", err
+		}
+		if !ok {
+			return fileId, foundPath, ErrPartialFoundPath
+		}
+
+		fileId, foundPath = id, path.Join(foundPath, name)
+	}
+
+	return fileId, foundPath, nil
+}
+
+// sortFiles 按 FolderFilesOptions 的排序方式排序，支持 name、updated_at、created_at、size
+func sortFiles(items []*models.File, options *aliyundrive.FolderFilesOptions) {
+	desc := strings.EqualFold(options.OrderDirection, models.OrderDirectionTypeDescend)
+
+	less := func(i, j int) bool {
+		a, b := items[i], items[j]
+
+		switch options.OrderBy {
+		case "updated_at":
+			return a.UpdatedAt.Before(b.UpdatedAt)
+		case "created_at":
+			return a.CreatedAt.Before(b.CreatedAt)
+		case "size":
+			return a.Size < b.Size
+		}
+
+		return a.Name < b.Name
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if desc {
+			return less(j, i)
+		}
+		return less(i, j)
+	})
+}
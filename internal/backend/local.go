@@ -0,0 +1,412 @@
+package backend
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"github.com/jakeslee/aliyundrive"
+	"github.com/jakeslee/aliyundrive/models"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// localUploadPrefix 上传中的临时文件前缀，列目录时忽略
+const localUploadPrefix = ".aliyundrive-upload-"
+
+// localBackend 本地目录后端，删除的文件不进入回收站
+// fileId 由首次访问时的相对路径生成，移动、重命名后在进程内保持不变
+type localBackend struct {
+	root  string
+	mu    sync.Mutex
+	ids   map[string]string // fileId 到相对路径
+	paths map[string]string // 相对路径到 fileId
+}
+
+func NewLocal(root string) (Backend, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, errNotDirectory
+	}
+
+	return &localBackend{
+		root:  root,
+		ids:   map[string]string{RootFileId: "/"},
+		paths: map[string]string{"/": RootFileId},
+	}, nil
+}
+
+// idOf 返回相对路径对应的 fileId，调用时需要持有锁
+func (b *localBackend) idOf(rel string) string {
+	if id, ok := b.paths[rel]; ok {
+		return id
+	}
+
+	id := fmt.Sprintf("local%x", sha1.Sum([]byte(rel)))
+	b.ids[id] = rel
+	b.paths[rel] = id
+
+	return id
+}
+
+// pathOf 返回 fileId 对应的相对路径，调用时需要持有锁
+func (b *localBackend) pathOf(fileId string) (string, error) {
+	rel, ok := b.ids[fileId]
+	if !ok {
+		return "", os.ErrNotExist
+	}
+
+	return rel, nil
+}
+
+func (b *localBackend) abs(rel string) string {
+	return filepath.Join(b.root, filepath.FromSlash(rel))
+}
+
+// relocate 移动、重命名后更新 rel 及其子路径的 fileId
+func (b *localBackend) relocate(from, to string) {
+	for rel, id := range b.paths {
+		if rel != from && !strings.HasPrefix(rel, from+"/") {
+			continue
+		}
+
+		moved := to + rel[len(from):]
+		delete(b.paths, rel)
+		b.paths[moved] = id
+		b.ids[id] = moved
+	}
+}
+
+// forget 删除后移除 rel 及其子路径的 fileId
+func (b *localBackend) forget(rel string) {
+	for p, id := range b.paths {
+		if p == rel || strings.HasPrefix(p, rel+"/") {
+			delete(b.paths, p)
+			delete(b.ids, id)
+		}
+	}
+}
+
+func (b *localBackend) fileOf(rel string, fi os.FileInfo) *models.File {
+	file := &models.File{
+		DriveId:   "local",
+		Name:      fi.Name(),
+		Type:      models.FileTypeFile,
+		FileId:    b.idOf(rel),
+		Status:    models.FileStatusAvailable,
+		CreatedAt: fi.ModTime(),
+		UpdatedAt: fi.ModTime(),
+	}
+
+	if rel == "/" {
+		file.Name = RootFileId
+	} else {
+		file.ParentFileId = b.idOf(path.Dir(rel))
+	}
+
+	if fi.IsDir() {
+		file.Type = models.FileTypeFolder
+	} else {
+		file.Size = fi.Size()
+	}
+
+	return file
+}
+
+func (b *localBackend) stat(fileId string) (string, os.FileInfo, error) {
+	rel, err := b.pathOf(fileId)
+	if err != nil {
+		return "", nil, err
+	}
+
+	fi, err := os.Stat(b.abs(rel))
+	if err != nil {
+		return "", nil, err
+	}
+
+	return rel, fi, nil
+}
+
+func (b *localBackend) ResolvePathToFileId(fullPath string) (string, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return resolvePath(fullPath, func(parentFileId, name string) (string, bool, error) {
+		if strings.HasPrefix(name, localUploadPrefix) {
+			return "", false, nil
+		}
+
+		rel := path.Join(b.ids[parentFileId], name)
+		if _, err := os.Stat(b.abs(rel)); err != nil {
+			// 上一级是文件时和不存在一样，只返回找到的部分
+			if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+				return "", false, nil
+			}
+			return "", false, err
+		}
+
+		return b.idOf(rel), true, nil
+	})
+}
+
+func (b *localBackend) GetFile(fileId string) (*models.File, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rel, fi, err := b.stat(fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.fileOf(rel, fi), nil
+}
+
+// GetFolderFiles 一次返回目录的全部文件
+func (b *localBackend) GetFolderFiles(options *aliyundrive.FolderFilesOptions) (*models.Files, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rel, err := b.pathOf(options.FolderFileId)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := ioutil.ReadDir(b.abs(rel))
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*models.File, 0, len(infos))
+	for _, fi := range infos {
+		if strings.HasPrefix(fi.Name(), localUploadPrefix) {
+			continue
+		}
+		items = append(items, b.fileOf(path.Join(rel, fi.Name()), fi))
+	}
+
+	sortFiles(items, options)
+
+	return &models.Files{Items: items}, nil
+}
+
+func (b *localBackend) GetPath(fileId string) ([]*models.File, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rel, err := b.pathOf(fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []*models.File
+
+	for ; rel != "/"; rel = path.Dir(rel) {
+		fi, err := os.Stat(b.abs(rel))
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, b.fileOf(rel, fi))
+	}
+
+	return items, nil
+}
+
+func (b *localBackend) CreateDirectory(parentFileId, name string) (*models.File, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	parent, err := b.pathOf(parentFileId)
+	if err != nil {
+		return nil, err
+	}
+
+	rel := path.Join(parent, name)
+	if err := os.Mkdir(b.abs(rel), 0755); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+
+	fi, err := os.Stat(b.abs(rel))
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, os.ErrExist
+	}
+
+	return b.fileOf(rel, fi), nil
+}
+
+func (b *localBackend) MoveFile(fileId, toParentFileId string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rel, err := b.pathOf(fileId)
+	if err != nil {
+		return err
+	}
+
+	to, err := b.pathOf(toParentFileId)
+	if err != nil {
+		return err
+	}
+
+	return b.rename(rel, path.Join(to, path.Base(rel)))
+}
+
+func (b *localBackend) RenameFile(fileId, name string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rel, err := b.pathOf(fileId)
+	if err != nil {
+		return err
+	}
+
+	return b.rename(rel, path.Join(path.Dir(rel), name))
+}
+
+// rename 不覆盖已存在的文件，调用时需要持有锁
+func (b *localBackend) rename(from, to string) error {
+	if from == "/" || to == from || strings.HasPrefix(to, from+"/") {
+		return os.ErrInvalid
+	}
+
+	if _, err := os.Lstat(b.abs(to)); err == nil {
+		return os.ErrExist
+	}
+
+	if err := os.Rename(b.abs(from), b.abs(to)); err != nil {
+		return err
+	}
+
+	b.relocate(from, to)
+
+	return nil
+}
+
+func (b *localBackend) RemoveFile(fileId string) error {
+	if fileId == RootFileId {
+		return os.ErrPermission
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rel, err := b.pathOf(fileId)
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(b.abs(rel)); err != nil {
+		return err
+	}
+
+	b.forget(rel)
+
+	return nil
+}
+
+// UploadFile 先写入同目录的临时文件，完成后改名，同名文件会被覆盖
+func (b *localBackend) UploadFile(options *aliyundrive.UploadFileOptions) (*models.File, error) {
+	if err := validName(options.Name); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	parent, err := b.pathOf(options.ParentFileId)
+	rel := path.Join(parent, options.Name)
+	fileId := b.idOf(rel)
+	b.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	tempFile, err := ioutil.TempFile(b.abs(parent), localUploadPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tempFile.Name())
+
+	if options.ProgressStart != nil {
+		options.ProgressStart(&aliyundrive.ProgressInfo{FileId: fileId})
+	}
+
+	hash := sha1.New()
+
+	n, err := io.Copy(io.MultiWriter(tempFile, hash), options.Reader)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Size > 0 && n != options.Size {
+		return nil, errSizeMismatch
+	}
+
+	if err := os.Rename(tempFile.Name(), b.abs(rel)); err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(b.abs(rel))
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	file := b.fileOf(rel, fi)
+	file.ContentHash = strings.ToUpper(fmt.Sprintf("%x", hash.Sum(nil)))
+	file.ContentHashName = "sha1"
+
+	return file, nil
+}
+
+func (b *localBackend) Download(fileId string, offset int64) (io.ReadCloser, error) {
+	b.mu.Lock()
+	rel, err := b.pathOf(fileId)
+	b.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(b.abs(rel))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (b *localBackend) EvictCacheWithPrefix(prefix string) {}
@@ -0,0 +1,355 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"github.com/jakeslee/aliyundrive"
+	"github.com/jakeslee/aliyundrive/models"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryNode struct {
+	file     *models.File
+	data     []byte
+	children map[string]string // 文件名到 fileId
+}
+
+// memoryBackend 内存后端，重启后数据丢失，删除的文件不进入回收站
+type memoryBackend struct {
+	mu    sync.RWMutex
+	nodes map[string]*memoryNode
+	seq   int64
+}
+
+func NewMemory() Backend {
+	now := time.Now()
+
+	return &memoryBackend{
+		nodes: map[string]*memoryNode{
+			RootFileId: {
+				file: &models.File{
+					DriveId:   "memory",
+					Name:      RootFileId,
+					Type:      models.FileTypeFolder,
+					FileId:    RootFileId,
+					Status:    models.FileStatusAvailable,
+					CreatedAt: now,
+					UpdatedAt: now,
+				},
+				children: make(map[string]string),
+			},
+		},
+	}
+}
+
+func (b *memoryBackend) nextId() string {
+	b.seq++
+	return fmt.Sprintf("mem%016x", b.seq)
+}
+
+// node 返回 fileId 对应的节点，调用时需要持有锁
+func (b *memoryBackend) node(fileId string) (*memoryNode, error) {
+	n, ok := b.nodes[fileId]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return n, nil
+}
+
+func (b *memoryBackend) folder(fileId string) (*memoryNode, error) {
+	n, err := b.node(fileId)
+	if err != nil {
+		return nil, err
+	}
+	if n.children == nil {
+		return nil, errNotDirectory
+	}
+
+	return n, nil
+}
+
+func (b *memoryBackend) ResolvePathToFileId(fullPath string) (string, string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return resolvePath(fullPath, func(parentFileId, name string) (string, bool, error) {
+		parent, err := b.folder(parentFileId)
+		if err != nil {
+			return "", false, nil
+		}
+
+		id, ok := parent.children[name]
+		return id, ok, nil
+	})
+}
+
+func (b *memoryBackend) GetFile(fileId string) (*models.File, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	n, err := b.node(fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	file := *n.file
+	return &file, nil
+}
+
+// GetFolderFiles 一次返回目录的全部文件
+func (b *memoryBackend) GetFolderFiles(options *aliyundrive.FolderFilesOptions) (*models.Files, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	n, err := b.folder(options.FolderFileId)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*models.File, 0, len(n.children))
+	for _, id := range n.children {
+		file := *b.nodes[id].file
+		items = append(items, &file)
+	}
+
+	sortFiles(items, options)
+
+	return &models.Files{Items: items}, nil
+}
+
+func (b *memoryBackend) GetPath(fileId string) ([]*models.File, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var items []*models.File
+
+	for fileId != RootFileId {
+		n, err := b.node(fileId)
+		if err != nil {
+			return nil, err
+		}
+
+		file := *n.file
+		items = append(items, &file)
+		fileId = n.file.ParentFileId
+	}
+
+	return items, nil
+}
+
+func (b *memoryBackend) CreateDirectory(parentFileId, name string) (*models.File, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	parent, err := b.folder(parentFileId)
+	if err != nil {
+		return nil, err
+	}
+
+	if id, ok := parent.children[name]; ok {
+		if b.nodes[id].children == nil {
+			return nil, os.ErrExist
+		}
+		file := *b.nodes[id].file
+		return &file, nil
+	}
+
+	now := time.Now()
+	file := &models.File{
+		DriveId:      "memory",
+		Name:         name,
+		Type:         models.FileTypeFolder,
+		FileId:       b.nextId(),
+		ParentFileId: parentFileId,
+		Status:       models.FileStatusAvailable,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	b.nodes[file.FileId] = &memoryNode{file: file, children: make(map[string]string)}
+	parent.children[name] = file.FileId
+
+	result := *file
+	return &result, nil
+}
+
+func (b *memoryBackend) MoveFile(fileId, toParentFileId string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n, err := b.node(fileId)
+	if err != nil {
+		return err
+	}
+
+	to, err := b.folder(toParentFileId)
+	if err != nil {
+		return err
+	}
+
+	// 不能移动到自己的子目录中
+	for id := toParentFileId; id != RootFileId; id = b.nodes[id].file.ParentFileId {
+		if id == fileId {
+			return os.ErrInvalid
+		}
+	}
+
+	if _, ok := to.children[n.file.Name]; ok {
+		return os.ErrExist
+	}
+
+	delete(b.nodes[n.file.ParentFileId].children, n.file.Name)
+	to.children[n.file.Name] = fileId
+	n.file.ParentFileId = toParentFileId
+
+	return nil
+}
+
+func (b *memoryBackend) RenameFile(fileId, name string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n, err := b.node(fileId)
+	if err != nil {
+		return err
+	}
+
+	parent := b.nodes[n.file.ParentFileId]
+	if _, ok := parent.children[name]; ok {
+		return os.ErrExist
+	}
+
+	delete(parent.children, n.file.Name)
+	parent.children[name] = fileId
+	n.file.Name = name
+	n.file.UpdatedAt = time.Now()
+
+	return nil
+}
+
+func (b *memoryBackend) RemoveFile(fileId string) error {
+	if fileId == RootFileId {
+		return os.ErrPermission
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n, err := b.node(fileId)
+	if err != nil {
+		return err
+	}
+
+	delete(b.nodes[n.file.ParentFileId].children, n.file.Name)
+	b.removeNode(fileId)
+
+	return nil
+}
+
+func (b *memoryBackend) removeNode(fileId string) {
+	for _, id := range b.nodes[fileId].children {
+		b.removeNode(id)
+	}
+
+	delete(b.nodes, fileId)
+}
+
+// UploadFile 读取全部内容后保存，同名文件会被覆盖
+func (b *memoryBackend) UploadFile(options *aliyundrive.UploadFileOptions) (*models.File, error) {
+	if err := validName(options.Name); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	if _, err := b.folder(options.ParentFileId); err != nil {
+		b.mu.Unlock()
+		return nil, err
+	}
+	fileId := b.nextId()
+	b.mu.Unlock()
+
+	if options.ProgressStart != nil {
+		options.ProgressStart(&aliyundrive.ProgressInfo{FileId: fileId})
+	}
+
+	data, err := ioutil.ReadAll(options.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Size > 0 && int64(len(data)) != options.Size {
+		return nil, errSizeMismatch
+	}
+
+	now := time.Now()
+	file := &models.File{
+		DriveId:      "memory",
+		Name:         options.Name,
+		Type:         models.FileTypeFile,
+		FileId:       fileId,
+		ParentFileId: options.ParentFileId,
+		Status:       models.FileStatusAvailable,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	file.Size = int64(len(data))
+	file.ContentHash = strings.ToUpper(fmt.Sprintf("%x", sha1.Sum(data)))
+	file.ContentHashName = "sha1"
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	parent, err := b.folder(options.ParentFileId)
+	if err != nil {
+		return nil, err
+	}
+
+	if id, ok := parent.children[options.Name]; ok {
+		if b.nodes[id].children != nil {
+			return nil, os.ErrExist
+		}
+		delete(b.nodes, id)
+	}
+
+	b.nodes[fileId] = &memoryNode{file: file, data: data}
+	parent.children[options.Name] = fileId
+
+	result := *file
+	return &result, nil
+}
+
+func (b *memoryBackend) Download(fileId string, offset int64) (io.ReadCloser, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	n, err := b.node(fileId)
+	if err != nil {
+		return nil, err
+	}
+	if n.children != nil {
+		return nil, os.ErrInvalid
+	}
+
+	if offset > int64(len(n.data)) {
+		offset = int64(len(n.data))
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(n.data[offset:])), nil
+}
+
+func (b *memoryBackend) EvictCacheWithPrefix(prefix string) {}
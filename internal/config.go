@@ -1,17 +1,66 @@
 package internal
 
+import "github.com/jakeslee/aliyundrive-webdav/internal/access"
+
 const Version = "0.0.8"
 
 type config struct {
-	Host         string `arg:"-h" help:"监听地址" default:"0.0.0.0"`
-	Port         int    `arg:"-p" help:"监听端口" default:"18080"`
-	RefreshToken string `arg:"-r,env:REFRESH_TOKEN" help:"Refresh Token" default:"false"`
-	RapidUpload  bool   `arg:"--rapid,env:RAPID" help:"秒传，默认关闭" default:"false"`
-	WorkDir      string `arg:"-w,env:WORK_DIR" help:"工作目录，用于保存 RefreshToken 刷新结果" default:"/tmp"`
-	UploadSpeed  int    `arg:"--upload-speed,env:UPLOAD_SPEED" help:"上传速度限制，单位 MB/s，默认无限制"`
-	AuthType     string `arg:"-a,env:AUTH_TYPE" help:"认证类型，可选 basic, none" default:"none"`
-	HttpUsername string `arg:"--http-user,env:HTTP_USER" help:"Basic Auth：登录帐号"`
-	HttpPassword string `arg:"--http-pass,env:HTTP_PASS" help:"Basic Auth：登录密码"`
+	Login *LoginCommand `arg:"subcommand:login" help:"扫码登录阿里云盘，RefreshToken 保存到工作目录" yaml:"-"`
+
+	Config string `arg:"-c,--config,env:CONFIG" help:"YAML 或 TOML 配置文件，命令行参数和环境变量优先，修改后或收到 SIGHUP 时重新加载用户、限流和路径规则" yaml:"-"`
+
+	Host         string `arg:"-h" help:"监听地址" default:"0.0.0.0" yaml:"host"`
+	Port         int    `arg:"-p" help:"监听端口" default:"18080" yaml:"port"`
+	RefreshToken string `arg:"-r,env:REFRESH_TOKEN" help:"Refresh Token" default:"false" yaml:"refresh_token"`
+	RapidUpload  bool   `arg:"--rapid,env:RAPID" help:"秒传，默认关闭" default:"false" yaml:"rapid_upload"`
+	HardDelete   bool   `arg:"--hard-delete,env:HARD_DELETE" help:"彻底删除文件，默认移动到回收站" default:"false" yaml:"hard_delete"`
+	WorkDir      string `arg:"-w,env:WORK_DIR" help:"工作目录，用于保存 RefreshToken 刷新结果" default:"/tmp" yaml:"work_dir"`
+	TokenKey     string `arg:"--token-key,env:TOKEN_KEY" help:"加密保存 RefreshToken 刷新结果的密钥，为空时明文保存" yaml:"token_key"`
+	TokenKeyFile string `arg:"--token-key-file,env:TOKEN_KEY_FILE" help:"从文件读取加密密钥，优先于 --token-key" yaml:"token_key_file"`
+	Backend      string `arg:"--backend,env:BACKEND" help:"存储后端，可选 aliyun, memory, local" default:"aliyun" yaml:"backend"`
+	LocalDir     string `arg:"--local-dir,env:LOCAL_DIR" help:"local 存储后端的根目录" yaml:"local_dir"`
+	UploadSpeed  int    `arg:"--upload-speed,env:UPLOAD_SPEED" help:"上传速度限制，单位 MB/s，默认无限制" yaml:"upload_speed"`
+	AuthType     string `arg:"-a,env:AUTH_TYPE" help:"认证类型，可选 basic, none" default:"none" yaml:"auth_type"`
+	HttpUsername string `arg:"--http-user,env:HTTP_USER" help:"Basic Auth：登录帐号" yaml:"http_user"`
+	HttpPassword string `arg:"--http-pass,env:HTTP_PASS" help:"Basic Auth：登录密码" yaml:"http_pass"`
+
+	Mounts []string `arg:"--mount,separate,env:MOUNTS" help:"挂载多个网盘到一级目录，格式为 名称[:backup|resource]=RefreshToken，RefreshToken 刷新结果保存在工作目录的 refresh_token_名称 文件中" yaml:"mounts"`
+
+	ArchiveMaxFiles int `arg:"--archive-max-files,env:ARCHIVE_MAX_FILES" help:"打包下载的最大文件数，0 为不限制" default:"10000" yaml:"archive_max_files"`
+	ArchiveMaxSize  int `arg:"--archive-max-size,env:ARCHIVE_MAX_SIZE" help:"打包下载的最大大小，单位 MB，0 为不限制" yaml:"archive_max_size"`
+
+	LogLevel      string `arg:"--log-level,env:LOG_LEVEL" help:"日志级别，可选 debug, info, warn, error" default:"info" yaml:"log_level"`
+	LogFormat     string `arg:"--log-format,env:LOG_FORMAT" help:"日志格式，可选 text, json" default:"text" yaml:"log_format"`
+	AccessLog     string `arg:"--access-log,env:ACCESS_LOG" help:"JSON 格式的访问日志文件，- 为标准输出，为空时关闭" yaml:"access_log"`
+	AuditLog      string `arg:"--audit-log,env:AUDIT_LOG" help:"JSON 格式的审计日志文件，记录上传、删除、移动、重命名和创建目录，- 为标准输出，为空时关闭" yaml:"audit_log"`
+	LogMaxSize    int    `arg:"--log-max-size,env:LOG_MAX_SIZE" help:"访问日志和审计日志单个文件的最大大小，单位 MB，超过后轮转" default:"100" yaml:"log_max_size"`
+	LogMaxBackups int    `arg:"--log-max-backups,env:LOG_MAX_BACKUPS" help:"访问日志和审计日志保留的旧文件数" default:"5" yaml:"log_max_backups"`
+
+	Webhooks           []string `arg:"--webhook,separate,env:WEBHOOKS" help:"上传、删除、移动和创建目录后 POST JSON 到此地址，可指定多个" yaml:"webhooks"`
+	WebhookSecret      string   `arg:"--webhook-secret,env:WEBHOOK_SECRET" help:"回调签名密钥，X-Webhook-Signature 为 sha256=HMAC-SHA256(密钥, 时间戳.请求体)" yaml:"webhook_secret"`
+	WebhookEvents      []string `arg:"--webhook-event,separate,env:WEBHOOK_EVENTS" help:"回调的事件类型，可选 upload, mkdir, delete, move，默认全部" yaml:"webhook_events"`
+	WebhookMaxAttempts int      `arg:"--webhook-max-attempts,env:WEBHOOK_MAX_ATTEMPTS" help:"回调最大尝试次数，失败后按指数退避重试，全部失败后保存到工作目录的死信队列" default:"5" yaml:"webhook_max_attempts"`
+
+	EventsBuffer int      `arg:"--events-buffer,env:EVENTS_BUFFER" help:"GET /events（Accept: text/event-stream）推送文件变更事件，缓冲的事件数用于 Last-Event-ID 补发，0 为关闭" default:"1000" yaml:"events_buffer"`
+	EventsScopes []string `arg:"--events-scope,separate,env:EVENTS_SCOPES" help:"用户在 /events 只能收到指定路径下的事件，格式为 用户=路径，可指定多个" yaml:"events_scopes"`
+
+	// 以下只能在配置文件中设置
+	Users     []access.User    `arg:"-" yaml:"users"`
+	RateLimit access.RateLimit `arg:"-" yaml:"rate_limit"`
+	Rules     []access.Rule    `arg:"-" yaml:"rules"`
+
+	ShutdownTimeout int `arg:"--shutdown-timeout,env:SHUTDOWN_TIMEOUT" help:"收到 SIGINT 或 SIGTERM 后等待请求、上传和秒传完成的最长时间，单位秒" default:"60" yaml:"shutdown_timeout"`
+
+	AdminAddr     string `arg:"--admin-addr,env:ADMIN_ADDR" help:"管理端口监听地址，提供 /healthz、/readyz、监控指标和 pprof，为空时关闭" default:"127.0.0.1:18081" yaml:"admin_addr"`
+	AdminUsername string `arg:"--admin-user,env:ADMIN_USER" help:"管理端口 Basic Auth 帐号，为空时只提供 /healthz、/readyz 和监控指标，/healthz 和 /readyz 始终不认证" yaml:"admin_user"`
+	AdminPassword string `arg:"--admin-pass,env:ADMIN_PASS" help:"管理端口 Basic Auth 密码" yaml:"admin_pass"`
+	Pprof         bool   `arg:"--pprof,env:PPROF" help:"在管理端口开启 /debug/pprof/，默认关闭" default:"false" yaml:"pprof"`
+	MetricsPath   string `arg:"--metrics-path,env:METRICS_PATH" help:"管理端口上的 Prometheus 监控指标路径，为空时关闭" default:"/metrics" yaml:"metrics_path"`
+}
+
+// LoginCommand 扫码登录子命令
+type LoginCommand struct {
+	Mount string `arg:"positional" help:"挂载点名称，为空时登录默认网盘"`
 }
 
 func (c *config) Version() string {
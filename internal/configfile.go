@@ -0,0 +1,216 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/alexflint/go-arg"
+	"github.com/jakeslee/aliyundrive-webdav/internal/access"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+var errUnknownConfigFormat = errors.New("config: unknown config file format, use .yaml, .yml or .toml")
+
+// LoadFile 读取配置文件，返回在默认值基础上合并后的配置，命令行参数和环境变量设置的选项不会被覆盖
+func LoadFile(path string, args []string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %s", err)
+	}
+
+	// TOML 先转换为 YAML，两种格式共用 yaml 标签
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+	case ".toml":
+		values := make(map[string]interface{})
+		if _, err = toml.Decode(string(data), &values); err != nil {
+			return nil, fmt.Errorf("config: %s: %s", path, err)
+		}
+		if data, err = yaml.Marshal(values); err != nil {
+			return nil, fmt.Errorf("config: %s: %s", path, err)
+		}
+	default:
+		return nil, errUnknownConfigFormat
+	}
+
+	// 从默认值开始合并，重新加载时配置文件中删除的选项恢复为默认值
+	c, err := defaults()
+	if err != nil {
+		return nil, err
+	}
+	c.Login, c.Config = Config.Login, Config.Config
+	if err = yaml.UnmarshalStrict(data, &c); err != nil {
+		return nil, fmt.Errorf("config: %s: %s", path, err)
+	}
+
+	current, merged := reflect.ValueOf(Config).Elem(), reflect.ValueOf(&c).Elem()
+	for name := range explicitFields(args) {
+		merged.FieldByName(name).Set(current.FieldByName(name))
+	}
+
+	if err = c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// defaults 返回只包含默认值的配置
+func defaults() (config, error) {
+	var c config
+
+	p, err := arg.NewParser(arg.Config{}, &c)
+	if err != nil {
+		return c, fmt.Errorf("config: %s", err)
+	}
+	if err = p.Parse(nil); err != nil {
+		return c, fmt.Errorf("config: %s", err)
+	}
+
+	return c, nil
+}
+
+// explicitFields 返回通过命令行参数或环境变量设置的字段
+func explicitFields(args []string) map[string]bool {
+	fields := make(map[string]bool)
+
+	t := reflect.TypeOf(config{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag, ok := field.Tag.Lookup("arg")
+		if !ok || tag == "-" {
+			continue
+		}
+
+		names := []string{"--" + strings.ToLower(field.Name)}
+		for _, item := range strings.Split(tag, ",") {
+			switch {
+			case strings.HasPrefix(item, "--"):
+				names[0] = item
+			case strings.HasPrefix(item, "-"):
+				names = append(names, item)
+			case item == "env":
+				if _, ok := os.LookupEnv(strings.ToUpper(field.Name)); ok {
+					fields[field.Name] = true
+				}
+			case strings.HasPrefix(item, "env:"):
+				if _, ok := os.LookupEnv(item[len("env:"):]); ok {
+					fields[field.Name] = true
+				}
+			}
+		}
+
+		for _, arg := range args {
+			if arg == "--" {
+				break
+			}
+			if i := strings.Index(arg, "="); i >= 0 {
+				arg = arg[:i]
+			}
+			for _, name := range names {
+				if arg == name {
+					fields[field.Name] = true
+				}
+			}
+		}
+	}
+
+	return fields
+}
+
+// Validate 检查选项的取值范围
+func (c *config) Validate() error {
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("config: port %d out of range", c.Port)
+	}
+
+	switch c.AuthType {
+	case "basic", "none":
+	default:
+		return fmt.Errorf("config: unknown auth_type %s, use basic or none", c.AuthType)
+	}
+
+	switch c.Backend {
+	case "aliyun", "memory":
+	case "local":
+		if c.LocalDir == "" {
+			return errors.New("config: local_dir is required for local backend")
+		}
+	default:
+		return fmt.Errorf("config: unknown backend %s, use aliyun, memory or local", c.Backend)
+	}
+
+	if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+		return fmt.Errorf("config: log_level: %s", err)
+	}
+
+	for name, value := range map[string]int{
+		"upload_speed":         c.UploadSpeed,
+		"archive_max_files":    c.ArchiveMaxFiles,
+		"archive_max_size":     c.ArchiveMaxSize,
+		"log_max_size":         c.LogMaxSize,
+		"log_max_backups":      c.LogMaxBackups,
+		"webhook_max_attempts": c.WebhookMaxAttempts,
+		"events_buffer":        c.EventsBuffer,
+		"shutdown_timeout":     c.ShutdownTimeout,
+	} {
+		if value < 0 {
+			return fmt.Errorf("config: %s must not be negative", name)
+		}
+	}
+
+	if len(c.Users) > 0 && c.AuthType != "basic" {
+		return errors.New("config: users require auth_type basic")
+	}
+
+	if c.AdminUsername != "" && c.AdminPassword == "" {
+		return errors.New("config: admin_user requires admin_pass")
+	}
+
+	if _, err := c.Policy(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Policy 根据 users、http_user、rate_limit、rules 和 events_scopes 创建访问策略
+func (c *config) Policy() (*access.Policy, error) {
+	users := append([]access.User{}, c.Users...)
+	if c.HttpUsername != "" {
+		users = append(users, access.User{Name: c.HttpUsername, Password: c.HttpPassword})
+	}
+
+	scopes := make(map[string][]string)
+	for _, value := range c.EventsScopes {
+		name, prefix := value, ""
+		if i := strings.Index(value, "="); i >= 0 {
+			name, prefix = value[:i], value[i+1:]
+		}
+
+		if name == "" || prefix == "" {
+			return nil, fmt.Errorf("config: invalid events scope %s", value)
+		}
+
+		scopes[name] = append(scopes[name], "/"+strings.Trim(prefix, "/"))
+	}
+
+	policy, err := access.NewPolicy(&access.Options{
+		Users:        users,
+		RateLimit:    c.RateLimit,
+		Rules:        c.Rules,
+		EventsScopes: scopes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("config: %s", err)
+	}
+
+	return policy, nil
+}
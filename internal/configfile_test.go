@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	file := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestLoadFile(t *testing.T) {
+	file := writeConfig(t, "config.yaml", `
+port: 9000
+auth_type: basic
+users:
+  - name: alice
+    password: a
+rules:
+  - path: /secret
+    users: [alice]
+`)
+
+	c, err := LoadFile(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Port != 9000 || len(c.Users) != 1 || len(c.Rules) != 1 {
+		t.Fatalf("config not loaded: %+v", c)
+	}
+
+	// 配置文件没有设置的选项使用默认值
+	if c.Backend != "aliyun" || c.LogLevel != "info" || c.ShutdownTimeout != 60 {
+		t.Fatalf("defaults not applied: backend %s, log_level %s, shutdown_timeout %d", c.Backend, c.LogLevel, c.ShutdownTimeout)
+	}
+}
+
+func TestLoadFileReloadRestoresDefaults(t *testing.T) {
+	saved := *Config
+	defer func() { *Config = saved }()
+
+	// 当前配置来自上一次加载的配置文件
+	Config.Port = 9000
+	Config.LogLevel = "debug"
+
+	c, err := LoadFile(writeConfig(t, "config.yaml", "auth_type: none\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Port != 18080 || c.LogLevel != "info" {
+		t.Fatalf("removed options not restored: port %d, log_level %s", c.Port, c.LogLevel)
+	}
+}
+
+func TestLoadFileExplicitArgs(t *testing.T) {
+	saved := *Config
+	defer func() { *Config = saved }()
+
+	Config.Port = 9999
+
+	c, err := LoadFile(writeConfig(t, "config.yaml", "port: 9000\n"), []string{"-p", "9999"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Port != 9999 {
+		t.Fatalf("command line option overridden: port %d", c.Port)
+	}
+}
+
+func TestLoadFileTOML(t *testing.T) {
+	c, err := LoadFile(writeConfig(t, "config.toml", `
+port = 9000
+rapid_upload = true
+
+[rate_limit]
+requests = 10
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Port != 9000 || !c.RapidUpload || c.RateLimit.Requests != 10 {
+		t.Fatalf("config not loaded: %+v", c)
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"config.json", "{}", "unknown config file format"},
+		{"config.yaml", "unknown_option: 1\n", "unknown_option"},
+		{"config.yaml", "port: 70000\n", "port 70000 out of range"},
+		{"config.yaml", "backend: local\n", "local_dir is required"},
+		{"config.yaml", "users:\n  - name: alice\n", "users require auth_type basic"},
+		{"config.yaml", "admin_user: admin\n", "admin_user requires admin_pass"},
+		{"config.yaml", "auth_type: basic\nrules:\n  - path: /a\n    users: [bob]\n", "unknown user bob"},
+	}
+
+	for _, tt := range tests {
+		_, err := LoadFile(writeConfig(t, tt.name, tt.content), nil)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s %q: error %v, expected %q", tt.name, tt.content, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Package credential 跟踪各个网盘 RefreshToken 的刷新状态，凭证失效时 WebDAV 请求返回 503，
+// 管理端口可以查看状态并在运行时更换 RefreshToken
+package credential
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jakeslee/aliyundrive-webdav/internal/metrics"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessTokenLifetime AccessToken 有效期，阿里云盘返回的 expires_in 固定为 7200 秒
+const AccessTokenLifetime = 2 * time.Hour
+
+var (
+	ErrInvalid      = errors.New("credential: refresh token is invalid or expired")
+	errUnknownMount = errors.New("credential: unknown mount")
+)
+
+// ReplaceFunc 使用新的 RefreshToken 登录，成功后由调用方通过 Success 记录
+type ReplaceFunc func(token string) error
+
+// Status 一个网盘的凭证状态，默认网盘的 Mount 为空
+type Status struct {
+	Mount       string     `json:"mount"`
+	Valid       bool       `json:"valid"`
+	User        string     `json:"user,omitempty"`
+	LastRefresh *time.Time `json:"last_refresh,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Failures    int        `json:"failures"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+type entry struct {
+	status  Status
+	replace ReplaceFunc
+}
+
+// valid 刷新成功过，并且最近一次刷新成功或 AccessToken 还没有过期
+// 网络抖动导致刷新失败时，在 AccessToken 过期前仍然可以使用
+func (e *entry) valid(now time.Time) bool {
+	if e.status.LastRefresh == nil {
+		return false
+	}
+
+	return e.status.Failures == 0 || now.Before(*e.status.ExpiresAt)
+}
+
+// Monitor 记录各个网盘的凭证状态，没有注册网盘时不拦截任何请求
+type Monitor struct {
+	mu     sync.Mutex
+	drives map[string]*entry
+}
+
+func NewMonitor() *Monitor {
+	return &Monitor{drives: make(map[string]*entry)}
+}
+
+// Register 注册网盘，刷新成功前凭证视为无效
+func (m *Monitor) Register(mount string, replace ReplaceFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.drives[mount] = &entry{status: Status{Mount: mount}, replace: replace}
+}
+
+// Success 记录一次刷新成功
+func (m *Monitor) Success(mount, user string) {
+	metrics.ObserveTokenRefresh(nil)
+
+	now := time.Now()
+	expires := now.Add(AccessTokenLifetime)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.drives[mount]
+	if !ok {
+		return
+	}
+
+	e.status.User = user
+	e.status.LastRefresh = &now
+	e.status.ExpiresAt = &expires
+	e.status.Failures = 0
+	e.status.LastError = ""
+}
+
+// Failure 记录一次刷新失败
+func (m *Monitor) Failure(mount string, err error) {
+	metrics.ObserveTokenRefresh(err)
+
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.drives[mount]
+	if !ok {
+		return
+	}
+
+	e.status.Failures++
+	e.status.LastFailure = &now
+	e.status.LastError = err.Error()
+}
+
+// Check 检查路径所在网盘的凭证，挂载多个网盘时按第一级目录区分，其余路径不检查
+// p 为网盘中的路径，ownCloud 的 remote.php 路径需要先去掉前缀
+func (m *Monitor) Check(p string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.drives[""]
+	if !ok {
+		name := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)[0]
+		if e, ok = m.drives[name]; !ok {
+			return nil
+		}
+	}
+
+	if e.valid(time.Now()) {
+		return nil
+	}
+
+	if e.status.Mount != "" {
+		return fmt.Errorf("%w, mount %s, %s", ErrInvalid, e.status.Mount, e.status.LastError)
+	}
+
+	return fmt.Errorf("%w, %s", ErrInvalid, e.status.LastError)
+}
+
+// Replace 在运行时更换 RefreshToken，不需要重启
+func (m *Monitor) Replace(mount, token string) error {
+	m.mu.Lock()
+	e, ok := m.drives[mount]
+	m.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w %s", errUnknownMount, mount)
+	}
+
+	return e.replace(token)
+}
+
+// Statuses 按挂载点名称排序的凭证状态
+func (m *Monitor) Statuses() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	statuses := make([]Status, 0, len(m.drives))
+
+	for _, e := range m.drives {
+		s := e.status
+		s.Valid = e.valid(now)
+		statuses = append(statuses, s)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Mount < statuses[j].Mount
+	})
+
+	return statuses
+}
+
+// Metrics 作为 metrics.RegisterCredentials 的参数
+func (m *Monitor) Metrics() []metrics.CredentialStatus {
+	statuses := m.Statuses()
+	result := make([]metrics.CredentialStatus, 0, len(statuses))
+
+	for _, s := range statuses {
+		c := metrics.CredentialStatus{Mount: s.Mount, Valid: s.Valid, Failures: s.Failures}
+		if s.LastRefresh != nil {
+			c.LastRefresh, c.ExpiresAt = *s.LastRefresh, *s.ExpiresAt
+		}
+		result = append(result, c)
+	}
+
+	return result
+}
+
+// ServeHTTP GET 返回全部凭证状态，POST {"mount": "", "refresh_token": ""} 更换 RefreshToken
+func (m *Monitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		// 只接受 JSON，浏览器跨站提交的表单无法设置这个 Content-Type
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			http.Error(w, "credential: Content-Type must be application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		var req struct {
+			Mount        string `json:"mount"`
+			RefreshToken string `json:"refresh_token"`
+		}
+
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&req); err != nil {
+			http.Error(w, "credential: invalid request body, "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := m.Replace(req.Mount, req.RefreshToken); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errUnknownMount) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(m.Statuses())
+}
@@ -0,0 +1,132 @@
+package credential
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	m := NewMonitor()
+
+	// 没有注册网盘时不拦截
+	if err := m.Check("/any"); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Register("a", func(string) error { return nil })
+	m.Register("b", func(string) error { return nil })
+
+	// 刷新成功前视为无效
+	if err := m.Check("/a/file"); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("check before refresh: %v", err)
+	}
+
+	m.Success("a", "alice")
+	if err := m.Check("/a/file"); err != nil {
+		t.Fatalf("check after refresh: %v", err)
+	}
+	if err := m.Check("/b/file"); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("check other mount: %v", err)
+	}
+
+	// 挂载点以外的路径不检查
+	if err := m.Check("/c/file"); err != nil {
+		t.Fatalf("check unknown mount: %v", err)
+	}
+
+	// AccessToken 过期前刷新失败仍然可用
+	m.Failure("a", errors.New("network error"))
+	if err := m.Check("/a/file"); err != nil {
+		t.Fatalf("check after transient failure: %v", err)
+	}
+
+	expired := time.Now().Add(-time.Minute)
+	m.drives["a"].status.ExpiresAt = &expired
+	err := m.Check("/a/file")
+	if !errors.Is(err, ErrInvalid) || !strings.Contains(err.Error(), "network error") {
+		t.Fatalf("check after expiry: %v", err)
+	}
+}
+
+func TestCheckDefaultDrive(t *testing.T) {
+	m := NewMonitor()
+	m.Register("", func(string) error { return nil })
+
+	if err := m.Check("/dir/file"); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("check before refresh: %v", err)
+	}
+
+	m.Success("", "alice")
+	if err := m.Check("/dir/file"); err != nil {
+		t.Fatalf("check after refresh: %v", err)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	m := NewMonitor()
+
+	var replaced string
+	m.Register("a", func(token string) error {
+		if token == "bad" {
+			return errors.New("invalid token")
+		}
+		replaced = token
+		return nil
+	})
+
+	post := func(contentType, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/credentials", strings.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, r)
+		return w
+	}
+
+	// 表单提交不能更换 RefreshToken
+	if w := post("application/x-www-form-urlencoded", `{"mount":"a","refresh_token":"new"}`); w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("form post: status %d", w.Code)
+	}
+	if w := post("text/plain", `{"mount":"a","refresh_token":"new"}`); w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("text post: status %d", w.Code)
+	}
+	if replaced != "" {
+		t.Fatal("token replaced by non-JSON request")
+	}
+
+	if w := post("application/json", `{"mount":"x","refresh_token":"new"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown mount: status %d", w.Code)
+	}
+	if w := post("application/json", `{"mount":"a","refresh_token":"bad"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad token: status %d", w.Code)
+	}
+	if w := post("application/json", `not json`); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad body: status %d", w.Code)
+	}
+
+	w := post("application/json; charset=utf-8", `{"mount":"a","refresh_token":"new"}`)
+	if w.Code != http.StatusOK || replaced != "new" {
+		t.Fatalf("replace: status %d, token %q", w.Code, replaced)
+	}
+
+	var statuses []Status
+	if err := json.Unmarshal(w.Body.Bytes(), &statuses); err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].Mount != "a" {
+		t.Fatalf("statuses: %+v", statuses)
+	}
+
+	r := httptest.NewRequest(http.MethodDelete, "/credentials", nil)
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("delete: status %d", w.Code)
+	}
+}
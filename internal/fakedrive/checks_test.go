@@ -0,0 +1,656 @@
+package fakedrive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jakeslee/aliyundrive-webdav/internal/api"
+	"github.com/jakeslee/aliyundrive-webdav/internal/qrlogin"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// uploadWait PUT 在后台上传，完成前 GET 可能返回 404 或旧内容
+const uploadWait = 10 * time.Second
+
+// Check 一项 WebDAV 检查，参考 litmus 的 basic、copymove、props、locks 测试
+type Check struct {
+	Name string
+	Run  func(h *Harness) error
+}
+
+// Checks 按顺序执行的检查，后面的检查依赖前面创建的文件
+var Checks = []Check{
+	{"options", checkOptions},
+	{"mkcol", checkMkcol},
+	{"mkcol_again", checkMkcolAgain},
+	{"mkcol_no_parent", checkMkcolNoParent},
+	{"put_get", checkPutGet},
+	{"get_range", checkGetRange},
+	{"put_overwrite", checkPutOverwrite},
+	{"put_large", checkPutLarge},
+	{"put_rapid", checkPutRapid},
+	{"propfind_depth1", checkPropfind},
+	{"proppatch", checkProppatch},
+	{"quota_live_props", checkQuotaLiveProps},
+	{"move", checkMove},
+	{"move_no_overwrite", checkMoveNoOverwrite},
+	{"lock_unlock", checkLock},
+	{"delete", checkDelete},
+	{"trash", checkTrash},
+	{"token_refresh", checkTokenRefresh},
+	{"token_refresh_concurrent", checkTokenRefreshConcurrent},
+	{"qr_login", checkQRLogin},
+	{"sync_move_collection", checkSyncMoveCollection},
+	{"search_scope", checkSearchScope},
+	{"delete_collection", checkDeleteCollection},
+}
+
+// TestIntegration 启动模拟的阿里云盘服务和 WebDAV 服务，按顺序执行全部检查
+func TestIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+
+	logrus.SetLevel(logrus.WarnLevel)
+
+	h, err := NewHarness()
+	if err != nil {
+		t.Fatalf("start harness error %s", err)
+	}
+	defer h.Close()
+
+	for _, c := range Checks {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			if err := c.Run(h); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// Do 发送 WebDAV 请求并读取全部响应
+func (h *Harness) Do(method, p string, header map[string]string, body []byte) (*http.Response, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, h.URL+p, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resp, data, nil
+}
+
+func (h *Harness) expect(method, p string, header map[string]string, body []byte, status int) (*http.Response, []byte, error) {
+	resp, data, err := h.Do(method, p, header, body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != status {
+		return nil, nil, fmt.Errorf("%s %s: status %d, expected %d: %s", method, p, resp.StatusCode, status, strings.TrimSpace(string(data)))
+	}
+
+	return resp, data, nil
+}
+
+// waitContent 等待后台上传完成，直到模拟服务中保存的内容和 GET 都返回 content
+// 秒传模式下上传完成前 GET 读取的是本地缓存，因此需要同时检查模拟服务
+func (h *Harness) waitContent(p string, content []byte) error {
+	deadline := time.Now().Add(uploadWait)
+
+	for {
+		stored, ok := h.Drive.content(p)
+		resp, data, err := h.Do(http.MethodGet, p, nil, nil)
+		if err == nil && ok && bytes.Equal(stored, content) && resp.StatusCode == http.StatusOK && bytes.Equal(data, content) {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("GET %s: status %d, %d bytes, content mismatch", p, resp.StatusCode, len(data))
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// waitStatus 等待 GET 返回指定状态
+func (h *Harness) waitStatus(p string, status int) error {
+	deadline := time.Now().Add(uploadWait)
+
+	for {
+		resp, _, err := h.Do(http.MethodGet, p, nil, nil)
+		if err == nil && resp.StatusCode == status {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("GET %s: status %d, expected %d", p, resp.StatusCode, status)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func checkOptions(h *Harness) error {
+	resp, _, err := h.expect(http.MethodOptions, "/", nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	if dav := resp.Header.Get("DAV"); !strings.Contains(dav, "1") || !strings.Contains(dav, "2") {
+		return fmt.Errorf("DAV header %q, expected class 1 and 2", dav)
+	}
+
+	return nil
+}
+
+func checkMkcol(h *Harness) error {
+	_, _, err := h.expect("MKCOL", "/litmus/", nil, nil, http.StatusCreated)
+	return err
+}
+
+func checkMkcolAgain(h *Harness) error {
+	_, _, err := h.expect("MKCOL", "/litmus/", nil, nil, http.StatusMethodNotAllowed)
+	return err
+}
+
+func checkMkcolNoParent(h *Harness) error {
+	_, _, err := h.expect("MKCOL", "/litmus/missing/child/", nil, nil, http.StatusConflict)
+	return err
+}
+
+func checkPutGet(h *Harness) error {
+	content := []byte("hello, aliyundrive\n")
+
+	if _, _, err := h.expect(http.MethodPut, "/litmus/hello.txt", nil, content, http.StatusCreated); err != nil {
+		return err
+	}
+
+	return h.waitContent("/litmus/hello.txt", content)
+}
+
+func checkGetRange(h *Harness) error {
+	_, data, err := h.expect(http.MethodGet, "/litmus/hello.txt", map[string]string{
+		"Range": "bytes=7-17",
+	}, nil, http.StatusPartialContent)
+	if err != nil {
+		return err
+	}
+
+	if string(data) != "aliyundrive" {
+		return fmt.Errorf("range content %q", data)
+	}
+
+	return nil
+}
+
+func checkPutOverwrite(h *Harness) error {
+	content := []byte("overwritten\n")
+
+	resp, data, err := h.Do(http.MethodPut, "/litmus/hello.txt", nil, content)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("PUT status %d: %s", resp.StatusCode, data)
+	}
+
+	return h.waitContent("/litmus/hello.txt", content)
+}
+
+// checkPutLarge 超过分片大小的文件分多片上传
+func checkPutLarge(h *Harness) error {
+	content := bytes.Repeat([]byte("0123456789abcdef"), (defaultPartSize+defaultPartSize/2)/16)
+
+	if _, _, err := h.expect(http.MethodPut, "/litmus/large.bin", nil, content, http.StatusCreated); err != nil {
+		return err
+	}
+
+	return h.waitContent("/litmus/large.bin", content)
+}
+
+// checkPutRapid 相同内容再次上传时通过 proof_code 秒传
+func checkPutRapid(h *Harness) error {
+	content := bytes.Repeat([]byte("rapid upload content "), 1024)
+
+	if _, _, err := h.expect(http.MethodPut, "/litmus/rapid1.txt", nil, content, http.StatusCreated); err != nil {
+		return err
+	}
+	if err := h.waitContent("/litmus/rapid1.txt", content); err != nil {
+		return err
+	}
+
+	uploads := h.Drive.uploadCount()
+
+	if _, _, err := h.expect(http.MethodPut, "/litmus/rapid2.txt", nil, content, http.StatusCreated); err != nil {
+		return err
+	}
+	if err := h.waitContent("/litmus/rapid2.txt", content); err != nil {
+		return err
+	}
+
+	if n := h.Drive.uploadCount(); n != uploads {
+		return fmt.Errorf("expected rapid upload, %d parts uploaded", n-uploads)
+	}
+
+	return nil
+}
+
+func checkPropfind(h *Harness) error {
+	_, data, err := h.expect("PROPFIND", "/litmus/", map[string]string{
+		"Depth": "1",
+	}, nil, http.StatusMultiStatus)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{"/litmus/hello.txt", "/litmus/large.bin", "/litmus/rapid2.txt"} {
+		if !bytes.Contains(data, []byte(name)) {
+			return fmt.Errorf("PROPFIND missing %s", name)
+		}
+	}
+
+	return nil
+}
+
+// checkProppatch 在目录上设置自定义属性，秒传模式下刚上传的文件读取的是本地缓存，不支持自定义属性
+func checkProppatch(h *Harness) error {
+	body := []byte(`<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:Z="http://example.com/ns">
+  <D:set><D:prop><Z:color>blue</Z:color></D:prop></D:set>
+</D:propertyupdate>`)
+
+	if _, _, err := h.expect("PROPPATCH", "/litmus/", nil, body, http.StatusMultiStatus); err != nil {
+		return err
+	}
+
+	_, data, err := h.expect("PROPFIND", "/litmus/", map[string]string{
+		"Depth": "0",
+	}, []byte(`<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><color xmlns="http://example.com/ns"/></D:prop></D:propfind>`), http.StatusMultiStatus)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Contains(data, []byte("blue")) {
+		return errors.New("dead property not returned")
+	}
+
+	return nil
+}
+
+// checkQuotaLiveProps 容量属性只在明确请求时返回，并且不能修改
+func checkQuotaLiveProps(h *Harness) error {
+	_, data, err := h.expect("PROPFIND", "/", map[string]string{"Depth": "0"}, nil, http.StatusMultiStatus)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("quota-used-bytes")) {
+		return errors.New("quota returned for allprop")
+	}
+
+	_, data, err = h.expect("PROPFIND", "/", map[string]string{"Depth": "0"}, []byte(`<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:quota-used-bytes/></D:prop></D:propfind>`), http.StatusMultiStatus)
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(data, []byte("HTTP/1.1 200 OK")) {
+		return fmt.Errorf("quota not returned: %s", data)
+	}
+
+	_, data, err = h.expect("PROPPATCH", "/", nil, []byte(`<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:"><D:set><D:prop><D:quota-used-bytes>1</D:quota-used-bytes></D:prop></D:set></D:propertyupdate>`), http.StatusMultiStatus)
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(data, []byte("HTTP/1.1 403 Forbidden")) {
+		return fmt.Errorf("quota modified: %s", data)
+	}
+
+	return nil
+}
+
+func checkMove(h *Harness) error {
+	if _, _, err := h.expect("MOVE", "/litmus/hello.txt", map[string]string{
+		"Destination": h.URL + "/litmus/moved.txt",
+	}, nil, http.StatusCreated); err != nil {
+		return err
+	}
+
+	if err := h.waitStatus("/litmus/hello.txt", http.StatusNotFound); err != nil {
+		return err
+	}
+
+	return h.waitContent("/litmus/moved.txt", []byte("overwritten\n"))
+}
+
+func checkMoveNoOverwrite(h *Harness) error {
+	_, _, err := h.expect("MOVE", "/litmus/moved.txt", map[string]string{
+		"Destination": h.URL + "/litmus/rapid1.txt",
+		"Overwrite":   "F",
+	}, nil, http.StatusPreconditionFailed)
+	return err
+}
+
+func checkLock(h *Harness) error {
+	body := []byte(`<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`)
+
+	resp, _, err := h.expect("LOCK", "/litmus/moved.txt", map[string]string{
+		"Timeout": "Second-60",
+	}, body, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	token := resp.Header.Get("Lock-Token")
+	if token == "" {
+		return errors.New("LOCK returned no Lock-Token")
+	}
+
+	if _, _, err := h.expect(http.MethodPut, "/litmus/moved.txt", nil, []byte("locked"), http.StatusLocked); err != nil {
+		return err
+	}
+
+	_, _, err = h.expect("UNLOCK", "/litmus/moved.txt", map[string]string{
+		"Lock-Token": token,
+	}, nil, http.StatusNoContent)
+	return err
+}
+
+func checkDelete(h *Harness) error {
+	if _, _, err := h.expect(http.MethodDelete, "/litmus/moved.txt", nil, nil, http.StatusNoContent); err != nil {
+		return err
+	}
+
+	return h.waitStatus("/litmus/moved.txt", http.StatusNotFound)
+}
+
+func checkTrash(h *Harness) error {
+	_, data, err := h.expect("PROPFIND", "/.trash/", map[string]string{
+		"Depth": "1",
+	}, nil, http.StatusMultiStatus)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Contains(data, []byte("moved.txt")) {
+		return errors.New("deleted file not found in /.trash")
+	}
+
+	return nil
+}
+
+// checkTokenRefresh AccessToken 失效后客户端应自动刷新，RefreshToken 随之更换
+func checkTokenRefresh(h *Harness) error {
+	refreshToken := h.Drive.RefreshToken()
+	h.Drive.ExpireAccessTokens()
+
+	if _, _, err := h.expect("MKCOL", "/litmus/refresh/", nil, nil, http.StatusCreated); err != nil {
+		return err
+	}
+
+	if h.Drive.RefreshToken() == refreshToken {
+		return errors.New("refresh token not rotated")
+	}
+
+	return nil
+}
+
+// checkTokenRefreshConcurrent 定时刷新、更换 RefreshToken 和请求发现 AccessToken 失效时同时刷新
+// 模拟服务的 RefreshToken 只能使用一次，没有加锁的刷新会使用已经失效的 RefreshToken
+func checkTokenRefreshConcurrent(h *Harness) error {
+	h.Drive.ExpireAccessTokens()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 30)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(3)
+
+		go func() {
+			defer wg.Done()
+			errs <- h.Token.Refresh("")
+		}()
+
+		go func() {
+			defer wg.Done()
+			credential, err := h.Token.Credential()
+			if err == nil {
+				_, err = api.GetUserInfo(h.aliyun, credential)
+			}
+			errs <- err
+		}()
+
+		go func() {
+			defer wg.Done()
+			_, _, err := h.expect("PROPFIND", "/litmus/", map[string]string{"Depth": "1"}, nil, http.StatusMultiStatus)
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	// 保存的 RefreshToken 是模拟服务最后一次发放的
+	var saved string
+	h.Token.Flush(func(refreshToken string) { saved = refreshToken })
+	if saved != h.Drive.RefreshToken() {
+		return fmt.Errorf("refresh token %s, expected %s", saved, h.Drive.RefreshToken())
+	}
+
+	return nil
+}
+
+// checkQRLogin 扫码确认后应返回当前的 RefreshToken
+func checkQRLogin(h *Harness) error {
+	ctx, cancel := context.WithTimeout(context.Background(), uploadWait)
+	defer cancel()
+
+	client := qrlogin.NewClient()
+
+	session, err := client.Generate(ctx)
+	if err != nil {
+		return err
+	}
+
+	status, err := client.Query(ctx, session)
+	if err != nil {
+		return err
+	}
+	if status.State != qrlogin.StateNew {
+		return fmt.Errorf("state %s, want %s", status.State, qrlogin.StateNew)
+	}
+
+	h.Drive.ConfirmQRCodes()
+
+	token, err := client.Wait(ctx, session, nil)
+	if err != nil {
+		return err
+	}
+
+	if token != h.Drive.RefreshToken() {
+		return errors.New("unexpected refresh token " + token)
+	}
+
+	return nil
+}
+
+// syncTokenPattern 从 sync-collection 响应中取出 sync-token
+var syncTokenPattern = regexp.MustCompile(`<D:sync-token>([^<]*)</D:sync-token>`)
+
+// syncCollection 发送无限深度的 sync-collection 请求，返回响应和新 token
+func (h *Harness) syncCollection(p, token string) (string, string, error) {
+	body := []byte(`<?xml version="1.0" encoding="utf-8"?>
+<D:sync-collection xmlns:D="DAV:"><D:sync-token>` + token + `</D:sync-token><D:sync-level>infinite</D:sync-level><D:prop><D:getetag/></D:prop></D:sync-collection>`)
+
+	_, data, err := h.expect("REPORT", p, nil, body, http.StatusMultiStatus)
+	if err != nil {
+		return "", "", err
+	}
+
+	m := syncTokenPattern.FindSubmatch(data)
+	if m == nil {
+		return "", "", errors.New("sync-token not returned")
+	}
+
+	return string(data), string(m[1]), nil
+}
+
+func checkSyncMoveCollection(h *Harness) error {
+	if _, _, err := h.expect("MKCOL", "/litmus/syncsrc/", nil, nil, http.StatusCreated); err != nil {
+		return err
+	}
+	if _, _, err := h.expect(http.MethodPut, "/litmus/syncsrc/a.txt", nil, []byte("sync\n"), http.StatusCreated); err != nil {
+		return err
+	}
+	if err := h.waitContent("/litmus/syncsrc/a.txt", []byte("sync\n")); err != nil {
+		return err
+	}
+
+	_, token, err := h.syncCollection("/litmus/", "")
+	if err != nil {
+		return err
+	}
+
+	if _, _, err := h.expect("MOVE", "/litmus/syncsrc/", map[string]string{
+		"Destination": h.URL + "/litmus/syncdst/",
+	}, nil, http.StatusCreated); err != nil {
+		return err
+	}
+
+	data, _, err := h.syncCollection("/litmus/", token)
+	if err != nil {
+		return err
+	}
+
+	// 移动目录后，原目录下的文件应报告删除，新目录下的文件应报告新增
+	removed := regexp.MustCompile(`<D:href>/litmus/syncsrc/a.txt</D:href><D:status>HTTP/1.1 404`)
+	if !removed.MatchString(data) {
+		return fmt.Errorf("descendant of moved collection not reported deleted: %s", data)
+	}
+	if !strings.Contains(data, "<D:href>/litmus/syncdst/a.txt</D:href><D:propstat>") {
+		return fmt.Errorf("descendant of moved collection not reported: %s", data)
+	}
+
+	return nil
+}
+
+// search 发送 basicsearch 请求，返回响应中的 href
+func (h *Harness) search(scope, depth, where string) ([]string, error) {
+	body := []byte(`<?xml version="1.0" encoding="utf-8"?>
+<D:searchrequest xmlns:D="DAV:"><D:basicsearch>
+  <D:select><D:prop><D:getlastmodified/></D:prop></D:select>
+  <D:from><D:scope><D:href>` + scope + `</D:href><D:depth>` + depth + `</D:depth></D:scope></D:from>
+  ` + where + `
+</D:basicsearch></D:searchrequest>`)
+
+	_, data, err := h.expect("SEARCH", "/", nil, body, http.StatusMultiStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	var hrefs []string
+	for _, m := range hrefPattern.FindAllSubmatch(data, -1) {
+		hrefs = append(hrefs, string(m[1]))
+	}
+
+	return hrefs, nil
+}
+
+// hrefPattern 从 multistatus 响应中取出 href
+var hrefPattern = regexp.MustCompile(`<D:href>([^<]*)</D:href>`)
+
+// checkSearchScope 检查搜索范围和按保留的修改时间搜索
+func checkSearchScope(h *Harness) error {
+	for _, p := range []string{"/litmus/find/", "/litmus/find/old/", "/litmus/other/", "/litmus/other/old/"} {
+		if _, _, err := h.expect("MKCOL", p, nil, nil, http.StatusCreated); err != nil {
+			return err
+		}
+	}
+
+	if _, _, err := h.expect("PROPPATCH", "/litmus/find/old/", nil, []byte(`<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:"><D:set><D:prop><D:getlastmodified>Sat, 01 Jan 2000 00:00:00 GMT</D:getlastmodified></D:prop></D:set></D:propertyupdate>`), http.StatusMultiStatus); err != nil {
+		return err
+	}
+
+	// depth 0 只返回 scope 本身
+	hrefs, err := h.search("/litmus/find/", "0", "")
+	if err != nil {
+		return err
+	}
+	if len(hrefs) != 1 || hrefs[0] != "/litmus/find/" {
+		return fmt.Errorf("depth 0 search returned %v", hrefs)
+	}
+
+	// 递归搜索只返回 scope 下的文件
+	hrefs, err = h.search("/litmus/find/", "infinity", `<D:where><D:eq><D:prop><D:displayname/></D:prop><D:literal>old</D:literal></D:eq></D:where>`)
+	if err != nil {
+		return err
+	}
+	if len(hrefs) != 1 || hrefs[0] != "/litmus/find/old/" {
+		return fmt.Errorf("scoped search returned %v", hrefs)
+	}
+
+	// 修改时间按 PROPPATCH 设置的值比较
+	hrefs, err = h.search("/litmus/", "infinity", `<D:where><D:lt><D:prop><D:getlastmodified/></D:prop><D:literal>Mon, 01 Jan 2001 00:00:00 GMT</D:literal></D:lt></D:where>`)
+	if err != nil {
+		return err
+	}
+	if len(hrefs) != 1 || hrefs[0] != "/litmus/find/old/" {
+		return fmt.Errorf("getlastmodified search returned %v", hrefs)
+	}
+
+	return nil
+}
+
+func checkDeleteCollection(h *Harness) error {
+	if _, _, err := h.expect(http.MethodDelete, "/litmus/", nil, nil, http.StatusNoContent); err != nil {
+		return err
+	}
+
+	_, _, err := h.expect("PROPFIND", "/litmus/", map[string]string{
+		"Depth": "0",
+	}, nil, http.StatusNotFound)
+	return err
+}
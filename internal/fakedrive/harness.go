@@ -0,0 +1,130 @@
+package fakedrive
+
+import (
+	"context"
+	"github.com/jakeslee/aliyundrive"
+	"github.com/jakeslee/aliyundrive-webdav/internal/api"
+	"github.com/jakeslee/aliyundrive-webdav/internal/backend"
+	aliWebdav "github.com/jakeslee/aliyundrive-webdav/internal/webdav"
+	"golang.org/x/net/webdav"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// initialRefreshToken 模拟服务启动时的 RefreshToken
+const initialRefreshToken = "fakedrive-initial-refresh-token"
+
+// Harness 集成测试环境：模拟的阿里云盘服务，以及通过 aliyundrive 客户端连接它的 WebDAV 服务
+type Harness struct {
+	Drive  *Server
+	URL    string
+	Client *http.Client
+	Token  *api.Token // WebDAV 服务使用的 Token
+
+	aliyun  *aliyundrive.AliyunDrive
+	workDir string
+	server  *http.Server
+}
+
+// NewHarness 启动模拟服务和 WebDAV 服务
+// aliyundrive 的接口地址是常量，这里通过 HTTPS_PROXY 和 SSL_CERT_FILE 把请求转到模拟服务，
+// 因此需要在进程发起任何 HTTPS 请求之前调用
+func NewHarness() (*Harness, error) {
+	drive := NewServer(initialRefreshToken)
+	if err := drive.Start(""); err != nil {
+		return nil, err
+	}
+
+	h := &Harness{
+		Drive: drive,
+		Client: &http.Client{
+			Transport: &http.Transport{Proxy: nil},
+			Timeout:   time.Minute,
+		},
+	}
+
+	if err := h.start(); err != nil {
+		_ = h.Close()
+		return nil, err
+	}
+
+	return h, nil
+}
+
+func (h *Harness) start() error {
+	workDir, err := ioutil.TempDir("", "fakedrive-")
+	if err != nil {
+		return err
+	}
+	h.workDir = workDir
+
+	caFile := filepath.Join(workDir, "ca.pem")
+	if err := ioutil.WriteFile(caFile, h.Drive.CACertPEM(), 0644); err != nil {
+		return err
+	}
+
+	if err := os.Setenv("SSL_CERT_FILE", caFile); err != nil {
+		return err
+	}
+	if err := os.Setenv("HTTPS_PROXY", h.Drive.URL); err != nil {
+		return err
+	}
+
+	client := aliyundrive.NewClient(&aliyundrive.Options{})
+	h.aliyun = client
+
+	h.Token = api.NewToken(client, aliyundrive.NewCredential(&aliyundrive.Credential{
+		RefreshToken: initialRefreshToken,
+	}))
+	if err := h.Token.Refresh(""); err != nil {
+		return err
+	}
+
+	fileSystem := aliWebdav.NewAliDriveFS(backend.NewAliyun(client, h.Token), &aliWebdav.Options{
+		RapidUpload: true,
+		WorkDir:     workDir,
+	})
+
+	handler := &aliWebdav.Handler{
+		Handler: webdav.Handler{
+			FileSystem: fileSystem,
+			LockSystem: webdav.NewMemLS(),
+		},
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	h.URL = "http://" + listener.Addr().String()
+	h.server = &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), aliWebdav.CtxSizeValue, r.ContentLength)
+			handler.ServeHTTP(w, r.WithContext(ctx))
+		}),
+	}
+
+	go func() {
+		_ = h.server.Serve(listener)
+	}()
+
+	return nil
+}
+
+// Close 停止服务并删除临时目录
+func (h *Harness) Close() error {
+	if h.server != nil {
+		_ = h.server.Close()
+	}
+
+	if h.workDir != "" {
+		_ = os.RemoveAll(h.workDir)
+	}
+
+	return h.Drive.Close()
+}
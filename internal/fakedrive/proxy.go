@@ -0,0 +1,170 @@
+package fakedrive
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// proxyHosts 代理接管的域名，aliyundrive 和 qrlogin 中这些地址是常量，只能通过 HTTPS_PROXY 转到本地
+var proxyHosts = []string{"api.aliyundrive.com", "auth.aliyundrive.com", "passport.aliyundrive.com"}
+
+var errUnknownHost = errors.New("fakedrive: unknown proxy host")
+
+// connectProxy 处理 CONNECT 请求，使用自签名证书终止 TLS 后交给模拟服务处理
+type connectProxy struct {
+	caPEM []byte
+	cert  tls.Certificate
+}
+
+func newConnectProxy() (*connectProxy, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fakedrive CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: proxyHosts[0]},
+		DNSNames:     proxyHosts,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	leafDER, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &connectProxy{
+		caPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		cert: tls.Certificate{
+			Certificate: [][]byte{leafDER, caDER},
+			PrivateKey:  key,
+		},
+	}, nil
+}
+
+func (p *connectProxy) serveConnect(w http.ResponseWriter, r *http.Request, handler http.Handler) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	known := false
+	for _, h := range proxyHosts {
+		known = known || h == host
+	}
+	if !known {
+		http.Error(w, errUnknownHost.Error(), http.StatusForbidden)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijack unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+
+	if _, err := buf.WriteString("HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil || buf.Flush() != nil {
+		_ = conn.Close()
+		return
+	}
+
+	tlsConn := tls.Server(conn, &tls.Config{
+		Certificates: []tls.Certificate{p.cert},
+		NextProtos:   []string{"http/1.1"},
+	})
+
+	go func() {
+		_ = http.Serve(newConnListener(tlsConn), handler)
+	}()
+}
+
+// connListener 只返回一个连接的 Listener，连接关闭后 Accept 返回错误
+type connListener struct {
+	conn   net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	return &connListener{conn: conn, closed: make(chan struct{})}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+
+	l.once.Do(func() {
+		conn = &notifyConn{Conn: l.conn, closed: l.closed}
+	})
+
+	if conn != nil {
+		return conn, nil
+	}
+
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+func (l *connListener) Close() error {
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// notifyConn 关闭时通知 connListener 结束 Accept
+type notifyConn struct {
+	net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *notifyConn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+
+	return c.Conn.Close()
+}
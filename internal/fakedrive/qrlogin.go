@@ -0,0 +1,74 @@
+package fakedrive
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+)
+
+// qrCode 扫码登录生成的二维码，confirmed 后查询返回当前的 RefreshToken
+type qrCode struct {
+	t         int
+	confirmed bool
+}
+
+// ConfirmQRCodes 模拟在手机上确认全部未过期的二维码
+func (s *Server) ConfirmQRCodes() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, code := range s.qrCodes {
+		code.confirmed = true
+	}
+}
+
+func (s *Server) handleQRGenerate(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	ck := s.nextId("ck")
+	code := &qrCode{t: s.seq}
+	s.qrCodes[ck] = code
+	s.mu.Unlock()
+
+	writeQRResponse(w, map[string]interface{}{
+		"t":           code.t,
+		"ck":          ck,
+		"codeContent": "https://passport.aliyundrive.com/qrcodeCheck.htm?ck=" + ck,
+	})
+}
+
+func (s *Server) handleQRQuery(w http.ResponseWriter, r *http.Request) {
+	ck := r.PostFormValue("ck")
+
+	s.mu.Lock()
+	code, ok := s.qrCodes[ck]
+	if ok && code.confirmed {
+		delete(s.qrCodes, ck)
+	}
+	refreshToken := s.refreshToken
+	s.mu.Unlock()
+
+	switch {
+	case !ok:
+		writeQRResponse(w, map[string]interface{}{"qrCodeStatus": "EXPIRED"})
+	case code.confirmed:
+		ext, _ := json.Marshal(map[string]interface{}{
+			"pds_login_result": map[string]string{"refreshToken": refreshToken},
+		})
+		writeQRResponse(w, map[string]interface{}{
+			"qrCodeStatus": "CONFIRMED",
+			"bizExt":       base64.StdEncoding.EncodeToString(ext),
+		})
+	default:
+		writeQRResponse(w, map[string]interface{}{"qrCodeStatus": "NEW"})
+	}
+}
+
+func writeQRResponse(w http.ResponseWriter, data map[string]interface{}) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"content": map[string]interface{}{
+			"data":    data,
+			"success": true,
+		},
+		"hasError": false,
+	})
+}
@@ -0,0 +1,1067 @@
+// Package fakedrive 本地模拟的阿里云盘接口，用于在没有网络的环境中做集成测试
+// 只实现 aliyundrive 和 internal/api 用到的接口，数据保存在内存中
+package fakedrive
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/jakeslee/aliyundrive/models"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DriveId 模拟网盘的 drive_id
+	DriveId = "fake-drive"
+
+	// UserId 模拟用户的 user_id
+	UserId = "fake-user"
+
+	rootFileId = "root"
+
+	// defaultPartSize 上传分片大小，和 aliyundrive 保持一致
+	defaultPartSize = 10 * 1024 * 1024
+
+	// totalSize 网盘总容量
+	totalSize = 1 << 40
+
+	downloadTimeLayout = "2006-01-02T15:04:05.000Z"
+)
+
+var searchCondition = regexp.MustCompile(`^(name|parent_file_id|type|updated_at)\s*(match|=|>=|<=|>|<)\s*"((?:[^"\\]|\\.)*)"$`)
+
+type fakeFile struct {
+	file    models.File
+	data    []byte
+	trashed bool
+}
+
+type fakeUpload struct {
+	fileId string
+	parts  map[int][]byte
+}
+
+// Server 模拟的阿里云盘服务，通过 CONNECT 代理接收 api、auth 和 passport.aliyundrive.com 的请求，
+// 上传、下载地址直接指向 URL
+type Server struct {
+	URL string
+
+	mu           sync.Mutex
+	refreshToken string
+	accessTokens map[string]bool
+	files        map[string]*fakeFile
+	uploads      map[string]*fakeUpload
+	qrCodes      map[string]*qrCode
+	seq          int
+	partCount    int
+	mux          *http.ServeMux
+	proxy        *connectProxy
+	server       *http.Server
+}
+
+// NewServer 创建模拟服务，refreshToken 为唯一有效的 RefreshToken，每次刷新后更换
+func NewServer(refreshToken string) *Server {
+	now := time.Now()
+
+	s := &Server{
+		refreshToken: refreshToken,
+		accessTokens: make(map[string]bool),
+		files: map[string]*fakeFile{
+			rootFileId: {
+				file: models.File{
+					DriveId:   DriveId,
+					Name:      rootFileId,
+					Type:      models.FileTypeFolder,
+					FileId:    rootFileId,
+					Status:    models.FileStatusAvailable,
+					CreatedAt: now,
+					UpdatedAt: now,
+				},
+			},
+		},
+		uploads: make(map[string]*fakeUpload),
+		qrCodes: make(map[string]*qrCode),
+		mux:     http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/v2/account/token", s.handleToken)
+	s.mux.HandleFunc("/v2/user/get", s.auth(s.handleUser))
+	s.mux.HandleFunc("/v2/databox/get_personal_info", s.auth(s.handlePersonalInfo))
+	s.mux.HandleFunc("/v2/file/list", s.auth(s.handleList))
+	s.mux.HandleFunc("/v2/file/get", s.auth(s.handleGet))
+	s.mux.HandleFunc("/v2/file/get_download_url", s.auth(s.handleDownloadURL))
+	s.mux.HandleFunc("/v2/file/search", s.auth(s.handleSearch))
+	s.mux.HandleFunc("/v2/file/update", s.auth(s.handleRename))
+	s.mux.HandleFunc("/v2/file/move", s.auth(s.handleMove))
+	s.mux.HandleFunc("/v2/file/complete", s.auth(s.handleComplete))
+	s.mux.HandleFunc("/v2/file/delete", s.auth(s.handleDelete))
+	s.mux.HandleFunc("/v2/file/list_revisions", s.auth(s.handleRevisions))
+	s.mux.HandleFunc("/v2/recyclebin/trash", s.auth(s.handleTrash))
+	s.mux.HandleFunc("/v2/recyclebin/list", s.auth(s.handleRecycleBin))
+	s.mux.HandleFunc("/v2/recyclebin/restore", s.auth(s.handleRestore))
+	s.mux.HandleFunc("/adrive/v2/file/createWithFolders", s.auth(s.handleCreate))
+	s.mux.HandleFunc("/adrive/v1/file/get_path", s.auth(s.handleGetPath))
+	s.mux.HandleFunc("/upload/", s.handleUploadPart)
+	s.mux.HandleFunc("/download/", s.handleDownload)
+	s.mux.HandleFunc("/newlogin/qrcode/generate.do", s.handleQRGenerate)
+	s.mux.HandleFunc("/newlogin/qrcode/query.do", s.handleQRQuery)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect && s.proxy != nil {
+		s.proxy.serveConnect(w, r, s.mux)
+		return
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+// Start 在 addr 上监听，addr 为空时使用随机端口
+func (s *Server) Start(addr string) error {
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+
+	proxy, err := newConnectProxy()
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.proxy = proxy
+	s.URL = "http://" + listener.Addr().String()
+	s.server = &http.Server{Handler: s}
+
+	go func() {
+		_ = s.server.Serve(listener)
+	}()
+
+	return nil
+}
+
+// Close 停止监听，已建立的代理连接不受影响
+func (s *Server) Close() error {
+	if s.server == nil {
+		return nil
+	}
+
+	return s.server.Close()
+}
+
+// CACertPEM 返回代理证书的 CA，需要加入客户端信任的证书中
+func (s *Server) CACertPEM() []byte {
+	if s.proxy == nil {
+		return nil
+	}
+
+	return s.proxy.caPEM
+}
+
+// RefreshToken 返回当前有效的 RefreshToken
+func (s *Server) RefreshToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.refreshToken
+}
+
+// uploadCount 返回已上传的分片数量，秒传不会上传分片
+func (s *Server) uploadCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.partCount
+}
+
+// content 按路径返回已上传完成的文件内容
+func (s *Server) content(p string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f := s.files[rootFileId]
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if f = s.exists(f.file.FileId, name); f == nil {
+			return nil, false
+		}
+	}
+
+	if f.file.Type != models.FileTypeFile {
+		return nil, false
+	}
+
+	return f.data, true
+}
+
+// ExpireAccessTokens 使全部 AccessToken 失效，用于测试自动刷新
+func (s *Server) ExpireAccessTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accessTokens = make(map[string]bool)
+}
+
+func (s *Server) nextId(prefix string) string {
+	s.seq++
+	return fmt.Sprintf("%s%024x", prefix, s.seq)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]string{
+		"code":    code,
+		"message": message,
+	})
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// auth 校验 AccessToken，失效时返回 AccessTokenInvalid 触发客户端刷新
+func (s *Server) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		s.mu.Lock()
+		ok := s.accessTokens[token]
+		s.mu.Unlock()
+
+		if !ok {
+			writeError(w, http.StatusUnauthorized, models.CodeAccessTokenInvalid, "AccessToken is invalid")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameter", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if req.RefreshToken == "" || req.RefreshToken != s.refreshToken {
+		writeError(w, http.StatusBadRequest, "InvalidParameter.RefreshToken", "The input parameter refresh_token is not valid.")
+		return
+	}
+
+	accessToken := s.nextId("access")
+	s.accessTokens[accessToken] = true
+	s.refreshToken = s.nextId("refresh")
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":     accessToken,
+		"refresh_token":    s.refreshToken,
+		"expires_in":       7200,
+		"token_type":       "Bearer",
+		"user_id":          UserId,
+		"user_name":        "fake",
+		"nick_name":        "fake",
+		"default_drive_id": DriveId,
+	})
+}
+
+func (s *Server) handleUser(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"user_id":           UserId,
+		"user_name":         "fake",
+		"nick_name":         "fake",
+		"default_drive_id":  DriveId,
+		"backup_drive_id":   DriveId,
+		"resource_drive_id": DriveId,
+	})
+}
+
+func (s *Server) handlePersonalInfo(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	var used int64
+	for _, f := range s.files {
+		used += int64(len(f.data))
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"personal_space_info": map[string]int64{
+			"used_size":  used,
+			"total_size": totalSize,
+		},
+	})
+}
+
+// lookup 返回未删除的文件，调用时需要持有锁
+func (s *Server) lookup(fileId string) (*fakeFile, bool) {
+	f, ok := s.files[fileId]
+	if !ok || f.trashed || f.file.Status != models.FileStatusAvailable {
+		return nil, false
+	}
+
+	return f, true
+}
+
+// children 返回目录下未删除的文件，调用时需要持有锁
+func (s *Server) children(parentFileId string) []*fakeFile {
+	var result []*fakeFile
+	for _, f := range s.files {
+		if f.file.ParentFileId == parentFileId && f.file.FileId != rootFileId && !f.trashed && f.file.Status == models.FileStatusAvailable {
+			result = append(result, f)
+		}
+	}
+
+	return result
+}
+
+// page 按 marker 分页，marker 为下一页的起始位置
+func page(files []*fakeFile, marker string, limit int) ([]models.File, string) {
+	start, _ := strconv.Atoi(marker)
+	if limit <= 0 {
+		limit = 100
+	}
+
+	items := make([]models.File, 0, limit)
+	for i := start; i < len(files) && len(items) < limit; i++ {
+		items = append(items, files[i].file)
+	}
+
+	next := ""
+	if start+len(items) < len(files) {
+		next = strconv.Itoa(start + len(items))
+	}
+
+	return items, next
+}
+
+func sortFiles(files []*fakeFile, orderBy, direction string) {
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := &files[i].file, &files[j].file
+		if strings.EqualFold(direction, models.OrderDirectionTypeDescend) {
+			a, b = b, a
+		}
+
+		switch orderBy {
+		case "updated_at":
+			return a.UpdatedAt.Before(b.UpdatedAt)
+		case "created_at":
+			return a.CreatedAt.Before(b.CreatedAt)
+		case "size":
+			return a.Size < b.Size
+		}
+
+		return a.Name < b.Name
+	})
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ParentFileId   string `json:"parent_file_id"`
+		Marker         string `json:"marker"`
+		Limit          int    `json:"limit"`
+		OrderBy        string `json:"order_by"`
+		OrderDirection string `json:"order_direction"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameter", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.lookup(req.ParentFileId); !ok {
+		writeError(w, http.StatusNotFound, "NotFound.File", "The resource file cannot be found.")
+		return
+	}
+
+	files := s.children(req.ParentFileId)
+	sortFiles(files, req.OrderBy, req.OrderDirection)
+
+	items, next := page(files, req.Marker, req.Limit)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"items":       items,
+		"next_marker": next,
+	})
+}
+
+type fileIdRequest struct {
+	FileId     string `json:"file_id"`
+	RevisionId string `json:"revision_id"`
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	var req fileIdRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameter", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.lookup(req.FileId)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NotFound.File", "The resource file cannot be found.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, f.file)
+}
+
+func (s *Server) handleDownloadURL(w http.ResponseWriter, r *http.Request) {
+	var req fileIdRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameter", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.lookup(req.FileId)
+	if !ok || f.file.Type == models.FileTypeFolder {
+		writeError(w, http.StatusNotFound, "NotFound.File", "The resource file cannot be found.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"url":        s.URL + "/download/" + req.FileId,
+		"size":       len(f.data),
+		"method":     http.MethodGet,
+		"expiration": time.Now().Add(4 * time.Hour).UTC().Format(downloadTimeLayout),
+	})
+}
+
+// compareTime 按查询语句的时间格式比较 updated_at，精确到秒
+func compareTime(t time.Time, op, value string) bool {
+	v, err := time.Parse("2006-01-02T15:04:05", value)
+	if err != nil {
+		return false
+	}
+
+	t = t.UTC().Truncate(time.Second)
+
+	switch op {
+	case ">":
+		return t.After(v)
+	case ">=":
+		return !t.Before(v)
+	case "<":
+		return t.Before(v)
+	case "<=":
+		return !t.After(v)
+	}
+
+	return t.Equal(v)
+}
+
+// handleSearch 只支持 name match、parent_file_id =、type =、updated_at 比较用 and 连接的条件
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query  string `json:"query"`
+		Marker string `json:"marker"`
+		Limit  int    `json:"limit"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameter", err.Error())
+		return
+	}
+
+	query := strings.NewReplacer("(", "", ")", "").Replace(req.Query)
+
+	var conditions [][]string
+	for _, cond := range strings.Split(query, " and ") {
+		m := searchCondition.FindStringSubmatch(strings.TrimSpace(cond))
+		if m == nil {
+			writeError(w, http.StatusBadRequest, "InvalidParameter.Query", "unsupported query "+cond)
+			return
+		}
+
+		value, err := strconv.Unquote(`"` + m[3] + `"`)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidParameter.Query", err.Error())
+			return
+		}
+
+		conditions = append(conditions, []string{m[1], m[2], value})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var files []*fakeFile
+	for _, f := range s.files {
+		if f.file.FileId == rootFileId || f.trashed || f.file.Status != models.FileStatusAvailable {
+			continue
+		}
+
+		matched := true
+		for _, cond := range conditions {
+			switch cond[0] {
+			case "name":
+				matched = matched && strings.Contains(strings.ToLower(f.file.Name), strings.ToLower(cond[2]))
+			case "parent_file_id":
+				matched = matched && f.file.ParentFileId == cond[2]
+			case "type":
+				matched = matched && string(f.file.Type) == cond[2]
+			case "updated_at":
+				matched = matched && compareTime(f.file.UpdatedAt, cond[1], cond[2])
+			}
+		}
+
+		if matched {
+			files = append(files, f)
+		}
+	}
+
+	sortFiles(files, "name", "ASC")
+
+	items, next := page(files, req.Marker, req.Limit)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"items":       items,
+		"next_marker": next,
+	})
+}
+
+// exists 目录下是否已有同名文件，调用时需要持有锁
+func (s *Server) exists(parentFileId, name string) *fakeFile {
+	for _, f := range s.children(parentFileId) {
+		if f.file.Name == name {
+			return f
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) handleRename(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		FileId string `json:"file_id"`
+		Name   string `json:"name"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameter", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.lookup(req.FileId)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NotFound.File", "The resource file cannot be found.")
+		return
+	}
+
+	if other := s.exists(f.file.ParentFileId, req.Name); other != nil && other != f {
+		writeError(w, http.StatusConflict, "AlreadyExist.File", "The resource file has already exists.")
+		return
+	}
+
+	f.file.Name = req.Name
+	f.file.UpdatedAt = time.Now()
+
+	writeJSON(w, http.StatusOK, f.file)
+}
+
+func (s *Server) handleMove(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		FileId         string `json:"file_id"`
+		ToParentFileId string `json:"to_parent_file_id"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameter", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.lookup(req.FileId)
+	to, toOk := s.lookup(req.ToParentFileId)
+	if !ok || !toOk || to.file.Type != models.FileTypeFolder {
+		writeError(w, http.StatusNotFound, "NotFound.File", "The resource file cannot be found.")
+		return
+	}
+
+	for id := req.ToParentFileId; id != rootFileId; id = s.files[id].file.ParentFileId {
+		if id == req.FileId {
+			writeError(w, http.StatusBadRequest, "ForbiddenMoveToChild", "cannot move a folder into itself")
+			return
+		}
+	}
+
+	if s.exists(req.ToParentFileId, f.file.Name) != nil {
+		writeError(w, http.StatusConflict, "AlreadyExist.File", "The resource file has already exists.")
+		return
+	}
+
+	f.file.ParentFileId = req.ToParentFileId
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"file_id":  f.file.FileId,
+		"drive_id": DriveId,
+	})
+}
+
+// handleCreate 创建目录或文件，文件提供 proof_code 且内容已存在时秒传
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ParentFileId  string          `json:"parent_file_id"`
+		Name          string          `json:"name"`
+		Type          models.FileType `json:"type"`
+		Size          int64           `json:"size"`
+		CheckNameMode string          `json:"check_name_mode"`
+		PreHash       string          `json:"pre_hash"`
+		ContentHash   string          `json:"content_hash"`
+		ProofCode     string          `json:"proof_code"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameter", err.Error())
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parent, ok := s.lookup(req.ParentFileId)
+	if !ok || parent.file.Type != models.FileTypeFolder {
+		writeError(w, http.StatusNotFound, "NotFound.File", "The resource file cannot be found.")
+		return
+	}
+
+	name := req.Name
+	if existing := s.exists(req.ParentFileId, name); existing != nil {
+		switch req.CheckNameMode {
+		case "refuse":
+			if req.Type == models.FileTypeFolder && existing.file.Type == models.FileTypeFolder {
+				writeJSON(w, http.StatusCreated, existing.file)
+				return
+			}
+			writeError(w, http.StatusConflict, "AlreadyExist.File", "The resource file has already exists.")
+			return
+		case "auto_rename":
+			ext := path.Ext(name)
+			for i := 1; s.exists(req.ParentFileId, name) != nil; i++ {
+				name = fmt.Sprintf("%s(%d)%s", strings.TrimSuffix(req.Name, ext), i, ext)
+			}
+		}
+	}
+
+	now := time.Now()
+	f := &fakeFile{
+		file: models.File{
+			DriveId:      DriveId,
+			Name:         name,
+			Type:         req.Type,
+			FileId:       s.nextId("file"),
+			ParentFileId: req.ParentFileId,
+			Status:       models.FileStatusAvailable,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		},
+	}
+
+	if req.Type == models.FileTypeFolder {
+		s.files[f.file.FileId] = f
+		writeJSON(w, http.StatusCreated, f.file)
+		return
+	}
+
+	// 预秒传：前 1KB 的 SHA1 匹配到已有文件
+	if req.PreHash != "" && s.findByPreHash(req.PreHash, req.Size) != nil {
+		writeError(w, http.StatusConflict, models.CodePreHashMatched, "Pre hash matched.")
+		return
+	}
+
+	resp := map[string]interface{}{
+		"drive_id":       DriveId,
+		"file_id":        f.file.FileId,
+		"file_name":      name,
+		"parent_file_id": req.ParentFileId,
+		"type":           models.FileTypeFile,
+	}
+
+	if req.ProofCode != "" {
+		if source := s.findByContentHash(req.ContentHash, req.Size); source != nil && proofCode(token, source.data) == req.ProofCode {
+			f.data = source.data
+			s.setContent(f)
+			s.files[f.file.FileId] = f
+
+			resp["rapid_upload"] = true
+			writeJSON(w, http.StatusCreated, resp)
+			return
+		}
+	}
+
+	uploadId := s.nextId("upload")
+	s.uploads[uploadId] = &fakeUpload{fileId: f.file.FileId, parts: make(map[int][]byte)}
+
+	// 上传完成前文件不可见
+	f.file.Status = "uploading"
+	s.files[f.file.FileId] = f
+
+	var parts []map[string]interface{}
+	for i := int64(0); i*defaultPartSize < req.Size || (i == 0 && req.Size == 0); i++ {
+		size := req.Size - i*defaultPartSize
+		if size > defaultPartSize {
+			size = defaultPartSize
+		}
+
+		parts = append(parts, map[string]interface{}{
+			"part_number": i + 1,
+			"part_size":   size,
+			"upload_url":  fmt.Sprintf("%s/upload/%s/%d", s.URL, uploadId, i+1),
+		})
+
+		if req.Size == 0 {
+			break
+		}
+	}
+
+	resp["upload_id"] = uploadId
+	resp["rapid_upload"] = false
+	resp["part_info_list"] = parts
+
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func (s *Server) findByPreHash(preHash string, size int64) *fakeFile {
+	for _, f := range s.files {
+		if f.file.Type != models.FileTypeFile || f.file.Status != models.FileStatusAvailable || int64(len(f.data)) != size {
+			continue
+		}
+
+		head := f.data
+		if len(head) > 1024 {
+			head = head[:1024]
+		}
+
+		if fmt.Sprintf("%x", sha1.Sum(head)) == preHash {
+			return f
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) findByContentHash(contentHash string, size int64) *fakeFile {
+	for _, f := range s.files {
+		if f.file.Type == models.FileTypeFile && f.file.Status == models.FileStatusAvailable &&
+			int64(len(f.data)) == size && strings.EqualFold(f.file.ContentHash, contentHash) {
+			return f
+		}
+	}
+
+	return nil
+}
+
+// proofCode 和 aliyundrive.ComputeProofCodeV1 的算法相同
+func proofCode(accessToken string, data []byte) string {
+	size := int64(len(data))
+	if size == 0 {
+		return ""
+	}
+
+	hashed := fmt.Sprintf("%x", md5.Sum([]byte(accessToken)))[0:16]
+	n, _ := new(big.Int).SetString(hashed, 16)
+
+	start := n.Mod(n, big.NewInt(size)).Int64()
+	end := start + 8
+	if end > size {
+		end = size
+	}
+
+	return base64.StdEncoding.EncodeToString(data[start:end])
+}
+
+// setContent 根据内容更新文件大小和 HASH
+func (s *Server) setContent(f *fakeFile) {
+	f.file.Size = int64(len(f.data))
+	f.file.ContentHash = strings.ToUpper(fmt.Sprintf("%x", sha1.Sum(f.data)))
+	f.file.ContentHashName = "sha1"
+	f.file.Status = models.FileStatusAvailable
+	f.file.UpdatedAt = time.Now()
+}
+
+func (s *Server) handleUploadPart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/upload/"), "/")
+	if len(segments) != 2 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	part, err := strconv.Atoi(segments[1])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, ok := s.uploads[segments[0]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	upload.parts[part] = data
+	s.partCount++
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleComplete(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		FileId   string `json:"file_id"`
+		UploadId string `json:"upload_id"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameter", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, ok := s.uploads[req.UploadId]
+	if !ok || upload.fileId != req.FileId {
+		writeError(w, http.StatusNotFound, "NotFound.UploadId", "The resource upload_id cannot be found.")
+		return
+	}
+
+	f := s.files[req.FileId]
+
+	var numbers []int
+	for n := range upload.parts {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	f.data = nil
+	for _, n := range numbers {
+		f.data = append(f.data, upload.parts[n]...)
+	}
+
+	// 同名文件在上传期间被创建时，完成后覆盖
+	if existing := s.exists(f.file.ParentFileId, f.file.Name); existing != nil {
+		delete(s.files, existing.file.FileId)
+	}
+
+	s.setContent(f)
+	delete(s.uploads, req.UploadId)
+
+	resp := map[string]interface{}{}
+	raw, _ := json.Marshal(f.file)
+	_ = json.Unmarshal(raw, &resp)
+	resp["upload_id"] = req.UploadId
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	fileId := strings.TrimPrefix(r.URL.Path, "/download/")
+
+	s.mu.Lock()
+	f, ok := s.lookup(fileId)
+	var data []byte
+	var modTime time.Time
+	if ok {
+		data, modTime = f.data, f.file.UpdatedAt
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	http.ServeContent(w, r, "", modTime, strings.NewReader(string(data)))
+}
+
+func (s *Server) handleTrash(w http.ResponseWriter, r *http.Request) {
+	var req fileIdRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameter", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.lookup(req.FileId)
+	if !ok || req.FileId == rootFileId {
+		writeError(w, http.StatusNotFound, "NotFound.File", "The resource file cannot be found.")
+		return
+	}
+
+	f.trashed = true
+	f.file.UpdatedAt = time.Now()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleRecycleBin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Marker string `json:"marker"`
+		Limit  int    `json:"limit"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameter", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var files []*fakeFile
+	for _, f := range s.files {
+		if f.trashed {
+			files = append(files, f)
+		}
+	}
+
+	sortFiles(files, "updated_at", models.OrderDirectionTypeDescend)
+
+	items, next := page(files, req.Marker, req.Limit)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"items":       items,
+		"next_marker": next,
+	})
+}
+
+func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
+	var req fileIdRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameter", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.files[req.FileId]
+	if !ok || !f.trashed {
+		writeError(w, http.StatusNotFound, "NotFound.File", "The resource file cannot be found.")
+		return
+	}
+
+	f.trashed = false
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	var req fileIdRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameter", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.files[req.FileId]; !ok || req.FileId == rootFileId {
+		writeError(w, http.StatusNotFound, "NotFound.File", "The resource file cannot be found.")
+		return
+	}
+
+	s.remove(req.FileId)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// remove 删除文件及子文件，调用时需要持有锁
+func (s *Server) remove(fileId string) {
+	for id, f := range s.files {
+		if f.file.ParentFileId == fileId && id != rootFileId {
+			s.remove(id)
+		}
+	}
+
+	delete(s.files, fileId)
+}
+
+// handleRevisions 模拟服务不保存历史版本
+func (s *Server) handleRevisions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"items":       []interface{}{},
+		"next_marker": "",
+	})
+}
+
+func (s *Server) handleGetPath(w http.ResponseWriter, r *http.Request) {
+	var req fileIdRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameter", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := []models.File{}
+	for id := req.FileId; id != rootFileId; {
+		f, ok := s.files[id]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NotFound.File", "The resource file cannot be found.")
+			return
+		}
+
+		items = append(items, f.file)
+		id = f.file.ParentFileId
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"items": items,
+	})
+}
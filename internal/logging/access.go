@@ -0,0 +1,117 @@
+package logging
+
+import (
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// AccessLog 记录每个请求的访问日志
+type AccessLog struct {
+	logger *logrus.Logger
+}
+
+func NewAccessLog(w io.Writer) *AccessLog {
+	return &AccessLog{logger: newJSONLogger(w)}
+}
+
+// Middleware 请求结束后记录方法、路径、用户、客户端地址、状态码、字节数和耗时
+func (l *AccessLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+
+		var body *countingReader
+		if r.Body != nil {
+			body = &countingReader{ReadCloser: r.Body}
+			r.Body = body
+		}
+
+		next.ServeHTTP(rw, r)
+
+		fields := logrus.Fields{
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"client_ip":   clientIP(r),
+			"status":      rw.status,
+			"bytes_sent":  rw.n,
+			"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"user_agent":  r.UserAgent(),
+		}
+
+		if body != nil {
+			fields["bytes_received"] = body.n
+		}
+		if user, _, ok := r.BasicAuth(); ok {
+			fields["user"] = user
+		}
+		if destination := r.Header.Get("Destination"); destination != "" {
+			if u, err := url.Parse(destination); err == nil {
+				destination = u.Path
+			}
+			fields["destination"] = destination
+		}
+
+		l.logger.WithFields(fields).Info("access")
+	})
+}
+
+// clientIP 返回连接的对端地址，不信任 X-Forwarded-For
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// responseWriter 记录状态码和响应字节数
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	n           int64
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+
+	return n, err
+}
+
+// Flush 支持流式响应
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// countingReader 记录读取的请求体字节数
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+
+	return n, err
+}
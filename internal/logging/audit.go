@@ -0,0 +1,51 @@
+package logging
+
+import (
+	aliWebdav "github.com/jakeslee/aliyundrive-webdav/internal/webdav"
+	"github.com/sirupsen/logrus"
+	"io"
+	"path"
+)
+
+// AuditLog 记录修改文件的操作：上传、删除、移动、重命名和创建目录
+type AuditLog struct {
+	logger *logrus.Logger
+}
+
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{logger: newJSONLogger(w)}
+}
+
+// Listen 作为 EventListener 订阅文件系统的变更事件
+func (l *AuditLog) Listen(event *aliWebdav.Event) {
+	fields := logrus.Fields{
+		"op":      auditOp(event),
+		"path":    event.Path,
+		"file_id": event.FileId,
+		"is_dir":  event.IsDir,
+	}
+
+	if event.Destination != "" {
+		fields["destination"] = event.Destination
+	}
+	if event.Type == aliWebdav.EventUpload {
+		fields["size"] = event.Size
+		if event.Hash != "" {
+			fields["hash"] = event.Hash
+		}
+	}
+	if event.User != "" {
+		fields["user"] = event.User
+	}
+
+	l.logger.WithFields(fields).WithTime(event.Time).Info("audit")
+}
+
+// auditOp 同一目录中的移动记为重命名
+func auditOp(event *aliWebdav.Event) string {
+	if event.Type == aliWebdav.EventMove && path.Dir(event.Path) == path.Dir(event.Destination) {
+		return "rename"
+	}
+
+	return string(event.Type)
+}
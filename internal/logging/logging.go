@@ -0,0 +1,87 @@
+// Package logging 日志级别和格式配置，以及 JSON 格式的访问日志和审计日志
+package logging
+
+import (
+	"errors"
+	nested "github.com/antonfisher/nested-logrus-formatter"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+
+	// Stdout 日志路径为 - 时输出到标准输出
+	Stdout = "-"
+)
+
+var errUnknownFormat = errors.New("logging: unknown log format")
+
+// Setup 设置全局日志级别和格式
+func Setup(level, format string) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case FormatText:
+		logrus.SetFormatter(&nested.Formatter{
+			HideKeys: true,
+		})
+	case FormatJSON:
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return errUnknownFormat
+	}
+
+	logrus.SetLevel(lvl)
+
+	return nil
+}
+
+// RotateOptions 日志文件轮转配置
+type RotateOptions struct {
+	MaxSize    int // 单个文件的最大大小，单位 MB
+	MaxBackups int // 保留的旧文件数
+	MaxAge     int // 旧文件保留天数，0 为不限制
+}
+
+// NewWriter 返回日志输出，路径为 - 时输出到标准输出，否则按大小轮转
+func NewWriter(path string, options *RotateOptions) io.WriteCloser {
+	if path == Stdout {
+		return nopCloser{os.Stdout}
+	}
+
+	return &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    options.MaxSize,
+		MaxBackups: options.MaxBackups,
+		MaxAge:     options.MaxAge,
+		LocalTime:  true,
+	}
+}
+
+// newJSONLogger 每行一个 JSON 对象的日志
+func newJSONLogger(w io.Writer) *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(w)
+	logger.SetLevel(logrus.InfoLevel)
+	logger.SetFormatter(&logrus.JSONFormatter{
+		TimestampFormat: time.RFC3339Nano,
+	})
+
+	return logger
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
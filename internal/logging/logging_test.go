@@ -0,0 +1,141 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	aliWebdav "github.com/jakeslee/aliyundrive-webdav/internal/webdav"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decode 解析一行 JSON 日志
+func decode(t *testing.T, b *bytes.Buffer) map[string]interface{} {
+	var entry map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid log line %q: %s", b.String(), err)
+	}
+	b.Reset()
+
+	return entry
+}
+
+// hasFields 检查日志包含 fields，值为 nil 时检查不包含该字段
+func hasFields(t *testing.T, name string, entry map[string]interface{}, fields map[string]interface{}) {
+	for k, v := range fields {
+		got, ok := entry[k]
+		if v == nil {
+			if ok {
+				t.Errorf("%s: unexpected %s=%v", name, k, got)
+			}
+			continue
+		}
+		if !ok || got != v {
+			t.Errorf("%s: %s=%v, expected %v", name, k, got, v)
+		}
+	}
+}
+
+func TestSetup(t *testing.T) {
+	defer logrus.SetLevel(logrus.GetLevel())
+	defer logrus.SetFormatter(logrus.StandardLogger().Formatter)
+
+	for _, tt := range []struct {
+		level, format string
+		ok            bool
+	}{
+		{"info", FormatText, true},
+		{"debug", FormatJSON, true},
+		{"verbose", FormatText, false},
+		{"info", "xml", false},
+	} {
+		if err := Setup(tt.level, tt.format); (err == nil) != tt.ok {
+			t.Errorf("Setup(%q, %q) = %v", tt.level, tt.format, err)
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var b bytes.Buffer
+	log := NewAccessLog(&b)
+
+	handler := log.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(ioutil.Discard, r.Body)
+		if r.Method == "GET" {
+			_, _ = w.Write([]byte("hello"))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	tests := []struct {
+		name, method, target, body string
+		header                     map[string]string
+		user                       string
+		fields                     map[string]interface{}
+	}{
+		{"download", "GET", "/dir/a.txt", "", nil, "",
+			map[string]interface{}{"method": "GET", "path": "/dir/a.txt", "status": 200.0, "bytes_sent": 5.0, "client_ip": "192.0.2.1", "user": nil}},
+		{"upload", "PUT", "/a.txt", "content", nil, "alice",
+			map[string]interface{}{"status": 201.0, "bytes_received": 7.0, "user": "alice"}},
+		// 目标只记录路径
+		{"move", "MOVE", "/a.txt", "", map[string]string{"Destination": "http://example.com/b%20c.txt"}, "alice",
+			map[string]interface{}{"destination": "/b c.txt"}},
+		// 不信任 X-Forwarded-For
+		{"forwarded", "GET", "/a.txt", "", map[string]string{"X-Forwarded-For": "10.0.0.1", "User-Agent": "client/1.0"}, "",
+			map[string]interface{}{"client_ip": "192.0.2.1", "user_agent": "client/1.0"}},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		for k, v := range tt.header {
+			r.Header.Set(k, v)
+		}
+		if tt.user != "" {
+			r.SetBasicAuth(tt.user, "secret")
+		}
+
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		entry := decode(t, &b)
+		hasFields(t, tt.name, entry, tt.fields)
+		if _, ok := entry["duration_ms"]; !ok || entry["msg"] != "access" {
+			t.Errorf("%s: entry %v", tt.name, entry)
+		}
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	var b bytes.Buffer
+	log := NewAuditLog(&b)
+
+	at := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		event  *aliWebdav.Event
+		fields map[string]interface{}
+	}{
+		{"upload", &aliWebdav.Event{Type: aliWebdav.EventUpload, Path: "/a.txt", FileId: "f1", Size: 5, Hash: "AAF4", User: "alice", Time: at},
+			map[string]interface{}{"op": "upload", "path": "/a.txt", "file_id": "f1", "size": 5.0, "hash": "AAF4", "user": "alice", "time": "2021-01-02T03:04:05Z"}},
+		// 同一目录中的移动记为重命名
+		{"rename", &aliWebdav.Event{Type: aliWebdav.EventMove, Path: "/dir/a.txt", Destination: "/dir/b.txt", Time: at},
+			map[string]interface{}{"op": "rename", "destination": "/dir/b.txt", "user": nil, "size": nil}},
+		{"move", &aliWebdav.Event{Type: aliWebdav.EventMove, Path: "/dir/a.txt", Destination: "/other/a.txt", IsDir: true, Time: at},
+			map[string]interface{}{"op": "move", "is_dir": true}},
+		{"delete", &aliWebdav.Event{Type: aliWebdav.EventDelete, Path: "/a.txt", User: "bob", Time: at},
+			map[string]interface{}{"op": "delete", "user": "bob", "destination": nil, "hash": nil}},
+		{"mkdir", &aliWebdav.Event{Type: aliWebdav.EventMkdir, Path: "/dir", IsDir: true, Time: at},
+			map[string]interface{}{"op": "mkdir", "is_dir": true}},
+	}
+
+	for _, tt := range tests {
+		log.Listen(tt.event)
+		hasFields(t, tt.name, decode(t, &b), tt.fields)
+	}
+}
@@ -0,0 +1,232 @@
+// Package metrics Prometheus 监控指标
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const namespace = "aliyundrive_webdav"
+
+var (
+	// RequestsTotal 按 WebDAV 方法和状态码统计的请求数
+	RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "WebDAV requests by method and status code.",
+	}, []string{"method", "status"})
+
+	// RequestDuration 按 WebDAV 方法统计的请求耗时
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "WebDAV request latency by method.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"method"})
+
+	// TransferBytes 客户端上传（PUT/POST 请求体）和下载（GET 响应体）的字节数
+	TransferBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transfer_bytes_total",
+		Help:      "Bytes uploaded and downloaded by WebDAV clients.",
+	}, []string{"direction"})
+
+	// BackendCalls 按操作统计的网盘接口调用数
+	BackendCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backend_calls_total",
+		Help:      "Drive API calls by operation.",
+	}, []string{"operation"})
+
+	// BackendErrors 按操作统计的网盘接口错误数
+	BackendErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backend_errors_total",
+		Help:      "Drive API call errors by operation.",
+	}, []string{"operation"})
+
+	// BackendDuration 按操作统计的网盘接口耗时
+	BackendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "backend_duration_seconds",
+		Help:      "Drive API call latency by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	// CacheRequests 按缓存统计的命中和未命中次数
+	CacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Cache lookups by cache and result (hit or miss).",
+	}, []string{"cache", "result"})
+
+	// UploadsInFlight 正在上传到网盘的文件数
+	UploadsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "uploads_in_flight",
+		Help:      "Files currently being uploaded to the drive.",
+	})
+
+	// RapidUploads 秒传结果，hit 为秒传成功，miss 为上传了文件内容
+	RapidUploads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rapid_uploads_total",
+		Help:      "Rapid upload attempts by result (hit or miss).",
+	}, []string{"result"})
+
+	// TokenRefreshes 刷新 RefreshToken 的结果
+	TokenRefreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_refreshes_total",
+		Help:      "Refresh token attempts by result (success or failure).",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		RequestsTotal, RequestDuration, TransferBytes,
+		BackendCalls, BackendErrors, BackendDuration,
+		CacheRequests, UploadsInFlight, RapidUploads, TokenRefreshes,
+	)
+}
+
+// CredentialStatus 一个网盘凭证的状态，默认网盘的 Mount 为空
+type CredentialStatus struct {
+	Mount       string
+	Valid       bool
+	LastRefresh time.Time
+	ExpiresAt   time.Time
+	Failures    int
+}
+
+var (
+	credentialValidDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "credential_valid"),
+		"Whether the drive credential is usable (1) or not (0), by mount.", []string{"mount"}, nil)
+	credentialLastRefreshDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "credential_last_refresh_timestamp_seconds"),
+		"Unix time of the last successful token refresh, by mount.", []string{"mount"}, nil)
+	credentialExpiryDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "credential_expiry_timestamp_seconds"),
+		"Unix time when the current access token expires, by mount.", []string{"mount"}, nil)
+	credentialFailuresDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "credential_refresh_failures"),
+		"Consecutive token refresh failures, by mount.", []string{"mount"}, nil)
+)
+
+// credentialCollector 抓取时读取凭证状态，凭证是否有效和当前时间有关，不能在刷新时记录
+type credentialCollector struct {
+	statuses func() []CredentialStatus
+}
+
+func (c *credentialCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- credentialValidDesc
+	ch <- credentialLastRefreshDesc
+	ch <- credentialExpiryDesc
+	ch <- credentialFailuresDesc
+}
+
+func (c *credentialCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.statuses() {
+		valid := 0.0
+		if s.Valid {
+			valid = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(credentialValidDesc, prometheus.GaugeValue, valid, s.Mount)
+		ch <- prometheus.MustNewConstMetric(credentialFailuresDesc, prometheus.GaugeValue, float64(s.Failures), s.Mount)
+
+		if !s.LastRefresh.IsZero() {
+			ch <- prometheus.MustNewConstMetric(credentialLastRefreshDesc, prometheus.GaugeValue, float64(s.LastRefresh.Unix()), s.Mount)
+			ch <- prometheus.MustNewConstMetric(credentialExpiryDesc, prometheus.GaugeValue, float64(s.ExpiresAt.Unix()), s.Mount)
+		}
+	}
+}
+
+// RegisterCredentials 注册凭证状态指标，只能调用一次
+func RegisterCredentials(statuses func() []CredentialStatus) {
+	prometheus.MustRegister(&credentialCollector{statuses: statuses})
+}
+
+// Handler 返回 /metrics 的处理器
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveBackend 记录一次网盘接口调用
+func ObserveBackend(operation string, start time.Time, err error) {
+	BackendCalls.WithLabelValues(operation).Inc()
+	BackendDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		BackendErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// ObserveCache 记录一次缓存查询
+func ObserveCache(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+
+	CacheRequests.WithLabelValues(cache, result).Inc()
+}
+
+// ObserveRapidUpload 记录一次秒传结果
+func ObserveRapidUpload(rapid bool) {
+	result := "miss"
+	if rapid {
+		result = "hit"
+	}
+
+	RapidUploads.WithLabelValues(result).Inc()
+}
+
+// ObserveTokenRefresh 记录一次刷新 Token 的结果
+func ObserveTokenRefresh(err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+
+	TokenRefreshes.WithLabelValues(result).Inc()
+}
+
+// webdavMethods 作为标签的请求方法，其余方法归为 OTHER，避免标签数量无限增长
+var webdavMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "DELETE": true, "OPTIONS": true,
+	"PROPFIND": true, "PROPPATCH": true, "MKCOL": true, "COPY": true, "MOVE": true,
+	"LOCK": true, "UNLOCK": true, "REPORT": true, "SEARCH": true,
+}
+
+// Middleware 统计请求数、耗时和传输字节数
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		method := r.Method
+		if !webdavMethods[method] {
+			method = "OTHER"
+		}
+
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+
+		var body *countingReader
+		if r.Body != nil && (r.Method == http.MethodPut || r.Method == http.MethodPost) {
+			body = &countingReader{ReadCloser: r.Body}
+			r.Body = body
+		}
+
+		next.ServeHTTP(rw, r)
+
+		RequestsTotal.WithLabelValues(method, strconv.Itoa(rw.status)).Inc()
+		RequestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+
+		if body != nil {
+			TransferBytes.WithLabelValues("upload").Add(float64(body.n))
+		}
+		if r.Method == http.MethodGet {
+			TransferBytes.WithLabelValues("download").Add(float64(rw.n))
+		}
+	})
+}
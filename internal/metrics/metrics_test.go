@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		method, label    string
+		status           int
+		body, response   string
+		upload, download float64
+	}{
+		{"GET", "GET", http.StatusOK, "", "hello", 0, 5},
+		{"PUT", "PUT", http.StatusCreated, "uploaded", "", 8, 0},
+		{"POST", "POST", http.StatusSeeOther, "form", "", 4, 0},
+		{"PROPFIND", "PROPFIND", http.StatusMultiStatus, "", "<multistatus/>", 0, 0},
+		// 未知方法归为 OTHER
+		{"BREW", "OTHER", http.StatusNotImplemented, "", "", 0, 0},
+	}
+
+	for _, tt := range tests {
+		handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(ioutil.Discard, r.Body)
+			w.WriteHeader(tt.status)
+			// 重复调用 WriteHeader 不改变记录的状态码
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(tt.response))
+		}))
+
+		requests := RequestsTotal.WithLabelValues(tt.label, strconv.Itoa(tt.status))
+		before := testutil.ToFloat64(requests)
+		upload := testutil.ToFloat64(TransferBytes.WithLabelValues("upload"))
+		download := testutil.ToFloat64(TransferBytes.WithLabelValues("download"))
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, "/a.txt", strings.NewReader(tt.body)))
+
+		if got := testutil.ToFloat64(requests) - before; got != 1 {
+			t.Errorf("%s: requests_total increased by %v", tt.method, got)
+		}
+		if got := testutil.ToFloat64(TransferBytes.WithLabelValues("upload")) - upload; got != tt.upload {
+			t.Errorf("%s: uploaded bytes %v, expected %v", tt.method, got, tt.upload)
+		}
+		if got := testutil.ToFloat64(TransferBytes.WithLabelValues("download")) - download; got != tt.download {
+			t.Errorf("%s: downloaded bytes %v, expected %v", tt.method, got, tt.download)
+		}
+	}
+}
+
+func TestObserve(t *testing.T) {
+	failed := errors.New("failed")
+
+	tests := []struct {
+		name    string
+		observe func()
+		counter prometheus.Counter
+	}{
+		{"cache hit", func() { ObserveCache("quota", true) }, CacheRequests.WithLabelValues("quota", "hit")},
+		{"cache miss", func() { ObserveCache("quota", false) }, CacheRequests.WithLabelValues("quota", "miss")},
+		{"backend call", func() { ObserveBackend("list", time.Now(), nil) }, BackendCalls.WithLabelValues("list")},
+		{"backend error", func() { ObserveBackend("upload", time.Now(), failed) }, BackendErrors.WithLabelValues("upload")},
+		{"rapid hit", func() { ObserveRapidUpload(true) }, RapidUploads.WithLabelValues("hit")},
+		{"rapid miss", func() { ObserveRapidUpload(false) }, RapidUploads.WithLabelValues("miss")},
+		{"refresh success", func() { ObserveTokenRefresh(nil) }, TokenRefreshes.WithLabelValues("success")},
+		{"refresh failure", func() { ObserveTokenRefresh(failed) }, TokenRefreshes.WithLabelValues("failure")},
+	}
+
+	for _, tt := range tests {
+		before := testutil.ToFloat64(tt.counter)
+		tt.observe()
+		if got := testutil.ToFloat64(tt.counter) - before; got != 1 {
+			t.Errorf("%s: counter increased by %v", tt.name, got)
+		}
+	}
+
+	// 成功的调用不计入错误数
+	errorsBefore := testutil.ToFloat64(BackendErrors.WithLabelValues("list"))
+	ObserveBackend("list", time.Now(), nil)
+	if got := testutil.ToFloat64(BackendErrors.WithLabelValues("list")); got != errorsBefore {
+		t.Errorf("successful call counted as error")
+	}
+}
+
+func TestCredentialCollector(t *testing.T) {
+	refreshed := time.Unix(1609459200, 0)
+	c := &credentialCollector{statuses: func() []CredentialStatus {
+		return []CredentialStatus{
+			{Mount: "", Valid: true, LastRefresh: refreshed, ExpiresAt: refreshed.Add(2 * time.Hour)},
+			// 没有刷新成功过时不输出时间
+			{Mount: "backup", Failures: 3},
+		}
+	}}
+
+	expected := `
+# HELP aliyundrive_webdav_credential_expiry_timestamp_seconds Unix time when the current access token expires, by mount.
+# TYPE aliyundrive_webdav_credential_expiry_timestamp_seconds gauge
+aliyundrive_webdav_credential_expiry_timestamp_seconds{mount=""} 1.6094664e+09
+# HELP aliyundrive_webdav_credential_last_refresh_timestamp_seconds Unix time of the last successful token refresh, by mount.
+# TYPE aliyundrive_webdav_credential_last_refresh_timestamp_seconds gauge
+aliyundrive_webdav_credential_last_refresh_timestamp_seconds{mount=""} 1.6094592e+09
+# HELP aliyundrive_webdav_credential_refresh_failures Consecutive token refresh failures, by mount.
+# TYPE aliyundrive_webdav_credential_refresh_failures gauge
+aliyundrive_webdav_credential_refresh_failures{mount=""} 0
+aliyundrive_webdav_credential_refresh_failures{mount="backup"} 3
+# HELP aliyundrive_webdav_credential_valid Whether the drive credential is usable (1) or not (0), by mount.
+# TYPE aliyundrive_webdav_credential_valid gauge
+aliyundrive_webdav_credential_valid{mount=""} 1
+aliyundrive_webdav_credential_valid{mount="backup"} 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
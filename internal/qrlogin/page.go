@@ -0,0 +1,229 @@
+package qrlogin
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sessionTTL 页面上的二维码超过该时间后不再查询
+	sessionTTL = 10 * time.Minute
+
+	qrSize = 256
+)
+
+var pageTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>扫码登录阿里云盘</title>
+<style>
+body { font-family: sans-serif; text-align: center; margin-top: 40px; }
+#state { margin-top: 16px; }
+</style>
+</head>
+<body>
+<h2>使用阿里云盘 App 扫码登录</h2>
+{{if .Targets}}
+<form method="get">
+<select name="target" onchange="this.form.submit()">
+{{range .Targets}}<option value="{{.Name}}"{{if .Selected}} selected{{end}}>{{.Label}}</option>{{end}}
+</select>
+</form>
+{{end}}
+<img src="data:image/png;base64,{{.Image}}" width="256" height="256" alt="QR code">
+<div id="state">等待扫码</div>
+<script>
+(function poll() {
+  fetch("{{.StatusURL}}", {credentials: "same-origin"})
+    .then(function (r) { return r.json(); })
+    .then(function (s) {
+      document.getElementById("state").textContent = s.message;
+      if (!s.done) setTimeout(poll, 2000);
+    })
+    .catch(function () { setTimeout(poll, 5000); });
+})();
+</script>
+</body>
+</html>
+`))
+
+type pageSession struct {
+	session *Session
+	target  string
+	created time.Time
+}
+
+type pageTarget struct {
+	Name     string
+	Label    string
+	Selected bool
+}
+
+// Handler 管理端口上的扫码登录页面，Prefix 下的 /status 返回扫码状态
+// 登录成功后会替换网盘凭证，必须通过 admin.Server.HandleProtected 注册，只允许管理员访问
+type Handler struct {
+	Client *Client
+	Prefix string
+
+	// Targets 可以登录的挂载点，为空时只有默认网盘
+	Targets []string
+
+	// Save 使用登录得到的 RefreshToken，target 为挂载点名称，默认网盘为空
+	Save func(target, token string) error
+
+	mu       sync.Mutex
+	sessions map[string]*pageSession
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimPrefix(r.URL.Path, h.Prefix) {
+	case "", "/":
+		h.servePage(w, r)
+	case "/status":
+		h.serveStatus(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) servePage(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" && len(h.Targets) > 0 {
+		target = h.Targets[0]
+	}
+	if !h.validTarget(target) {
+		http.Error(w, "unknown target "+target, http.StatusBadRequest)
+		return
+	}
+
+	session, err := h.Client.Generate(r.Context())
+	if err != nil {
+		logrus.Errorf("generate login qr code error %s", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	image, err := PNG(session.Content, qrSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	id := newSessionId()
+
+	h.mu.Lock()
+	if h.sessions == nil {
+		h.sessions = make(map[string]*pageSession)
+	}
+	for key, s := range h.sessions {
+		if time.Since(s.created) > sessionTTL {
+			delete(h.sessions, key)
+		}
+	}
+	h.sessions[id] = &pageSession{session: session, target: target, created: time.Now()}
+	h.mu.Unlock()
+
+	targets := make([]pageTarget, 0, len(h.Targets))
+	for _, name := range h.Targets {
+		targets = append(targets, pageTarget{Name: name, Label: "挂载点 " + name, Selected: name == target})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = pageTemplate.Execute(w, map[string]interface{}{
+		"Targets":   targets,
+		"Image":     base64.StdEncoding.EncodeToString(image),
+		"StatusURL": h.Prefix + "/status?id=" + id,
+	})
+}
+
+func (h *Handler) serveStatus(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+
+	h.mu.Lock()
+	s, ok := h.sessions[id]
+	h.mu.Unlock()
+
+	result := struct {
+		State   State  `json:"state"`
+		Message string `json:"message"`
+		Done    bool   `json:"done"`
+	}{}
+
+	switch {
+	case !ok || time.Since(s.created) > sessionTTL:
+		result.State, result.Done = StateExpired, true
+		result.Message = StateExpired.String() + "，请刷新页面"
+	default:
+		status, err := h.Client.Query(r.Context(), s.session)
+		if err != nil {
+			logrus.Warnf("query login qr code error %s", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		result.State, result.Message = status.State, status.State.String()
+
+		switch status.State {
+		case StateConfirmed:
+			result.Done = true
+			if err = h.Save(s.target, status.RefreshToken); err != nil {
+				logrus.Errorf("save refresh token error %s", err)
+				result.Message = "保存 RefreshToken 失败：" + err.Error()
+			} else {
+				logrus.Infof("refresh token of %s updated by qr code login", targetName(s.target))
+				result.Message = "登录成功，RefreshToken 已更新"
+			}
+		case StateExpired, StateCanceled:
+			result.Done = true
+		}
+
+		if result.Done {
+			h.mu.Lock()
+			delete(h.sessions, id)
+			h.mu.Unlock()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+func (h *Handler) validTarget(target string) bool {
+	if len(h.Targets) == 0 {
+		return target == ""
+	}
+
+	for _, name := range h.Targets {
+		if name == target {
+			return true
+		}
+	}
+
+	return false
+}
+
+func targetName(target string) string {
+	if target == "" {
+		return "default drive"
+	}
+
+	return "mount " + target
+}
+
+func newSessionId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,249 @@
+// Package qrlogin 使用阿里云盘 App 扫描二维码登录，获取 RefreshToken
+package qrlogin
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultBaseURL = "https://passport.aliyundrive.com"
+
+	generatePath = "/newlogin/qrcode/generate.do"
+	queryPath    = "/newlogin/qrcode/query.do"
+
+	// PollInterval 查询扫码状态的间隔
+	PollInterval = 2 * time.Second
+
+	requestTimeout = 30 * time.Second
+)
+
+// State 二维码状态
+type State string
+
+const (
+	StateNew       State = "NEW"
+	StateScanned   State = "SCANED"
+	StateConfirmed State = "CONFIRMED"
+	StateExpired   State = "EXPIRED"
+	StateCanceled  State = "CANCELED"
+)
+
+var (
+	ErrExpired  = errors.New("qrlogin: qr code expired")
+	ErrCanceled = errors.New("qrlogin: login canceled")
+	errNoToken  = errors.New("qrlogin: refresh token not found in login result")
+)
+
+// Session 一次扫码登录，Content 为二维码内容
+type Session struct {
+	Content string
+	t       string
+	ck      string
+}
+
+// Status 扫码状态，State 为 StateConfirmed 时 RefreshToken 有效
+type Status struct {
+	State        State
+	RefreshToken string
+}
+
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client
+}
+
+func NewClient() *Client {
+	return &Client{
+		BaseURL: DefaultBaseURL,
+		HTTP:    &http.Client{Timeout: requestTimeout},
+	}
+}
+
+type response struct {
+	Content struct {
+		Data struct {
+			T            json.Number `json:"t"`
+			Ck           string      `json:"ck"`
+			CodeContent  string      `json:"codeContent"`
+			QrCodeStatus State       `json:"qrCodeStatus"`
+			BizExt       string      `json:"bizExt"`
+			TitleMsg     string      `json:"titleMsg"`
+		} `json:"data"`
+		Success bool `json:"success"`
+	} `json:"content"`
+	HasError bool `json:"hasError"`
+}
+
+func (c *Client) params() url.Values {
+	return url.Values{
+		"appName":     {"aliyun_drive"},
+		"fromSite":    {"52"},
+		"appEntrance": {"web"},
+		"isMobile":    {"false"},
+		"lang":        {"zh_CN"},
+	}
+}
+
+func (c *Client) do(request *http.Request) (*response, error) {
+	resp, err := c.HTTP.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("qrlogin: unexpected status %d", resp.StatusCode)
+	}
+
+	result := &response{}
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("qrlogin: decode response error, %s", err)
+	}
+
+	if result.HasError {
+		msg := result.Content.Data.TitleMsg
+		if msg == "" {
+			msg = "unknown error"
+		}
+		return nil, fmt.Errorf("qrlogin: %s", msg)
+	}
+
+	return result, nil
+}
+
+// Generate 生成登录二维码
+func (c *Client) Generate(ctx context.Context) (*Session, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		c.BaseURL+generatePath+"?"+c.params().Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	data := result.Content.Data
+	if data.CodeContent == "" || data.Ck == "" {
+		return nil, errors.New("qrlogin: invalid qr code response")
+	}
+
+	return &Session{Content: data.CodeContent, t: data.T.String(), ck: data.Ck}, nil
+}
+
+// Query 查询扫码状态
+func (c *Client) Query(ctx context.Context, session *Session) (*Status, error) {
+	form := c.params()
+	form.Set("t", session.t)
+	form.Set("ck", session.ck)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		c.BaseURL+queryPath+"?"+url.Values{"appName": {"aliyun_drive"}, "fromSite": {"52"}}.Encode(),
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	result, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{State: result.Content.Data.QrCodeStatus}
+	if status.State != StateConfirmed {
+		return status, nil
+	}
+
+	if status.RefreshToken, err = parseBizExt(result.Content.Data.BizExt); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// Wait 定时查询直到确认登录，onChange 在状态变化时调用，可以为空
+func (c *Client) Wait(ctx context.Context, session *Session, onChange func(state State)) (string, error) {
+	var last State
+
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+
+	for {
+		status, err := c.Query(ctx, session)
+		if err != nil {
+			return "", err
+		}
+
+		if status.State != last && onChange != nil {
+			onChange(status.State)
+		}
+		last = status.State
+
+		switch status.State {
+		case StateConfirmed:
+			return status.RefreshToken, nil
+		case StateExpired:
+			return "", ErrExpired
+		case StateCanceled:
+			return "", ErrCanceled
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// parseBizExt 登录结果为 base64 编码的 JSON，RefreshToken 在 pds_login_result 中
+func parseBizExt(bizExt string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(bizExt)
+	if err != nil {
+		return "", fmt.Errorf("qrlogin: decode login result error, %s", err)
+	}
+
+	var ext struct {
+		LoginResult struct {
+			RefreshToken string `json:"refreshToken"`
+		} `json:"pds_login_result"`
+	}
+	if err = json.Unmarshal(data, &ext); err != nil {
+		return "", fmt.Errorf("qrlogin: decode login result error, %s", err)
+	}
+
+	if ext.LoginResult.RefreshToken == "" {
+		return "", errNoToken
+	}
+
+	return ext.LoginResult.RefreshToken, nil
+}
+
+// String 返回状态的中文说明
+func (s State) String() string {
+	switch s {
+	case StateNew:
+		return "等待扫码"
+	case StateScanned:
+		return "已扫码，请在手机上确认登录"
+	case StateConfirmed:
+		return "登录成功"
+	case StateExpired:
+		return "二维码已过期"
+	case StateCanceled:
+		return "已取消登录"
+	}
+
+	return strconv.Quote(string(s))
+}
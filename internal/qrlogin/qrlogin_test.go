@@ -0,0 +1,247 @@
+package qrlogin
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// passport 模拟的登录服务，查询时返回 state
+type passport struct {
+	mu       sync.Mutex
+	state    State
+	hasError bool
+	queries  []string // 查询时提交的 ck
+}
+
+func (p *passport) setState(state State) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.state = state
+}
+
+func (p *passport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	data := map[string]interface{}{}
+
+	switch r.URL.Path {
+	case generatePath:
+		data["t"] = 1
+		data["ck"] = "ck-1"
+		data["codeContent"] = "https://passport.aliyundrive.com/qrcodeCheck.htm?ck=ck-1"
+	case queryPath:
+		p.queries = append(p.queries, r.PostFormValue("ck"))
+		data["qrCodeStatus"] = p.state
+		if p.state == StateConfirmed {
+			data["bizExt"] = bizExt(`{"pds_login_result":{"refreshToken":"new-token"}}`)
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if p.hasError {
+		data = map[string]interface{}{"titleMsg": "busy"}
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"content":  map[string]interface{}{"data": data, "success": !p.hasError},
+		"hasError": p.hasError,
+	})
+}
+
+func bizExt(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func newTestClient(t *testing.T) (*Client, *passport) {
+	p := &passport{state: StateNew}
+	server := httptest.NewServer(p)
+	t.Cleanup(server.Close)
+
+	return &Client{BaseURL: server.URL, HTTP: server.Client()}, p
+}
+
+func TestParseBizExt(t *testing.T) {
+	for _, tt := range []struct {
+		bizExt, token string
+		ok            bool
+	}{
+		{bizExt(`{"pds_login_result":{"refreshToken":"abc"}}`), "abc", true},
+		{bizExt(`{"pds_login_result":{}}`), "", false},
+		{bizExt(`not json`), "", false},
+		{"%%%", "", false},
+	} {
+		token, err := parseBizExt(tt.bizExt)
+		if token != tt.token || (err == nil) != tt.ok {
+			t.Errorf("parseBizExt(%s) = %s, %v", tt.bizExt, token, err)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	client, p := newTestClient(t)
+	ctx := context.Background()
+
+	session, err := client.Generate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(session.Content, "ck=ck-1") {
+		t.Fatalf("content %s", session.Content)
+	}
+
+	for _, tt := range []struct {
+		state    State
+		hasError bool
+		token    string
+		ok       bool
+	}{
+		{state: StateNew, ok: true},
+		{state: StateScanned, ok: true},
+		{state: StateConfirmed, token: "new-token", ok: true},
+		{state: StateNew, hasError: true},
+	} {
+		p.mu.Lock()
+		p.state, p.hasError = tt.state, tt.hasError
+		p.mu.Unlock()
+
+		status, err := client.Query(ctx, session)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Query() error %v", tt.state, err)
+			continue
+		}
+		if err == nil && (status.State != tt.state || status.RefreshToken != tt.token) {
+			t.Errorf("%s: Query() = %+v", tt.state, status)
+		}
+	}
+
+	if p.queries[0] != "ck-1" {
+		t.Errorf("query with ck %s", p.queries[0])
+	}
+}
+
+func TestWait(t *testing.T) {
+	client, p := newTestClient(t)
+	ctx := context.Background()
+
+	session, err := client.Generate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		state State
+		token string
+		err   error
+	}{
+		{StateConfirmed, "new-token", nil},
+		{StateExpired, "", ErrExpired},
+		{StateCanceled, "", ErrCanceled},
+	} {
+		p.setState(tt.state)
+
+		var changes []State
+		token, err := client.Wait(ctx, session, func(state State) {
+			changes = append(changes, state)
+		})
+		if token != tt.token || err != tt.err || len(changes) != 1 || changes[0] != tt.state {
+			t.Errorf("%s: Wait() = %s, %v, changes %v", tt.state, token, err, changes)
+		}
+	}
+
+	// 等待时取消
+	p.setState(StateNew)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := client.Wait(cancelled, session, nil); err == nil {
+		t.Errorf("Wait() with canceled context succeeded")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	client, p := newTestClient(t)
+
+	saved := map[string]string{}
+	h := &Handler{
+		Client:  client,
+		Prefix:  "/login",
+		Targets: []string{"backup", "resource"},
+		Save: func(target, token string) error {
+			saved[target] = token
+			return nil
+		},
+	}
+
+	serve := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		return w
+	}
+
+	for _, tt := range []struct {
+		target string
+		status int
+	}{
+		{"/login/?target=other", http.StatusBadRequest},
+		{"/login/unknown", http.StatusNotFound},
+	} {
+		if w := serve(tt.target); w.Code != tt.status {
+			t.Errorf("%s: status %d, expected %d", tt.target, w.Code, tt.status)
+		}
+	}
+
+	w := serve("/login/?target=resource")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `value="resource" selected`) {
+		t.Fatalf("page status %d, %s", w.Code, w.Body.String())
+	}
+
+	// 页面脚本中的地址经过 JS 转义，只取出 id
+	match := regexp.MustCompile(`status\?id=([0-9a-f]+)`).FindStringSubmatch(w.Body.String())
+	if match == nil {
+		t.Fatalf("status url not found, %s", w.Body.String())
+	}
+	statusURL := "/login/status?id=" + match[1]
+
+	for _, tt := range []struct {
+		state   State
+		result  State
+		done    bool
+		message string
+	}{
+		{StateNew, StateNew, false, StateNew.String()},
+		{StateScanned, StateScanned, false, StateScanned.String()},
+		{StateConfirmed, StateConfirmed, true, "登录成功，RefreshToken 已更新"},
+		// 登录完成后删除会话
+		{StateConfirmed, StateExpired, true, StateExpired.String() + "，请刷新页面"},
+	} {
+		p.setState(tt.state)
+
+		w := serve(statusURL)
+
+		var result struct {
+			State   State  `json:"state"`
+			Message string `json:"message"`
+			Done    bool   `json:"done"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%s: %s, %s", tt.state, err, w.Body.String())
+		}
+		if result.State != tt.result || result.Done != tt.done || result.Message != tt.message {
+			t.Errorf("%s: status %+v", tt.state, result)
+		}
+	}
+
+	if len(saved) != 1 || saved["resource"] != "new-token" {
+		t.Errorf("saved %v", saved)
+	}
+}
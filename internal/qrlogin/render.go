@@ -0,0 +1,20 @@
+package qrlogin
+
+import (
+	"github.com/skip2/go-qrcode"
+)
+
+// Terminal 使用半高字符在终端中显示二维码
+func Terminal(content string) (string, error) {
+	q, err := qrcode.New(content, qrcode.Low)
+	if err != nil {
+		return "", err
+	}
+
+	return q.ToSmallString(false), nil
+}
+
+// PNG 生成 size*size 像素的二维码图片
+func PNG(content string, size int) ([]byte, error) {
+	return qrcode.Encode(content, qrcode.Medium, size)
+}
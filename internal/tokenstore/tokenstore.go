@@ -0,0 +1,182 @@
+// Package tokenstore 保存 RefreshToken 刷新结果，写入临时文件后重命名，可选使用 AES-GCM 加密
+package tokenstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// encryptedPrefix 加密文件的前缀，没有前缀的文件按明文读取，便于从旧版本迁移
+const encryptedPrefix = "enc:v1:"
+
+var (
+	ErrNoKey        = errors.New("tokenstore: token file is encrypted but no key is configured")
+	ErrInvalidToken = errors.New("tokenstore: invalid refresh token")
+	errDecrypt      = errors.New("tokenstore: decrypt token file error, wrong key or corrupted file")
+)
+
+// Store 一个 RefreshToken 文件，key 为空时明文保存
+type Store struct {
+	path string
+	key  []byte
+}
+
+// New 创建 Store，key 经过 SHA-256 后作为 AES-256 密钥
+func New(path, key string) *Store {
+	s := &Store{path: path}
+	if key != "" {
+		sum := sha256.Sum256([]byte(key))
+		s.key = sum[:]
+	}
+
+	return s
+}
+
+// ReadKey 读取密钥，keyFile 不为空时优先从文件读取
+func ReadKey(key, keyFile string) (string, error) {
+	if keyFile == "" {
+		return key, nil
+	}
+
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return "", fmt.Errorf("tokenstore: read key file error, %s", err)
+	}
+
+	key = strings.TrimSpace(string(data))
+	if key == "" {
+		return "", fmt.Errorf("tokenstore: key file %s is empty", keyFile)
+	}
+
+	return key, nil
+}
+
+// Load 读取 RefreshToken，文件不存在时返回 os.ErrNotExist
+func (s *Store) Load() (string, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return "", err
+	}
+
+	content := strings.TrimSpace(string(data))
+
+	if strings.HasPrefix(content, encryptedPrefix) {
+		if s.key == nil {
+			return "", ErrNoKey
+		}
+
+		if content, err = s.decrypt(content[len(encryptedPrefix):]); err != nil {
+			return "", err
+		}
+	}
+
+	return Validate(content)
+}
+
+// Save 写入临时文件后重命名，中途失败不会留下不完整的文件
+func (s *Store) Save(token string) error {
+	token, err := Validate(token)
+	if err != nil {
+		return err
+	}
+
+	content := token
+	if s.key != nil {
+		if content, err = s.encrypt(token); err != nil {
+			return err
+		}
+		content = encryptedPrefix + content
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err = f.Chmod(0600); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err = f.WriteString(content); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.path)
+}
+
+// Validate 去掉首尾空白，RefreshToken 不能为空，也不能包含空白或控制字符
+func Validate(token string) (string, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", ErrInvalidToken
+	}
+
+	for _, r := range token {
+		if r <= ' ' || r > '~' {
+			return "", ErrInvalidToken
+		}
+	}
+
+	return token, nil
+}
+
+func (s *Store) encrypt(plain string) (string, error) {
+	gcm, err := s.gcm()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(plain), nil)), nil
+}
+
+func (s *Store) decrypt(content string) (string, error) {
+	gcm, err := s.gcm()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(content)
+	if err != nil || len(data) < gcm.NonceSize() {
+		return "", errDecrypt
+	}
+
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errDecrypt
+	}
+
+	return string(plain), nil
+}
+
+func (s *Store) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,131 @@
+package tokenstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "token")
+	s := New(file, "")
+
+	if _, err := s.Load(); !os.IsNotExist(err) {
+		t.Fatalf("load missing file: %v", err)
+	}
+
+	// 较短的新 token 不能残留旧内容
+	if err := s.Save("a-long-refresh-token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save("short"); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := s.Load()
+	if err != nil || token != "short" {
+		t.Fatalf("load: %q, %v", token, err)
+	}
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("mode %o, expected 600", fi.Mode().Perm())
+	}
+
+	// 不留下临时文件
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("%d files left in work dir", len(entries))
+	}
+}
+
+func TestEncrypted(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+
+	if err := New(file, "secret").Save("refresh-token"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), encryptedPrefix) || strings.Contains(string(data), "refresh-token") {
+		t.Fatalf("token saved in plain text: %s", data)
+	}
+
+	if token, err := New(file, "secret").Load(); err != nil || token != "refresh-token" {
+		t.Fatalf("load: %q, %v", token, err)
+	}
+	if _, err := New(file, "").Load(); err != ErrNoKey {
+		t.Fatalf("load without key: %v", err)
+	}
+	if _, err := New(file, "wrong").Load(); err != errDecrypt {
+		t.Fatalf("load with wrong key: %v", err)
+	}
+}
+
+func TestLoadPlainWithKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+
+	// 旧版本保存的明文文件，带有换行
+	if err := ioutil.WriteFile(file, []byte("plain-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if token, err := New(file, "secret").Load(); err != nil || token != "plain-token" {
+		t.Fatalf("load: %q, %v", token, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		token string
+		want  string
+		err   error
+	}{
+		{" token\n", "token", nil},
+		{"", "", ErrInvalidToken},
+		{"  \t", "", ErrInvalidToken},
+		{"to ken", "", ErrInvalidToken},
+		{"token\x00", "", ErrInvalidToken},
+		{"令牌", "", ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		got, err := Validate(tt.token)
+		if got != tt.want || err != tt.err {
+			t.Errorf("Validate(%q) = %q, %v, expected %q, %v", tt.token, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	if key, err := ReadKey("env-key", ""); err != nil || key != "env-key" {
+		t.Fatalf("read key: %q, %v", key, err)
+	}
+
+	file := filepath.Join(t.TempDir(), "key")
+	if err := ioutil.WriteFile(file, []byte("file-key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := ReadKey("env-key", file); err != nil || key != "file-key" {
+		t.Fatalf("read key file: %q, %v", key, err)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadKey("", file); err == nil {
+		t.Fatal("empty key file accepted")
+	}
+}
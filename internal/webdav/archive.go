@@ -0,0 +1,228 @@
+package webdav
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"time"
+)
+
+var errArchiveTooLarge = errors.New("webdav: archive exceeds limit")
+
+type archiveEntry struct {
+	name string // 压缩包内的相对路径
+	path string
+	fi   os.FileInfo
+}
+
+// archiveWriter 压缩包格式，zip 和 tar.gz 共用遍历和读取逻辑
+type archiveWriter interface {
+	Add(entry *archiveEntry, content io.Reader) error
+	Close() error
+}
+
+// serveArchive 把目录打包为 zip 或 tar.gz 流式返回，不在本地缓存文件
+func (h *Handler) serveArchive(w http.ResponseWriter, r *http.Request, reqPath, format string) (int, error) {
+	var ext, ctype string
+
+	switch format {
+	case "zip":
+		ext, ctype = ".zip", "application/zip"
+	case "tar.gz", "tgz":
+		ext, ctype = ".tar.gz", "application/gzip"
+	default:
+		return http.StatusBadRequest, errUnsupportedMethod
+	}
+
+	// OpenFile 需要从 ctx 中读取文件大小
+	ctx := context.WithValue(r.Context(), CtxSizeValue, int64(0))
+	dir := path.Clean("/" + reqPath)
+
+	// 先遍历目录，超过限制时在写入响应前返回错误
+	var entries []*archiveEntry
+	var total int64
+
+	if err := h.walk(ctx, dir, "", func(entry *archiveEntry) error {
+		entries = append(entries, entry)
+		if !entry.fi.IsDir() {
+			total += entry.fi.Size()
+		}
+
+		if h.ArchiveMaxFiles > 0 && len(entries) > h.ArchiveMaxFiles {
+			return errArchiveTooLarge
+		}
+		if h.ArchiveMaxSize > 0 && total > h.ArchiveMaxSize {
+			return errArchiveTooLarge
+		}
+
+		return nil
+	}); err != nil {
+		if err == errArchiveTooLarge {
+			return http.StatusRequestEntityTooLarge, err
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	name := path.Base(dir)
+	if name == "/" {
+		name = "aliyundrive"
+	}
+
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(name+ext))
+
+	if r.Method == "HEAD" {
+		return 0, nil
+	}
+
+	var aw archiveWriter
+	if ext == ".zip" {
+		aw = &zipArchive{w: zip.NewWriter(w)}
+	} else {
+		gw := gzip.NewWriter(w)
+		aw = &tarArchive{gw: gw, w: tar.NewWriter(gw)}
+	}
+
+	for _, entry := range entries {
+		if err := h.addArchiveEntry(ctx, aw, entry); err != nil {
+			// 响应已经开始，只能中断连接
+			logrus.Errorf("archive %s error at %s, %s", dir, entry.path, err)
+			return 0, err
+		}
+	}
+
+	return 0, aw.Close()
+}
+
+func (h *Handler) addArchiveEntry(ctx context.Context, aw archiveWriter, entry *archiveEntry) error {
+	if entry.fi.IsDir() || entry.fi.Size() == 0 {
+		return aw.Add(entry, nil)
+	}
+
+	f, err := h.FileSystem.OpenFile(ctx, entry.path, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return aw.Add(entry, f)
+}
+
+// walk 递归遍历目录，按目录、文件的先后顺序回调
+func (h *Handler) walk(ctx context.Context, dir, prefix string, fn func(entry *archiveEntry) error) error {
+	f, err := h.FileSystem.OpenFile(ctx, dir, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	infos, err := f.Readdir(-1)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range infos {
+		entry := &archiveEntry{
+			name: path.Join(prefix, fi.Name()),
+			path: path.Join(dir, fi.Name()),
+			fi:   fi,
+		}
+
+		// 用户不能读取的文件和目录不打包
+		if !h.readable(ctx, entry.path) {
+			continue
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			if err := h.walk(ctx, entry.path, entry.name, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+type zipArchive struct {
+	w *zip.Writer
+}
+
+func (z *zipArchive) Add(entry *archiveEntry, content io.Reader) error {
+	header := &zip.FileHeader{
+		Name:     entry.name,
+		Method:   zip.Deflate,
+		Modified: entry.fi.ModTime(),
+	}
+
+	if entry.fi.IsDir() {
+		header.Name += "/"
+		header.Method = zip.Store
+		header.SetMode(os.ModeDir | 0755)
+	} else {
+		header.SetMode(0644)
+	}
+
+	dst, err := z.w.CreateHeader(header)
+	if err != nil || content == nil {
+		return err
+	}
+
+	_, err = Copy(dst, content)
+
+	return err
+}
+
+func (z *zipArchive) Close() error {
+	return z.w.Close()
+}
+
+type tarArchive struct {
+	gw *gzip.Writer
+	w  *tar.Writer
+}
+
+func (t *tarArchive) Add(entry *archiveEntry, content io.Reader) error {
+	header := &tar.Header{
+		Name:     entry.name,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     entry.fi.Size(),
+		ModTime:  entry.fi.ModTime().Truncate(time.Second),
+		Format:   tar.FormatPAX,
+	}
+
+	if entry.fi.IsDir() {
+		header.Name += "/"
+		header.Typeflag = tar.TypeDir
+		header.Mode = 0755
+		header.Size = 0
+	}
+
+	if err := t.w.WriteHeader(header); err != nil || content == nil {
+		return err
+	}
+
+	_, err := CopyN(t.w, content, header.Size)
+
+	return err
+}
+
+func (t *tarArchive) Close() error {
+	if err := t.w.Close(); err != nil {
+		return err
+	}
+
+	return t.gw.Close()
+}
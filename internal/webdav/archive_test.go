@@ -0,0 +1,134 @@
+package webdav
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// readArchive 返回压缩包中的文件内容，目录的内容为空
+func readArchive(t *testing.T, format string, body []byte) map[string]string {
+	files := make(map[string]string)
+
+	if format == "zip" {
+		zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := ioutil.ReadAll(rc)
+			_ = rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[f.Name] = string(content)
+		}
+		return files
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name] = string(content)
+	}
+}
+
+func TestServeArchive(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"/docs/a.txt":        "hello",
+		"/docs/empty.txt":    "",
+		"/docs/sub/b.txt":    "world",
+		"/secret/hidden.txt": "secret",
+	})
+
+	docs := map[string]string{"a.txt": "hello", "empty.txt": "", "sub/": "", "sub/b.txt": "world"}
+
+	tests := []struct {
+		name, user, target, format string
+		disposition                string
+		files                      map[string]string
+	}{
+		{"zip", "bob", "/docs/?archive=zip", "zip", "attachment; filename*=UTF-8''docs.zip", docs},
+		{"tar.gz", "bob", "/docs/?archive=tar.gz", "tar.gz", "attachment; filename*=UTF-8''docs.tar.gz", docs},
+		{"tgz", "bob", "/docs/?archive=tgz", "tar.gz", "attachment; filename*=UTF-8''docs.tar.gz", docs},
+		// 没有权限的目录不打包
+		{"root", "bob", "/?archive=zip", "zip", "attachment; filename*=UTF-8''aliyundrive.zip",
+			map[string]string{"docs/": "", "docs/a.txt": "hello", "docs/empty.txt": "", "docs/sub/": "", "docs/sub/b.txt": "world"}},
+		{"authorized root", "alice", "/?archive=zip", "zip", "attachment; filename*=UTF-8''aliyundrive.zip",
+			map[string]string{"docs/": "", "docs/a.txt": "hello", "docs/empty.txt": "", "docs/sub/": "", "docs/sub/b.txt": "world",
+				"secret/": "", "secret/hidden.txt": "secret"}},
+	}
+
+	for _, tt := range tests {
+		w := serve(h, tt.user, "GET", tt.target, nil, "")
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d, %s", tt.name, w.Code, w.Body.String())
+			continue
+		}
+		if got := w.Header().Get("Content-Disposition"); got != tt.disposition {
+			t.Errorf("%s: Content-Disposition %s", tt.name, got)
+		}
+		if files := readArchive(t, tt.format, w.Body.Bytes()); !reflect.DeepEqual(files, tt.files) {
+			t.Errorf("%s: files %v, expected %v", tt.name, files, tt.files)
+		}
+	}
+
+	if w := serve(h, "bob", "HEAD", "/docs/?archive=zip", nil, ""); w.Code != http.StatusOK ||
+		w.Header().Get("Content-Type") != "application/zip" || w.Body.Len() != 0 {
+		t.Errorf("HEAD status %d, content type %s, body %d bytes", w.Code, w.Header().Get("Content-Type"), w.Body.Len())
+	}
+}
+
+func TestServeArchiveErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxFiles int
+		maxSize  int64
+		target   string
+		status   int
+	}{
+		{"unsupported format", 0, 0, "/docs/?archive=rar", http.StatusBadRequest},
+		{"file limit", 2, 0, "/docs/?archive=zip", http.StatusRequestEntityTooLarge},
+		{"size limit", 0, 9, "/docs/?archive=zip", http.StatusRequestEntityTooLarge},
+		{"within limits", 3, 10, "/docs/?archive=zip", http.StatusOK},
+		{"unauthorized", 0, 0, "/secret/?archive=zip", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		h := newTestHandler(t, map[string]string{
+			"/docs/a.txt":        "hello",
+			"/docs/sub/b.txt":    "world",
+			"/secret/hidden.txt": "secret",
+		})
+		h.ArchiveMaxFiles = tt.maxFiles
+		h.ArchiveMaxSize = tt.maxSize
+
+		if w := serve(h, "bob", "GET", tt.target, nil, ""); w.Code != tt.status {
+			t.Errorf("%s: status %d, expected %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
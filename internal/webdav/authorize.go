@@ -0,0 +1,205 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"github.com/jakeslee/aliyundrive"
+	"golang.org/x/net/webdav"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+)
+
+// Authorizer 检查用户能否读取或修改路径，返回错误时拒绝请求
+type Authorizer interface {
+	Authorize(user string, write bool, p string) error
+
+	// AuthorizeTree 同时检查 p 下的全部规则，用于删除、移动目录等会修改子路径的请求
+	AuthorizeTree(user string, write bool, p string) error
+}
+
+// originResolver 把历史版本、回收站中的路径映射回原来的路径，路径规则按原路径匹配
+type originResolver interface {
+	OriginPath(ctx context.Context, name string) (string, error)
+}
+
+// authorize 检查请求读取和修改的路径，目录列表等子路径由 readable 过滤
+func (h *Handler) authorize(r *http.Request) error {
+	if h.Authorizer == nil {
+		return nil
+	}
+
+	ctx := r.Context()
+
+	reqPath, _, err := h.stripPrefix(r.URL.Path)
+	if err != nil {
+		return nil
+	}
+
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS", "PROPFIND", "REPORT", "SEARCH", "COPY":
+		err = h.check(ctx, false, reqPath, false)
+	case "DELETE", "MOVE", "LOCK":
+		err = h.check(ctx, true, reqPath, true)
+	default:
+		// POST 上传文件，PUT、MKCOL、PROPPATCH 等只修改请求路径
+		err = h.check(ctx, true, reqPath, false)
+	}
+	if err != nil {
+		return err
+	}
+
+	if r.Method == "COPY" || r.Method == "MOVE" {
+		u, err := url.Parse(r.Header.Get("Destination"))
+		if err != nil {
+			return nil
+		}
+		if dst, _, err := h.stripPrefix(u.Path); err == nil {
+			// 覆盖目录时会删除目标下的全部文件
+			return h.check(ctx, true, dst, true)
+		}
+	}
+
+	return nil
+}
+
+// check 按原路径检查权限，tree 为 true 时同时检查子路径的规则
+func (h *Handler) check(ctx context.Context, write bool, p string, tree bool) error {
+	p = path.Clean("/" + p)
+
+	if o, ok := h.FileSystem.(originResolver); ok {
+		origin, err := o.OriginPath(ctx, p)
+		if err != nil {
+			return err
+		}
+		p = origin
+	}
+
+	if tree {
+		return h.Authorizer.AuthorizeTree(userOf(ctx), write, p)
+	}
+
+	return h.Authorizer.Authorize(userOf(ctx), write, p)
+}
+
+// readable 用户能否读取路径，用于过滤目录列表、打包、搜索和同步结果
+func (h *Handler) readable(ctx context.Context, p string) bool {
+	return h.Authorizer == nil || h.check(ctx, false, p, false) == nil
+}
+
+// serveFiltered 交给 webdav.Handler 处理，PROPFIND 的目录列表和 COPY 复制的子文件只包含用户可以读取的文件
+func (h *Handler) serveFiltered(w http.ResponseWriter, r *http.Request) {
+	fs := h.FileSystem
+
+	// 容量属性只在明确请求时返回
+	if r.Method == "PROPFIND" && allpropRequest(r) {
+		fs = &allpropFS{FileSystem: fs}
+	}
+	if h.Authorizer != nil {
+		fs = &authorizedFS{FileSystem: fs, h: h}
+	}
+
+	handler := h.Handler
+	handler.FileSystem = fs
+	handler.ServeHTTP(w, r)
+}
+
+// authorizedFS 只读打开的目录过滤掉用户不能读取的文件，交给 webdav.Handler 处理 PROPFIND 和 COPY
+type authorizedFS struct {
+	webdav.FileSystem
+	h *Handler
+}
+
+func (a *authorizedFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	f, err := a.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil || flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return f, err
+	}
+
+	return &authorizedFile{File: f, h: a.h, ctx: ctx, name: name}, nil
+}
+
+type authorizedFile struct {
+	webdav.File
+	h    *Handler
+	ctx  context.Context
+	name string
+}
+
+func (f *authorizedFile) Readdir(count int) ([]fs.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	if err != nil {
+		return infos, err
+	}
+
+	result := infos[:0]
+	for _, fi := range infos {
+		if f.h.readable(f.ctx, path.Join(f.name, fi.Name())) {
+			result = append(result, fi)
+		}
+	}
+
+	return result, nil
+}
+
+func (f *authorizedFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	holder, ok := f.File.(webdav.DeadPropsHolder)
+	if !ok {
+		return nil, nil
+	}
+
+	props, err := holder.DeadProps()
+	if err != nil {
+		return nil, err
+	}
+
+	// 用户不能修改的文件只返回读取权限，客户端据此禁止修改
+	if p, ok := props[propOCPermissions]; ok && f.h.check(f.ctx, true, f.name, false) != nil {
+		props[propOCPermissions] = webdav.Property{XMLName: p.XMLName, InnerXML: []byte(ocReadOnlyPermissions)}
+	}
+
+	return props, nil
+}
+
+func (f *authorizedFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	if holder, ok := f.File.(webdav.DeadPropsHolder); ok {
+		return holder.Patch(patches)
+	}
+
+	return nil, webdav.ErrNotImplemented
+}
+
+// OriginPath 历史版本映射到对应的文件，回收站中的文件映射到删除前的位置，回收站本身映射到根目录
+func (a *aliDriveFS) OriginPath(ctx context.Context, name string) (string, error) {
+	if rel, ok := versionsPath(name); ok {
+		return rel, nil
+	}
+
+	rel, ok := trashPath(name)
+	if !ok {
+		return name, nil
+	}
+	if rel == "" {
+		return "/", nil
+	}
+
+	item, err := a.trashItem(rel)
+	if os.IsNotExist(err) {
+		// 不存在的文件按原路径检查，之后返回 404
+		return name, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return a.trash.origin(rel, func() (string, error) {
+		dir, err := a.pathOf(item.ParentFileId, map[string]string{aliyundrive.DefaultRootFileId: "/"})
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(dir, item.Name), nil
+	})
+}
@@ -0,0 +1,152 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/net/webdav"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// testAuthorizer 只有 alice 可以访问 /secret
+type testAuthorizer struct{}
+
+func (testAuthorizer) Authorize(user string, write bool, p string) error {
+	if (p == "/secret" || strings.HasPrefix(p, "/secret/")) && user != "alice" {
+		return errors.New("forbidden")
+	}
+	return nil
+}
+
+func (a testAuthorizer) AuthorizeTree(user string, write bool, p string) error {
+	if err := a.Authorize(user, write, p); err != nil {
+		return err
+	}
+	if p == "/" {
+		return a.Authorize(user, write, "/secret")
+	}
+	return nil
+}
+
+// newTestHandler 创建使用内存文件系统的 Handler，files 为文件路径和内容
+func newTestHandler(t *testing.T, files map[string]string) *Handler {
+	fs := webdav.NewMemFS()
+	ctx := context.Background()
+
+	for name, content := range files {
+		// 逐级创建上级目录
+		dir := ""
+		for _, elem := range strings.Split(strings.Trim(path.Dir(name), "/"), "/") {
+			if elem == "" {
+				continue
+			}
+			dir += "/" + elem
+			if err := fs.Mkdir(ctx, dir, 0755); err != nil && !os.IsExist(err) {
+				t.Fatal(err)
+			}
+		}
+
+		f, err := fs.OpenFile(ctx, name, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		_ = f.Close()
+	}
+
+	return &Handler{
+		Handler: webdav.Handler{
+			FileSystem: fs,
+			LockSystem: webdav.NewMemLS(),
+		},
+		Authorizer: testAuthorizer{},
+	}
+}
+
+// withUser 设置请求的用户
+func withUser(r *http.Request, user string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), CtxUserValue, user))
+}
+
+// serve 以 user 身份发送请求，和 main 一样在 ctx 中设置请求大小
+func serve(h *Handler, user, method, target string, header map[string]string, body string) *httptest.ResponseRecorder {
+	r := withUser(httptest.NewRequest(method, target, strings.NewReader(body)), user)
+	r = r.WithContext(context.WithValue(r.Context(), CtxSizeValue, r.ContentLength))
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestAuthorizeRequests(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"/docs/a.txt":   "hello",
+		"/secret/b.txt": "secret",
+	})
+
+	for _, tt := range []struct {
+		user, method, target string
+		header               map[string]string
+		status               int
+	}{
+		{"bob", "GET", "/secret/b.txt", nil, http.StatusForbidden},
+		{"alice", "GET", "/secret/b.txt", nil, http.StatusOK},
+		{"bob", "PUT", "/secret/c.txt", nil, http.StatusForbidden},
+		{"bob", "POST", "/secret/", nil, http.StatusForbidden},
+		{"bob", "PROPPATCH", "/secret/b.txt", nil, http.StatusForbidden},
+		{"bob", "DELETE", "/", nil, http.StatusForbidden},
+		{"bob", "MOVE", "/docs/a.txt", map[string]string{"Destination": "/secret/a.txt"}, http.StatusForbidden},
+		{"bob", "COPY", "/secret/b.txt", map[string]string{"Destination": "/docs/b.txt"}, http.StatusForbidden},
+		{"bob", "SEARCH", "/secret/", nil, http.StatusForbidden},
+		{"bob", "REPORT", "/secret/", nil, http.StatusForbidden},
+	} {
+		if w := serve(h, tt.user, tt.method, tt.target, tt.header, ""); w.Code != tt.status {
+			t.Errorf("%s %s %s: status %d, expected %d", tt.user, tt.method, tt.target, w.Code, tt.status)
+		}
+	}
+}
+
+func TestAuthorizeListing(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"/docs/a.txt":   "hello",
+		"/secret/b.txt": "secret",
+	})
+
+	depth1 := map[string]string{"Depth": "1"}
+
+	w := serve(h, "bob", "PROPFIND", "/", depth1, "")
+	if w.Code != http.StatusMultiStatus || strings.Contains(w.Body.String(), "/secret") || !strings.Contains(w.Body.String(), "/docs") {
+		t.Fatalf("PROPFIND by bob: status %d, %s", w.Code, w.Body.String())
+	}
+
+	w = serve(h, "alice", "PROPFIND", "/", depth1, "")
+	if !strings.Contains(w.Body.String(), "/secret") {
+		t.Fatalf("PROPFIND by alice: status %d, %s", w.Code, w.Body.String())
+	}
+
+	w = serve(h, "bob", "GET", "/", nil, "")
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "secret") {
+		t.Fatalf("directory page by bob: status %d", w.Code)
+	}
+
+	// 复制根目录时跳过不能读取的文件
+	if w = serve(h, "bob", "COPY", "/", map[string]string{"Destination": "/copy/"}, ""); w.Code != http.StatusCreated {
+		t.Fatalf("COPY by bob: status %d, %s", w.Code, w.Body.String())
+	}
+	if _, err := h.FileSystem.Stat(context.Background(), "/copy/secret/b.txt"); err == nil {
+		t.Fatal("unreadable file copied")
+	}
+	if _, err := h.FileSystem.Stat(context.Background(), "/copy/docs/a.txt"); err != nil {
+		t.Fatalf("readable file not copied: %s", err)
+	}
+}
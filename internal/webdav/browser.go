@@ -0,0 +1,372 @@
+package webdav
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/net/webdav"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxUploadMemory 表单上传时缓存在内存中的大小，超过部分写入临时文件
+const maxUploadMemory = 32 << 20
+
+type browserEntry struct {
+	Name        string    `json:"name"`
+	Path        string    `json:"path"`
+	Href        string    `json:"href"`
+	IsDir       bool      `json:"is_dir"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"modified"`
+	ContentType string    `json:"content_type,omitempty"`
+	Icon        string    `json:"-"`
+}
+
+type browserCrumb struct {
+	Name string
+	Href string
+}
+
+type browserPage struct {
+	Path     string          `json:"path"`
+	Entries  []*browserEntry `json:"entries"`
+	Crumbs   []browserCrumb  `json:"-"`
+	Sort     string          `json:"-"`
+	Order    string          `json:"-"`
+	Parent   bool            `json:"-"` // 是否显示上级目录
+	ReadOnly bool            `json:"-"` // 通过分享链接访问时不允许上传
+	Query    string          `json:"-"` // 需要附加到链接上的查询参数
+
+	parent string // 上级目录的链接
+}
+
+// SortHref 返回按 key 排序的链接，当前排序列再次点击时反转顺序
+func (p *browserPage) SortHref(key string) string {
+	order := "asc"
+	if p.Sort == key && p.Order == "asc" {
+		order = "desc"
+	}
+
+	return p.withQuery("?sort=" + key + "&order=" + order)
+}
+
+// ArchiveHref 返回打包下载当前目录的链接
+func (p *browserPage) ArchiveHref(format string) string {
+	return p.withQuery("?archive=" + format)
+}
+
+// ParentHref 返回上级目录的链接，请求路径没有以 / 结尾时相对路径 ../ 会指向错误的目录
+func (p *browserPage) ParentHref() string {
+	if p.Query == "" {
+		return p.parent
+	}
+	return p.parent + "?" + p.Query
+}
+
+func (p *browserPage) withQuery(href string) string {
+	if p.Query == "" {
+		return href
+	}
+	return href + "&" + p.Query
+}
+
+// serveDirectory 目录的 GET 请求返回 HTML 列表，Accept: application/json 时返回 JSON
+func (h *Handler) serveDirectory(w http.ResponseWriter, r *http.Request, reqPath string, f webdav.File) (int, error) {
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	dir := path.Clean("/" + reqPath)
+
+	root, suffix := "/", ""
+	sc := shareFromContext(r.Context())
+	if sc != nil {
+		root, suffix = sc.root, "?"+sc.query
+	}
+
+	// 通过分享链接访问时只显示相对分享目录的路径，不暴露云盘中的完整路径
+	display := func(p string) string {
+		if sc == nil {
+			return p
+		}
+		return path.Join("/", strings.TrimPrefix(p, sc.root))
+	}
+
+	page := &browserPage{
+		Path:     display(dir),
+		Entries:  make([]*browserEntry, 0, len(infos)),
+		Crumbs:   h.crumbs(root, dir, suffix),
+		Sort:     r.URL.Query().Get("sort"),
+		Order:    r.URL.Query().Get("order"),
+		Parent:   dir != root,
+		ReadOnly: sc != nil,
+		parent:   h.link(path.Dir(dir), true),
+	}
+	if sc != nil {
+		page.Query = sc.query
+	}
+
+	for _, fi := range infos {
+		p := path.Join(dir, fi.Name())
+		if !h.readable(r.Context(), p) {
+			continue
+		}
+		entry := &browserEntry{
+			Name:    fi.Name(),
+			Path:    display(p),
+			Href:    h.link(p, fi.IsDir()) + suffix,
+			IsDir:   fi.IsDir(),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+			Icon:    fileIcon(fi),
+		}
+		if !fi.IsDir() {
+			entry.ContentType = contentType(fi)
+		}
+
+		page.Entries = append(page.Entries, entry)
+	}
+
+	sortEntries(page.Entries, page.Sort, page.Order)
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, page)
+		return 0, nil
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == "HEAD" {
+		return 0, nil
+	}
+
+	if err := browserTemplate.Execute(w, page); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return 0, nil
+}
+
+// handleBrowserUpload 处理目录页面的表单上传，完成后返回目录页面
+func (h *Handler) handleBrowserUpload(w http.ResponseWriter, r *http.Request, reqPath string) (int, error) {
+	// 浏览器跨站提交的表单会带上 Basic Auth 凭证，只接受同源页面的上传
+	if !sameOrigin(r) {
+		return http.StatusForbidden, errCrossOrigin
+	}
+
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		return http.StatusBadRequest, err
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	dir := path.Clean("/" + reqPath)
+
+	for _, headers := range r.MultipartForm.File {
+		for _, header := range headers {
+			name := path.Base(path.Clean("/" + header.Filename))
+			if name == "/" || name == "." {
+				continue
+			}
+
+			src, err := header.Open()
+			if err != nil {
+				return http.StatusBadRequest, err
+			}
+
+			err = h.uploadFile(r.Context(), path.Join(dir, name), header.Size, src)
+			_ = src.Close()
+			if err != nil {
+				return http.StatusInternalServerError, err
+			}
+		}
+	}
+
+	http.Redirect(w, r, h.link(dir, true), http.StatusSeeOther)
+
+	return 0, nil
+}
+
+// sameOrigin 检查 Origin 或 Referer 是否和 Host 一致，都没有时不是浏览器发起的请求
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// uploadFile 通过文件系统上传文件，size 为文件大小
+func (h *Handler) uploadFile(ctx context.Context, name string, size int64, src io.Reader) error {
+	ctx = context.WithValue(ctx, CtxSizeValue, size)
+
+	f, err := h.FileSystem.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	_, copyErr := Copy(f, src)
+	closeErr := f.Close()
+
+	if copyErr != nil {
+		return copyErr
+	}
+
+	return closeErr
+}
+
+// link 返回带前缀、已转义的链接，目录以 / 结尾
+func (h *Handler) link(p string, dir bool) string {
+	p = h.Prefix + p
+	if dir && !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// crumbs 返回从 root 到 dir 的导航链接，suffix 附加在每个链接后
+func (h *Handler) crumbs(root, dir, suffix string) []browserCrumb {
+	crumbs := []browserCrumb{{Name: path.Base(root), Href: h.link(root, true) + suffix}}
+
+	current := root
+	for _, name := range strings.Split(strings.Trim(strings.TrimPrefix(dir, root), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		current = path.Join(current, name)
+		crumbs = append(crumbs, browserCrumb{Name: name, Href: h.link(current, true) + suffix})
+	}
+
+	return crumbs
+}
+
+// sortEntries 目录始终排在文件之前
+func sortEntries(entries []*browserEntry, key, order string) {
+	less := func(a, b *browserEntry) bool {
+		switch key {
+		case "size":
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case "modified":
+			if !a.ModTime.Equal(b.ModTime) {
+				return a.ModTime.Before(b.ModTime)
+			}
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		if order == "desc" {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+}
+
+func fileIcon(fi os.FileInfo) string {
+	if fi.IsDir() {
+		return "📁"
+	}
+
+	if info, ok := fi.(*aliFileInfo); ok && info.file != nil {
+		switch info.file.Category {
+		case "image":
+			return "🖼️"
+		case "video":
+			return "🎬"
+		case "audio":
+			return "🎵"
+		case "doc":
+			return "📝"
+		case "zip":
+			return "📦"
+		}
+	}
+
+	return "📄"
+}
+
+// formatSize 以 1024 为单位格式化文件大小
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+var browserTemplate = template.Must(template.New("browser").Funcs(template.FuncMap{
+	"size": formatSize,
+	"time": func(t time.Time) string { return t.Local().Format("2006-01-02 15:04:05") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Path}} - aliyundrive-webdav</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #333; }
+a { color: #0969da; text-decoration: none; }
+a:hover { text-decoration: underline; }
+table { border-collapse: collapse; width: 100%; }
+th, td { padding: .4em .8em; text-align: left; border-bottom: 1px solid #eee; }
+th a { color: #333; }
+td.size, th.size { text-align: right; white-space: nowrap; }
+td.time { white-space: nowrap; color: #666; }
+.crumbs { font-size: 1.2em; margin-bottom: 1em; }
+form { margin: 1em 0; }
+</style>
+</head>
+<body>
+<div class="crumbs">{{range $i, $c := .Crumbs}}{{if $i}} / {{end}}<a href="{{$c.Href}}">{{$c.Name}}</a>{{end}}</div>
+<p>打包下载：<a href="{{.ArchiveHref "zip"}}">zip</a> <a href="{{.ArchiveHref "tar.gz"}}">tar.gz</a></p>
+{{if not .ReadOnly}}<form method="post" enctype="multipart/form-data">
+<input type="file" name="file" multiple required>
+<button type="submit">上传</button>
+</form>{{end}}
+<table>
+<thead>
+<tr>
+<th><a href="{{.SortHref "name"}}">名称</a></th>
+<th class="size"><a href="{{.SortHref "size"}}">大小</a></th>
+<th><a href="{{.SortHref "modified"}}">修改时间</a></th>
+<th></th>
+</tr>
+</thead>
+<tbody>
+{{if .Parent}}<tr><td colspan="4"><a href="{{.ParentHref}}">⬆️ ..</a></td></tr>{{end}}
+{{range .Entries}}<tr>
+<td>{{.Icon}} <a href="{{.Href}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td>
+<td class="size">{{if not .IsDir}}{{size .Size}}{{end}}</td>
+<td class="time">{{time .ModTime}}</td>
+<td>{{if not .IsDir}}<a href="{{.Href}}" download>下载</a>{{end}}</td>
+</tr>
+{{end}}</tbody>
+</table>
+</body>
+</html>
+`))
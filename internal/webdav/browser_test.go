@@ -0,0 +1,231 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSortEntries(t *testing.T) {
+	now := time.Now()
+	entries := func() []*browserEntry {
+		return []*browserEntry{
+			{Name: "b.txt", Size: 1, ModTime: now},
+			{Name: "Z", IsDir: true, ModTime: now},
+			{Name: "a.txt", Size: 3, ModTime: now.Add(-time.Hour)},
+			{Name: "c.txt", Size: 2, ModTime: now.Add(time.Hour)},
+			{Name: "d", IsDir: true, ModTime: now.Add(time.Hour)},
+		}
+	}
+
+	tests := []struct {
+		key, order string
+		want       string
+	}{
+		{"", "", "d Z a.txt b.txt c.txt"},
+		{"name", "desc", "Z d c.txt b.txt a.txt"},
+		{"size", "asc", "d Z b.txt c.txt a.txt"},
+		{"modified", "asc", "Z d a.txt b.txt c.txt"},
+		{"modified", "desc", "d Z c.txt b.txt a.txt"},
+	}
+
+	for _, tt := range tests {
+		list := entries()
+		sortEntries(list, tt.key, tt.order)
+
+		var names []string
+		for _, e := range list {
+			names = append(names, e.Name)
+		}
+		if got := strings.Join(names, " "); got != tt.want {
+			t.Errorf("sort %s %s: %s, expected %s", tt.key, tt.order, got, tt.want)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+	}
+
+	for _, tt := range tests {
+		if got := formatSize(tt.size); got != tt.want {
+			t.Errorf("formatSize(%d) = %q, expected %q", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		origin, referer string
+		want            bool
+	}{
+		{"", "", true},
+		{"http://example.com", "", true},
+		{"http://EXAMPLE.com", "", true},
+		{"", "http://example.com/dir/", true},
+		{"http://evil.com", "", false},
+		{"", "http://evil.com/dir/", false},
+		{"http://evil.com", "http://example.com/dir/", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "http://example.com/dir/", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if tt.referer != "" {
+			r.Header.Set("Referer", tt.referer)
+		}
+
+		if got := sameOrigin(r); got != tt.want {
+			t.Errorf("sameOrigin(%q, %q) = %v", tt.origin, tt.referer, got)
+		}
+	}
+}
+
+func TestBrowseDirectory(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"/docs/a.txt":        "hello",
+		"/docs/<b>.txt":      "bold",
+		"/docs/sub/c.txt":    "c",
+		"/secret/hidden.txt": "secret",
+	})
+
+	tests := []struct {
+		name, user, target string
+		header             map[string]string
+		contains, excludes []string
+	}{
+		{"html", "bob", "/docs/", nil,
+			[]string{`href="/docs/a.txt"`, `href="/docs/sub/"`, "&lt;b&gt;.txt", `href="/docs/%3Cb%3E.txt"`}, []string{"<b>.txt"}},
+		// 没有权限的目录不显示
+		{"unreadable entries", "bob", "/", nil, []string{`href="/docs/"`}, []string{"secret"}},
+		{"readable entries", "alice", "/", nil, []string{`href="/docs/"`, `href="/secret/"`}, nil},
+		{"sort links", "bob", "/docs/?sort=name&order=asc", nil, []string{"?sort=name&amp;order=desc"}, nil},
+	}
+
+	for _, tt := range tests {
+		w := serve(h, tt.user, "GET", tt.target, tt.header, "")
+		body := w.Body.String()
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+			t.Errorf("%s: status %d, content type %s", tt.name, w.Code, w.Header().Get("Content-Type"))
+		}
+		for _, s := range tt.contains {
+			if !strings.Contains(body, s) {
+				t.Errorf("%s: %s not in %s", tt.name, s, body)
+			}
+		}
+		for _, s := range tt.excludes {
+			if strings.Contains(body, s) {
+				t.Errorf("%s: unexpected %s in %s", tt.name, s, body)
+			}
+		}
+	}
+
+	w := serve(h, "bob", "GET", "/docs/?sort=size&order=desc", map[string]string{"Accept": "application/json"}, "")
+	var page browserPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("json listing %s: %s", err, w.Body.String())
+	}
+
+	var names []string
+	for _, e := range page.Entries {
+		names = append(names, e.Name)
+	}
+	if page.Path != "/docs" || strings.Join(names, " ") != "sub a.txt <b>.txt" || page.Entries[1].Path != "/docs/a.txt" {
+		t.Fatalf("json listing %+v", page)
+	}
+}
+
+// uploadForm 返回表单上传的请求体和 Content-Type
+func uploadForm(t *testing.T, files map[string]string) (string, string) {
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+
+	for name, content := range files {
+		fw, err := mw.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.String(), mw.FormDataContentType()
+}
+
+func TestBrowserUpload(t *testing.T) {
+	h := newTestHandler(t, map[string]string{"/docs/a.txt": "hello"})
+
+	tests := []struct {
+		name     string
+		origin   string
+		filename string
+		status   int
+		created  string
+	}{
+		{"upload", "", "new.txt", http.StatusSeeOther, "/docs/new.txt"},
+		{"same origin", "http://example.com", "same.txt", http.StatusSeeOther, "/docs/same.txt"},
+		// 文件名中的路径被忽略
+		{"path in filename", "", "../../escape.txt", http.StatusSeeOther, "/docs/escape.txt"},
+		{"cross origin", "http://evil.com", "evil.txt", http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		body, contentType := uploadForm(t, map[string]string{tt.filename: tt.name})
+		header := map[string]string{"Content-Type": contentType}
+		if tt.origin != "" {
+			header["Origin"] = tt.origin
+		}
+
+		w := serve(h, "bob", "POST", "http://example.com/docs/", header, body)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, expected %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if tt.created == "" {
+			continue
+		}
+		if location := w.Header().Get("Location"); location != "/docs/" {
+			t.Errorf("%s: redirected to %s", tt.name, location)
+		}
+
+		f, err := h.FileSystem.OpenFile(context.Background(), tt.created, os.O_RDONLY, 0)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		content, _ := ioutil.ReadAll(f)
+		_ = f.Close()
+		if string(content) != tt.name {
+			t.Errorf("%s: content %q", tt.name, content)
+		}
+	}
+
+	// 没有权限的目录不能上传
+	body, contentType := uploadForm(t, map[string]string{"x.txt": "x"})
+	if w := serve(h, "bob", "POST", "/secret/", map[string]string{"Content-Type": contentType}, body); w.Code != http.StatusForbidden {
+		t.Errorf("upload to unauthorized directory status %d", w.Code)
+	}
+}
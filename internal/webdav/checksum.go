@@ -0,0 +1,56 @@
+package webdav
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"golang.org/x/net/webdav"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var propOCChecksums = xml.Name{Space: "http://owncloud.org/ns", Local: "checksums"}
+
+// checksumSha1 返回阿里云盘保存的文件 SHA1（小写 HEX），目录或未上传完成的文件返回空
+func checksumSha1(fi os.FileInfo) string {
+	info, ok := fi.(*aliFileInfo)
+	if !ok || info.file == nil || info.IsDir() {
+		return ""
+	}
+
+	if !strings.EqualFold(info.file.ContentHashName, "sha1") || info.file.ContentHash == "" {
+		return ""
+	}
+
+	return strings.ToLower(info.file.ContentHash)
+}
+
+// setChecksumHeaders 设置 OC-Checksum（ownCloud）和 Digest（RFC 3230）响应头
+func setChecksumHeaders(w http.ResponseWriter, fi os.FileInfo) {
+	sum := checksumSha1(fi)
+	if sum == "" {
+		return
+	}
+
+	w.Header().Set("OC-Checksum", "SHA1:"+sum)
+
+	if raw, err := hex.DecodeString(sum); err == nil {
+		w.Header().Set("Digest", "SHA="+base64.StdEncoding.EncodeToString(raw))
+	}
+}
+
+// checksumProps 返回 oc:checksums 属性
+func checksumProps(fi os.FileInfo) map[xml.Name]webdav.Property {
+	sum := checksumSha1(fi)
+	if sum == "" {
+		return nil
+	}
+
+	return map[xml.Name]webdav.Property{
+		propOCChecksums: {
+			XMLName:  propOCChecksums,
+			InnerXML: []byte(`<checksum xmlns="http://owncloud.org/ns">SHA1:` + sum + `</checksum>`),
+		},
+	}
+}
@@ -0,0 +1,74 @@
+package webdav
+
+import (
+	"github.com/jakeslee/aliyundrive/models"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const helloSha1 = "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"
+
+// hashedFile 返回带有 hash 的文件信息
+func hashedFile(fileType models.FileType, hashName, hash string) os.FileInfo {
+	file := &models.File{Type: fileType}
+	file.ContentHashName = hashName
+	file.ContentHash = hash
+
+	return NewAliFileInfo(file)
+}
+
+func TestChecksumSha1(t *testing.T) {
+	tests := []struct {
+		name string
+		fi   os.FileInfo
+		want string
+	}{
+		{"upper case", hashedFile(models.FileTypeFile, "sha1", strings.ToUpper(helloSha1)), helloSha1},
+		{"hash name case", hashedFile(models.FileTypeFile, "SHA1", helloSha1), helloSha1},
+		{"other hash", hashedFile(models.FileTypeFile, "md5", helloSha1), ""},
+		{"no hash", hashedFile(models.FileTypeFile, "sha1", ""), ""},
+		{"folder", hashedFile(models.FileTypeFolder, "sha1", helloSha1), ""},
+		// 上传未完成时没有 file
+		{"uploading", &aliFileInfo{name: "a.txt", modTime: time.Now()}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := checksumSha1(tt.fi); got != tt.want {
+			t.Errorf("%s: checksumSha1() = %q, expected %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestChecksumRequests(t *testing.T) {
+	fs := newTestFS(t, false)
+	upload(t, fs, "/a.txt", "hello", nil)
+	h := newDriveHandler(fs, nil)
+
+	for _, method := range []string{"GET", "HEAD"} {
+		w := serve(h, "alice", method, "/a.txt", nil, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s status %d", method, w.Code)
+		}
+		if got := w.Header().Get("OC-Checksum"); got != "SHA1:"+helloSha1 {
+			t.Errorf("%s OC-Checksum %q", method, got)
+		}
+		if got := w.Header().Get("Digest"); got != "SHA=qvTGHdzF6KLavt4PO0gs2a6pQ00=" {
+			t.Errorf("%s Digest %q", method, got)
+		}
+	}
+
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:oc="http://owncloud.org/ns"><D:prop><oc:checksums/></D:prop></D:propfind>`
+	w := serve(h, "alice", "PROPFIND", "/a.txt", map[string]string{"Depth": "0"}, body)
+	if !strings.Contains(w.Body.String(), "SHA1:"+helloSha1) {
+		t.Fatalf("oc:checksums missing: %s", w.Body.String())
+	}
+
+	// 目录没有校验和
+	w = serve(h, "alice", "PROPFIND", "/", map[string]string{"Depth": "0"}, body)
+	if strings.Contains(w.Body.String(), "SHA1:") {
+		t.Fatalf("folder has checksum: %s", w.Body.String())
+	}
+}
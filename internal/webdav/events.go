@@ -0,0 +1,67 @@
+package webdav
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventUpload EventType = "upload"
+	EventMkdir  EventType = "mkdir"
+	EventDelete EventType = "delete"
+	EventMove   EventType = "move"
+)
+
+// Event 文件变更事件，由 aliDriveFS 的修改操作和上传完成时产生
+type Event struct {
+	Type        EventType `json:"type"`
+	Path        string    `json:"path"`
+	Destination string    `json:"destination,omitempty"` // 移动、重命名后的路径
+	FileId      string    `json:"file_id,omitempty"`
+	IsDir       bool      `json:"is_dir,omitempty"`
+	Size        int64     `json:"size,omitempty"`
+	Hash        string    `json:"hash,omitempty"` // 上传文件的 SHA1
+	User        string    `json:"user,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+type EventListener func(event *Event)
+
+// userOf 返回请求的用户，由 CtxUserValue 传入
+func userOf(ctx context.Context) string {
+	user, _ := ctx.Value(CtxUserValue).(string)
+	return user
+}
+
+// EventSource 可以订阅文件变更事件的文件系统
+type EventSource interface {
+	Subscribe(listener EventListener)
+}
+
+type eventBus struct {
+	mu        sync.RWMutex
+	listeners []EventListener
+}
+
+func (b *eventBus) Subscribe(listener EventListener) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.listeners = append(b.listeners, listener)
+}
+
+func (b *eventBus) publish(event *Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, listener := range b.listeners {
+		listener(event)
+	}
+}
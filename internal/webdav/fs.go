@@ -4,9 +4,12 @@ import (
 	"container/list"
 	"context"
 	"crypto/sha1"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"github.com/jakeslee/aliyundrive"
+	"github.com/jakeslee/aliyundrive-webdav/internal/backend"
+	"github.com/jakeslee/aliyundrive-webdav/internal/metrics"
 	"github.com/jakeslee/aliyundrive/models"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/webdav"
@@ -14,6 +17,7 @@ import (
 	"io"
 	"io/fs"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -22,30 +26,114 @@ import (
 )
 
 const (
-	CtxSizeValue = "Size"
+	CtxSizeValue    = "Size"
+	CtxModTimeValue = "ModTime"
+	CtxUserValue    = "User" // 请求的用户，记录在文件变更事件中
 )
 
 var RapidCache = sync.Map{}
 var RapidCacheFolder = sync.Map{}
 
-func NewAliDriveFS(drive *aliyundrive.AliyunDrive, credential *aliyundrive.Credential, rapid bool) webdav.FileSystem {
-	logrus.Infof("rapid upload mode: %v", rapid)
-	return &aliDriveFS{
-		driver:      drive,
-		credential:  credential,
-		rapidUpload: rapid,
+// CleanRapidCache 删除秒传的本地临时文件，退出后不会再执行 1 分钟后的定时清理
+func CleanRapidCache() {
+	RapidCache.Range(func(key, value interface{}) bool {
+		if err := os.Remove(value.(string)); err != nil && !os.IsNotExist(err) {
+			logrus.Warnf("remove temp file error %s", err)
+		}
+		RapidCache.Delete(key)
+
+		return true
+	})
+}
+
+type Options struct {
+	RapidUpload bool   // 秒传模式
+	WorkDir     string // 工作目录，用于保存文件元信息
+	HardDelete  bool   // 彻底删除文件，不移动到回收站
+}
+
+func NewAliDriveFS(b backend.Backend, options *Options) webdav.FileSystem {
+	logrus.Infof("rapid upload mode: %v", options.RapidUpload)
+	logrus.Infof("hard delete mode: %v", options.HardDelete)
+	fs := &aliDriveFS{
+		backend:     b,
+		rapidUpload: options.RapidUpload,
+		hardDelete:  options.HardDelete,
+		meta:        newMetaStore(options.WorkDir),
+		quota:       newQuotaCache(b),
+		events:      &eventBus{},
+		journal:     newChangeJournal(),
+		trash:       &trashCache{},
+		versions:    &revisionCache{},
 	}
+
+	fs.events.Subscribe(fs.journal.listen)
+
+	return fs
+}
+
+// ReadyChecker 可以检查存储后端是否可用的文件系统，用于就绪检查
+type ReadyChecker interface {
+	Ready() error
+}
+
+// Drainer 可以等待后台上传完成的文件系统，用于优雅退出
+type Drainer interface {
+	Drain(ctx context.Context) error
 }
 
 type aliDriveFS struct {
 	mu          sync.Mutex
-	driver      *aliyundrive.AliyunDrive
-	credential  *aliyundrive.Credential
+	backend     backend.Backend
 	rapidUpload bool
+	hardDelete  bool
+	meta        *metaStore
+	quota       *quotaCache
+	events      *eventBus
+	journal     *changeJournal
+	trash       *trashCache
+	versions    *revisionCache
+
+	// transfers 请求结束后仍在后台进行的上传和秒传
+	transfers sync.WaitGroup
+}
+
+// Subscribe 订阅文件变更事件
+func (a *aliDriveFS) Subscribe(listener EventListener) {
+	a.events.Subscribe(listener)
+}
+
+// Ready 检查存储后端是否可用
+func (a *aliDriveFS) Ready() error {
+	return backend.Check(a.backend)
+}
+
+// Drain 等待后台上传完成，超时返回 ctx 的错误
+func (a *aliDriveFS) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		a.transfers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return a.meta.Flush()
+	case <-ctx.Done():
+		if err := a.meta.Flush(); err != nil {
+			logrus.Warnf("write metadata file error %s", err)
+		}
+		return ctx.Err()
+	}
+}
+
+// Quota 返回网盘可用和已用空间
+func (a *aliDriveFS) Quota(ctx context.Context, name string) (available, used int64, err error) {
+	return a.quota.Get()
 }
 
-func (a *aliDriveFS) mkdir(credential *aliyundrive.Credential, fileId, name string) (string, error) {
-	dir, err := a.driver.CreateDirectory(credential, fileId, name)
+func (a *aliDriveFS) mkdir(fileId, name string) (string, error) {
+	dir, err := a.backend.CreateDirectory(fileId, name)
 	if err != nil {
 		return "", err
 	}
@@ -54,9 +142,16 @@ func (a *aliDriveFS) mkdir(credential *aliyundrive.Credential, fileId, name stri
 }
 
 func (a *aliDriveFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if _, ok := trashPath(name); ok {
+		return os.ErrPermission
+	}
+	if _, ok := versionsPath(name); ok {
+		return os.ErrPermission
+	}
+
 	dir := aliyundrive.PrefixSlash(filepath.Clean(name))
 
-	fileId, foundPath, err := a.driver.ResolvePathToFileId(a.credential, dir)
+	fileId, foundPath, err := a.backend.ResolvePathToFileId(dir)
 
 	if err != nil && foundPath != "" {
 		left := aliyundrive.RemovePrefixSlash(dir[len(foundPath):])
@@ -64,13 +159,22 @@ func (a *aliDriveFS) Mkdir(ctx context.Context, name string, perm os.FileMode) e
 		splits := strings.Split(left, "/")
 
 		for _, folder := range splits {
-			id, err := a.mkdir(a.credential, fileId, folder)
+			id, err := a.mkdir(fileId, folder)
 
 			if err != nil {
 				return err
 			}
 
 			fileId = id
+			foundPath = filepath.Join(foundPath, folder)
+
+			a.events.publish(&Event{
+				Type:   EventMkdir,
+				Path:   foundPath,
+				FileId: id,
+				IsDir:  true,
+				User:   userOf(ctx),
+			})
 		}
 	}
 
@@ -78,11 +182,21 @@ func (a *aliDriveFS) Mkdir(ctx context.Context, name string, perm os.FileMode) e
 }
 
 func (a *aliDriveFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if rel, ok := trashPath(name); ok {
+		return a.openTrash(rel, flag)
+	}
+	if rel, ok := versionsPath(name); ok {
+		return a.openVersion(rel, flag)
+	}
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
 	if a.rapidUpload && flag&os.O_CREATE == 0 {
-		if cacheFile, ok := RapidCache.Load(name); ok {
+		cacheFile, ok := RapidCache.Load(name)
+		metrics.ObserveCache("rapid_local_file", ok)
+
+		if ok {
 			logrus.Infof("reqeusted file %s hit local cached file", name)
 
 			file, err := os.OpenFile(cacheFile.(string), flag, perm)
@@ -95,7 +209,7 @@ func (a *aliDriveFS) OpenFile(ctx context.Context, name string, flag int, perm o
 
 	size := ctx.Value(CtxSizeValue).(int64)
 
-	fileId, path, err := a.driver.ResolvePathToFileId(a.credential, name)
+	fileId, path, err := a.backend.ResolvePathToFileId(name)
 
 	// 找不到任何前缀文件，错误
 	if err != nil && path == "" {
@@ -108,7 +222,7 @@ func (a *aliDriveFS) OpenFile(ctx context.Context, name string, flag int, perm o
 		exist = true
 	}
 
-	file, err := a.driver.GetFile(a.credential, fileId)
+	file, err := a.backend.GetFile(fileId)
 	if err != nil {
 		return nil, err
 	}
@@ -119,12 +233,13 @@ func (a *aliDriveFS) OpenFile(ctx context.Context, name string, flag int, perm o
 		//	return nil, os.ErrExist
 		//}
 
-		// 否则删除文件，重新上传
+		// 否则把旧文件移动到回收站，重新上传，上传失败时可以从回收站恢复，不受 HardDelete 影响
 		if exist {
-			_, err := a.driver.RemoveFile(a.credential, fileId)
+			err := a.recycle(fileId)
 			if err != nil {
 				return nil, err
 			}
+			_ = a.meta.Delete(fileId)
 			fileId = file.ParentFileId
 		}
 
@@ -134,18 +249,29 @@ func (a *aliDriveFS) OpenFile(ctx context.Context, name string, flag int, perm o
 			return nil, os.ErrInvalid
 		}
 
+		// 客户端指定了修改时间（X-OC-Mtime），上传完成后保存
+		modTime, keepModTime := ctx.Value(CtxModTimeValue).(time.Time)
+		if !keepModTime {
+			modTime = time.Now()
+		}
+
 		_file := &aliFile{
 			n: &aliFileInfo{
 				size:         size,
 				name:         fileName,
 				mode:         perm,
-				modTime:      time.Now(),
+				modTime:      modTime,
 				parentFileId: fileId,
 			},
-			driver:      a.driver,
-			credential:  a.credential,
+			backend:     a.backend,
+			meta:        a.meta,
+			quota:       a.quota,
+			events:      a.events,
 			enableRapid: a.rapidUpload,
 			fullPath:    name,
+			keepModTime: keepModTime,
+			user:        userOf(ctx),
+			transfers:   &a.transfers,
 		}
 
 		if a.rapidUpload {
@@ -170,21 +296,43 @@ func (a *aliDriveFS) OpenFile(ctx context.Context, name string, flag int, perm o
 		_file.create.reader = reader
 		_file.create.writer = writer
 
+		metrics.UploadsInFlight.Inc()
+		a.transfers.Add(1)
+
 		go func() {
-			_, err := a.driver.UploadFile(a.credential, &aliyundrive.UploadFileOptions{
+			defer a.transfers.Done()
+			defer metrics.UploadsInFlight.Dec()
+
+			uploaded, err := a.backend.UploadFile(&aliyundrive.UploadFileOptions{
 				Name:         fileName,
 				Size:         size,
 				ParentFileId: fileId,
 				Reader:       reader,
 				ProgressStart: func(info *aliyundrive.ProgressInfo) {
+					// 和 Patch 同时修改 fileId 和 pendingProps
+					_file.mu.Lock()
+					defer _file.mu.Unlock()
+
 					_file.n.fileId = info.FileId
+					_file.saveMeta()
 				},
 			})
 			if err != nil {
 				logrus.Errorf("upload file error %s", err)
 				ctx.Done()
+			} else {
+				a.events.publish(&Event{
+					Type:   EventUpload,
+					Path:   name,
+					FileId: uploaded.FileId,
+					Size:   size,
+					Hash:   uploaded.ContentHash,
+					User:   userOf(ctx),
+				})
 			}
 
+			a.quota.Invalidate()
+
 			a.mu.Lock()
 			defer a.mu.Unlock()
 			_file.create.finished = true
@@ -197,11 +345,14 @@ func (a *aliDriveFS) OpenFile(ctx context.Context, name string, flag int, perm o
 		return nil, os.ErrNotExist
 	}
 
-	fileInfo := NewAliFileInfo(file.File)
+	fileInfo := NewAliFileInfo(file)
 	fileRes := &aliFile{
-		n:           fileInfo.(*aliFileInfo),
-		driver:      a.driver,
-		credential:  a.credential,
+		n:           a.meta.apply(fileInfo.(*aliFileInfo)),
+		backend:     a.backend,
+		meta:        a.meta,
+		quota:       a.quota,
+		events:      a.events,
+		journal:     a.journal,
 		fullPath:    name,
 		enableRapid: a.rapidUpload,
 	}
@@ -213,8 +364,15 @@ type aliFile struct {
 	n              *aliFileInfo
 	fullPath       string
 	mu             sync.Mutex
-	driver         *aliyundrive.AliyunDrive
-	credential     *aliyundrive.Credential
+	backend        backend.Backend
+	meta           *metaStore
+	quota          *quotaCache
+	events         *eventBus
+	journal        *changeJournal
+	keepModTime    bool               // 是否保存客户端指定的修改时间
+	pendingProps   []webdav.Proppatch // 上传完成前设置的自定义属性
+	user           string             // 上传文件的用户
+	transfers      *sync.WaitGroup    // 秒传在后台进行，退出时等待完成
 	nextMarker     string
 	lastFetchItems []*models.File
 	pos            int64
@@ -266,14 +424,12 @@ func (a *aliFile) Read(p []byte) (n int, err error) {
 	}
 
 	if a.reader == nil {
-		bytesRange := fmt.Sprintf("bytes=%d-", a.pos)
-
-		response, err := a.driver.Download(a.credential, a.n.file.FileId, bytesRange)
+		reader, err := a.backend.Download(a.n.file.FileId, a.pos)
 		if err != nil {
 			return 0, err
 		}
 
-		a.reader = response.Body
+		a.reader = reader
 		a.readerClosed = false
 	}
 
@@ -340,6 +496,11 @@ func (a *aliFile) Readdir(count int) ([]fs.FileInfo, error) {
 		return nil, os.ErrInvalid
 	}
 
+	// 虚拟目录中的目录不列出子文件
+	if a.n.virtual {
+		return nil, nil
+	}
+
 	result := make([]fs.FileInfo, 0, 10)
 	resultMap := make(map[string]fs.FileInfo)
 
@@ -361,7 +522,7 @@ func (a *aliFile) Readdir(count int) ([]fs.FileInfo, error) {
 		marker := ""
 
 		for {
-			files, err := a.driver.GetFolderFiles(a.credential, &aliyundrive.FolderFilesOptions{
+			files, err := a.backend.GetFolderFiles(&aliyundrive.FolderFilesOptions{
 				OrderBy:        "updated_at",
 				OrderDirection: models.OrderDirectionTypeDescend,
 				FolderFileId:   a.n.file.FileId,
@@ -373,8 +534,11 @@ func (a *aliFile) Readdir(count int) ([]fs.FileInfo, error) {
 			}
 
 			for _, item := range files.Items {
+				if reservedName(a.n.file.FileId, item.Name) {
+					continue
+				}
 				if _, ok := resultMap[item.Name]; !ok {
-					result = append(result, NewAliFileInfo(item))
+					result = append(result, a.meta.apply(NewAliFileInfo(item).(*aliFileInfo)))
 				}
 			}
 
@@ -393,8 +557,11 @@ func (a *aliFile) Readdir(count int) ([]fs.FileInfo, error) {
 			count--
 
 			item := a.lastFetchItems[a.pos]
+			if reservedName(a.n.file.FileId, item.Name) {
+				continue
+			}
 			if _, ok := resultMap[item.Name]; !ok {
-				result = append(result, NewAliFileInfo(item))
+				result = append(result, a.meta.apply(NewAliFileInfo(item).(*aliFileInfo)))
 			}
 		}
 
@@ -402,7 +569,7 @@ func (a *aliFile) Readdir(count int) ([]fs.FileInfo, error) {
 			break
 		}
 
-		files, err := a.driver.GetFolderFiles(a.credential, &aliyundrive.FolderFilesOptions{
+		files, err := a.backend.GetFolderFiles(&aliyundrive.FolderFilesOptions{
 			OrderBy:        "updated_at",
 			OrderDirection: models.OrderDirectionTypeDescend,
 			FolderFileId:   a.n.file.FileId,
@@ -425,6 +592,155 @@ func (a *aliFile) Stat() (fs.FileInfo, error) {
 	return a.n, nil
 }
 
+// SetModTime 设置文件修改时间，用于 PROPPATCH 修改 getlastmodified
+func (a *aliFile) SetModTime(modTime time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.n.modTime = modTime
+	a.keepModTime = true
+
+	// 上传未完成时还没有 fileId，等待上传完成后保存
+	if a.n.fileId == "" {
+		return nil
+	}
+
+	return a.meta.SetModTime(a.n.fileId, a.ancestors(), modTime)
+}
+
+func (a *aliFile) isRoot() bool {
+	return a.n.fileId == aliyundrive.DefaultRootFileId || filepath.Clean(a.fullPath) == "/"
+}
+
+// DeadProps 实现 webdav.DeadPropsHolder
+func (a *aliFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	props := fileDeadProps(a.meta, a.quota, a.n, a.isRoot())
+
+	// 目录提供当前的 sync-token，用于 sync-collection 增量同步
+	if a.n.IsDir() && a.journal != nil {
+		if props == nil {
+			props = make(map[xml.Name]webdav.Property)
+		}
+		props[propSyncToken] = webdav.Property{
+			XMLName:  propSyncToken,
+			InnerXML: []byte(escapeXML(a.journal.Token())),
+		}
+	}
+
+	for _, patch := range a.pendingProps {
+		for _, p := range patch.Props {
+			if props == nil {
+				props = make(map[xml.Name]webdav.Property)
+			}
+
+			if patch.Remove {
+				delete(props, p.XMLName)
+			} else {
+				props[p.XMLName] = p
+			}
+		}
+	}
+
+	return props, nil
+}
+
+// Patch 实现 webdav.DeadPropsHolder
+func (a *aliFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// 上传未完成时还没有 fileId，等待上传完成后保存
+	if a.n.fileId == "" {
+		a.pendingProps = append(a.pendingProps, patches...)
+	} else if err := a.meta.PatchProps(a.n.fileId, a.ancestors(), patches); err != nil {
+		return nil, err
+	}
+
+	pstat := webdav.Propstat{Status: http.StatusOK}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			pstat.Props = append(pstat.Props, webdav.Property{XMLName: p.XMLName})
+		}
+	}
+
+	return []webdav.Propstat{pstat}, nil
+}
+
+// fileDeadProps 合并保存的自定义属性、ownCloud 属性和校验值，根目录额外提供容量信息（RFC 4331）
+func fileDeadProps(meta *metaStore, quota *quotaCache, info *aliFileInfo, root bool) map[xml.Name]webdav.Property {
+	props := meta.DeadProps(info.fileId)
+
+	live := ocProps(info)
+	if root {
+		for name, p := range quota.props() {
+			if live == nil {
+				live = make(map[xml.Name]webdav.Property)
+			}
+			live[name] = p
+		}
+	}
+
+	for name, p := range live {
+		if props == nil {
+			props = make(map[xml.Name]webdav.Property)
+		}
+		props[name] = p
+	}
+
+	return props
+}
+
+// ancestors 返回上级目录的 fileId，路径无法解析时只返回父目录
+func (a *aliFile) ancestors() []string {
+	if ids := ancestorIds(a.backend, filepath.Dir(filepath.Clean("/"+a.fullPath))); len(ids) > 0 {
+		return ids
+	}
+
+	if a.n.parentFileId != "" {
+		return []string{a.n.parentFileId}
+	}
+
+	return nil
+}
+
+// ancestorIds 返回 dir 及其上级目录的 fileId，从近到远，不包含根目录
+func ancestorIds(b backend.Backend, dir string) []string {
+	var ids []string
+
+	for dir = filepath.Clean("/" + dir); dir != "/"; dir = filepath.Dir(dir) {
+		id, _, err := b.ResolvePathToFileId(dir)
+		if err != nil {
+			return nil
+		}
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// saveMeta 上传获得 fileId 后保存客户端指定的修改时间和自定义属性，调用方需要持有 a.mu
+func (a *aliFile) saveMeta() {
+	if a.n.fileId == "" {
+		return
+	}
+
+	if a.keepModTime {
+		if err := a.meta.SetModTime(a.n.fileId, a.ancestors(), a.n.modTime); err != nil {
+			logrus.Warnf("save modification time of %s error %s", a.fullPath, err)
+		}
+	}
+
+	if len(a.pendingProps) > 0 {
+		if err := a.meta.PatchProps(a.n.fileId, a.ancestors(), a.pendingProps); err != nil {
+			logrus.Warnf("save properties of %s error %s", a.fullPath, err)
+		}
+		a.pendingProps = nil
+	}
+}
+
 func (a *aliFile) rapidWrite(p []byte) (n int, err error) {
 	n, err = a.rapid.writer.Write(p)
 	if err != nil {
@@ -450,7 +766,13 @@ func (a *aliFile) uploadFinished() {
 		a.n.size = a.pos
 	}
 
+	metrics.UploadsInFlight.Inc()
+	a.transfers.Add(1)
+
 	go func() {
+		defer a.transfers.Done()
+		defer metrics.UploadsInFlight.Dec()
+
 		_hash := fmt.Sprintf("%x", a.rapid.hash.Sum(nil))
 		defer func(file *os.File) {
 			_ = file.Close()
@@ -475,7 +797,7 @@ func (a *aliFile) uploadFinished() {
 			})
 		}(a.rapid.file)
 
-		fileRapid, rapid, err := a.driver.UploadFileRapid(a.credential, &aliyundrive.UploadFileRapidOptions{
+		fileRapid, rapid, err := uploadRapid(a.backend, &aliyundrive.UploadFileRapidOptions{
 			UploadFileOptions: aliyundrive.UploadFileOptions{
 				Name:         a.n.name,
 				Size:         a.n.size,
@@ -490,77 +812,199 @@ func (a *aliFile) uploadFinished() {
 			return
 		}
 
+		metrics.ObserveRapidUpload(rapid)
+
+		a.quota.Invalidate()
+		a.events.publish(&Event{
+			Type:   EventUpload,
+			Path:   a.fullPath,
+			FileId: fileRapid.FileId,
+			Size:   a.n.size,
+			Hash:   strings.ToUpper(_hash),
+			User:   a.user,
+		})
+
 		logrus.Infof("upload %s finished, rapid mode: %v, fileId %s", a.n.name, rapid, fileRapid.FileId)
+
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
 		a.n.file = fileRapid
 		a.n.fileId = fileRapid.FileId
+		a.saveMeta()
 		a.rapid.finished = true
 	}()
 }
 
+// uploadRapid 后端不支持秒传时直接上传临时文件
+func uploadRapid(b backend.Backend, options *aliyundrive.UploadFileRapidOptions) (*models.File, bool, error) {
+	if uploader, ok := b.(backend.RapidUploader); ok {
+		return uploader.UploadFileRapid(options)
+	}
+
+	if _, err := options.File.Seek(0, io.SeekStart); err != nil {
+		return nil, false, err
+	}
+
+	options.Reader = options.File
+
+	file, err := b.UploadFile(&options.UploadFileOptions)
+
+	return file, false, err
+}
+
 func (a *aliFile) Write(p []byte) (n int, err error) {
 	a.mu.Lock()
-	defer a.mu.Unlock()
 
 	if a.n.IsDir() {
+		a.mu.Unlock()
 		return 0, os.ErrInvalid
 	}
 
 	if a.enableRapid {
+		defer a.mu.Unlock()
 		return a.rapidWrite(p)
 	}
 
-	if a.create.writer != nil {
-		n, err = a.create.writer.Write(p)
-		if err != nil {
-			logrus.Errorf("upload %s error %s", a.n.name, err)
-			return n, err
-		}
+	writer := a.create.writer
+	a.mu.Unlock()
 
-		a.create.writePos += int64(n)
+	if writer == nil {
+		return 0, errors.New("cannot write, writer is nil")
+	}
 
-		logrus.Debugf("uploaded %d, writepos: %d", n, a.create.writePos)
-		return n, nil
+	// 写入管道会等待上传读取，不能持有 a.mu，否则上传开始时的 ProgressStart 无法获得锁
+	n, err = writer.Write(p)
+	if err != nil {
+		logrus.Errorf("upload %s error %s", a.n.name, err)
+		return n, err
 	}
 
-	return 0, errors.New("cannot write, writer is nil")
+	a.mu.Lock()
+	a.create.writePos += int64(n)
+	logrus.Debugf("uploaded %d, writepos: %d", n, a.create.writePos)
+	a.mu.Unlock()
+
+	return n, nil
 }
 
 func (a *aliDriveFS) RemoveAll(ctx context.Context, name string) error {
+	if rel, ok := trashPath(name); ok {
+		return a.purgeTrash(ctx, rel)
+	}
+	if _, ok := versionsPath(name); ok {
+		return os.ErrPermission
+	}
+
 	a.mu.Lock()
 	a.mu.Unlock()
 
-	fileId, _, err := a.driver.ResolvePathToFileId(a.credential, name)
+	fileId, _, err := a.backend.ResolvePathToFileId(name)
 	if err != nil {
 		return err
 	}
 
 	logrus.Warnf("removing %s: %s", fileId, name)
 
-	_, err = a.driver.RemoveFile(a.credential, fileId)
+	err = a.remove(fileId)
+	if err != nil {
+		return err
+	}
+
+	// 秒传的本地缓存按路径保存，删除后不能再命中
+	RapidCache.Delete(name)
+
+	a.quota.Invalidate()
+	a.events.publish(&Event{
+		Type:   EventDelete,
+		Path:   name,
+		FileId: fileId,
+		User:   userOf(ctx),
+	})
+
+	return a.meta.Delete(fileId)
+}
+
+// remove 删除文件，后端支持回收站时默认移动到回收站
+func (a *aliDriveFS) remove(fileId string) error {
+	bin, ok := a.backend.(backend.RecycleBin)
+	if !a.hardDelete || !ok {
+		return a.recycle(fileId)
+	}
+
+	file, err := a.backend.GetFile(fileId)
 	if err != nil {
 		return err
 	}
 
+	if err := bin.DeleteFile(fileId); err != nil {
+		return err
+	}
+
+	a.backend.EvictCacheWithPrefix(fileId)
+	a.backend.EvictCacheWithPrefix(file.ParentFileId)
+
 	return nil
 }
 
+// recycle 移动到回收站
+func (a *aliDriveFS) recycle(fileId string) error {
+	err := a.backend.RemoveFile(fileId)
+	a.trash.Invalidate()
+	return err
+}
+
+// reservedWarned 已经提示过的保留名称，每个名称只提示一次
+var reservedWarned sync.Map
+
+// reservedName 根目录下和回收站、历史版本虚拟目录同名的文件无法访问，列表中不显示，需要在阿里云盘中重命名
+func reservedName(parentFileId, name string) bool {
+	if parentFileId != aliyundrive.DefaultRootFileId || ("/"+name != TrashDir && "/"+name != VersionsDir) {
+		return false
+	}
+
+	if _, warned := reservedWarned.LoadOrStore(name, true); !warned {
+		logrus.Warnf("%s in root folder is reserved and hidden, rename it in aliyundrive", name)
+	}
+	return true
+}
+
 func (a *aliDriveFS) Rename(ctx context.Context, oldName, newName string) error {
 	logrus.Infof("rename file %s to %s", oldName, newName)
 
-	fileId, _, err := a.driver.ResolvePathToFileId(a.credential, oldName)
+	if _, ok := trashPath(newName); ok {
+		return os.ErrPermission
+	}
+	if _, ok := versionsPath(newName); ok {
+		return os.ErrPermission
+	}
+	if _, ok := versionsPath(oldName); ok {
+		return os.ErrPermission
+	}
+
+	if rel, ok := trashPath(oldName); ok {
+		return a.restoreTrash(ctx, rel, newName)
+	}
+
+	fileId, _, err := a.backend.ResolvePathToFileId(oldName)
 	if err != nil {
 		logrus.Errorf("resolve file %s, err: %s", oldName, err)
 		return os.ErrNotExist
 	}
 
+	source, err := a.backend.GetFile(fileId)
+	if err != nil {
+		return err
+	}
+
 	oldDir, oldFileName := filepath.Split(filepath.Clean(oldName))
 	toDir, name := filepath.Split(filepath.Clean(newName))
 
-	toFileId, found, err := a.driver.ResolvePathToFileId(a.credential, newName)
+	toFileId, found, err := a.backend.ResolvePathToFileId(newName)
 
 	// 目标已存在，取消
 	if err == nil {
-		file, err := a.driver.GetFile(a.credential, toFileId)
+		file, err := a.backend.GetFile(toFileId)
 		if err != nil {
 			return err
 		}
@@ -572,6 +1016,7 @@ func (a *aliDriveFS) Rename(ctx context.Context, oldName, newName string) error
 
 		toDir = newName
 		name = oldFileName
+		newName = filepath.Join(newName, oldFileName)
 	}
 
 	// 目标路径不存在，创建路径
@@ -583,7 +1028,7 @@ func (a *aliDriveFS) Rename(ctx context.Context, oldName, newName string) error
 			return err
 		}
 
-		toFileId, _, err = a.driver.ResolvePathToFileId(a.credential, toDir)
+		toFileId, _, err = a.backend.ResolvePathToFileId(toDir)
 		if err != nil {
 			logrus.Errorf("resolve file %s, err: %s", toDir, err)
 			return err
@@ -593,7 +1038,7 @@ func (a *aliDriveFS) Rename(ctx context.Context, oldName, newName string) error
 	// 目标路径和当前路径不同，先移动过去
 	if oldDir != toDir {
 		logrus.Infof("dest not in current dir, moving %s to %s", oldName, toDir)
-		_, err := a.driver.MoveFile(a.credential, fileId, toFileId)
+		err := a.backend.MoveFile(fileId, toFileId)
 		if err != nil {
 			logrus.Errorf("moving file %s to %s, err: %s", oldName, toFileId, err)
 			return err
@@ -602,17 +1047,35 @@ func (a *aliDriveFS) Rename(ctx context.Context, oldName, newName string) error
 
 	// 如果文件名不同，重命名
 	if oldFileName != name {
-		_, err := a.driver.RenameFile(a.credential, fileId, name)
+		err := a.backend.RenameFile(fileId, name)
 		if err != nil {
 			logrus.Errorf("renaming file %s to %s, err: %s", fileId, name, err)
 			return err
 		}
 	}
 
-	return nil
+	RapidCache.Delete(oldName)
+
+	a.events.publish(&Event{
+		Type:        EventMove,
+		Path:        oldName,
+		Destination: newName,
+		FileId:      fileId,
+		IsDir:       source.Type == models.FileTypeFolder,
+		User:        userOf(ctx),
+	})
+
+	return a.meta.Move(fileId, ancestorIds(a.backend, filepath.Dir(filepath.Clean("/"+newName))))
 }
 
 func (a *aliDriveFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if rel, ok := trashPath(name); ok {
+		return a.statTrash(rel)
+	}
+	if rel, ok := versionsPath(name); ok {
+		return a.statVersion(rel)
+	}
+
 	if a.rapidUpload {
 		if _fileName, ok := RapidCache.Load(name); ok {
 			stat, err := os.Stat(_fileName.(string))
@@ -625,7 +1088,7 @@ func (a *aliDriveFS) Stat(ctx context.Context, name string) (os.FileInfo, error)
 		}
 	}
 
-	fileId, _, err := a.driver.ResolvePathToFileId(a.credential, name)
+	fileId, _, err := a.backend.ResolvePathToFileId(name)
 	if err != nil {
 		if err == aliyundrive.ErrPartialFoundPath {
 			return nil, os.ErrNotExist
@@ -634,12 +1097,12 @@ func (a *aliDriveFS) Stat(ctx context.Context, name string) (os.FileInfo, error)
 		return nil, err
 	}
 
-	file, err := a.driver.GetFile(a.credential, fileId)
+	file, err := a.backend.GetFile(fileId)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewAliFileInfo(file.File), nil
+	return a.meta.apply(NewAliFileInfo(file).(*aliFileInfo)), nil
 }
 
 func NewAliFileInfo(file *models.File) os.FileInfo {
@@ -668,6 +1131,7 @@ type aliFileInfo struct {
 	size         int64
 	mode         os.FileMode
 	modTime      time.Time
+	virtual      bool // 回收站、历史版本等虚拟目录中的文件
 }
 
 func (f *aliFileInfo) Name() string       { return f.name }
@@ -675,4 +1139,19 @@ func (f *aliFileInfo) Size() int64        { return f.size }
 func (f *aliFileInfo) Mode() os.FileMode  { return f.mode }
 func (f *aliFileInfo) ModTime() time.Time { return f.modTime }
 func (f *aliFileInfo) IsDir() bool        { return f.mode.IsDir() }
-func (f *aliFileInfo) Sys() interface{}   { return nil }
+
+// ContentType 实现 webdav.ContentTyper，虚拟目录中的文件不读取内容判断类型
+func (f *aliFileInfo) ContentType(ctx context.Context) (string, error) {
+	if !f.virtual {
+		return "", webdav.ErrNotImplemented
+	}
+	return contentType(f), nil
+}
+
+// Sys 返回阿里云盘的文件信息 *models.File
+func (f *aliFileInfo) Sys() interface{} {
+	if f.file == nil {
+		return nil
+	}
+	return f.file
+}
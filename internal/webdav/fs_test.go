@@ -0,0 +1,173 @@
+package webdav
+
+import (
+	"context"
+	"github.com/jakeslee/aliyundrive"
+	"github.com/jakeslee/aliyundrive-webdav/internal/backend"
+	"github.com/jakeslee/aliyundrive/models"
+	"golang.org/x/net/webdav"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestFS 创建使用内存后端的文件系统
+func newTestFS(t *testing.T, rapidUpload bool) *aliDriveFS {
+	return NewAliDriveFS(backend.NewMemory(), &Options{
+		RapidUpload: rapidUpload,
+		WorkDir:     t.TempDir(),
+	}).(*aliDriveFS)
+}
+
+// sizeContext 返回 OpenFile 需要的带有文件大小的 ctx
+func sizeContext(size int64) context.Context {
+	return context.WithValue(context.Background(), CtxSizeValue, size)
+}
+
+// newDriveHandler 创建使用 fs 的 Handler
+func newDriveHandler(fs *aliDriveFS, authorizer Authorizer) *Handler {
+	return &Handler{
+		Handler: webdav.Handler{
+			FileSystem: fs,
+			LockSystem: webdav.NewMemLS(),
+		},
+		Authorizer: authorizer,
+	}
+}
+
+// upload 上传文件，上传过程中通过 PROPPATCH 设置 props，等待后台上传完成
+func upload(t *testing.T, fs *aliDriveFS, name, content string, props []webdav.Proppatch) {
+	ctx := sizeContext(int64(len(content)))
+
+	f, err := fs.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if props != nil {
+		if _, err := f.(webdav.DeadPropsHolder).Patch(props); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := f.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUploadPendingProps(t *testing.T) {
+	for _, rapid := range []bool{false, true} {
+		fs := newTestFS(t, rapid)
+
+		upload(t, fs, "/a.txt", "hello", setProp("red"))
+
+		fileId, _, err := fs.backend.ResolvePathToFileId("/a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 上传完成前设置的属性在获得 fileId 后保存
+		if props := fs.meta.DeadProps(fileId); string(props[testProp].InnerXML) != "red" {
+			t.Fatalf("rapid %v: props set during upload not saved: %v", rapid, props)
+		}
+	}
+}
+
+// blockingBackend 上传完成后等待 release 关闭才返回
+type blockingBackend struct {
+	backend.Backend
+	release chan struct{}
+}
+
+func (b *blockingBackend) UploadFile(options *aliyundrive.UploadFileOptions) (*models.File, error) {
+	file, err := b.Backend.UploadFile(options)
+	<-b.release
+
+	return file, err
+}
+
+func TestDrain(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		rapid bool
+		mount bool
+	}{
+		{name: "upload"},
+		{name: "rapid upload", rapid: true},
+		{name: "mount", mount: true},
+	} {
+		b := &blockingBackend{Backend: backend.NewMemory(), release: make(chan struct{})}
+		var fs webdav.FileSystem = NewAliDriveFS(b, &Options{RapidUpload: tt.rapid, WorkDir: t.TempDir()})
+		name := "/a.txt"
+
+		if tt.mount {
+			var err error
+			if fs, err = NewMountFS([]*Mount{{Name: "backup", FileSystem: fs}}); err != nil {
+				t.Fatal(err)
+			}
+			name = "/backup/a.txt"
+		}
+
+		f, err := fs.OpenFile(sizeContext(5), name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// 后台上传未完成时超时
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err = fs.(Drainer).Drain(ctx)
+		cancel()
+		if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+			t.Fatalf("%s: Drain() = %v while uploading", tt.name, err)
+		}
+		if tt.mount && !strings.HasPrefix(err.Error(), "mount backup:") {
+			t.Errorf("%s: error %q without mount name", tt.name, err)
+		}
+
+		close(b.release)
+
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		err = fs.(Drainer).Drain(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("%s: Drain() = %v after upload", tt.name, err)
+		}
+
+		if _, _, err := b.ResolvePathToFileId("/a.txt"); err != nil {
+			t.Errorf("%s: file not uploaded, %s", tt.name, err)
+		}
+	}
+}
+
+func TestCleanRapidCache(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "rapid")
+	if err := ioutil.WriteFile(name, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	RapidCache.Store("clean-rapid-cache", name)
+	CleanRapidCache()
+
+	if _, ok := RapidCache.Load("clean-rapid-cache"); ok {
+		t.Errorf("cache entry not removed")
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("temp file not removed, %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package webdav
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	syncTokenPrefix = "http://aliyundrive-webdav/ns/sync/"
+
+	// journalLimit 变更记录保留条数，早于最旧记录的 sync-token 需要重新全量同步
+	journalLimit = 10000
+)
+
+var errInvalidSyncToken = errors.New("webdav: invalid sync token")
+
+type journalEntry struct {
+	seq     uint64
+	path    string
+	deleted bool
+	tree    bool   // 目录移动，子路径同时变化
+	target  string // 目录移走后的路径
+}
+
+// treeChange 目录移动带来的整棵子树变更
+type treeChange struct {
+	deleted bool
+	target  string
+}
+
+// changeJournal 记录本服务产生的文件变更，用于 sync-collection 增量同步
+type changeJournal struct {
+	mu      sync.Mutex
+	epoch   string // 随机生成，每个挂载点、每次启动都不同
+	seq     uint64
+	entries []journalEntry
+}
+
+func newChangeJournal() *changeJournal {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+
+	return &changeJournal{
+		epoch: hex.EncodeToString(b),
+	}
+}
+
+// listen 把文件变更事件写入变更记录
+func (j *changeJournal) listen(event *Event) {
+	switch event.Type {
+	case EventMove:
+		j.record(journalEntry{path: event.Path, deleted: true, tree: event.IsDir, target: event.Destination})
+		j.record(journalEntry{path: event.Destination, tree: event.IsDir})
+	default:
+		j.record(journalEntry{path: event.Path, deleted: event.Type == EventDelete})
+	}
+}
+
+func (j *changeJournal) record(entry journalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.seq++
+	entry.seq = j.seq
+	entry.path = path.Clean("/" + entry.path)
+	if entry.target != "" {
+		entry.target = path.Clean("/" + entry.target)
+	}
+	j.entries = append(j.entries, entry)
+
+	if len(j.entries) > journalLimit {
+		j.entries = j.entries[len(j.entries)-journalLimit:]
+	}
+}
+
+// Token 返回当前的 sync-token
+func (j *changeJournal) Token() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return fmt.Sprintf("%s%s-%d-%d", syncTokenPrefix, j.epoch, j.seq, time.Now().UnixNano())
+}
+
+// Since 返回 token 之后 dir 下的变更，路径对应的最后一次变更为准，同时返回 token 的生成时间；
+// infinite 时 trees 为移动过的目录，其子路径需要按目录展开
+func (j *changeJournal) Since(token, dir string, infinite bool) (changes map[string]bool, trees map[string]treeChange, since time.Time, err error) {
+	if !strings.HasPrefix(token, syncTokenPrefix) {
+		return nil, nil, since, errInvalidSyncToken
+	}
+
+	parts := strings.Split(token[len(syncTokenPrefix):], "-")
+	if len(parts) != 3 {
+		return nil, nil, since, errInvalidSyncToken
+	}
+
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return nil, nil, since, errInvalidSyncToken
+	}
+	nanos, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, nil, since, errInvalidSyncToken
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// 其他挂载点的 token、服务重启或记录已被清理，无法保证删除记录完整
+	if parts[0] != j.epoch || seq > j.seq || (len(j.entries) > 0 && seq+1 < j.entries[0].seq) {
+		return nil, nil, since, errInvalidSyncToken
+	}
+
+	dir = path.Clean("/" + dir)
+	changes = make(map[string]bool)
+	trees = make(map[string]treeChange)
+
+	for _, entry := range j.entries {
+		if entry.seq <= seq || !inCollection(dir, entry.path, infinite) {
+			continue
+		}
+
+		changes[entry.path] = entry.deleted
+
+		if infinite && entry.tree {
+			trees[entry.path] = treeChange{deleted: entry.deleted, target: entry.target}
+		}
+	}
+
+	return changes, trees, time.Unix(0, nanos), nil
+}
+
+// inCollection 判断 p 是否是 dir 的成员，infinite 为 false 时只包含直接成员
+func inCollection(dir, p string, infinite bool) bool {
+	if p == dir {
+		return false
+	}
+
+	if !infinite {
+		return path.Dir(p) == dir
+	}
+
+	return dir == "/" || strings.HasPrefix(p, dir+"/")
+}
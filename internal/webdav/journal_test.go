@@ -0,0 +1,172 @@
+package webdav
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChangeJournal(t *testing.T) {
+	j := newChangeJournal()
+	start := j.Token()
+
+	j.listen(&Event{Type: EventUpload, Path: "/dir/a.txt"})
+	j.listen(&Event{Type: EventUpload, Path: "/dir/sub/b.txt"})
+	j.listen(&Event{Type: EventDelete, Path: "/dir/a.txt"})
+	j.listen(&Event{Type: EventMove, Path: "/dir/old", Destination: "/dir/new", IsDir: true})
+	j.listen(&Event{Type: EventUpload, Path: "/other/c.txt"})
+
+	tests := []struct {
+		name     string
+		dir      string
+		infinite bool
+		changes  map[string]bool
+		trees    map[string]treeChange
+	}{
+		// 同一路径以最后一次变更为准
+		{"direct members", "/dir", false,
+			map[string]bool{"/dir/a.txt": true, "/dir/old": true, "/dir/new": false}, map[string]treeChange{}},
+		{"infinite", "/dir", true,
+			map[string]bool{"/dir/a.txt": true, "/dir/sub/b.txt": false, "/dir/old": true, "/dir/new": false},
+			map[string]treeChange{"/dir/old": {deleted: true, target: "/dir/new"}, "/dir/new": {}}},
+		{"sub directory", "/dir/sub", false, map[string]bool{"/dir/sub/b.txt": false}, map[string]treeChange{}},
+		{"root", "/", false, map[string]bool{}, map[string]treeChange{}},
+	}
+
+	for _, tt := range tests {
+		changes, trees, _, err := j.Since(start, tt.dir, tt.infinite)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if !reflect.DeepEqual(changes, tt.changes) || !reflect.DeepEqual(trees, tt.trees) {
+			t.Errorf("%s: changes %v, trees %v", tt.name, changes, trees)
+		}
+	}
+
+	// 新 token 之后没有变更
+	if changes, _, _, err := j.Since(j.Token(), "/", true); err != nil || len(changes) != 0 {
+		t.Errorf("changes after latest token %v, %v", changes, err)
+	}
+
+	epoch := strings.SplitN(strings.TrimPrefix(start, syncTokenPrefix), "-", 2)[0]
+	for _, token := range []string{
+		"",
+		"urn:other:token",
+		syncTokenPrefix + "bad",
+		syncTokenPrefix + epoch + "-x-1",
+		syncTokenPrefix + epoch + "-1-x",
+		syncTokenPrefix + "0000000000000000-0-1", // 其他挂载点或重启前的 token
+		syncTokenPrefix + epoch + "-100-1",       // 还没有生成的序号
+		newChangeJournal().Token(),
+	} {
+		if _, _, _, err := j.Since(token, "/", true); err != errInvalidSyncToken {
+			t.Errorf("Since(%q) error %v, expected %v", token, err, errInvalidSyncToken)
+		}
+	}
+}
+
+func TestChangeJournalLimit(t *testing.T) {
+	j := newChangeJournal()
+	token := j.Token()
+
+	for i := 0; i < journalLimit; i++ {
+		j.record(journalEntry{path: "/a.txt"})
+	}
+	if _, _, _, err := j.Since(token, "/", true); err != nil {
+		t.Fatalf("token within limit: %s", err)
+	}
+
+	// 记录被清理后旧 token 失效
+	j.record(journalEntry{path: "/a.txt"})
+	if _, _, _, err := j.Since(token, "/", true); err != errInvalidSyncToken {
+		t.Fatalf("token beyond limit: %v", err)
+	}
+}
+
+func TestInCollection(t *testing.T) {
+	tests := []struct {
+		dir, p   string
+		infinite bool
+		want     bool
+	}{
+		{"/dir", "/dir", true, false},
+		{"/dir", "/dir/a.txt", false, true},
+		{"/dir", "/dir/sub/a.txt", false, false},
+		{"/dir", "/dir/sub/a.txt", true, true},
+		{"/dir", "/dirx/a.txt", true, false},
+		{"/", "/a.txt", false, true},
+		{"/", "/dir/a.txt", true, true},
+	}
+
+	for _, tt := range tests {
+		if got := inCollection(tt.dir, tt.p, tt.infinite); got != tt.want {
+			t.Errorf("inCollection(%q, %q, %v) = %v", tt.dir, tt.p, tt.infinite, got)
+		}
+	}
+}
+
+// syncBody sync-collection REPORT 请求体
+func syncBody(token, level string) string {
+	return `<?xml version="1.0"?><D:sync-collection xmlns:D="DAV:"><D:sync-token>` + token +
+		`</D:sync-token><D:sync-level>` + level + `</D:sync-level><D:prop><D:getetag/></D:prop></D:sync-collection>`
+}
+
+// syncToken 返回 REPORT 响应中的 sync-token
+func syncToken(t *testing.T, body string) string {
+	start := strings.Index(body, "<D:sync-token>")
+	end := strings.Index(body, "</D:sync-token>")
+	if start < 0 || end < start {
+		t.Fatalf("no sync-token in %s", body)
+	}
+
+	return body[start+len("<D:sync-token>") : end]
+}
+
+func TestSyncCollection(t *testing.T) {
+	fs := newTestFS(t, false)
+	upload(t, fs, "/a.txt", "a", nil)
+	upload(t, fs, "/b.txt", "b", nil)
+	h := newDriveHandler(fs, nil)
+
+	w := serve(h, "alice", "REPORT", "/", nil, syncBody("", "1"))
+	if w.Code != http.StatusMultiStatus || !strings.Contains(w.Body.String(), "<D:href>/a.txt</D:href>") ||
+		!strings.Contains(w.Body.String(), "<D:href>/b.txt</D:href>") {
+		t.Fatalf("initial sync status %d, %s", w.Code, w.Body.String())
+	}
+	token := syncToken(t, w.Body.String())
+
+	upload(t, fs, "/c.txt", "c", nil)
+	if w := serve(h, "alice", "DELETE", "/a.txt", nil, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE status %d", w.Code)
+	}
+
+	w = serve(h, "alice", "REPORT", "/", nil, syncBody(token, "1"))
+	body := w.Body.String()
+	if w.Code != http.StatusMultiStatus || !strings.Contains(body, "<D:href>/c.txt</D:href>") ||
+		!strings.Contains(body, "<D:href>/a.txt</D:href><D:status>HTTP/1.1 404 Not Found</D:status>") {
+		t.Fatalf("incremental sync status %d, %s", w.Code, body)
+	}
+	if syncToken(t, body) == token {
+		t.Fatalf("sync-token not changed")
+	}
+
+	tests := []struct {
+		name, target, body string
+		status             int
+		contains           string
+	}{
+		{"invalid token", "/", syncBody(syncTokenPrefix+"bad", "1"), http.StatusForbidden, "<D:valid-sync-token/>"},
+		{"token of another journal", "/", syncBody(newChangeJournal().Token(), "infinite"), http.StatusForbidden, "<D:valid-sync-token/>"},
+		{"file", "/b.txt", syncBody("", "1"), http.StatusForbidden, ""},
+		{"missing", "/missing", syncBody("", "1"), http.StatusNotFound, ""},
+		{"other report", "/", `<?xml version="1.0"?><D:expand-property xmlns:D="DAV:"/>`, http.StatusNotImplemented, ""},
+	}
+
+	for _, tt := range tests {
+		w := serve(h, "alice", "REPORT", tt.target, nil, tt.body)
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.contains) {
+			t.Errorf("%s: status %d, %s", tt.name, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package webdav
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/webdav"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMetaFile = "metadata.json"
+
+	// metaFlushDelay 合并这段时间内的多次修改，只写一次文件
+	metaFlushDelay = time.Second
+)
+
+// fileMeta 保存阿里云盘不支持的文件元信息，按 fileId 索引，重命名、移动后依然有效
+type fileMeta struct {
+	// Ancestors 上级目录的 fileId，从近到远，用于删除目录时清理全部子文件的元信息
+	Ancestors []string    `json:"ancestors,omitempty"`
+	ModTime   *time.Time  `json:"mod_time,omitempty"`
+	Props     []*deadProp `json:"props,omitempty"`
+}
+
+func (m *fileMeta) empty() bool {
+	return m.ModTime == nil && len(m.Props) == 0
+}
+
+// deadProp PROPPATCH 设置的自定义属性
+type deadProp struct {
+	Space    string `json:"space"`
+	Local    string `json:"local"`
+	Lang     string `json:"lang,omitempty"`
+	InnerXML string `json:"inner_xml,omitempty"`
+}
+
+func (p *deadProp) name() xml.Name {
+	return xml.Name{Space: p.Space, Local: p.Local}
+}
+
+// metaStore 文件元信息存储，持久化到 WorkDir 下的 JSON 文件中，修改后延迟 metaFlushDelay 写入
+type metaStore struct {
+	mu    sync.RWMutex
+	path  string
+	items map[string]*fileMeta
+
+	dirty bool
+	timer *time.Timer
+}
+
+// newMetaStore 从 workDir 加载元信息，workDir 为空时仅保存在内存中
+func newMetaStore(workDir string) *metaStore {
+	s := &metaStore{
+		items: make(map[string]*fileMeta),
+	}
+
+	if workDir == "" {
+		return s
+	}
+
+	s.path = filepath.Join(workDir, defaultMetaFile)
+
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Warnf("read metadata file %s error %s", s.path, err)
+		}
+		return s
+	}
+
+	if err := json.Unmarshal(content, &s.items); err != nil {
+		logrus.Warnf("parse metadata file %s error %s", s.path, err)
+		s.items = make(map[string]*fileMeta)
+	}
+
+	return s
+}
+
+// ModTime 返回客户端设置的修改时间
+func (s *metaStore) ModTime(fileId string) (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if m, ok := s.items[fileId]; ok && m.ModTime != nil {
+		return *m.ModTime, true
+	}
+
+	return time.Time{}, false
+}
+
+// SetModTime 保存客户端设置的修改时间
+func (s *metaStore) SetModTime(fileId string, ancestors []string, modTime time.Time) error {
+	if fileId == "" {
+		return os.ErrInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := modTime.UTC()
+	s.item(fileId, ancestors).ModTime = &t
+
+	return s.save()
+}
+
+// DeadProps 返回文件的自定义属性
+func (s *metaStore) DeadProps(fileId string) map[xml.Name]webdav.Property {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.items[fileId]
+	if !ok || len(m.Props) == 0 {
+		return nil
+	}
+
+	props := make(map[xml.Name]webdav.Property, len(m.Props))
+	for _, p := range m.Props {
+		props[p.name()] = webdav.Property{
+			XMLName:  p.name(),
+			Lang:     p.Lang,
+			InnerXML: []byte(p.InnerXML),
+		}
+	}
+
+	return props
+}
+
+// PatchProps 按顺序设置或删除文件的自定义属性
+func (s *metaStore) PatchProps(fileId string, ancestors []string, patches []webdav.Proppatch) error {
+	if fileId == "" {
+		return os.ErrInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.item(fileId, ancestors)
+
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			props := m.Props[:0]
+			for _, exist := range m.Props {
+				if exist.name() != p.XMLName {
+					props = append(props, exist)
+				}
+			}
+			m.Props = props
+
+			if !patch.Remove {
+				m.Props = append(m.Props, &deadProp{
+					Space:    p.XMLName.Space,
+					Local:    p.XMLName.Local,
+					Lang:     p.Lang,
+					InnerXML: string(p.InnerXML),
+				})
+			}
+		}
+	}
+
+	return s.save()
+}
+
+// Move 文件移动或重命名后更新上级目录，ancestors 为新位置的上级目录，子文件的上级目录同时更新
+func (s *metaStore) Move(fileId string, ancestors []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+
+	for id, m := range s.items {
+		if id == fileId {
+			m.Ancestors = ancestors
+			changed = true
+			continue
+		}
+
+		if i := indexOf(m.Ancestors, fileId); i >= 0 {
+			m.Ancestors = append(m.Ancestors[:i+1:i+1], ancestors...)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return s.save()
+}
+
+// Delete 删除文件及其子文件（如果是目录）的元信息，子文件按 Ancestors 查找，不需要中间目录有元信息
+func (s *metaStore) Delete(fileId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+
+	for id, m := range s.items {
+		if id == fileId || indexOf(m.Ancestors, fileId) >= 0 {
+			delete(s.items, id)
+			count++
+		}
+	}
+
+	if count == 0 {
+		return nil
+	}
+
+	return s.save()
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// item 返回文件的元信息，不存在时创建，调用方需持有锁
+func (s *metaStore) item(fileId string, ancestors []string) *fileMeta {
+	m, ok := s.items[fileId]
+	if !ok {
+		m = &fileMeta{}
+		s.items[fileId] = m
+	}
+
+	if len(ancestors) > 0 {
+		m.Ancestors = ancestors
+	}
+
+	return m
+}
+
+// apply 使用保存的元信息覆盖从阿里云盘读取的文件信息
+func (s *metaStore) apply(info *aliFileInfo) *aliFileInfo {
+	if modTime, ok := s.ModTime(info.fileId); ok {
+		info.modTime = modTime
+	}
+
+	return info
+}
+
+// save 标记有修改，metaFlushDelay 后写入文件，调用方需持有锁
+func (s *metaStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	s.dirty = true
+	if s.timer == nil {
+		s.timer = time.AfterFunc(metaFlushDelay, func() {
+			if err := s.Flush(); err != nil {
+				logrus.Warnf("write metadata file %s error %s", s.path, err)
+			}
+		})
+	}
+
+	return nil
+}
+
+// Flush 立即写入未保存的修改，退出前调用
+func (s *metaStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+
+	if !s.dirty {
+		return nil
+	}
+
+	if err := s.write(); err != nil {
+		return err
+	}
+
+	s.dirty = false
+
+	return nil
+}
+
+// write 写入临时文件后重命名，避免写入中断导致文件损坏，调用方需持有锁
+func (s *metaStore) write() error {
+	for id, m := range s.items {
+		if m.empty() {
+			delete(s.items, id)
+		}
+	}
+
+	content, err := json.Marshal(s.items)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
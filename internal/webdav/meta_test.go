@@ -0,0 +1,105 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"golang.org/x/net/webdav"
+	"testing"
+	"time"
+)
+
+var testProp = xml.Name{Space: "urn:test", Local: "color"}
+
+func setProp(value string) []webdav.Proppatch {
+	return []webdav.Proppatch{{Props: []webdav.Property{{XMLName: testProp, InnerXML: []byte(value)}}}}
+}
+
+func TestMetaStorePersist(t *testing.T) {
+	dir := t.TempDir()
+	s := newMetaStore(dir)
+
+	modTime := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := s.SetModTime("file", []string{"dir", "root"}, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PatchProps("file", nil, setProp("red")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PatchProps("file", nil, setProp("blue")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newMetaStore(dir)
+
+	if got, ok := loaded.ModTime("file"); !ok || !got.Equal(modTime) {
+		t.Fatalf("mod time %s, expected %s", got, modTime)
+	}
+
+	props := loaded.DeadProps("file")
+	if len(props) != 1 || string(props[testProp].InnerXML) != "blue" {
+		t.Fatalf("props %v", props)
+	}
+
+	// 删除属性后没有元信息
+	if err := loaded.PatchProps("file", nil, []webdav.Proppatch{{Remove: true, Props: []webdav.Property{{XMLName: testProp}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if props := loaded.DeadProps("file"); props != nil {
+		t.Fatalf("removed props %v", props)
+	}
+}
+
+func TestMetaStoreTree(t *testing.T) {
+	tests := []struct {
+		name    string
+		move    string   // 移动的目录
+		to      []string // 移动后的上级目录
+		delete  string
+		deleted []string
+		kept    []string
+	}{
+		{name: "delete file", delete: "file", deleted: []string{"file"}, kept: []string{"dir", "sub"}},
+		{name: "delete dir", delete: "dir", deleted: []string{"dir", "sub", "file"}},
+		{name: "delete sub", delete: "sub", deleted: []string{"sub", "file"}, kept: []string{"dir"}},
+		// 移动后按新的上级目录删除，没有元信息的中间目录不影响查找
+		{name: "move sub", move: "sub", to: []string{"other", "root"}, delete: "other", deleted: []string{"sub", "file"}, kept: []string{"dir"}},
+		{name: "move sub then delete old parent", move: "sub", to: []string{"other", "root"}, delete: "dir", deleted: []string{"dir"}, kept: []string{"sub", "file"}},
+	}
+
+	for _, tt := range tests {
+		s := newMetaStore("")
+		now := time.Now()
+
+		for id, ancestors := range map[string][]string{
+			"dir":  {"root"},
+			"sub":  {"dir", "root"},
+			"file": {"sub", "dir", "root"},
+		} {
+			if err := s.SetModTime(id, ancestors, now); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if tt.move != "" {
+			if err := s.Move(tt.move, tt.to); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.Delete(tt.delete); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, id := range tt.deleted {
+			if _, ok := s.ModTime(id); ok {
+				t.Errorf("%s: %s not deleted", tt.name, id)
+			}
+		}
+		for _, id := range tt.kept {
+			if _, ok := s.ModTime(id); !ok {
+				t.Errorf("%s: %s deleted", tt.name, id)
+			}
+		}
+	}
+}
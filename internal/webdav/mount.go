@@ -0,0 +1,498 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/webdav"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+var errCrossMount = errors.New("webdav: cannot move across mounts")
+
+// Mount 挂载到一级目录的文件系统
+type Mount struct {
+	Name       string
+	FileSystem webdav.FileSystem
+}
+
+// mountFS 按一级目录把请求路由到各个挂载的文件系统，根目录列出全部挂载点
+type mountFS struct {
+	mounts []*Mount
+	byName map[string]*Mount
+}
+
+// ValidMountName 检查挂载点名称，名称同时用作工作目录中的子目录名
+func ValidMountName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return errors.New("webdav: invalid mount name " + name)
+	}
+
+	return nil
+}
+
+// NewMountFS 创建挂载表，挂载点名称不能重复
+func NewMountFS(mounts []*Mount) (webdav.FileSystem, error) {
+	m := &mountFS{
+		mounts: mounts,
+		byName: make(map[string]*Mount),
+	}
+
+	for _, mount := range mounts {
+		if err := ValidMountName(mount.Name); err != nil {
+			return nil, err
+		}
+		if _, ok := m.byName[mount.Name]; ok {
+			return nil, errors.New("webdav: duplicate mount " + mount.Name)
+		}
+
+		m.byName[mount.Name] = mount
+	}
+
+	return m, nil
+}
+
+// resolve 返回路径所在的挂载点和挂载点内的路径，根目录返回 nil
+func (m *mountFS) resolve(name string) (*Mount, string, error) {
+	p := path.Clean("/" + name)
+	if p == "/" {
+		return nil, "/", nil
+	}
+
+	first, rest := p[1:], "/"
+	if i := strings.Index(first, "/"); i >= 0 {
+		first, rest = first[:i], first[i:]
+	}
+
+	mount, ok := m.byName[first]
+	if !ok {
+		return nil, "", os.ErrNotExist
+	}
+
+	return mount, rest, nil
+}
+
+func (m *mountFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	mount, rest, err := m.resolve(name)
+	if err != nil {
+		if os.IsNotExist(err) && !strings.Contains(strings.Trim(name, "/"), "/") {
+			// 不能在根目录创建挂载点以外的目录
+			return os.ErrPermission
+		}
+		return err
+	}
+	if mount == nil || rest == "/" {
+		return os.ErrExist
+	}
+
+	return mount.FileSystem.Mkdir(ctx, rest, perm)
+}
+
+func (m *mountFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	mount, rest, err := m.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if mount == nil {
+		if flag&(os.O_CREATE|os.O_WRONLY|os.O_RDWR|os.O_TRUNC) != 0 {
+			return nil, os.ErrPermission
+		}
+		return &mountRoot{fs: m, ctx: ctx}, nil
+	}
+
+	f, err := mount.FileSystem.OpenFile(ctx, rest, flag, perm)
+	if err != nil || rest != "/" {
+		return f, err
+	}
+
+	return &mountDir{File: f, name: mount.Name}, nil
+}
+
+func (m *mountFS) RemoveAll(ctx context.Context, name string) error {
+	mount, rest, err := m.resolve(name)
+	if err != nil {
+		return err
+	}
+	if mount == nil || rest == "/" {
+		return os.ErrPermission
+	}
+
+	return mount.FileSystem.RemoveAll(ctx, rest)
+}
+
+func (m *mountFS) Rename(ctx context.Context, oldName, newName string) error {
+	src, oldRest, err := m.resolve(oldName)
+	if err != nil {
+		return err
+	}
+
+	dst, newRest, err := m.resolve(newName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if src == nil || dst == nil || oldRest == "/" || newRest == "/" {
+		return os.ErrPermission
+	}
+	if src != dst {
+		return errCrossMount
+	}
+
+	return src.FileSystem.Rename(ctx, oldRest, newRest)
+}
+
+func (m *mountFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	mount, rest, err := m.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if mount == nil {
+		return &aliFileInfo{
+			name:    "/",
+			mode:    os.ModeDir | os.ModePerm,
+			modTime: time.Now(),
+			virtual: true,
+		}, nil
+	}
+
+	fi, err := mount.FileSystem.Stat(ctx, rest)
+	if err != nil || rest != "/" {
+		return fi, err
+	}
+
+	return &mountInfo{FileInfo: fi, name: mount.Name}, nil
+}
+
+// Subscribe 订阅全部挂载点的文件变更事件，事件路径加上挂载点前缀
+func (m *mountFS) Subscribe(listener EventListener) {
+	for _, mount := range m.mounts {
+		source, ok := mount.FileSystem.(EventSource)
+		if !ok {
+			continue
+		}
+
+		prefix := "/" + mount.Name
+		source.Subscribe(func(event *Event) {
+			e := *event
+			e.Path = path.Join(prefix, e.Path)
+			if e.Destination != "" {
+				e.Destination = path.Join(prefix, e.Destination)
+			}
+
+			listener(&e)
+		})
+	}
+}
+
+// Ready 全部挂载点可用时才就绪
+func (m *mountFS) Ready() error {
+	for _, mount := range m.mounts {
+		if c, ok := mount.FileSystem.(ReadyChecker); ok {
+			if err := c.Ready(); err != nil {
+				return fmt.Errorf("mount %s: %s", mount.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Drain 等待全部挂载点的后台上传完成，某个挂载点超时后仍然继续，保存其余挂载点的元信息
+func (m *mountFS) Drain(ctx context.Context) error {
+	var result error
+
+	for _, mount := range m.mounts {
+		if d, ok := mount.FileSystem.(Drainer); ok {
+			if err := d.Drain(ctx); err != nil && result == nil {
+				result = fmt.Errorf("mount %s: %s", mount.Name, err)
+			}
+		}
+	}
+
+	return result
+}
+
+// Quota 返回上传目标所在挂载点的容量
+func (m *mountFS) Quota(ctx context.Context, name string) (available, used int64, err error) {
+	mount, rest, err := m.resolve(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	if mount == nil {
+		return 0, 0, os.ErrInvalid
+	}
+
+	q, ok := mount.FileSystem.(quotaReporter)
+	if !ok {
+		return 0, 0, os.ErrInvalid
+	}
+
+	return q.Quota(ctx, rest)
+}
+
+// SyncChanges 只支持在挂载点内同步
+func (m *mountFS) SyncChanges(ctx context.Context, dir, token string, infinite bool) (map[string]os.FileInfo, []string, string, error) {
+	mount, rest, err := m.resolve(dir)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if mount == nil {
+		return nil, nil, "", errUnsupportedReport
+	}
+
+	sc, ok := mount.FileSystem.(syncCollection)
+	if !ok {
+		return nil, nil, "", errUnsupportedReport
+	}
+
+	changed, deleted, newToken, err := sc.SyncChanges(ctx, rest, token, infinite)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	prefix := "/" + mount.Name
+	for i, p := range deleted {
+		deleted[i] = path.Join(prefix, p)
+	}
+
+	return prefixInfos(prefix, changed), deleted, newToken, nil
+}
+
+// Search 在挂载点内搜索，根目录递归搜索时搜索全部挂载点
+func (m *mountFS) Search(ctx context.Context, scope string, cond *searchCondition, depth, limit int) (map[string]os.FileInfo, error) {
+	mount, rest, err := m.resolve(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	mounts := []*Mount{mount}
+	if mount == nil {
+		if depth != infiniteDepth {
+			return m.searchRoot(ctx, cond, depth)
+		}
+		mounts, rest = m.mounts, "/"
+	}
+
+	result := make(map[string]os.FileInfo)
+
+	for _, mount := range mounts {
+		s, ok := mount.FileSystem.(searcher)
+		if !ok {
+			continue
+		}
+
+		found, err := s.Search(ctx, rest, cond, depth, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		for p, fi := range prefixInfos("/"+mount.Name, found) {
+			if limit > 0 && len(result) >= limit {
+				return result, nil
+			}
+			result[p] = fi
+		}
+	}
+
+	return result, nil
+}
+
+// searchRoot 在挂载点列表中搜索，depth 为 0 时只判断根目录，为 1 时判断各挂载点
+func (m *mountFS) searchRoot(ctx context.Context, cond *searchCondition, depth int) (map[string]os.FileInfo, error) {
+	result := make(map[string]os.FileInfo)
+
+	names := []string{"/"}
+	if depth == 1 {
+		names = names[:0]
+		for _, mount := range m.mounts {
+			names = append(names, "/"+mount.Name)
+		}
+	}
+
+	for _, name := range names {
+		fi, err := m.Stat(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if cond.matches(fi) {
+			result[name] = fi
+		}
+	}
+
+	return result, nil
+}
+
+// PropsOf 返回文件所在挂载点提供的自定义属性
+func (m *mountFS) PropsOf(name string, fi os.FileInfo) map[xml.Name]webdav.Property {
+	mount, rest, err := m.resolve(name)
+	if err != nil || mount == nil {
+		return nil
+	}
+
+	if info, ok := fi.(*mountInfo); ok {
+		fi = info.FileInfo
+	}
+
+	switch s := mount.FileSystem.(type) {
+	case syncCollection:
+		return s.PropsOf(rest, fi)
+	case searcher:
+		return s.PropsOf(rest, fi)
+	}
+
+	return nil
+}
+
+func (m *mountFS) IsVersionPath(name string) bool {
+	mount, rest, err := m.resolve(name)
+	if err != nil || mount == nil {
+		return false
+	}
+
+	vr, ok := mount.FileSystem.(versionRestorer)
+
+	return ok && vr.IsVersionPath(rest)
+}
+
+// OriginPath 挂载点内的历史版本、回收站路径映射回原路径，加上挂载点前缀
+func (m *mountFS) OriginPath(ctx context.Context, name string) (string, error) {
+	mount, rest, err := m.resolve(name)
+	if err != nil || mount == nil {
+		return path.Clean("/" + name), nil
+	}
+
+	o, ok := mount.FileSystem.(originResolver)
+	if !ok {
+		return path.Clean("/" + name), nil
+	}
+
+	origin, err := o.OriginPath(ctx, rest)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join("/"+mount.Name, origin), nil
+}
+
+// RestoreVersion 历史版本只能恢复到同一个挂载点
+func (m *mountFS) RestoreVersion(ctx context.Context, name, dst string) error {
+	src, rest, err := m.resolve(name)
+	if err != nil {
+		return err
+	}
+
+	target, dstRest, err := m.resolve(dst)
+	if err != nil {
+		return err
+	}
+
+	if src == nil || target == nil {
+		return os.ErrPermission
+	}
+	if src != target {
+		return errCrossMount
+	}
+
+	vr, ok := src.FileSystem.(versionRestorer)
+	if !ok {
+		return os.ErrPermission
+	}
+
+	return vr.RestoreVersion(ctx, rest, dstRest)
+}
+
+func prefixInfos(prefix string, infos map[string]os.FileInfo) map[string]os.FileInfo {
+	result := make(map[string]os.FileInfo, len(infos))
+	for p, fi := range infos {
+		if p == "/" {
+			fi = &mountInfo{FileInfo: fi, name: path.Base(prefix)}
+		}
+		result[path.Join(prefix, p)] = fi
+	}
+
+	return result
+}
+
+// mountInfo 挂载点根目录的信息，名称使用挂载点名称
+type mountInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (i *mountInfo) Name() string { return i.name }
+
+// mountDir 挂载点根目录，Stat 返回挂载点名称，其他操作交给挂载的文件系统
+type mountDir struct {
+	webdav.File
+	name string
+}
+
+func (d *mountDir) Stat() (fs.FileInfo, error) {
+	fi, err := d.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return &mountInfo{FileInfo: fi, name: d.name}, nil
+}
+
+func (d *mountDir) DeadProps() (map[xml.Name]webdav.Property, error) {
+	if holder, ok := d.File.(webdav.DeadPropsHolder); ok {
+		return holder.DeadProps()
+	}
+
+	return nil, nil
+}
+
+func (d *mountDir) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	if holder, ok := d.File.(webdav.DeadPropsHolder); ok {
+		return holder.Patch(patches)
+	}
+
+	return nil, webdav.ErrNotImplemented
+}
+
+// mountRoot 根目录，列出全部挂载点
+type mountRoot struct {
+	fs  *mountFS
+	ctx context.Context
+	pos int
+}
+
+func (r *mountRoot) Close() error { return nil }
+
+func (r *mountRoot) Read(p []byte) (int, error) { return 0, os.ErrInvalid }
+
+func (r *mountRoot) Write(p []byte) (int, error) { return 0, os.ErrPermission }
+
+func (r *mountRoot) Seek(offset int64, whence int) (int64, error) { return 0, os.ErrInvalid }
+
+func (r *mountRoot) Stat() (fs.FileInfo, error) { return r.fs.Stat(r.ctx, "/") }
+
+func (r *mountRoot) Readdir(count int) ([]fs.FileInfo, error) {
+	infos := make([]fs.FileInfo, 0, len(r.fs.mounts))
+	for _, mount := range r.fs.mounts {
+		fi, err := r.fs.Stat(r.ctx, "/"+mount.Name)
+		if err != nil {
+			// 挂载点不可用时仍然列出，避免整个根目录无法访问
+			logrus.Warnf("stat mount %s error %s", mount.Name, err)
+			fi = &aliFileInfo{
+				name:    mount.Name,
+				mode:    os.ModeDir | os.ModePerm,
+				modTime: time.Now(),
+				virtual: true,
+			}
+		}
+		infos = append(infos, fi)
+	}
+
+	return readdirSlice(infos, &r.pos, count)
+}
@@ -0,0 +1,134 @@
+package webdav
+
+import (
+	"errors"
+	"github.com/jakeslee/aliyundrive-webdav/internal/backend"
+	"golang.org/x/net/webdav"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidMountName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"backup", true},
+		{"资源盘", true},
+		{"", false},
+		{"a/b", false},
+		{`a\b`, false},
+		{".trash", false},
+		{"..", false},
+	}
+
+	for _, tt := range tests {
+		if err := ValidMountName(tt.name); (err == nil) != tt.ok {
+			t.Errorf("ValidMountName(%q) = %v", tt.name, err)
+		}
+	}
+
+	if _, err := NewMountFS([]*Mount{{Name: "a", FileSystem: newTestFS(t, false)}, {Name: "a", FileSystem: newTestFS(t, false)}}); err == nil {
+		t.Errorf("duplicate mount accepted")
+	}
+}
+
+func TestMountRequests(t *testing.T) {
+	backup, resource := newTestFS(t, false), newTestFS(t, false)
+	upload(t, backup, "/a.txt", "backup", nil)
+	upload(t, resource, "/a.txt", "resource", nil)
+
+	fs, err := NewMountFS([]*Mount{{Name: "backup", FileSystem: backup}, {Name: "resource", FileSystem: resource}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newDriveHandler(backup, nil)
+	h.FileSystem = fs
+
+	var events []string
+	fs.(EventSource).Subscribe(func(event *Event) {
+		events = append(events, string(event.Type)+" "+event.Path+" "+event.Destination)
+	})
+
+	tests := []struct {
+		name, method, target string
+		header               map[string]string
+		body                 string
+		status               int
+		contains             []string
+	}{
+		{"list mounts", "PROPFIND", "/", map[string]string{"Depth": "1"}, "", http.StatusMultiStatus,
+			[]string{"<D:href>/backup/</D:href>", "<D:href>/resource/</D:href>"}},
+		{"get backup", "GET", "/backup/a.txt", nil, "", http.StatusOK, []string{"backup"}},
+		{"get resource", "GET", "/resource/a.txt", nil, "", http.StatusOK, []string{"resource"}},
+		{"unknown mount", "GET", "/other/a.txt", nil, "", http.StatusNotFound, nil},
+		{"rename in mount", "MOVE", "/backup/a.txt", map[string]string{"Destination": "/backup/b.txt"}, "", http.StatusCreated, nil},
+		{"mkdir in mount", "MKCOL", "/resource/dir", nil, "", http.StatusCreated, nil},
+		{"sync in mount", "REPORT", "/backup/", nil, syncBody("", "1"), http.StatusMultiStatus, []string{"<D:href>/backup/b.txt</D:href>"}},
+		{"sync root", "REPORT", "/", nil, syncBody("", "1"), http.StatusNotImplemented, nil},
+		// 挂载点本身不能修改，不能跨挂载点移动
+		{"delete mount", "DELETE", "/backup", nil, "", http.StatusMethodNotAllowed, nil},
+		{"mkdir at root", "MKCOL", "/other", nil, "", http.StatusMethodNotAllowed, nil},
+		{"put at root", "PUT", "/c.txt", nil, "c", http.StatusNotFound, nil},
+		{"move across mounts", "MOVE", "/resource/a.txt", map[string]string{"Destination": "/backup/c.txt"}, "", http.StatusForbidden, nil},
+	}
+
+	for _, tt := range tests {
+		w := serve(h, "alice", tt.method, tt.target, tt.header, tt.body)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, expected %d, %s", tt.name, w.Code, tt.status, w.Body.String())
+		}
+		for _, s := range tt.contains {
+			if !strings.Contains(w.Body.String(), s) {
+				t.Errorf("%s: %s not in %s", tt.name, s, w.Body.String())
+			}
+		}
+	}
+
+	// 事件路径带有挂载点前缀
+	want := "move /backup/a.txt /backup/b.txt,mkdir /resource/dir "
+	if got := strings.Join(events, ","); got != want {
+		t.Errorf("events %q, expected %q", got, want)
+	}
+
+	if _, err := resource.Stat(sizeContext(0), "/a.txt"); err != nil {
+		t.Errorf("file moved across mounts: %s", err)
+	}
+}
+
+// unhealthyBackend 凭证失效的后端
+type unhealthyBackend struct {
+	backend.Backend
+}
+
+func (unhealthyBackend) Check() error {
+	return errors.New("credential expired")
+}
+
+func TestMountReady(t *testing.T) {
+	broken := NewAliDriveFS(unhealthyBackend{backend.NewMemory()}, &Options{WorkDir: t.TempDir()})
+
+	tests := []struct {
+		name   string
+		mounts []*Mount
+		err    string
+	}{
+		{"healthy", []*Mount{{Name: "a", FileSystem: newTestFS(t, false)}, {Name: "b", FileSystem: newTestFS(t, false)}}, ""},
+		{"one broken", []*Mount{{Name: "a", FileSystem: newTestFS(t, false)}, {Name: "b", FileSystem: broken}}, "mount b: credential expired"},
+		// 不支持就绪检查的文件系统视为就绪
+		{"memfs", []*Mount{{Name: "a", FileSystem: webdav.NewMemFS()}}, ""},
+	}
+
+	for _, tt := range tests {
+		fs, err := NewMountFS(tt.mounts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = fs.(ReadyChecker).Ready()
+		if (err == nil && tt.err != "") || (err != nil && err.Error() != tt.err) {
+			t.Errorf("%s: Ready() = %v, expected %q", tt.name, err, tt.err)
+		}
+	}
+}
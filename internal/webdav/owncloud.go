@@ -0,0 +1,454 @@
+package webdav
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"github.com/jakeslee/aliyundrive-webdav/internal"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/webdav"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ocNamespace = "http://owncloud.org/ns"
+
+	ocWebdavPrefix  = "/remote.php/webdav"
+	ocFilesPrefix   = "/remote.php/dav/files/"
+	ocUploadsPrefix = "/remote.php/dav/uploads/"
+
+	// ocChunkAssembly 分片上传 v2 中，MOVE 该文件到目标路径时合并分片
+	ocChunkAssembly = ".file"
+
+	// ocUploadRetention 未完成的分片上传保留时间
+	ocUploadRetention = 24 * time.Hour
+
+	// ocReadOnlyPermissions 用户没有写权限时的 oc:permissions
+	ocReadOnlyPermissions = "R"
+)
+
+var (
+	propOCFileId      = xml.Name{Space: ocNamespace, Local: "fileid"}
+	propOCId          = xml.Name{Space: ocNamespace, Local: "id"}
+	propOCPermissions = xml.Name{Space: ocNamespace, Local: "permissions"}
+	propOCSize        = xml.Name{Space: ocNamespace, Local: "size"}
+
+	errInvalidDestination = errors.New("webdav: invalid destination")
+	errUserMismatch       = errors.New("webdav: user does not match the authenticated user")
+)
+
+// ocProps 返回 ownCloud/Nextcloud 客户端使用的 oc:fileid、oc:permissions、oc:size 属性
+func ocProps(fi os.FileInfo) map[xml.Name]webdav.Property {
+	info, ok := fi.(*aliFileInfo)
+	if !ok || info.fileId == "" {
+		return nil
+	}
+
+	// R: 可共享 D: 可删除 N: 可重命名 V: 可移动 W: 可写 C/K: 可在目录中创建文件/目录
+	// 用户没有写权限时由 authorizedFile 替换为 ocReadOnlyPermissions
+	permissions := "RDNVW"
+	if info.IsDir() {
+		permissions = "RDNVCK"
+	}
+
+	props := map[xml.Name]webdav.Property{
+		propOCFileId:      {XMLName: propOCFileId, InnerXML: []byte(escapeXML(info.fileId))},
+		propOCId:          {XMLName: propOCId, InnerXML: []byte(escapeXML(info.fileId))},
+		propOCPermissions: {XMLName: propOCPermissions, InnerXML: []byte(permissions)},
+		propOCSize:        {XMLName: propOCSize, InnerXML: []byte(strconv.FormatInt(info.size, 10))},
+	}
+
+	for name, p := range checksumProps(fi) {
+		props[name] = p
+	}
+
+	return props
+}
+
+// OwnCloud ownCloud/Nextcloud 兼容层，把 remote.php 路径转发给 Handler
+type OwnCloud struct {
+	handler    *Handler
+	uploadsDir string
+}
+
+// NewOwnCloud 创建兼容层，分片上传的数据暂存在 workDir/uploads 目录下
+func NewOwnCloud(handler *Handler, workDir string) *OwnCloud {
+	o := &OwnCloud{
+		handler:    handler,
+		uploadsDir: filepath.Join(workDir, "uploads"),
+	}
+
+	go func() {
+		for range time.Tick(time.Hour) {
+			o.cleanUploads()
+		}
+	}()
+
+	return o
+}
+
+// Match 判断请求是否属于兼容层
+func (o *OwnCloud) Match(r *http.Request) bool {
+	p := r.URL.Path
+
+	return p == "/status.php" || strings.HasPrefix(p, "/ocs/") ||
+		p == ocWebdavPrefix || strings.HasPrefix(p, ocWebdavPrefix+"/") ||
+		strings.HasPrefix(p, ocFilesPrefix) || strings.HasPrefix(p, ocUploadsPrefix)
+}
+
+func (o *OwnCloud) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Path
+
+	switch {
+	case p == "/status.php":
+		o.serveStatus(w)
+	case strings.HasPrefix(p, "/ocs/"):
+		o.serveOCS(w, r)
+	case p == ocWebdavPrefix || strings.HasPrefix(p, ocWebdavPrefix+"/"):
+		o.serveFiles(w, r, ocWebdavPrefix)
+	case strings.HasPrefix(p, ocFilesPrefix):
+		user, _ := splitUser(p[len(ocFilesPrefix):])
+		if !sameUser(r.Context(), user) {
+			http.Error(w, errUserMismatch.Error(), http.StatusForbidden)
+			return
+		}
+		o.serveFiles(w, r, ocFilesPrefix+user)
+	case strings.HasPrefix(p, ocUploadsPrefix):
+		o.serveUploads(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// sameUser 路径中的用户名必须和认证的用户一致，没有开启认证时不检查
+func sameUser(ctx context.Context, user string) bool {
+	authenticated := userOf(ctx)
+	return authenticated == "" || authenticated == user
+}
+
+// DrivePath 去掉 remote.php 前缀，返回网盘中的路径，其余路径原样返回
+func DrivePath(p string) string {
+	switch {
+	case p == ocWebdavPrefix || strings.HasPrefix(p, ocWebdavPrefix+"/"):
+		p = p[len(ocWebdavPrefix):]
+	case strings.HasPrefix(p, ocFilesPrefix):
+		_, p = splitUser(p[len(ocFilesPrefix):])
+	}
+
+	return p
+}
+
+// splitUser 把 "<user>/rest" 拆分为用户和剩余路径
+func splitUser(p string) (user, rest string) {
+	if i := strings.Index(p, "/"); i >= 0 {
+		return p[:i], p[i:]
+	}
+	return p, "/"
+}
+
+func (o *OwnCloud) serveStatus(w http.ResponseWriter) {
+	writeJSON(w, map[string]interface{}{
+		"installed":       true,
+		"maintenance":     false,
+		"needsDbUpgrade":  false,
+		"version":         "10.0.0.0",
+		"versionstring":   "10.0.0",
+		"edition":         "",
+		"productname":     "aliyundrive-webdav " + internal.Version,
+		"extendedSupport": false,
+	})
+}
+
+// serveOCS 提供客户端启动时查询的 capabilities 和用户信息
+func (o *OwnCloud) serveOCS(w http.ResponseWriter, r *http.Request) {
+	var data interface{}
+
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/ocs/v1.php/cloud/capabilities", "/ocs/v2.php/cloud/capabilities":
+		data = map[string]interface{}{
+			"version": map[string]interface{}{
+				"major":  10,
+				"minor":  0,
+				"micro":  0,
+				"string": "10.0.0",
+			},
+			"capabilities": map[string]interface{}{
+				"core": map[string]interface{}{
+					"webdav-root": "remote.php/webdav",
+				},
+				"dav": map[string]interface{}{
+					"chunking": "1.0",
+				},
+				"files": map[string]interface{}{
+					"bigfilechunking": true,
+				},
+				"checksums": map[string]interface{}{
+					"supportedTypes":      []string{"SHA1"},
+					"preferredUploadType": "SHA1",
+				},
+			},
+		}
+	case "/ocs/v1.php/cloud/user", "/ocs/v2.php/cloud/user":
+		user, _, _ := r.BasicAuth()
+		data = map[string]interface{}{
+			"id":           user,
+			"display-name": user,
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"ocs": map[string]interface{}{
+			"meta": map[string]interface{}{
+				"status":     "ok",
+				"statuscode": 100,
+				"message":    "OK",
+			},
+			"data": data,
+		},
+	})
+}
+
+// serveFiles 去掉 remote.php 前缀后交给 Handler 处理，Destination 同样会去掉前缀
+func (o *OwnCloud) serveFiles(w http.ResponseWriter, r *http.Request, prefix string) {
+	h := *o.handler
+	h.Prefix = prefix
+
+	if r.URL.Path == prefix {
+		r.URL.Path = prefix + "/"
+	}
+
+	h.ServeHTTP(w, r)
+}
+
+// serveUploads 分片上传 v2：MKCOL 创建上传目录，PUT 上传分片，MOVE .file 合并到目标路径
+func (o *OwnCloud) serveUploads(w http.ResponseWriter, r *http.Request) {
+	user, rest := splitUser(r.URL.Path[len(ocUploadsPrefix):])
+	if user == "" || user == "." || user == ".." {
+		http.NotFound(w, r)
+		return
+	}
+
+	// 分片目录按认证的用户区分，不能访问其他用户的分片
+	if !sameUser(r.Context(), user) {
+		http.Error(w, errUserMismatch.Error(), http.StatusForbidden)
+		return
+	}
+	if authenticated := userOf(r.Context()); authenticated != "" {
+		user = authenticated
+	}
+
+	root := filepath.Join(o.uploadsDir, user)
+	if err := os.MkdirAll(root, 0700); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == "MOVE" && path.Base(rest) == ocChunkAssembly {
+		status, err := o.assembleChunks(w, r, webdav.Dir(root), path.Dir(rest))
+		if err != nil {
+			logrus.Errorf("assemble chunks %s error %s", rest, err)
+		}
+		if status != 0 {
+			http.Error(w, webdav.StatusText(status), status)
+		}
+		return
+	}
+
+	h := &webdav.Handler{
+		Prefix:     ocUploadsPrefix + user,
+		FileSystem: webdav.Dir(root),
+		LockSystem: o.handler.LockSystem,
+	}
+
+	h.ServeHTTP(w, r)
+}
+
+// assembleChunks 按分片名称顺序合并分片并上传到 Destination
+func (o *OwnCloud) assembleChunks(w http.ResponseWriter, r *http.Request, dir webdav.Dir, transfer string) (int, error) {
+	dest, err := o.destination(r)
+	if err == errUserMismatch {
+		return http.StatusForbidden, err
+	}
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if o.handler.Authorizer != nil {
+		if err = o.handler.check(r.Context(), true, dest, false); err != nil {
+			return http.StatusForbidden, err
+		}
+	}
+
+	release, status, err := o.handler.confirmLocks(r, "", dest)
+	if err != nil {
+		return status, err
+	}
+	defer release()
+
+	ctx := r.Context()
+
+	f, err := dir.OpenFile(ctx, transfer, os.O_RDONLY, 0)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+	chunks, err := f.Readdir(-1)
+	_ = f.Close()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	sortChunks(chunks)
+
+	var total int64
+	readers := make([]io.Reader, 0, len(chunks))
+
+	for _, chunk := range chunks {
+		if chunk.IsDir() || chunk.Name() == ocChunkAssembly {
+			continue
+		}
+
+		cf, err := dir.OpenFile(ctx, path.Join(transfer, chunk.Name()), os.O_RDONLY, 0)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		defer cf.Close()
+
+		readers = append(readers, cf)
+		total += chunk.Size()
+	}
+
+	if expected := r.Header.Get("OC-Total-Length"); expected != "" {
+		if n, err := strconv.ParseInt(expected, 10, 64); err == nil && n != total {
+			return http.StatusBadRequest, errors.New("chunks size mismatch")
+		}
+	}
+
+	if q, ok := o.handler.FileSystem.(quotaReporter); ok {
+		if available, _, err := q.Quota(ctx, dest); err == nil && total > available {
+			return http.StatusInsufficientStorage, errInsufficientStorage
+		}
+	}
+
+	_, statErr := o.handler.FileSystem.Stat(ctx, dest)
+	created := os.IsNotExist(statErr)
+
+	ctx = context.WithValue(ctx, CtxSizeValue, total)
+	if modTime, ok := parseOCMtime(r.Header.Get("X-OC-Mtime")); ok {
+		ctx = context.WithValue(ctx, CtxModTimeValue, modTime)
+		w.Header().Set("X-OC-MTime", "accepted")
+	}
+
+	df, err := o.handler.FileSystem.OpenFile(ctx, dest, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return http.StatusConflict, err
+	}
+
+	_, copyErr := Copy(df, io.MultiReader(readers...))
+	closeErr := df.Close()
+
+	if copyErr != nil {
+		return http.StatusInternalServerError, copyErr
+	}
+	if closeErr != nil {
+		return http.StatusInternalServerError, closeErr
+	}
+
+	if err := dir.RemoveAll(ctx, transfer); err != nil {
+		logrus.Warnf("remove upload chunks %s error %s", transfer, err)
+	}
+
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	return 0, nil
+}
+
+// destination 解析 Destination 头，返回去掉 remote.php 前缀后的路径
+func (o *OwnCloud) destination(r *http.Request) (string, error) {
+	u, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil {
+		return "", err
+	}
+
+	p := u.Path
+
+	switch {
+	case strings.HasPrefix(p, ocFilesPrefix):
+		var user string
+		user, p = splitUser(p[len(ocFilesPrefix):])
+		if !sameUser(r.Context(), user) {
+			return "", errUserMismatch
+		}
+	case strings.HasPrefix(p, ocWebdavPrefix+"/"):
+		p = p[len(ocWebdavPrefix):]
+	default:
+		return "", errInvalidDestination
+	}
+
+	if p == "/" {
+		return "", errInvalidDestination
+	}
+
+	return path.Clean(p), nil
+}
+
+// sortChunks 分片名为数字时按数字排序，否则按名称排序（如 v1 的 00000000-00001000）
+func sortChunks(chunks []os.FileInfo) {
+	sort.Slice(chunks, func(i, j int) bool {
+		a, errA := strconv.ParseInt(chunks[i].Name(), 10, 64)
+		b, errB := strconv.ParseInt(chunks[j].Name(), 10, 64)
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return chunks[i].Name() < chunks[j].Name()
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_, _ = w.Write(content)
+}
+
+// cleanUploads 删除超过保留时间的分片上传目录
+func (o *OwnCloud) cleanUploads() {
+	users, err := ioutil.ReadDir(o.uploadsDir)
+	if err != nil {
+		return
+	}
+
+	for _, user := range users {
+		transfers, err := ioutil.ReadDir(filepath.Join(o.uploadsDir, user.Name()))
+		if err != nil {
+			continue
+		}
+
+		for _, transfer := range transfers {
+			if time.Since(transfer.ModTime()) > ocUploadRetention {
+				_ = os.RemoveAll(filepath.Join(o.uploadsDir, user.Name(), transfer.Name()))
+			}
+		}
+	}
+}
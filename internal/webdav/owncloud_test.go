@@ -0,0 +1,69 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDrivePath(t *testing.T) {
+	tests := []struct {
+		p, want string
+	}{
+		{"/dir/a.txt", "/dir/a.txt"},
+		{"/remote.php/webdav", ""},
+		{"/remote.php/webdav/", "/"},
+		{"/remote.php/webdav/mount/a.txt", "/mount/a.txt"},
+		{"/remote.php/webdavx/a.txt", "/remote.php/webdavx/a.txt"},
+		{"/remote.php/dav/files/alice", "/"},
+		{"/remote.php/dav/files/alice/mount/a.txt", "/mount/a.txt"},
+	}
+
+	for _, tt := range tests {
+		if got := DrivePath(tt.p); got != tt.want {
+			t.Errorf("DrivePath(%q) = %q, expected %q", tt.p, got, tt.want)
+		}
+	}
+}
+
+// readOnlyAuthorizer bob 只能读取 /docs
+type readOnlyAuthorizer struct{}
+
+func (readOnlyAuthorizer) Authorize(user string, write bool, p string) error {
+	if write && user == "bob" && (p == "/docs" || strings.HasPrefix(p, "/docs/")) {
+		return errors.New("forbidden")
+	}
+	return nil
+}
+
+func (a readOnlyAuthorizer) AuthorizeTree(user string, write bool, p string) error {
+	return a.Authorize(user, write, p)
+}
+
+func TestOCPermissions(t *testing.T) {
+	fs := newTestFS(t, false)
+	if err := fs.Mkdir(context.WithValue(context.Background(), CtxSizeValue, int64(0)), "/docs", 0755); err != nil {
+		t.Fatal(err)
+	}
+	upload(t, fs, "/docs/a.txt", "hello", nil)
+
+	h := newDriveHandler(fs, readOnlyAuthorizer{})
+
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:oc="http://owncloud.org/ns"><D:prop><oc:permissions/></D:prop></D:propfind>`
+	tests := []struct {
+		user, name, want string
+	}{
+		{"alice", "/docs", "RDNVCK"},
+		{"alice", "/docs/a.txt", "RDNVW"},
+		{"bob", "/docs", ocReadOnlyPermissions},
+		{"bob", "/docs/a.txt", ocReadOnlyPermissions},
+	}
+
+	for _, tt := range tests {
+		w := serve(h, tt.user, "PROPFIND", tt.name, map[string]string{"Depth": "0"}, body)
+		if want := "<permissions xmlns=\"http://owncloud.org/ns\">" + tt.want + "</permissions>"; !strings.Contains(w.Body.String(), want) {
+			t.Errorf("%s %s: expected %s, got %s", tt.user, tt.name, tt.want, w.Body.String())
+		}
+	}
+}
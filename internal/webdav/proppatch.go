@@ -0,0 +1,427 @@
+package webdav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/webdav"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	propLastModified      = xml.Name{Space: "DAV:", Local: "getlastmodified"}
+	propWin32LastModified = xml.Name{Space: "urn:schemas-microsoft-com:", Local: "Win32LastModifiedTime"}
+
+	errInvalidProppatch = errors.New("webdav: invalid proppatch")
+)
+
+// protectedProps 由服务端计算、不能通过 PROPPATCH 修改的属性，webdav.Handler 自己的受保护属性由它检查
+var protectedProps = map[xml.Name]bool{
+	propQuotaAvailable: true,
+	propQuotaUsed:      true,
+	propOCChecksums:    true,
+	propOCFileId:       true,
+	propOCId:           true,
+	propOCPermissions:  true,
+	propOCSize:         true,
+	propSyncToken:      true,
+}
+
+// maxProppatchBody PROPPATCH 请求体大小限制
+const maxProppatchBody = 1 << 20
+
+// modTimeSetter 支持修改修改时间的文件
+type modTimeSetter interface {
+	SetModTime(modTime time.Time) error
+}
+
+// patchProp PROPPATCH 请求中的一个属性，start、end 为属性元素在请求体中的位置
+type patchProp struct {
+	name       xml.Name
+	value      string
+	start, end int64
+	modTime    bool
+}
+
+// patchOp 一个 set 或 remove 元素
+type patchOp struct {
+	remove     bool
+	start, end int64
+	props      []*patchProp
+}
+
+// parseProppatch 解析属性名称、文本值和位置，属性值的格式由 webdav.Handler 校验
+func parseProppatch(body []byte) ([]*patchOp, error) {
+	d := xml.NewDecoder(bytes.NewReader(body))
+
+	var (
+		ops   []*patchOp
+		op    *patchOp
+		depth int
+	)
+
+	for {
+		offset := d.InputOffset()
+
+		t, err := d.Token()
+		if err == io.EOF {
+			return ops, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			depth++
+
+			switch depth {
+			case 1:
+				if t.Name != (xml.Name{Space: "DAV:", Local: "propertyupdate"}) {
+					return nil, errInvalidProppatch
+				}
+			case 2:
+				if t.Name.Space != "DAV:" || (t.Name.Local != "set" && t.Name.Local != "remove") {
+					return nil, errInvalidProppatch
+				}
+				op = &patchOp{remove: t.Name.Local == "remove", start: offset}
+				ops = append(ops, op)
+			case 3:
+				if t.Name != (xml.Name{Space: "DAV:", Local: "prop"}) {
+					return nil, errInvalidProppatch
+				}
+			case 4:
+				var value bytes.Buffer
+				for level := 1; level > 0; {
+					inner, err := d.Token()
+					if err != nil {
+						return nil, err
+					}
+					switch inner := inner.(type) {
+					case xml.StartElement:
+						level++
+					case xml.EndElement:
+						level--
+					case xml.CharData:
+						value.Write(inner)
+					}
+				}
+				depth--
+
+				op.props = append(op.props, &patchProp{
+					name:  t.Name,
+					value: value.String(),
+					start: offset,
+					end:   d.InputOffset(),
+				})
+			}
+		case xml.EndElement:
+			if depth == 2 {
+				op.end = d.InputOffset()
+			}
+			depth--
+		}
+	}
+}
+
+// cutModTimeProps 从请求体中去掉修改时间属性，只包含修改时间的 set 元素整个去掉
+// 其余内容保持原样，根元素上声明的命名空间前缀仍然有效
+func cutModTimeProps(body []byte, ops []*patchOp) []byte {
+	var ranges [][2]int64
+
+	for _, op := range ops {
+		kept := 0
+		for _, p := range op.props {
+			if !p.modTime {
+				kept++
+			}
+		}
+
+		if kept == 0 {
+			ranges = append(ranges, [2]int64{op.start, op.end})
+			continue
+		}
+
+		for _, p := range op.props {
+			if p.modTime {
+				ranges = append(ranges, [2]int64{p.start, p.end})
+			}
+		}
+	}
+
+	result := make([]byte, 0, len(body))
+	var pos int64
+	for _, r := range ranges {
+		result = append(result, body[pos:r[0]]...)
+		pos = r[1]
+	}
+
+	return append(result, body[pos:]...)
+}
+
+// parseOCMtime 解析 X-OC-Mtime 头，值为 Unix 时间戳（秒，可能带小数）
+func parseOCMtime(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || seconds <= 0 {
+		return time.Time{}, false
+	}
+
+	sec := int64(seconds)
+	nsec := int64((seconds - float64(sec)) * float64(time.Second))
+
+	return time.Unix(sec, nsec), true
+}
+
+// parsePropTime 解析 getlastmodified/Win32LastModifiedTime 属性值
+func parsePropTime(value string) (time.Time, error) {
+	return http.ParseTime(strings.TrimSpace(value))
+}
+
+// handleProppatch 处理 PROPPATCH 中的修改时间属性和受保护属性，其余属性交由 webdav.Handler 处理
+// getlastmodified 在 webdav.Handler 中属于受保护属性，会直接返回 403
+// 按 RFC 4918 全部属性检查通过后才修改，任何一个属性失败时其余属性返回 424，不做任何修改
+func (h *Handler) handleProppatch(w http.ResponseWriter, r *http.Request) (status int, err error) {
+	reqPath, status, err := h.stripPrefix(r.URL.Path)
+	if err != nil {
+		return status, err
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxProppatchBody))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	// 格式错误时交给 webdav.Handler 返回 400
+	ops, err := parseProppatch(body)
+	if err != nil {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		h.Handler.ServeHTTP(w, r)
+		return 0, nil
+	}
+
+	var (
+		modTime                         *time.Time
+		badValue                        bool
+		modTimeProps, protected, others []xml.Name
+	)
+
+	for _, op := range ops {
+		for _, p := range op.props {
+			switch {
+			case protectedProps[p.name]:
+				protected = append(protected, p.name)
+			case !op.remove && (p.name == propLastModified || p.name == propWin32LastModified):
+				p.modTime = true
+				modTimeProps = append(modTimeProps, p.name)
+
+				if t, err := parsePropTime(p.value); err != nil {
+					badValue = true
+				} else {
+					modTime = &t
+				}
+			default:
+				others = append(others, p.name)
+			}
+		}
+	}
+
+	// 不包含修改时间和受保护属性，原样交给 webdav.Handler
+	if len(modTimeProps) == 0 && len(protected) == 0 {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		h.Handler.ServeHTTP(w, r)
+		return 0, nil
+	}
+
+	release, status, err := h.confirmLocks(r, reqPath, "")
+	if err != nil {
+		return status, err
+	}
+	defer release()
+
+	f, err := h.FileSystem.OpenFile(r.Context(), reqPath, os.O_RDONLY, 0)
+	if err != nil {
+		switch {
+		case os.IsNotExist(err):
+			return http.StatusNotFound, err
+		case os.IsPermission(err):
+			return http.StatusForbidden, err
+		}
+		return http.StatusInternalServerError, err
+	}
+	defer f.Close()
+
+	failed := append(append([]xml.Name{}, modTimeProps...), others...)
+
+	if len(protected) > 0 {
+		return writePropstats(w, r.URL.Path, propstatXML(http.StatusForbidden, protected, cannotModifyProtected)+
+			propstatXML(webdav.StatusFailedDependency, failed, ""))
+	}
+
+	setter, ok := f.(modTimeSetter)
+	if !ok || badValue {
+		status := http.StatusConflict
+		if !ok {
+			status = http.StatusForbidden
+		}
+
+		return writePropstats(w, r.URL.Path, propstatXML(status, modTimeProps, "")+
+			propstatXML(webdav.StatusFailedDependency, others, ""))
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	oldModTime := fi.ModTime()
+
+	if err := setter.SetModTime(*modTime); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if len(others) == 0 {
+		return writePropstats(w, r.URL.Path, propstatXML(http.StatusOK, modTimeProps, ""))
+	}
+
+	// 其余属性交由 webdav.Handler 处理，锁已经确认过，不再重复确认
+	restBody := cutModTimeProps(body, ops)
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(restBody))
+	r.ContentLength = int64(len(restBody))
+
+	handler := h.Handler
+	handler.LockSystem = confirmedLocks{h.LockSystem}
+
+	bw := newBufferedResponseWriter()
+	handler.ServeHTTP(bw, r)
+
+	// 其余属性失败时恢复修改时间
+	modTimeStatus := http.StatusOK
+	if bw.status != webdav.StatusMulti || !allPropsOK(bw.body.Bytes()) {
+		if err := setter.SetModTime(oldModTime); err != nil {
+			logrus.Warnf("restore modification time of %s error %s", reqPath, err)
+		}
+		modTimeStatus = webdav.StatusFailedDependency
+	}
+
+	content := bw.body.Bytes()
+	if bw.status == webdav.StatusMulti {
+		if i := bytes.LastIndex(content, []byte("</D:response>")); i >= 0 {
+			content = append(content[:i:i], append([]byte(propstatXML(modTimeStatus, modTimeProps, "")), content[i:]...)...)
+		}
+	}
+
+	for k, v := range bw.header {
+		w.Header()[k] = v
+	}
+	w.Header().Del("Content-Length")
+	w.WriteHeader(bw.status)
+	_, err = w.Write(content)
+
+	return 0, err
+}
+
+// writePropstats 返回只包含一个资源的 207 响应
+func writePropstats(w http.ResponseWriter, href, propstats string) (int, error) {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(webdav.StatusMulti)
+	_, err := fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><D:multistatus xmlns:D="DAV:"><D:response><D:href>%s</D:href>%s</D:response></D:multistatus>`,
+		escapeXML((&url.URL{Path: href}).EscapedPath()), propstats)
+
+	return 0, err
+}
+
+// allPropsOK webdav.Handler 返回的 207 响应中全部属性都修改成功
+func allPropsOK(content []byte) bool {
+	d := xml.NewDecoder(bytes.NewReader(content))
+
+	inStatus := false
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			inStatus = t.Name == xml.Name{Space: "DAV:", Local: "status"}
+		case xml.EndElement:
+			inStatus = false
+		case xml.CharData:
+			if inStatus {
+				if fields := strings.Fields(string(t)); len(fields) < 2 || fields[1] != "200" {
+					return false
+				}
+			}
+		}
+	}
+}
+
+// cannotModifyProtected 修改受保护属性时 propstat 中的错误
+const cannotModifyProtected = "<D:cannot-modify-protected-property/>"
+
+// propstatXML 生成属性状态 XML 片段，names 为空时返回空字符串，xmlErr 为 D:error 的内容
+func propstatXML(status int, names []xml.Name, xmlErr string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+
+	b.WriteString("<D:propstat><D:prop>")
+	for _, name := range names {
+		if name.Space == "DAV:" {
+			fmt.Fprintf(&b, "<D:%s/>", name.Local)
+		} else {
+			fmt.Fprintf(&b, `<%s xmlns="%s"/>`, name.Local, escapeXML(name.Space))
+		}
+	}
+	fmt.Fprintf(&b, "</D:prop><D:status>HTTP/1.1 %d %s</D:status>", status, webdav.StatusText(status))
+	if xmlErr != "" {
+		b.WriteString("<D:error>" + xmlErr + "</D:error>")
+	}
+	b.WriteString("</D:propstat>")
+
+	return b.String()
+}
+
+func escapeXML(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// bufferedResponseWriter 缓存响应，用于修改 webdav.Handler 的输出
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponseWriter() *bufferedResponseWriter {
+	return &bufferedResponseWriter{
+		header: make(http.Header),
+		status: http.StatusOK,
+	}
+}
+
+func (b *bufferedResponseWriter) Header() http.Header { return b.header }
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+func (b *bufferedResponseWriter) WriteHeader(status int) { b.status = status }
@@ -0,0 +1,158 @@
+package webdav
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proppatchBody 设置 prop 的 PROPPATCH 请求体，prop 中使用 oc 前缀表示 ownCloud 命名空间
+func proppatchBody(prop string) string {
+	return `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:oc="http://owncloud.org/ns" xmlns:t="urn:test">` +
+		`<D:set><D:prop>` + prop + `</D:prop></D:set></D:propertyupdate>`
+}
+
+func TestProppatchProtected(t *testing.T) {
+	h := newTestHandler(t, map[string]string{"/docs/a.txt": "hello"})
+
+	for _, prop := range []string{
+		"<oc:fileid>1</oc:fileid>",
+		"<oc:id>1</oc:id>",
+		"<oc:permissions>RDNVW</oc:permissions>",
+		"<oc:size>1</oc:size>",
+		"<oc:checksums><oc:checksum>SHA1:00</oc:checksum></oc:checksums>",
+		"<D:quota-used-bytes>1</D:quota-used-bytes>",
+		"<D:quota-available-bytes>1</D:quota-available-bytes>",
+		"<D:sync-token>token</D:sync-token>",
+	} {
+		// 受保护属性返回 403，同一请求中的其他属性返回 424，不做修改
+		w := serve(h, "alice", "PROPPATCH", "/docs/a.txt", nil, proppatchBody(prop+"<t:color>red</t:color>"))
+		body := w.Body.String()
+		if w.Code != http.StatusMultiStatus || !strings.Contains(body, "403 Forbidden") ||
+			!strings.Contains(body, "cannot-modify-protected-property") || !strings.Contains(body, "424 Failed Dependency") {
+			t.Errorf("%s: status %d, %s", prop, w.Code, body)
+		}
+	}
+
+	w := serve(h, "alice", "PROPFIND", "/docs/a.txt", map[string]string{"Depth": "0"}, "")
+	if strings.Contains(w.Body.String(), "color") {
+		t.Fatalf("failed PROPPATCH modified properties: %s", w.Body.String())
+	}
+}
+
+func TestParseOCMtime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"1609459200", time.Unix(1609459200, 0), true},
+		{" 1609459200 ", time.Unix(1609459200, 0), true},
+		{"1609459200.5", time.Unix(1609459200, 5e8), true},
+		{"", time.Time{}, false},
+		{"0", time.Time{}, false},
+		{"-1", time.Time{}, false},
+		{"yesterday", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		if got, ok := parseOCMtime(tt.value); !got.Equal(tt.want) || ok != tt.ok {
+			t.Errorf("parseOCMtime(%q) = %s, %v, expected %s, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestProppatchModTime(t *testing.T) {
+	modTime := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	lastModified := "<D:getlastmodified>" + modTime.Format(http.TimeFormat) + "</D:getlastmodified>"
+
+	tests := []struct {
+		name    string
+		prop    string
+		status  []string // 响应中应包含的状态
+		changed bool
+		color   bool
+	}{
+		{"getlastmodified", lastModified, []string{"200 OK"}, true, false},
+		{"Win32LastModifiedTime", `<Z:Win32LastModifiedTime xmlns:Z="urn:schemas-microsoft-com:">` + modTime.Format(http.TimeFormat) + `</Z:Win32LastModifiedTime>`, []string{"200 OK"}, true, false},
+		{"with dead property", lastModified + "<t:color>red</t:color>", []string{"200 OK"}, true, true},
+		// 任何一个属性失败时不做修改
+		{"bad value", "<D:getlastmodified>yesterday</D:getlastmodified><t:color>red</t:color>", []string{"409 Conflict", "424 Failed Dependency"}, false, false},
+		{"protected", lastModified + "<oc:fileid>1</oc:fileid>", []string{"403 Forbidden", "424 Failed Dependency"}, false, false},
+	}
+
+	for _, tt := range tests {
+		fs := newTestFS(t, false)
+		upload(t, fs, "/a.txt", "hello", nil)
+		h := newDriveHandler(fs, nil)
+
+		w := serve(h, "alice", "PROPPATCH", "/a.txt", nil, proppatchBody(tt.prop))
+		for _, status := range tt.status {
+			if w.Code != http.StatusMultiStatus || !strings.Contains(w.Body.String(), status) {
+				t.Errorf("%s: status %d, expected %s in %s", tt.name, w.Code, status, w.Body.String())
+			}
+		}
+
+		fi, err := fs.Stat(context.Background(), "/a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.ModTime().Equal(modTime) != tt.changed {
+			t.Errorf("%s: modification time %s, changed expected %v", tt.name, fi.ModTime(), tt.changed)
+		}
+
+		fileId, _, err := fs.backend.ResolvePathToFileId("/a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := fs.meta.DeadProps(fileId)[testProp]; ok != tt.color {
+			t.Errorf("%s: dead property set %v, expected %v", tt.name, ok, tt.color)
+		}
+	}
+}
+
+func TestProppatchModTimeLocked(t *testing.T) {
+	fs := newTestFS(t, false)
+	upload(t, fs, "/a.txt", "hello", nil)
+	h := newDriveHandler(fs, nil)
+
+	lock := `<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+	w := serve(h, "alice", "LOCK", "/a.txt", nil, lock)
+	if w.Code != http.StatusOK {
+		t.Fatalf("LOCK status %d", w.Code)
+	}
+	token := w.Header().Get("Lock-Token")
+
+	body := proppatchBody("<D:getlastmodified>" + time.Now().Format(http.TimeFormat) + "</D:getlastmodified>")
+	if w := serve(h, "alice", "PROPPATCH", "/a.txt", nil, body); w.Code != http.StatusLocked {
+		t.Fatalf("PROPPATCH without lock token status %d", w.Code)
+	}
+	if w := serve(h, "alice", "PROPPATCH", "/a.txt", map[string]string{"If": "(" + token + ")"}, body); w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPPATCH with lock token status %d", w.Code)
+	}
+}
+
+func TestPutOCMtime(t *testing.T) {
+	fs := newTestFS(t, false)
+	h := newDriveHandler(fs, nil)
+
+	w := serve(h, "alice", "PUT", "/a.txt", map[string]string{"X-OC-Mtime": "1609459200"}, "hello")
+	if w.Code != http.StatusCreated || w.Header().Get("X-OC-MTime") != "accepted" {
+		t.Fatalf("PUT status %d, X-OC-MTime %q", w.Code, w.Header().Get("X-OC-MTime"))
+	}
+
+	ctx := context.WithValue(context.Background(), CtxSizeValue, int64(0))
+	if err := fs.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := fs.Stat(ctx, "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(time.Unix(1609459200, 0)) {
+		t.Fatalf("modification time %s", fi.ModTime())
+	}
+}
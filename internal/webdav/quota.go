@@ -0,0 +1,195 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"github.com/jakeslee/aliyundrive-webdav/internal/backend"
+	"github.com/jakeslee/aliyundrive-webdav/internal/metrics"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/webdav"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// quotaTTL 网盘容量信息缓存时间
+	quotaTTL = time.Minute
+
+	// quotaErrorTTL 接口出错后的缓存时间，避免每次 PROPFIND 都重试
+	quotaErrorTTL = 10 * time.Second
+)
+
+var (
+	errQuotaUnsupported = errors.New("webdav: quota unsupported")
+
+	propQuotaAvailable = xml.Name{Space: "DAV:", Local: "quota-available-bytes"}
+	propQuotaUsed      = xml.Name{Space: "DAV:", Local: "quota-used-bytes"}
+
+	// quotaProps 容量属性，只在明确请求时返回，allprop 不返回（RFC 4331）
+	quotaProps = map[xml.Name]bool{
+		propQuotaAvailable: true,
+		propQuotaUsed:      true,
+	}
+)
+
+// quotaReporter 可以提供容量信息的文件系统（RFC 4331），name 为上传目标路径
+type quotaReporter interface {
+	Quota(ctx context.Context, name string) (available, used int64, err error)
+}
+
+// quotaCache 缓存网盘容量信息，避免每次 PROPFIND 都请求接口
+type quotaCache struct {
+	mu        sync.Mutex
+	backend   backend.Backend
+	fetchedAt time.Time
+	used      int64
+	total     int64
+	err       error
+	failedAt  time.Time
+}
+
+func newQuotaCache(b backend.Backend) *quotaCache {
+	return &quotaCache{
+		backend: b,
+	}
+}
+
+// Get 返回可用和已用空间，单位字节
+func (q *quotaCache) Get() (available, used int64, err error) {
+	reporter, ok := q.backend.(backend.QuotaReporter)
+	if !ok {
+		return 0, 0, errQuotaUnsupported
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	hit := time.Since(q.fetchedAt) <= quotaTTL
+	metrics.ObserveCache("quota", hit)
+
+	if !hit {
+		if q.err != nil && time.Since(q.failedAt) <= quotaErrorTTL {
+			return 0, 0, q.err
+		}
+
+		used, total, err := reporter.Quota()
+		if err != nil {
+			logrus.Warnf("get drive capacity error %s", err)
+			q.err, q.failedAt = err, time.Now()
+			return 0, 0, err
+		}
+
+		q.used = used
+		q.total = total
+		q.fetchedAt = time.Now()
+		q.err = nil
+	}
+
+	available = q.total - q.used
+	if available < 0 {
+		available = 0
+	}
+
+	return available, q.used, nil
+}
+
+// Invalidate 上传、删除后使缓存失效
+func (q *quotaCache) Invalidate() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.fetchedAt = time.Time{}
+	q.err = nil
+}
+
+// props 返回 quota-available-bytes、quota-used-bytes 属性
+func (q *quotaCache) props() map[xml.Name]webdav.Property {
+	available, used, err := q.Get()
+	if err != nil {
+		return nil
+	}
+
+	return map[xml.Name]webdav.Property{
+		propQuotaAvailable: {
+			XMLName:  propQuotaAvailable,
+			InnerXML: []byte(strconv.FormatInt(available, 10)),
+		},
+		propQuotaUsed: {
+			XMLName:  propQuotaUsed,
+			InnerXML: []byte(strconv.FormatInt(used, 10)),
+		},
+	}
+}
+
+// allpropRequest 判断 PROPFIND 是否请求全部属性，请求体为空时也是 allprop
+func allpropRequest(r *http.Request) bool {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxProppatchBody))
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return true
+	}
+
+	var req struct {
+		XMLName xml.Name  `xml:"DAV: propfind"`
+		Allprop *struct{} `xml:"DAV: allprop"`
+	}
+	if err := xml.Unmarshal(body, &req); err != nil {
+		return false
+	}
+
+	return req.Allprop != nil
+}
+
+// allpropFS allprop 时去掉容量属性
+type allpropFS struct {
+	webdav.FileSystem
+}
+
+func (a *allpropFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	f, err := a.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return f, err
+	}
+
+	return &allpropFile{File: f}, nil
+}
+
+type allpropFile struct {
+	webdav.File
+}
+
+func (f *allpropFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	holder, ok := f.File.(webdav.DeadPropsHolder)
+	if !ok {
+		return nil, nil
+	}
+
+	props, err := holder.DeadProps()
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range quotaProps {
+		delete(props, name)
+	}
+
+	return props, nil
+}
+
+func (f *allpropFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	if holder, ok := f.File.(webdav.DeadPropsHolder); ok {
+		return holder.Patch(patches)
+	}
+
+	return nil, webdav.ErrNotImplemented
+}
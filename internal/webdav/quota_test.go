@@ -0,0 +1,112 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"github.com/jakeslee/aliyundrive-webdav/internal/backend"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// quotaBackend 提供容量信息的内存后端
+type quotaBackend struct {
+	backend.Backend
+	used, total int64
+	err         error
+	calls       int
+}
+
+func (b *quotaBackend) Quota() (used, total int64, err error) {
+	b.calls++
+	return b.used, b.total, b.err
+}
+
+func TestQuotaCache(t *testing.T) {
+	tests := []struct {
+		name            string
+		used, total     int64
+		err             error
+		available, want int64
+	}{
+		{name: "free space", used: 30, total: 100, available: 70, want: 30},
+		{name: "over quota", used: 120, total: 100, available: 0, want: 120},
+		{name: "error", err: errors.New("unavailable")},
+	}
+
+	for _, tt := range tests {
+		b := &quotaBackend{Backend: backend.NewMemory(), used: tt.used, total: tt.total, err: tt.err}
+		q := newQuotaCache(b)
+
+		for i := 0; i < 2; i++ {
+			available, used, err := q.Get()
+			if err != tt.err || available != tt.available || used != tt.want {
+				t.Errorf("%s: Get() = %d, %d, %v", tt.name, available, used, err)
+			}
+		}
+
+		// 成功和失败的结果都会缓存
+		if b.calls != 1 {
+			t.Errorf("%s: %d calls, expected 1", tt.name, b.calls)
+		}
+
+		q.Invalidate()
+		_, _, _ = q.Get()
+		if b.calls != 2 {
+			t.Errorf("%s: %d calls after Invalidate, expected 2", tt.name, b.calls)
+		}
+	}
+
+	if _, _, err := newQuotaCache(backend.NewMemory()).Get(); err != errQuotaUnsupported {
+		t.Errorf("backend without quota: %v", err)
+	}
+}
+
+func TestQuotaRequests(t *testing.T) {
+	b := &quotaBackend{Backend: backend.NewMemory(), used: 30, total: 100}
+	fs := NewAliDriveFS(b, &Options{WorkDir: t.TempDir()}).(*aliDriveFS)
+	h := newDriveHandler(fs, nil)
+
+	quota := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:quota-available-bytes/><D:quota-used-bytes/></D:prop></D:propfind>`
+	allprop := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:allprop/></D:propfind>`
+
+	tests := []struct {
+		name, method, body string
+		header             map[string]string
+		status             int
+		contains, excludes []string
+	}{
+		{"requested", "PROPFIND", quota, map[string]string{"Depth": "0"}, http.StatusMultiStatus,
+			[]string{"<D:quota-available-bytes>70</D:quota-available-bytes>", "<D:quota-used-bytes>30</D:quota-used-bytes>"}, nil},
+		// allprop 和空请求体不返回容量属性
+		{"allprop", "PROPFIND", allprop, map[string]string{"Depth": "0"}, http.StatusMultiStatus, nil, []string{"quota-"}},
+		{"empty body", "PROPFIND", "", map[string]string{"Depth": "0"}, http.StatusMultiStatus, nil, []string{"quota-"}},
+		{"upload fits", "PUT", strings.Repeat("a", 70), nil, http.StatusCreated, nil, nil},
+		{"upload too large", "PUT", strings.Repeat("a", 71), nil, http.StatusInsufficientStorage, nil, nil},
+	}
+
+	for _, tt := range tests {
+		target := "/"
+		if tt.method == "PUT" {
+			target = "/a.txt"
+		}
+
+		w := serve(h, "alice", tt.method, target, tt.header, tt.body)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, expected %d", tt.name, w.Code, tt.status)
+		}
+		for _, s := range tt.contains {
+			if !strings.Contains(w.Body.String(), s) {
+				t.Errorf("%s: %s not in %s", tt.name, s, w.Body.String())
+			}
+		}
+		for _, s := range tt.excludes {
+			if strings.Contains(w.Body.String(), s) {
+				t.Errorf("%s: unexpected %s in %s", tt.name, s, w.Body.String())
+			}
+		}
+	}
+	if err := fs.Drain(context.WithValue(context.Background(), CtxSizeValue, int64(0))); err != nil {
+		t.Fatal(err)
+	}
+}
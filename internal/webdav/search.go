@@ -0,0 +1,683 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/jakeslee/aliyundrive"
+	"github.com/jakeslee/aliyundrive-webdav/internal/backend"
+	"github.com/jakeslee/aliyundrive/models"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/webdav"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// driveTimeLayout 阿里云盘查询语句中的时间格式
+	driveTimeLayout = "2006-01-02T15:04:05"
+
+	// infiniteDepth 搜索全部子目录
+	infiniteDepth = -1
+)
+
+var (
+	errInvalidSearch     = errors.New("webdav: invalid search request")
+	errUnsupportedSearch = errors.New("webdav: unsupported search condition")
+
+	// defaultSearchProps allprop 或 ?search= 时返回的属性
+	defaultSearchProps = []xml.Name{
+		propResourceType, propDisplayName, propContentLength,
+		propLastModified, propContentType, propETag,
+	}
+)
+
+// searcher 支持服务端搜索的文件系统，query 为阿里云盘的查询语句
+type searcher interface {
+	Search(ctx context.Context, scope string, cond *searchCondition, depth, limit int) (map[string]os.FileInfo, error)
+	PropsOf(name string, fi os.FileInfo) map[xml.Name]webdav.Property
+}
+
+// searchNode 通用 XML 节点，用于解析 basicsearch 条件
+type searchNode struct {
+	XMLName  xml.Name
+	Content  string       `xml:",chardata"`
+	Children []searchNode `xml:",any"`
+}
+
+func (n *searchNode) child(local string) *searchNode {
+	if n == nil {
+		return nil
+	}
+
+	for i := range n.Children {
+		if n.Children[i].XMLName.Space == "DAV:" && n.Children[i].XMLName.Local == local {
+			return &n.Children[i]
+		}
+	}
+
+	return nil
+}
+
+func (n *searchNode) text() string {
+	if n == nil {
+		return ""
+	}
+	return strings.TrimSpace(n.Content)
+}
+
+// searchQuery 解析后的搜索请求
+type searchQuery struct {
+	scope   string
+	depth   int
+	cond    *searchCondition
+	limit   int
+	names   []xml.Name
+	allprop bool
+}
+
+// searchCondition 解析后的查询条件，query 交给阿里云盘查询，为空时需要列出全部文件；
+// 修改时间使用保留的 mtime，只能在本地判断，local 为 true 时需要用 match 过滤
+type searchCondition struct {
+	query string
+	local bool
+	match func(fi os.FileInfo) bool
+}
+
+// matches 判断文件是否满足条件，cond 为空时全部满足
+func (c *searchCondition) matches(fi os.FileInfo) bool {
+	return c == nil || c.match(fi)
+}
+
+// filter 判断阿里云盘返回的文件是否还需要本地过滤后才满足条件
+func (c *searchCondition) filter(fi os.FileInfo) bool {
+	return c == nil || !c.local || c.match(fi)
+}
+
+// driveQuery 返回交给阿里云盘的查询语句
+func (c *searchCondition) driveQuery() string {
+	if c == nil {
+		return ""
+	}
+	return c.query
+}
+
+// quoteDriveValue 转换为阿里云盘查询语句的字符串，只转义反斜杠和双引号
+func quoteDriveValue(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// Search 调用阿里云盘搜索接口，只返回 scope 下的文件，depth 为 0 时只判断 scope 本身
+func (a *aliDriveFS) Search(ctx context.Context, scope string, cond *searchCondition, depth, limit int) (map[string]os.FileInfo, error) {
+	s, ok := a.backend.(backend.Searcher)
+	if !ok {
+		return nil, errUnsupportedSearch
+	}
+
+	scope = path.Clean("/" + scope)
+	result := make(map[string]os.FileInfo)
+
+	if depth == 0 {
+		fi, err := a.Stat(ctx, scope)
+		if err != nil {
+			return nil, err
+		}
+		if cond.matches(fi) {
+			result[scope] = fi
+		}
+		return result, nil
+	}
+
+	scopeId, _, err := a.backend.ResolvePathToFileId(scope)
+	if err != nil {
+		if err == aliyundrive.ErrPartialFoundPath {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+
+	query := cond.driveQuery()
+
+	if depth != infiniteDepth {
+		parent := "parent_file_id = " + quoteDriveValue(scopeId)
+		if query == "" {
+			query = parent
+		} else {
+			query = parent + " and (" + query + ")"
+		}
+	}
+
+	// 条件无法交给阿里云盘时，列出 scope 下的全部文件在本地过滤
+	if query == "" {
+		if cond == nil {
+			return nil, errInvalidSearch
+		}
+
+		files := make(map[string]os.FileInfo)
+		if err := a.listChanged(scopeId, scope, time.Time{}, true, files); err != nil {
+			return nil, err
+		}
+
+		for p, fi := range files {
+			if limit > 0 && len(result) >= limit {
+				break
+			}
+			if cond.matches(fi) {
+				result[p] = fi
+			}
+		}
+
+		return result, nil
+	}
+
+	// 非根目录递归搜索时先列出 scope 下的目录，按父目录过滤，不需要逐个查询路径
+	parents := map[string]string{scopeId: scope}
+	restricted := depth != infiniteDepth || scopeId != aliyundrive.DefaultRootFileId
+	if depth == infiniteDepth && restricted {
+		if err := a.folderPaths(scopeId, scope, parents); err != nil {
+			return nil, err
+		}
+	}
+
+	marker := ""
+
+	for {
+		resp, err := s.Search(query, marker, 100)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range resp.Items {
+			dir, ok := parents[item.ParentFileId]
+			if !ok {
+				if restricted {
+					continue
+				}
+
+				if dir, err = a.pathOf(item.ParentFileId, parents); err != nil {
+					logrus.Warnf("resolve path of %s error %s", item.FileId, err)
+					continue
+				}
+			}
+
+			fi := a.meta.apply(NewAliFileInfo(item).(*aliFileInfo))
+			if !cond.filter(fi) {
+				continue
+			}
+
+			result[path.Join(dir, item.Name)] = fi
+
+			if limit > 0 && len(result) >= limit {
+				return result, nil
+			}
+		}
+
+		if resp.NextMarker == "" {
+			return result, nil
+		}
+
+		marker = resp.NextMarker
+	}
+}
+
+// folderPaths 列出 dir 下的全部子目录，result 为目录 id 到路径的映射
+func (a *aliDriveFS) folderPaths(fileId, dir string, result map[string]string) error {
+	result[fileId] = dir
+	marker := ""
+
+	for {
+		files, err := a.backend.GetFolderFiles(&aliyundrive.FolderFilesOptions{
+			FolderFileId: fileId,
+			Marker:       marker,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, item := range files.Items {
+			if item.Type != models.FileTypeFolder {
+				continue
+			}
+			if err := a.folderPaths(item.FileId, path.Join(dir, item.Name), result); err != nil {
+				return err
+			}
+		}
+
+		if files.NextMarker == "" {
+			return nil
+		}
+
+		marker = files.NextMarker
+	}
+}
+
+// pathOf 返回目录的完整路径，cache 用于同一次搜索中复用
+func (a *aliDriveFS) pathOf(fileId string, cache map[string]string) (string, error) {
+	if p, ok := cache[fileId]; ok {
+		return p, nil
+	}
+
+	items, err := a.backend.GetPath(fileId)
+	if err != nil {
+		return "", err
+	}
+
+	// 接口按从当前目录到根目录的顺序返回
+	names := make([]string, 0, len(items))
+	if len(items) > 0 && items[0].FileId == fileId {
+		for i := len(items) - 1; i >= 0; i-- {
+			names = append(names, items[i].Name)
+		}
+	} else {
+		for _, item := range items {
+			names = append(names, item.Name)
+		}
+	}
+
+	p := "/" + strings.Join(names, "/")
+	cache[fileId] = p
+
+	return p, nil
+}
+
+// handleSearch 处理 SEARCH 方法（RFC 5323 basicsearch）
+func (h *Handler) handleSearch(w http.ResponseWriter, r *http.Request) (status int, err error) {
+	reqPath, status, err := h.stripPrefix(r.URL.Path)
+	if err != nil {
+		return status, err
+	}
+
+	if _, ok := h.FileSystem.(searcher); !ok {
+		return http.StatusNotImplemented, errUnsupportedSearch
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxProppatchBody))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	q, err := h.parseSearch(body, reqPath)
+	if err == errUnsupportedSearch {
+		return http.StatusUnprocessableEntity, err
+	}
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	// scope 可以和请求路径不同
+	if h.Authorizer != nil {
+		if err = h.check(r.Context(), false, q.scope, false); err != nil {
+			return http.StatusForbidden, err
+		}
+	}
+
+	return h.serveSearch(w, r, q)
+}
+
+// handleGetSearch 处理目录的 GET ?search=keyword，按文件名搜索目录及子目录
+func (h *Handler) handleGetSearch(w http.ResponseWriter, r *http.Request, reqPath, keyword string) (int, error) {
+	if _, ok := h.FileSystem.(searcher); !ok {
+		return http.StatusNotImplemented, errUnsupportedSearch
+	}
+
+	return h.serveSearch(w, r, &searchQuery{
+		scope: reqPath,
+		depth: infiniteDepth,
+		cond:  nameCondition("match", keyword),
+		names: defaultSearchProps,
+	})
+}
+
+func (h *Handler) serveSearch(w http.ResponseWriter, r *http.Request, q *searchQuery) (int, error) {
+	s := h.FileSystem.(searcher)
+
+	result, err := s.Search(r.Context(), q.scope, q.cond, q.depth, q.limit)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return http.StatusNotFound, err
+		}
+		if err == errInvalidSearch {
+			return http.StatusBadRequest, err
+		}
+		if err == errUnsupportedSearch {
+			return http.StatusNotImplemented, err
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	paths := make([]string, 0, len(result))
+	for p := range result {
+		if h.readable(r.Context(), p) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	var b bytes.Buffer
+
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><D:multistatus xmlns:D="DAV:">`)
+
+	for _, p := range paths {
+		fi := result[p]
+		deadProps := s.PropsOf(p, fi)
+
+		names := q.names
+		if q.allprop {
+			names = append([]xml.Name{}, defaultSearchProps...)
+			for name := range deadProps {
+				names = append(names, name)
+			}
+		}
+
+		h.writeResponse(&b, p, fi, deadProps, names)
+	}
+
+	b.WriteString("</D:multistatus>")
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(webdav.StatusMulti)
+	_, err = w.Write(b.Bytes())
+
+	return 0, err
+}
+
+// parseSearch 解析 basicsearch 请求，把 where 条件转换为阿里云盘的查询语句
+func (h *Handler) parseSearch(body []byte, reqPath string) (*searchQuery, error) {
+	var root searchNode
+	if err := xml.Unmarshal(body, &root); err != nil {
+		return nil, err
+	}
+
+	if root.XMLName.Space != "DAV:" || root.XMLName.Local != "searchrequest" {
+		return nil, errInvalidSearch
+	}
+
+	bs := root.child("basicsearch")
+	if bs == nil {
+		return nil, errUnsupportedSearch
+	}
+
+	q := &searchQuery{
+		scope: reqPath,
+		depth: infiniteDepth,
+	}
+
+	// 查询的属性
+	sel := bs.child("select")
+	if sel == nil {
+		return nil, errInvalidSearch
+	}
+	if sel.child("allprop") != nil {
+		q.allprop = true
+	} else if prop := sel.child("prop"); prop != nil {
+		for _, n := range prop.Children {
+			q.names = append(q.names, n.XMLName)
+		}
+	}
+	if !q.allprop && len(q.names) == 0 {
+		q.names = defaultSearchProps
+	}
+
+	// 查询范围
+	if scope := bs.child("from").child("scope"); scope != nil {
+		href := scope.child("href").text()
+		if href != "" {
+			u, err := url.Parse(href)
+			if err != nil {
+				return nil, err
+			}
+
+			p := u.Path
+			if h.Prefix != "" {
+				if !strings.HasPrefix(p, h.Prefix) {
+					return nil, errInvalidSearch
+				}
+				p = strings.TrimPrefix(p, h.Prefix)
+			}
+			q.scope = p
+		}
+
+		switch scope.child("depth").text() {
+		case "0":
+			q.depth = 0
+		case "1":
+			q.depth = 1
+		}
+	}
+
+	// 查询条件
+	if where := bs.child("where"); where != nil && len(where.Children) > 0 {
+		cond, err := translateCondition(&where.Children[0])
+		if err != nil {
+			return nil, err
+		}
+		q.cond = cond
+	}
+
+	if n := bs.child("limit").child("nresults").text(); n != "" {
+		limit, err := strconv.Atoi(n)
+		if err != nil || limit < 0 {
+			return nil, errInvalidSearch
+		}
+		q.limit = limit
+	}
+
+	return q, nil
+}
+
+// translateCondition 转换 basicsearch 条件，支持 and/or、比较、like 和 is-collection
+func translateCondition(n *searchNode) (*searchCondition, error) {
+	if n.XMLName.Space != "DAV:" {
+		return nil, errUnsupportedSearch
+	}
+
+	switch n.XMLName.Local {
+	case "and", "or":
+		if len(n.Children) == 0 {
+			return nil, errInvalidSearch
+		}
+
+		and := n.XMLName.Local == "and"
+		conds := make([]*searchCondition, 0, len(n.Children))
+		parts := make([]string, 0, len(n.Children))
+		local, partial := false, false
+
+		for i := range n.Children {
+			cond, err := translateCondition(&n.Children[i])
+			if err != nil {
+				return nil, err
+			}
+
+			conds = append(conds, cond)
+			local = local || cond.local
+
+			if cond.query != "" {
+				parts = append(parts, "("+cond.query+")")
+			} else {
+				partial = true
+			}
+		}
+
+		// and 可以只把部分条件交给阿里云盘，or 需要全部条件都能查询
+		query := ""
+		if and || !partial {
+			query = strings.Join(parts, " "+n.XMLName.Local+" ")
+		}
+
+		return &searchCondition{
+			query: query,
+			local: local,
+			match: func(fi os.FileInfo) bool {
+				for _, cond := range conds {
+					if cond.match(fi) != and {
+						return !and
+					}
+				}
+				return and
+			},
+		}, nil
+	case "is-collection":
+		return &searchCondition{
+			query: `type = "folder"`,
+			match: func(fi os.FileInfo) bool { return fi.IsDir() },
+		}, nil
+	case "eq", "lt", "gt", "lte", "gte", "like":
+		return translateComparison(n)
+	}
+
+	return nil, errUnsupportedSearch
+}
+
+var comparisonOperators = map[string]string{
+	"eq":  "=",
+	"lt":  "<",
+	"gt":  ">",
+	"lte": "<=",
+	"gte": ">=",
+}
+
+// compare 按比较运算判断 c 的结果，c 小于、等于、大于时分别为负数、0、正数
+func compare(op string, c int) bool {
+	switch op {
+	case "lt":
+		return c < 0
+	case "gt":
+		return c > 0
+	case "lte":
+		return c <= 0
+	case "gte":
+		return c >= 0
+	}
+	return c == 0
+}
+
+// nameCondition 按文件名查询，op 为 = 或 match
+func nameCondition(op, keyword string) *searchCondition {
+	return &searchCondition{
+		query: "name " + op + " " + quoteDriveValue(keyword),
+		match: func(fi os.FileInfo) bool {
+			if op == "=" {
+				return fi.Name() == keyword
+			}
+			return strings.Contains(strings.ToLower(fi.Name()), strings.ToLower(keyword))
+		},
+	}
+}
+
+// typeCondition 按文件类型查询，op 为 = 或 match
+func typeCondition(op, value string) *searchCondition {
+	return &searchCondition{
+		query: "mime_type " + op + " " + quoteDriveValue(value),
+		match: func(fi os.FileInfo) bool {
+			if fi.IsDir() {
+				return false
+			}
+			if op == "=" {
+				return contentType(fi) == value
+			}
+			return strings.Contains(strings.ToLower(contentType(fi)), strings.ToLower(value))
+		},
+	}
+}
+
+func translateComparison(n *searchNode) (*searchCondition, error) {
+	prop := n.child("prop")
+	literal := n.child("literal")
+	if prop == nil || literal == nil || len(prop.Children) != 1 {
+		return nil, errInvalidSearch
+	}
+
+	name := prop.Children[0].XMLName
+	value := literal.text()
+	op := n.XMLName.Local
+
+	if op == "like" {
+		switch name {
+		case propDisplayName, propContentType:
+			keyword := strings.Trim(value, "%")
+			if keyword == "" {
+				return nil, errUnsupportedSearch
+			}
+
+			// 没有通配符时为精确匹配
+			match := "match"
+			if keyword == value {
+				match = "="
+			}
+
+			if name == propContentType {
+				return typeCondition(match, keyword), nil
+			}
+			return nameCondition(match, keyword), nil
+		}
+
+		return nil, errUnsupportedSearch
+	}
+
+	switch name {
+	case propDisplayName:
+		if op != "eq" {
+			return nil, errUnsupportedSearch
+		}
+		return nameCondition("=", value), nil
+	case propContentType:
+		if op != "eq" {
+			return nil, errUnsupportedSearch
+		}
+		return typeCondition("=", value), nil
+	case propContentLength:
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errInvalidSearch
+		}
+		return &searchCondition{
+			query: fmt.Sprintf("size %s %d", comparisonOperators[op], size),
+			match: func(fi os.FileInfo) bool {
+				if fi.IsDir() {
+					return false
+				}
+				c := 0
+				if fi.Size() < size {
+					c = -1
+				} else if fi.Size() > size {
+					c = 1
+				}
+				return compare(op, c)
+			},
+		}, nil
+	case propLastModified:
+		t, err := http.ParseTime(value)
+		if err != nil {
+			if t, err = time.Parse(time.RFC3339, value); err != nil {
+				return nil, errInvalidSearch
+			}
+		}
+
+		// 修改时间可能是客户端设置的 mtime，和阿里云盘的 updated_at 不同，只能在本地比较
+		t = t.Truncate(time.Second)
+		return &searchCondition{
+			local: true,
+			match: func(fi os.FileInfo) bool {
+				m := fi.ModTime().Truncate(time.Second)
+				c := 0
+				if m.Before(t) {
+					c = -1
+				} else if m.After(t) {
+					c = 1
+				}
+				return compare(op, c)
+			},
+		}, nil
+	}
+
+	return nil, errUnsupportedSearch
+}
@@ -0,0 +1,56 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+const (
+	likeName     = `<D:like><D:prop><D:displayname/></D:prop><D:literal>%a%</D:literal></D:like>`
+	eqSize       = `<D:eq><D:prop><D:getcontentlength/></D:prop><D:literal>5</D:literal></D:eq>`
+	badSize      = `<D:eq><D:prop><D:getcontentlength/></D:prop><D:literal>x</D:literal></D:eq>`
+	modifiedTime = `<D:gt><D:prop><D:getlastmodified/></D:prop><D:literal>2021-01-01T00:00:00Z</D:literal></D:gt>`
+)
+
+func TestTranslateCondition(t *testing.T) {
+	tests := []struct {
+		where string
+		query string
+		local bool
+		err   error
+	}{
+		{likeName, `name match "a"`, false, nil},
+		{eqSize, "size = 5", false, nil},
+		{`<D:is-collection/>`, `type = "folder"`, false, nil},
+		{modifiedTime, "", true, nil},
+		{`<D:and>` + likeName + eqSize + `</D:and>`, `(name match "a") and (size = 5)`, false, nil},
+		{`<D:or>` + likeName + eqSize + `</D:or>`, `(name match "a") or (size = 5)`, false, nil},
+		// and 只把能查询的条件交给阿里云盘
+		{`<D:and>` + modifiedTime + likeName + `</D:and>`, `(name match "a")`, true, nil},
+		// or 中有本地条件时只能在本地过滤，后面的条件也要转换
+		{`<D:or>` + modifiedTime + likeName + `</D:or>`, "", true, nil},
+		{`<D:or>` + modifiedTime + badSize + `</D:or>`, "", false, errInvalidSearch},
+		{`<D:or></D:or>`, "", false, errInvalidSearch},
+		{`<D:not>` + likeName + `</D:not>`, "", false, errUnsupportedSearch},
+	}
+
+	for _, tt := range tests {
+		var n searchNode
+		if err := xml.Unmarshal([]byte(`<D:where xmlns:D="DAV:">`+tt.where+`</D:where>`), &n); err != nil {
+			t.Fatal(err)
+		}
+
+		cond, err := translateCondition(&n.Children[0])
+		if err != tt.err {
+			t.Errorf("%s: error %v, expected %v", tt.where, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if cond.query != tt.query || cond.local != tt.local {
+			t.Errorf("%s: query %q local %v, expected %q %v", tt.where, cond.query, cond.local, tt.query, tt.local)
+		}
+	}
+}
@@ -0,0 +1,579 @@
+package webdav
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"html/template"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSharesFile      = "shares.json"
+	defaultShareSecretFile = "share_secret"
+
+	// ShareAdminPrefix 分享管理接口路径，需要 Basic Auth
+	ShareAdminPrefix = "/_admin/shares"
+
+	// defaultShareExpiry 未指定有效期时的默认值
+	defaultShareExpiry = 7 * 24 * time.Hour
+
+	// shareFetchedExpiry 断点续传记录的保存时间，之后从中间开始的请求重新计数
+	shareFetchedExpiry = 24 * time.Hour
+
+	ctxShareValue = "Share"
+)
+
+var (
+	errShareInvalid = errors.New("webdav: invalid share link")
+	errShareExpired = errors.New("webdav: share link expired or download limit reached")
+)
+
+// share 分享链接，签名包含 id、路径和过期时间
+type share struct {
+	Id           string    `json:"id"`
+	Path         string    `json:"path"`
+	IsDir        bool      `json:"is_dir"`
+	Creator      string    `json:"creator,omitempty"` // 通过分享链接访问时以创建者的权限读取
+	Expires      time.Time `json:"expires"`
+	Password     string    `json:"password,omitempty"` // 密码的 HMAC，不保存明文
+	MaxDownloads int       `json:"max_downloads,omitempty"`
+	Downloads    int       `json:"downloads"`
+	Created      time.Time `json:"created"`
+}
+
+func (s *share) available() bool {
+	return time.Now().Before(s.Expires) &&
+		(s.MaxDownloads <= 0 || s.Downloads < s.MaxDownloads)
+}
+
+// shareView 管理接口返回的分享信息
+type shareView struct {
+	Id           string    `json:"id"`
+	Path         string    `json:"path"`
+	IsDir        bool      `json:"is_dir"`
+	Creator      string    `json:"creator,omitempty"`
+	URL          string    `json:"url"`
+	Expires      time.Time `json:"expires"`
+	HasPassword  bool      `json:"has_password"`
+	MaxDownloads int       `json:"max_downloads,omitempty"`
+	Downloads    int       `json:"downloads"`
+	Revoked      bool      `json:"revoked,omitempty"`
+	Created      time.Time `json:"created"`
+}
+
+type shareRequest struct {
+	Path         string `json:"path"`
+	ExpiresIn    int64  `json:"expires_in"` // 有效期，单位秒
+	Password     string `json:"password"`
+	MaxDownloads int    `json:"max_downloads"`
+}
+
+// shareContext 通过分享链接访问时保存在请求 ctx 中，目录页面据此生成链接
+type shareContext struct {
+	root  string
+	query string
+}
+
+func shareFromContext(ctx context.Context) *shareContext {
+	sc, _ := ctx.Value(ctxShareValue).(*shareContext)
+	return sc
+}
+
+// Shares 带签名和有效期的分享链接，通过分享链接访问时不需要 Basic Auth
+type Shares struct {
+	// AllowPassword 限制每个客户端输入分享密码的频率，为空时不限制
+	AllowPassword func(key string) bool
+
+	mu      sync.Mutex
+	handler *Handler
+	path    string
+	secret  []byte
+	items   map[string]*share
+
+	// fetched 已经计数的下载，key 为分享、客户端地址、路径和文件版本，断点续传时不重复计数
+	fetched map[string]time.Time
+}
+
+// NewShares 创建分享管理，签名密钥和分享记录保存在 workDir 下
+func NewShares(handler *Handler, workDir string) (*Shares, error) {
+	s := &Shares{
+		handler: handler,
+		items:   make(map[string]*share),
+		fetched: make(map[string]time.Time),
+	}
+
+	if workDir == "" {
+		s.secret = make([]byte, 32)
+		_, err := rand.Read(s.secret)
+		return s, err
+	}
+
+	secret, err := loadSecret(filepath.Join(workDir, defaultShareSecretFile))
+	if err != nil {
+		return nil, err
+	}
+	s.secret = secret
+
+	s.path = filepath.Join(workDir, defaultSharesFile)
+
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Warnf("read shares file %s error %s", s.path, err)
+		}
+		return s, nil
+	}
+
+	if err := json.Unmarshal(content, &s.items); err != nil {
+		logrus.Warnf("parse shares file %s error %s", s.path, err)
+		s.items = make(map[string]*share)
+	}
+	s.prune()
+
+	return s, nil
+}
+
+// loadSecret 读取签名密钥，不存在时生成新的密钥
+func loadSecret(file string) ([]byte, error) {
+	content, err := ioutil.ReadFile(file)
+	if err == nil && len(content) > 0 {
+		return content, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(file, secret, 0600); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+func (s *Shares) sign(parts ...string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(strings.Join(parts, "\n")))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Shares) signature(sh *share) string {
+	return s.sign("share", sh.Id, sh.Path, strconv.FormatInt(sh.Expires.Unix(), 10))
+}
+
+// query 返回分享链接的查询参数
+func (s *Shares) query(sh *share) string {
+	return url.Values{
+		"share":   {sh.Id},
+		"expires": {strconv.FormatInt(sh.Expires.Unix(), 10)},
+		"sig":     {s.signature(sh)},
+	}.Encode()
+}
+
+func (s *Shares) view(r *http.Request, sh *share) *shareView {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+
+	return &shareView{
+		Id:           sh.Id,
+		Path:         sh.Path,
+		IsDir:        sh.IsDir,
+		Creator:      sh.Creator,
+		URL:          scheme + "://" + r.Host + s.handler.link(sh.Path, sh.IsDir) + "?" + s.query(sh),
+		Expires:      sh.Expires,
+		HasPassword:  sh.Password != "",
+		MaxDownloads: sh.MaxDownloads,
+		Downloads:    sh.Downloads,
+		Created:      sh.Created,
+	}
+}
+
+// MatchAdmin 判断是否为分享管理接口
+func (s *Shares) MatchAdmin(r *http.Request) bool {
+	return r.URL.Path == ShareAdminPrefix || strings.HasPrefix(r.URL.Path, ShareAdminPrefix+"/")
+}
+
+// ServeAdmin 分享管理接口：GET 列出、POST 创建、DELETE 撤销
+func (s *Shares) ServeAdmin(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, ShareAdminPrefix), "/")
+
+	switch {
+	case id == "" && r.Method == "GET":
+		s.mu.Lock()
+		s.prune()
+		views := make([]*shareView, 0, len(s.items))
+		for _, sh := range s.items {
+			views = append(views, s.view(r, sh))
+		}
+		s.mu.Unlock()
+
+		sort.Slice(views, func(i, j int) bool {
+			return views[i].Created.Before(views[j].Created)
+		})
+
+		writeJSON(w, views)
+	case id == "" && r.Method == "POST":
+		s.create(w, r)
+	case id != "" && (r.Method == "GET" || r.Method == "DELETE"):
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		sh, ok := s.items[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		view := s.view(r, sh)
+
+		// 撤销的分享直接删除，之后的访问视为无效链接
+		if r.Method == "DELETE" {
+			delete(s.items, id)
+			if err := s.save(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			view.Revoked = true
+		}
+
+		writeJSON(w, view)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Shares) create(w http.ResponseWriter, r *http.Request) {
+	var req shareRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxProppatchBody)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p := path.Clean("/" + req.Path)
+
+	// 分享后任何人都可以读取，创建者必须能够读取路径及其下的全部文件
+	if s.handler.Authorizer != nil {
+		if err := s.handler.check(r.Context(), false, p, true); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	fi, err := s.handler.FileSystem.Stat(r.Context(), p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	expiry := defaultShareExpiry
+	if req.ExpiresIn > 0 {
+		expiry = time.Duration(req.ExpiresIn) * time.Second
+	}
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	sh := &share{
+		Id:           hex.EncodeToString(idBytes),
+		Path:         p,
+		IsDir:        fi.IsDir(),
+		Creator:      userOf(r.Context()),
+		Expires:      now.Add(expiry).Truncate(time.Second),
+		MaxDownloads: req.MaxDownloads,
+		Created:      now,
+	}
+	if req.Password != "" {
+		sh.Password = s.sign("password", sh.Id, req.Password)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items[sh.Id] = sh
+	if err := s.save(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	content, err := json.Marshal(s.view(r, sh))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(content)
+}
+
+// Match 判断是否为分享链接的访问请求
+func (s *Shares) Match(r *http.Request) bool {
+	switch r.Method {
+	case "GET", "HEAD", "POST":
+		return r.URL.Query().Get("share") != ""
+	}
+	return false
+}
+
+// ServeShare 校验签名、有效期、密码和下载次数后，以创建者的身份只读转发给 Handler
+func (s *Shares) ServeShare(w http.ResponseWriter, r *http.Request) {
+	reqPath, status, err := s.handler.stripPrefix(r.URL.Path)
+	if err != nil {
+		w.WriteHeader(status)
+		return
+	}
+	reqPath = path.Clean("/" + reqPath)
+
+	sh, err := s.verify(r.URL.Query(), reqPath)
+	if err != nil {
+		logrus.Warnf("share %s access denied, %s, ip: %s", r.URL.Query().Get("share"), err, r.RemoteAddr)
+
+		if err == errShareExpired {
+			http.Error(w, err.Error(), http.StatusGone)
+		} else {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		}
+		return
+	}
+
+	if sh.Password != "" && !s.authorized(w, r, sh) {
+		return
+	}
+
+	if r.Method == "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), CtxSizeValue, int64(0))
+	ctx = context.WithValue(ctx, CtxUserValue, sh.Creator)
+
+	fi, err := s.handler.FileSystem.Stat(ctx, reqPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	query.Del("search")
+
+	// 下载文件或打包下载目录时计数，同一客户端断点续传同一版本的文件不重复计数
+	if r.Method == "GET" && (!fi.IsDir() || query.Get("archive") != "") {
+		key := strings.Join([]string{
+			sh.Id, clientAddr(r), reqPath, query.Get("archive"),
+			strconv.FormatInt(fi.ModTime().UnixNano(), 36), strconv.FormatInt(fi.Size(), 36),
+		}, "\n")
+
+		if !s.download(sh.Id, key, isResumed(r)) {
+			http.Error(w, errShareExpired.Error(), http.StatusGone)
+			return
+		}
+	}
+
+	ctx = context.WithValue(ctx, ctxShareValue, &shareContext{
+		root:  sh.Path,
+		query: s.query(sh),
+	})
+
+	r = r.WithContext(ctx)
+	r.URL.RawQuery = query.Encode()
+
+	s.handler.ServeHTTP(w, r)
+}
+
+func (s *Shares) verify(query url.Values, reqPath string) (*share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sh, ok := s.items[query.Get("share")]
+	if !ok {
+		return nil, errShareInvalid
+	}
+
+	if !hmac.Equal([]byte(query.Get("sig")), []byte(s.signature(sh))) ||
+		query.Get("expires") != strconv.FormatInt(sh.Expires.Unix(), 10) {
+		return nil, errShareInvalid
+	}
+
+	if !sh.available() {
+		return nil, errShareExpired
+	}
+
+	if reqPath != sh.Path && !(sh.IsDir && inCollection(sh.Path, reqPath, true)) {
+		return nil, errShareInvalid
+	}
+
+	return sh, nil
+}
+
+// authorized 校验分享密码，通过后写入 Cookie，之后的访问不需要再次输入
+func (s *Shares) authorized(w http.ResponseWriter, r *http.Request, sh *share) bool {
+	name := "share_" + sh.Id
+	token := s.sign("cookie", sh.Id, sh.Password)
+
+	if c, err := r.Cookie(name); err == nil && hmac.Equal([]byte(c.Value), []byte(token)) {
+		return true
+	}
+
+	if r.Method == "POST" {
+		if s.AllowPassword != nil && !s.AllowPassword(sh.Id+"\n"+clientAddr(r)) {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "webdav: too many password attempts", http.StatusTooManyRequests)
+			return false
+		}
+
+		password := r.PostFormValue("password")
+		if hmac.Equal([]byte(s.sign("password", sh.Id, password)), []byte(sh.Password)) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     name,
+				Value:    token,
+				Path:     "/",
+				Expires:  sh.Expires,
+				HttpOnly: true,
+			})
+			http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+			return false
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusUnauthorized)
+	if r.Method != "HEAD" {
+		_ = sharePasswordTemplate.Execute(w, r.Method == "POST")
+	}
+
+	return false
+}
+
+// download 增加下载次数，超过限制时返回 false
+// 从中间开始的请求只有在同一 key 之前计数过时才视为断点续传
+func (s *Shares) download(id, key string, resumed bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, t := range s.fetched {
+		if now.Sub(t) > shareFetchedExpiry {
+			delete(s.fetched, k)
+		}
+	}
+
+	if _, ok := s.fetched[key]; ok && resumed {
+		s.fetched[key] = now
+		return true
+	}
+
+	sh, ok := s.items[id]
+	if !ok || !sh.available() {
+		return false
+	}
+
+	s.fetched[key] = now
+	sh.Downloads++
+	if err := s.save(); err != nil {
+		logrus.Warnf("save shares file error %s", err)
+	}
+
+	return true
+}
+
+// isResumed 判断是否为从中间开始的请求
+func isResumed(r *http.Request) bool {
+	rangeReq := r.Header.Get("Range")
+	return rangeReq != "" && !strings.HasPrefix(rangeReq, "bytes=0-")
+}
+
+// clientAddr 返回连接的对端地址，不包含端口
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// prune 删除已经过期的分享
+func (s *Shares) prune() {
+	now := time.Now()
+	for id, sh := range s.items {
+		if !now.Before(sh.Expires) {
+			delete(s.items, id)
+		}
+	}
+}
+
+func (s *Shares) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	s.prune()
+
+	content, err := json.Marshal(s.items)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		logrus.Warnf("write shares file error %s", err)
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+var sharePasswordTemplate = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>aliyundrive-webdav</title>
+</head>
+<body style="font-family: sans-serif; margin: 2em;">
+<form method="post">
+<p>{{if .}}密码错误，请重新输入{{else}}请输入分享密码{{end}}</p>
+<input type="password" name="password" autofocus required>
+<button type="submit">确定</button>
+</form>
+</body>
+</html>
+`))
@@ -0,0 +1,264 @@
+package webdav
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createShare 通过管理接口创建分享，返回状态码和分享链接
+func createShare(t *testing.T, s *Shares, user, body string) (int, string) {
+	r := withUser(httptest.NewRequest(http.MethodPost, ShareAdminPrefix, strings.NewReader(body)), user)
+	w := httptest.NewRecorder()
+	s.ServeAdmin(w, r)
+
+	if w.Code != http.StatusCreated {
+		return w.Code, ""
+	}
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Fatalf("Content-Type %q", ct)
+	}
+
+	var view shareView
+	if err := json.Unmarshal(w.Body.Bytes(), &view); err != nil {
+		t.Fatal(err)
+	}
+
+	return w.Code, view.URL
+}
+
+// getShare 通过分享链接访问，remote 为客户端地址
+func getShare(s *Shares, link, method, remote string, header map[string]string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, link, strings.NewReader(body))
+	r.RemoteAddr = remote
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeShare(w, r)
+
+	return w
+}
+
+func newTestShares(t *testing.T) *Shares {
+	s, err := NewShares(newTestHandler(t, map[string]string{
+		"/docs/a.txt":   "hello world",
+		"/secret/b.txt": "secret",
+	}), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestShareCreateAuthorized(t *testing.T) {
+	s := newTestShares(t)
+
+	for _, tt := range []struct {
+		user, path string
+		status     int
+	}{
+		{"bob", "/secret/b.txt", http.StatusForbidden},
+		{"bob", "/", http.StatusForbidden}, // 分享上级目录会暴露 /secret
+		{"bob", "/docs/a.txt", http.StatusCreated},
+		{"alice", "/secret/b.txt", http.StatusCreated},
+		{"alice", "/missing", http.StatusNotFound},
+	} {
+		status, _ := createShare(t, s, tt.user, `{"path": "`+tt.path+`"}`)
+		if status != tt.status {
+			t.Errorf("%s shares %s: status %d, expected %d", tt.user, tt.path, status, tt.status)
+		}
+	}
+}
+
+func TestShareSignature(t *testing.T) {
+	s := newTestShares(t)
+
+	_, link := createShare(t, s, "alice", `{"path": "/docs"}`)
+
+	if w := getShare(s, link, "GET", "10.0.0.1:1000", nil, ""); w.Code != http.StatusOK {
+		t.Fatalf("directory share: status %d", w.Code)
+	}
+
+	u, _ := url.Parse(link)
+	u.Path = "/docs/a.txt"
+	if w := getShare(s, u.String(), "GET", "10.0.0.1:1000", nil, ""); w.Code != http.StatusOK || w.Body.String() != "hello world" {
+		t.Fatalf("file in shared directory: status %d, body %q", w.Code, w.Body.String())
+	}
+
+	// 分享目录以外的路径
+	u.Path = "/secret/b.txt"
+	if w := getShare(s, u.String(), "GET", "10.0.0.1:1000", nil, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("path outside share: status %d", w.Code)
+	}
+
+	// 修改有效期后签名不匹配
+	u.Path = "/docs/a.txt"
+	query := u.Query()
+	query.Set("expires", "9999999999")
+	u.RawQuery = query.Encode()
+	if w := getShare(s, u.String(), "GET", "10.0.0.1:1000", nil, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("tampered expiry: status %d", w.Code)
+	}
+}
+
+func TestShareRevoke(t *testing.T) {
+	s := newTestShares(t)
+
+	_, link := createShare(t, s, "alice", `{"path": "/docs/a.txt"}`)
+	u, _ := url.Parse(link)
+
+	r := httptest.NewRequest(http.MethodDelete, ShareAdminPrefix+"/"+u.Query().Get("share"), nil)
+	w := httptest.NewRecorder()
+	s.ServeAdmin(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("revoke: status %d", w.Code)
+	}
+
+	// 撤销的分享记录已经删除
+	if w := getShare(s, link, "GET", "10.0.0.1:1000", nil, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("revoked share: status %d", w.Code)
+	}
+	if len(s.items) != 0 {
+		t.Fatalf("revoked share kept: %d shares", len(s.items))
+	}
+}
+
+func TestSharePrune(t *testing.T) {
+	s := newTestShares(t)
+
+	_, link := createShare(t, s, "alice", `{"path": "/docs/a.txt"}`)
+	createShare(t, s, "alice", `{"path": "/docs"}`)
+
+	u, _ := url.Parse(link)
+	s.items[u.Query().Get("share")].Expires = time.Now().Add(-time.Second)
+
+	r := httptest.NewRequest(http.MethodGet, ShareAdminPrefix, nil)
+	w := httptest.NewRecorder()
+	s.ServeAdmin(w, r)
+
+	var views []*shareView
+	if err := json.Unmarshal(w.Body.Bytes(), &views); err != nil {
+		t.Fatal(err)
+	}
+	if len(views) != 1 || views[0].Path != "/docs" || len(s.items) != 1 {
+		t.Fatalf("expired share listed: %s", w.Body.String())
+	}
+}
+
+func TestShareCreator(t *testing.T) {
+	s := newTestShares(t)
+
+	// 只有创建者可以读取的文件，通过分享链接访问时以创建者的权限读取
+	_, link := createShare(t, s, "alice", `{"path": "/secret"}`)
+
+	u, _ := url.Parse(link)
+	u.Path = "/secret/b.txt"
+	if w := getShare(s, u.String(), "GET", "10.0.0.1:1000", nil, ""); w.Code != http.StatusOK || w.Body.String() != "secret" {
+		t.Fatalf("shared by alice: status %d, body %q", w.Code, w.Body.String())
+	}
+
+	// 分享保存的是创建者，不是访问者提供的用户
+	sh := s.items[u.Query().Get("share")]
+	sh.Creator = "bob"
+	if w := getShare(s, u.String(), "GET", "10.0.0.1:1000", nil, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("creator lost access: status %d", w.Code)
+	}
+}
+
+func TestShareListing(t *testing.T) {
+	s := newTestShares(t)
+
+	_, link := createShare(t, s, "alice", `{"path": "/docs"}`)
+
+	w := getShare(s, link, "GET", "10.0.0.1:1000", map[string]string{"Accept": "application/json"}, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("listing: status %d", w.Code)
+	}
+
+	var page browserPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+
+	// 分享页面的路径相对于分享目录
+	if page.Path != "/" || len(page.Entries) != 1 || page.Entries[0].Path != "/a.txt" {
+		t.Fatalf("listing: %s", w.Body.String())
+	}
+}
+
+func TestShareDownloadLimit(t *testing.T) {
+	s := newTestShares(t)
+
+	_, link := createShare(t, s, "alice", `{"path": "/docs/a.txt", "max_downloads": 2}`)
+
+	if w := getShare(s, link, "GET", "10.0.0.1:1000", nil, ""); w.Code != http.StatusOK {
+		t.Fatalf("first download: status %d", w.Code)
+	}
+
+	// 同一客户端断点续传不重复计数
+	resume := map[string]string{"Range": "bytes=6-"}
+	if w := getShare(s, link, "GET", "10.0.0.1:2000", resume, ""); w.Code != http.StatusPartialContent {
+		t.Fatalf("resumed download: status %d", w.Code)
+	}
+
+	// 其他客户端从中间开始下载需要计数
+	if w := getShare(s, link, "GET", "10.0.0.2:1000", resume, ""); w.Code != http.StatusPartialContent {
+		t.Fatalf("ranged download: status %d", w.Code)
+	}
+
+	if w := getShare(s, link, "GET", "10.0.0.1:1000", resume, ""); w.Code != http.StatusGone {
+		t.Fatalf("download over limit: status %d", w.Code)
+	}
+	if w := getShare(s, link, "GET", "10.0.0.3:1000", nil, ""); w.Code != http.StatusGone {
+		t.Fatalf("download over limit: status %d", w.Code)
+	}
+}
+
+func TestSharePassword(t *testing.T) {
+	s := newTestShares(t)
+
+	attempts := 0
+	s.AllowPassword = func(key string) bool {
+		attempts++
+		return attempts <= 2
+	}
+
+	_, link := createShare(t, s, "alice", `{"path": "/docs/a.txt", "password": "pw"}`)
+
+	if w := getShare(s, link, "GET", "10.0.0.1:1000", nil, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("without password: status %d", w.Code)
+	}
+
+	form := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+
+	if w := getShare(s, link, "POST", "10.0.0.1:1000", form, "password=wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: status %d", w.Code)
+	}
+
+	w := getShare(s, link, "POST", "10.0.0.1:1000", form, "password=pw")
+	if w.Code != http.StatusSeeOther || len(w.Result().Cookies()) != 1 {
+		t.Fatalf("correct password: status %d", w.Code)
+	}
+	cookie := w.Result().Cookies()[0]
+
+	// 超过尝试次数后即使密码正确也拒绝
+	if w := getShare(s, link, "POST", "10.0.0.1:1000", form, "password=pw"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("too many attempts: status %d", w.Code)
+	}
+
+	r := httptest.NewRequest("GET", link, nil)
+	r.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	s.ServeShare(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "hello world" {
+		t.Fatalf("with cookie: status %d", w.Code)
+	}
+}
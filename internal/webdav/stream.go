@@ -0,0 +1,261 @@
+package webdav
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EventStreamPath = "/events"
+
+	// streamHeartbeat 定时发送注释行，防止代理关闭空闲连接
+	streamHeartbeat = 30 * time.Second
+
+	// streamClientBuffer 客户端未读取的事件数，超过后断开连接，客户端重连时通过 Last-Event-ID 补发
+	streamClientBuffer = 256
+)
+
+type streamEntry struct {
+	id    uint64
+	event *Event
+	data  []byte
+}
+
+type streamClient struct {
+	ch      chan *streamEntry
+	scopes  []string
+	dropped bool
+}
+
+// EventStream 通过 Server-Sent Events 推送文件变更事件，最近的事件保存在环形缓冲区中用于断线重连后补发
+type EventStream struct {
+	mu      sync.Mutex
+	ring    []*streamEntry
+	next    int // 下一个写入 ring 的位置
+	lastId  uint64
+	clients map[*streamClient]bool
+
+	// scopes 返回用户可见的路径前缀，未限制的用户可以收到全部事件
+	scopes ScopesFunc
+}
+
+// ScopesFunc 返回用户可见的路径前缀，第二个返回值为 false 时不限制
+type ScopesFunc func(user string) ([]string, bool)
+
+// NewEventStream 创建事件流，size 为缓冲的事件数，scopes 为空时全部用户都不限制
+func NewEventStream(size int, scopes ScopesFunc) *EventStream {
+	if size <= 0 {
+		size = 1
+	}
+
+	return &EventStream{
+		ring:    make([]*streamEntry, size),
+		clients: make(map[*streamClient]bool),
+		scopes:  scopes,
+	}
+}
+
+// Listen 作为 EventListener 订阅文件系统的变更事件
+func (s *EventStream) Listen(event *Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		logrus.Errorf("marshal event error %s", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastId++
+	entry := &streamEntry{id: s.lastId, event: event, data: data}
+
+	s.ring[s.next] = entry
+	s.next = (s.next + 1) % len(s.ring)
+
+	for c := range s.clients {
+		if !c.match(event) {
+			continue
+		}
+
+		select {
+		case c.ch <- entry:
+		default:
+			c.dropped = true
+			close(c.ch)
+			delete(s.clients, c)
+		}
+	}
+}
+
+// Close 断开全部客户端，服务器关闭时调用，否则 Shutdown 会一直等待事件流结束
+func (s *EventStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.clients {
+		c.dropped = true
+		close(c.ch)
+		delete(s.clients, c)
+	}
+}
+
+// Match 事件流只处理 GET /events 且 Accept 为 text/event-stream 的请求，同名文件仍可以正常访问
+func (s *EventStream) Match(r *http.Request) bool {
+	return r.Method == http.MethodGet && r.URL.Path == EventStreamPath &&
+		strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// ServeHTTP 推送事件，可以用 prefix 参数只订阅指定路径下的事件
+func (s *EventStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	scopes, ok := s.clientScopes(userOf(r.Context()), r.URL.Query()["prefix"])
+	if !ok {
+		http.Error(w, "prefix is out of scope", http.StatusForbidden)
+		return
+	}
+
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("lastEventId")
+	}
+
+	var since uint64
+	if lastEventId != "" {
+		var err error
+		if since, err = strconv.ParseUint(lastEventId, 10, 64); err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	c := &streamClient{ch: make(chan *streamEntry, streamClientBuffer), scopes: scopes}
+	replay := s.subscribe(c, since, lastEventId != "")
+	defer s.unsubscribe(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for _, entry := range replay {
+		if err := writeStreamEntry(w, entry); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case entry, ok := <-c.ch:
+			if !ok {
+				return
+			}
+			if err := writeStreamEntry(w, entry); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+
+		flusher.Flush()
+	}
+}
+
+// subscribe 注册客户端并返回需要补发的事件，在同一把锁内完成，避免遗漏或重复
+func (s *EventStream) subscribe(c *streamClient, since uint64, resume bool) []*streamEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clients[c] = true
+
+	if !resume {
+		return nil
+	}
+
+	replay := make([]*streamEntry, 0)
+	for i := 0; i < len(s.ring); i++ {
+		entry := s.ring[(s.next+i)%len(s.ring)]
+		if entry != nil && entry.id > since && c.match(entry.event) {
+			replay = append(replay, entry)
+		}
+	}
+
+	return replay
+}
+
+func (s *EventStream) unsubscribe(c *streamClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !c.dropped {
+		delete(s.clients, c)
+	}
+}
+
+// clientScopes 返回客户端订阅的路径前缀，请求的前缀必须在用户可见范围内
+func (s *EventStream) clientScopes(user string, prefixes []string) ([]string, bool) {
+	var allowed []string
+	var limited bool
+	if s.scopes != nil {
+		allowed, limited = s.scopes(user)
+	}
+
+	if len(prefixes) == 0 {
+		return allowed, true
+	}
+
+	scopes := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		prefix = "/" + strings.Trim(prefix, "/")
+
+		if limited && !inScopes(prefix, allowed) {
+			return nil, false
+		}
+
+		scopes = append(scopes, prefix)
+	}
+
+	return scopes, true
+}
+
+// match 事件的源路径或目标路径在订阅范围内
+func (c *streamClient) match(event *Event) bool {
+	if len(c.scopes) == 0 {
+		return true
+	}
+
+	return inScopes(event.Path, c.scopes) || (event.Destination != "" && inScopes(event.Destination, c.scopes))
+}
+
+func inScopes(p string, scopes []string) bool {
+	for _, scope := range scopes {
+		if scope == "/" || p == scope || strings.HasPrefix(p, scope+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeStreamEntry(w http.ResponseWriter, entry *streamEntry) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", entry.id, entry.event.Type, entry.data)
+	return err
+}
@@ -0,0 +1,188 @@
+package webdav
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testScopes bob 只能收到 /docs 下的事件
+func testScopes(user string) ([]string, bool) {
+	if user == "bob" {
+		return []string{"/docs"}, true
+	}
+	return nil, false
+}
+
+func TestInScopes(t *testing.T) {
+	for _, tt := range []struct {
+		p      string
+		scopes []string
+		in     bool
+	}{
+		{"/docs", []string{"/docs"}, true},
+		{"/docs/a.txt", []string{"/docs"}, true},
+		{"/docs2/a.txt", []string{"/docs"}, false},
+		{"/a.txt", []string{"/docs", "/"}, true},
+		{"/a.txt", nil, false},
+	} {
+		if in := inScopes(tt.p, tt.scopes); in != tt.in {
+			t.Errorf("inScopes(%s, %v) = %v", tt.p, tt.scopes, in)
+		}
+	}
+}
+
+func TestClientScopes(t *testing.T) {
+	s := NewEventStream(8, testScopes)
+
+	for _, tt := range []struct {
+		user     string
+		prefixes []string
+		scopes   []string
+		ok       bool
+	}{
+		{"alice", nil, nil, true},
+		{"alice", []string{"photos/"}, []string{"/photos"}, true},
+		{"bob", nil, []string{"/docs"}, true},
+		{"bob", []string{"/docs/work"}, []string{"/docs/work"}, true},
+		{"bob", []string{"/photos"}, nil, false},
+	} {
+		scopes, ok := s.clientScopes(tt.user, tt.prefixes)
+		if ok != tt.ok || strings.Join(scopes, ",") != strings.Join(tt.scopes, ",") {
+			t.Errorf("clientScopes(%s, %v) = %v, %v", tt.user, tt.prefixes, scopes, ok)
+		}
+	}
+}
+
+func TestEventStreamMatch(t *testing.T) {
+	s := NewEventStream(8, nil)
+
+	for _, tt := range []struct {
+		method, target, accept string
+		match                  bool
+	}{
+		{"GET", "/events", "text/event-stream", true},
+		{"GET", "/events", "*/*", false},
+		{"PUT", "/events", "text/event-stream", false},
+		{"GET", "/events/a.txt", "text/event-stream", false},
+	} {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		r.Header.Set("Accept", tt.accept)
+		if match := s.Match(r); match != tt.match {
+			t.Errorf("%s %s %s: match %v", tt.method, tt.target, tt.accept, match)
+		}
+	}
+}
+
+// openStream 以 user 身份连接事件流，返回读取 id 和 event 行的函数
+func openStream(t *testing.T, url, user string, header map[string]string) (*http.Response, func() string) {
+	r, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Accept", "text/event-stream")
+	r.Header.Set("X-User", user)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+
+	reader := bufio.NewReader(resp.Body)
+
+	return resp, func() string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return strings.Join(lines, " ")
+			}
+			line = strings.TrimSpace(line)
+			if line == "" {
+				return strings.Join(lines, " ")
+			}
+			if strings.HasPrefix(line, "id:") || strings.HasPrefix(line, "event:") {
+				lines = append(lines, line)
+			}
+		}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	s := NewEventStream(2, testScopes)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), CtxUserValue, r.Header.Get("X-User"))))
+	}))
+	defer server.Close()
+
+	s.Listen(&Event{Type: EventUpload, Path: "/a.txt"})
+	s.Listen(&Event{Type: EventMkdir, Path: "/docs/dir"})
+	s.Listen(&Event{Type: EventMove, Path: "/b.txt", Destination: "/docs/b.txt"})
+
+	// 缓冲区只保留最近两个事件
+	_, next := openStream(t, server.URL+EventStreamPath, "alice", map[string]string{"Last-Event-ID": "0"})
+	for _, want := range []string{"id: 2 event: mkdir", "id: 3 event: move"} {
+		if got := next(); got != want {
+			t.Fatalf("replay %q, expected %q", got, want)
+		}
+	}
+
+	_, bob := openStream(t, server.URL+EventStreamPath+"?lastEventId=2", "bob", nil)
+	if got := bob(); got != "id: 3 event: move" {
+		t.Fatalf("bob replay %q", got)
+	}
+
+	// 等待两个客户端都完成订阅
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		n := len(s.clients)
+		s.mu.Unlock()
+		if n == 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.Listen(&Event{Type: EventDelete, Path: "/a.txt"})
+	s.Listen(&Event{Type: EventUpload, Path: "/docs/c.txt"})
+
+	if got := next(); got != "id: 4 event: delete" {
+		t.Fatalf("alice got %q", got)
+	}
+	if got := next(); got != "id: 5 event: upload" {
+		t.Fatalf("alice got %q", got)
+	}
+	// bob 收不到 /docs 以外的事件
+	if got := bob(); got != "id: 5 event: upload" {
+		t.Fatalf("bob got %q", got)
+	}
+
+	// 关闭后结束事件流
+	s.Close()
+	if got := next(); got != "" {
+		t.Fatalf("after close got %q", got)
+	}
+
+	for _, tt := range []struct {
+		user, target string
+		header       map[string]string
+		status       int
+	}{
+		{"bob", EventStreamPath + "?prefix=/photos", nil, http.StatusForbidden},
+		{"alice", EventStreamPath, map[string]string{"Last-Event-ID": "abc"}, http.StatusBadRequest},
+	} {
+		if resp, _ := openStream(t, server.URL+tt.target, tt.user, tt.header); resp.StatusCode != tt.status {
+			t.Errorf("%s %s: status %d, expected %d", tt.user, tt.target, resp.StatusCode, tt.status)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/jakeslee/aliyundrive"
+	"github.com/jakeslee/aliyundrive-webdav/internal/backend"
+	"github.com/jakeslee/aliyundrive/models"
+	"golang.org/x/net/webdav"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// syncClockSkew 轮询时向前多取的时间，避免本机和阿里云盘时间偏差漏掉变更
+const syncClockSkew = time.Minute
+
+var (
+	propResourceType  = xml.Name{Space: "DAV:", Local: "resourcetype"}
+	propDisplayName   = xml.Name{Space: "DAV:", Local: "displayname"}
+	propContentLength = xml.Name{Space: "DAV:", Local: "getcontentlength"}
+	propContentType   = xml.Name{Space: "DAV:", Local: "getcontenttype"}
+	propETag          = xml.Name{Space: "DAV:", Local: "getetag"}
+	propSyncToken     = xml.Name{Space: "DAV:", Local: "sync-token"}
+
+	errUnsupportedReport = errors.New("webdav: unsupported report")
+)
+
+// syncCollection 支持 sync-collection（RFC 6578）增量同步的文件系统
+type syncCollection interface {
+	SyncChanges(ctx context.Context, dir, token string, infinite bool) (changed map[string]os.FileInfo, deleted []string, newToken string, err error)
+	PropsOf(name string, fi os.FileInfo) map[xml.Name]webdav.Property
+}
+
+type syncCollectionRequest struct {
+	XMLName   xml.Name `xml:"DAV: sync-collection"`
+	SyncToken string   `xml:"DAV: sync-token"`
+	SyncLevel string   `xml:"DAV: sync-level"`
+	Prop      struct {
+		Names []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	} `xml:"DAV: prop"`
+}
+
+// SyncChanges 返回 token 之后 dir 下的变更，token 为空时返回全部成员
+// 变更来自本服务的修改记录，以及按 updated_at 查询阿里云盘的文件；
+// 通过其他客户端删除的文件无法发现
+func (a *aliDriveFS) SyncChanges(ctx context.Context, dir, token string, infinite bool) (map[string]os.FileInfo, []string, string, error) {
+	// 先生成新 token，处理期间的变更会在下次同步时再次返回
+	newToken := a.journal.Token()
+
+	var since time.Time
+	var journaled map[string]bool
+	var trees map[string]treeChange
+
+	if token != "" {
+		var err error
+		journaled, trees, since, err = a.journal.Since(token, dir, infinite)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		since = since.Add(-syncClockSkew)
+	}
+
+	dir = path.Clean("/" + dir)
+
+	fileId, _, err := a.backend.ResolvePathToFileId(dir)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	changed := make(map[string]os.FileInfo)
+
+	if _, ok := a.backend.(backend.Searcher); ok && infinite && token != "" {
+		// 增量同步用搜索接口查询变更，避免每次遍历整棵目录树
+		cond := &searchCondition{query: "updated_at > " + quoteDriveValue(since.UTC().Format(driveTimeLayout))}
+		if changed, err = a.Search(ctx, dir, cond, infiniteDepth, 0); err != nil {
+			return nil, nil, "", err
+		}
+	} else if err := a.listChanged(fileId, dir, since, infinite, changed); err != nil {
+		return nil, nil, "", err
+	}
+
+	// 移动的目录需要展开子路径：移入的目录返回全部子文件，移走的目录按移动后的目录列出原来的子路径
+	removed := make(map[string]bool)
+
+	for p, t := range trees {
+		root := p
+		if t.deleted {
+			root = t.target
+		}
+
+		treeId, _, err := a.backend.ResolvePathToFileId(root)
+		if err != nil {
+			if !t.deleted {
+				// 移入后又被删除或移走，由后续记录处理
+				continue
+			}
+			// 移走的目录已不存在，无法列出原来的子路径，需要重新全量同步
+			return nil, nil, "", errInvalidSyncToken
+		}
+
+		files := make(map[string]os.FileInfo)
+		if err := a.listChanged(treeId, p, time.Time{}, true, files); err != nil {
+			return nil, nil, "", err
+		}
+
+		for sub, fi := range files {
+			if t.deleted {
+				removed[sub] = true
+			} else {
+				changed[sub] = fi
+			}
+		}
+	}
+
+	for p, isDeleted := range journaled {
+		if isDeleted {
+			removed[p] = true
+		} else if _, ok := changed[p]; !ok {
+			fi, err := a.Stat(ctx, p)
+			if err == nil {
+				changed[p] = fi
+				continue
+			}
+			if !os.IsNotExist(err) {
+				return nil, nil, "", err
+			}
+			removed[p] = true
+		}
+	}
+
+	var deleted []string
+
+	for p := range removed {
+		if _, ok := changed[p]; !ok {
+			deleted = append(deleted, p)
+		}
+	}
+
+	sort.Strings(deleted)
+
+	return changed, deleted, newToken, nil
+}
+
+// listChanged 按 updated_at 倒序列出目录，只有一层时遇到早于 since 的文件即可停止
+func (a *aliDriveFS) listChanged(fileId, dir string, since time.Time, infinite bool, result map[string]os.FileInfo) error {
+	// 目录列表有缓存，先失效以获取最新数据
+	a.backend.EvictCacheWithPrefix(fileId + ":")
+
+	marker := ""
+
+	for {
+		files, err := a.backend.GetFolderFiles(&aliyundrive.FolderFilesOptions{
+			OrderBy:        "updated_at",
+			OrderDirection: models.OrderDirectionTypeDescend,
+			FolderFileId:   fileId,
+			Marker:         marker,
+		})
+		if err != nil {
+			return err
+		}
+
+		stop := false
+
+		for _, item := range files.Items {
+			p := path.Join(dir, item.Name)
+
+			if item.UpdatedAt.After(since) {
+				result[p] = a.meta.apply(NewAliFileInfo(item).(*aliFileInfo))
+			} else if !infinite {
+				stop = true
+				break
+			}
+
+			// 子目录的变更不会更新父目录的 updated_at，需要逐层检查
+			if infinite && item.Type == models.FileTypeFolder {
+				if err := a.listChanged(item.FileId, p, since, infinite, result); err != nil {
+					return err
+				}
+			}
+		}
+
+		if stop || files.NextMarker == "" {
+			return nil
+		}
+
+		marker = files.NextMarker
+	}
+}
+
+// PropsOf 返回文件的自定义属性和扩展属性，name 为文件路径
+func (a *aliDriveFS) PropsOf(name string, fi os.FileInfo) map[xml.Name]webdav.Property {
+	info, ok := fi.(*aliFileInfo)
+	if !ok {
+		return nil
+	}
+
+	return fileDeadProps(a.meta, a.quota, info, info.fileId == aliyundrive.DefaultRootFileId)
+}
+
+// handleReport 处理 REPORT 方法，目前只支持 sync-collection
+func (h *Handler) handleReport(w http.ResponseWriter, r *http.Request) (status int, err error) {
+	reqPath, status, err := h.stripPrefix(r.URL.Path)
+	if err != nil {
+		return status, err
+	}
+
+	sc, ok := h.FileSystem.(syncCollection)
+	if !ok {
+		return http.StatusNotImplemented, errUnsupportedReport
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxProppatchBody))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	var req syncCollectionRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		if _, ok := err.(xml.UnmarshalError); ok {
+			return http.StatusNotImplemented, errUnsupportedReport
+		}
+		return http.StatusBadRequest, err
+	}
+
+	ctx := r.Context()
+
+	fi, err := h.FileSystem.Stat(ctx, reqPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return http.StatusNotFound, err
+		}
+		return http.StatusInternalServerError, err
+	}
+	if !fi.IsDir() {
+		return http.StatusForbidden, nil
+	}
+
+	infinite := strings.TrimSpace(req.SyncLevel) == "infinite"
+
+	changed, deleted, token, err := sc.SyncChanges(ctx, reqPath, strings.TrimSpace(req.SyncToken), infinite)
+	if err == errInvalidSyncToken {
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		_, err = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><D:error xmlns:D="DAV:"><D:valid-sync-token/></D:error>`))
+		return 0, err
+	}
+	if err == errUnsupportedReport {
+		return http.StatusNotImplemented, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	names := make([]xml.Name, 0, len(req.Prop.Names))
+	for _, n := range req.Prop.Names {
+		names = append(names, n.XMLName)
+	}
+	if len(names) == 0 {
+		names = append(names, propETag)
+	}
+
+	paths := make([]string, 0, len(changed))
+	for p := range changed {
+		if h.readable(ctx, p) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	var b bytes.Buffer
+
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><D:multistatus xmlns:D="DAV:">`)
+
+	for _, p := range paths {
+		fi := changed[p]
+		h.writeResponse(&b, p, fi, sc.PropsOf(p, fi), names)
+	}
+
+	for _, p := range deleted {
+		if !h.readable(ctx, p) {
+			continue
+		}
+		fmt.Fprintf(&b, "<D:response><D:href>%s</D:href><D:status>HTTP/1.1 %d %s</D:status></D:response>",
+			h.href(p, false), http.StatusNotFound, webdav.StatusText(http.StatusNotFound))
+	}
+
+	fmt.Fprintf(&b, "<D:sync-token>%s</D:sync-token></D:multistatus>", escapeXML(token))
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(webdav.StatusMulti)
+	_, err = w.Write(b.Bytes())
+
+	return 0, err
+}
+
+// writeResponse 输出单个文件的 multistatus response 元素
+func (h *Handler) writeResponse(b *bytes.Buffer, p string, fi os.FileInfo, deadProps map[xml.Name]webdav.Property, names []xml.Name) {
+	found, missing := findProps(fi, deadProps, names)
+
+	fmt.Fprintf(b, "<D:response><D:href>%s</D:href>", h.href(p, fi.IsDir()))
+	if len(found) > 0 {
+		b.WriteString("<D:propstat><D:prop>")
+		for _, prop := range found {
+			writeProp(b, prop)
+		}
+		fmt.Fprintf(b, "</D:prop><D:status>HTTP/1.1 %d %s</D:status></D:propstat>", http.StatusOK, webdav.StatusText(http.StatusOK))
+	}
+	if len(missing) > 0 {
+		b.WriteString(propstatXML(http.StatusNotFound, missing, ""))
+	}
+	b.WriteString("</D:response>")
+}
+
+// href 返回用于 XML 的链接
+func (h *Handler) href(p string, dir bool) string {
+	return escapeXML(h.link(p, dir))
+}
+
+// findProps 查找属性值，与 webdav.Handler 的 PROPFIND 保持一致
+func findProps(fi os.FileInfo, deadProps map[xml.Name]webdav.Property, names []xml.Name) (found []webdav.Property, missing []xml.Name) {
+	for _, name := range names {
+		if p, ok := deadProps[name]; ok {
+			found = append(found, p)
+			continue
+		}
+
+		var value string
+		ok := true
+
+		switch {
+		case name == propResourceType:
+			if fi.IsDir() {
+				value = `<D:collection xmlns:D="DAV:"/>`
+			}
+		case name == propDisplayName:
+			value = escapeXML(fi.Name())
+		case name == propLastModified:
+			value = fi.ModTime().UTC().Format(http.TimeFormat)
+		case name == propContentLength && !fi.IsDir():
+			value = strconv.FormatInt(fi.Size(), 10)
+		case name == propContentType && !fi.IsDir():
+			value = escapeXML(contentType(fi))
+		case name == propETag && !fi.IsDir():
+			value = fmt.Sprintf(`"%x%x"`, fi.ModTime().UnixNano(), fi.Size())
+		default:
+			ok = false
+		}
+
+		if ok {
+			found = append(found, webdav.Property{XMLName: name, InnerXML: []byte(value)})
+		} else {
+			missing = append(missing, name)
+		}
+	}
+
+	return found, missing
+}
+
+// contentType 优先使用阿里云盘返回的类型，否则根据扩展名判断
+func contentType(fi os.FileInfo) string {
+	if info, ok := fi.(*aliFileInfo); ok && info.file != nil && info.file.ContentType != "" {
+		return info.file.ContentType
+	}
+
+	if ctype := mime.TypeByExtension(filepath.Ext(fi.Name())); ctype != "" {
+		return ctype
+	}
+
+	return "application/octet-stream"
+}
+
+func writeProp(b *bytes.Buffer, p webdav.Property) {
+	if p.XMLName.Space == "DAV:" {
+		fmt.Fprintf(b, "<D:%s>%s</D:%s>", p.XMLName.Local, p.InnerXML, p.XMLName.Local)
+		return
+	}
+
+	fmt.Fprintf(b, `<%s xmlns="%s">%s</%s>`, p.XMLName.Local, escapeXML(p.XMLName.Space), p.InnerXML, p.XMLName.Local)
+}
@@ -0,0 +1,338 @@
+package webdav
+
+import (
+	"context"
+	"github.com/jakeslee/aliyundrive"
+	"github.com/jakeslee/aliyundrive-webdav/internal/backend"
+	"github.com/jakeslee/aliyundrive-webdav/internal/metrics"
+	"github.com/jakeslee/aliyundrive/models"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/webdav"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TrashDir 回收站虚拟目录，列出阿里云盘回收站中的文件
+	TrashDir = "/.trash"
+
+	// trashCacheTTL 回收站列表缓存时间，PROPFIND 会对每个文件调用 Stat
+	trashCacheTTL = 10 * time.Second
+)
+
+// trashPath 判断路径是否在回收站中，返回回收站中的文件名，回收站本身返回空字符串
+func trashPath(name string) (string, bool) {
+	p := path.Clean("/" + name)
+	if p == TrashDir {
+		return "", true
+	}
+
+	if !strings.HasPrefix(p, TrashDir+"/") {
+		return "", false
+	}
+
+	return p[len(TrashDir)+1:], true
+}
+
+// trashCache 回收站列表缓存，文件名重复时加上 fileId 区分
+type trashCache struct {
+	mu      sync.Mutex
+	items   map[string]*models.File
+	names   []string
+	origins map[string]string
+	fetched time.Time
+}
+
+func (c *trashCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.fetched = time.Time{}
+	c.origins = nil
+}
+
+// origin 返回回收站中文件删除前的路径，列表刷新前复用结果，避免检查权限时逐个查询
+func (c *trashCache) origin(name string, resolve func() (string, error)) (string, error) {
+	c.mu.Lock()
+	p, ok := c.origins[name]
+	c.mu.Unlock()
+
+	if ok {
+		return p, nil
+	}
+
+	p, err := resolve()
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	if c.origins == nil {
+		c.origins = make(map[string]string)
+	}
+	c.origins[name] = p
+	c.mu.Unlock()
+
+	return p, nil
+}
+
+// recycleBin 后端不支持回收站时，回收站目录不存在
+func (a *aliDriveFS) recycleBin() (backend.RecycleBin, error) {
+	bin, ok := a.backend.(backend.RecycleBin)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return bin, nil
+}
+
+// trashItems 返回回收站中的文件，按删除顺序排列
+func (a *aliDriveFS) trashItems() ([]string, map[string]*models.File, error) {
+	bin, err := a.recycleBin()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := a.trash
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hit := time.Since(c.fetched) < trashCacheTTL
+	metrics.ObserveCache("trash", hit)
+
+	if hit {
+		return c.names, c.items, nil
+	}
+
+	items := make(map[string]*models.File)
+	var names []string
+	marker := ""
+
+	for {
+		resp, err := bin.ListRecycleBin(marker)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, item := range resp.Items {
+			name := item.Name
+			if _, ok := items[name]; ok {
+				ext := path.Ext(name)
+				if item.Type == models.FileTypeFolder {
+					ext = ""
+				}
+				name = strings.TrimSuffix(name, ext) + " (" + item.FileId + ")" + ext
+			}
+
+			items[name] = item
+			names = append(names, name)
+		}
+
+		if resp.NextMarker == "" {
+			break
+		}
+
+		marker = resp.NextMarker
+	}
+
+	c.items, c.names, c.origins, c.fetched = items, names, nil, time.Now()
+
+	return names, items, nil
+}
+
+func (a *aliDriveFS) trashItem(name string) (*models.File, error) {
+	_, items, err := a.trashItems()
+	if err != nil {
+		return nil, err
+	}
+
+	item, ok := items[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return item, nil
+}
+
+func trashRootInfo() *aliFileInfo {
+	return &aliFileInfo{
+		name:    path.Base(TrashDir),
+		mode:    os.ModeDir | os.ModePerm,
+		modTime: time.Now(),
+		virtual: true,
+	}
+}
+
+func newTrashFileInfo(name string, item *models.File) *aliFileInfo {
+	info := NewAliFileInfo(item).(*aliFileInfo)
+	info.name = name
+	info.virtual = true
+
+	return info
+}
+
+// statTrash 返回回收站或回收站中文件的信息，只支持回收站的第一层
+func (a *aliDriveFS) statTrash(name string) (*aliFileInfo, error) {
+	if name == "" {
+		if _, err := a.recycleBin(); err != nil {
+			return nil, err
+		}
+		return trashRootInfo(), nil
+	}
+
+	if strings.Contains(name, "/") {
+		return nil, os.ErrNotExist
+	}
+
+	item, err := a.trashItem(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTrashFileInfo(name, item), nil
+}
+
+// openTrash 打开回收站中的文件，只读
+func (a *aliDriveFS) openTrash(name string, flag int) (webdav.File, error) {
+	if flag&(os.O_CREATE|os.O_WRONLY|os.O_RDWR|os.O_TRUNC) != 0 {
+		return nil, os.ErrPermission
+	}
+
+	info, err := a.statTrash(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		return &trashDir{fs: a, info: info}, nil
+	}
+
+	return &aliFile{
+		n:        info,
+		backend:  a.backend,
+		meta:     a.meta,
+		quota:    a.quota,
+		events:   a.events,
+		fullPath: path.Join(TrashDir, name),
+	}, nil
+}
+
+// purgeTrash 彻底删除回收站中的文件
+func (a *aliDriveFS) purgeTrash(ctx context.Context, name string) error {
+	if name == "" || strings.Contains(name, "/") {
+		return os.ErrPermission
+	}
+
+	bin, err := a.recycleBin()
+	if err != nil {
+		return err
+	}
+
+	item, err := a.trashItem(name)
+	if err != nil {
+		return err
+	}
+
+	logrus.Warnf("purging %s: %s", item.FileId, name)
+
+	if err := bin.DeleteFile(item.FileId); err != nil {
+		return err
+	}
+
+	a.trash.Invalidate()
+	a.quota.Invalidate()
+	a.events.publish(&Event{
+		Type:   EventDelete,
+		Path:   path.Join(TrashDir, name),
+		FileId: item.FileId,
+		IsDir:  item.Type == models.FileTypeFolder,
+		User:   userOf(ctx),
+	})
+
+	return a.meta.Delete(item.FileId)
+}
+
+// restoreTrash 恢复回收站中的文件，目标路径和原位置不同时再移动过去
+func (a *aliDriveFS) restoreTrash(ctx context.Context, name, newName string) error {
+	if name == "" || strings.Contains(name, "/") {
+		return os.ErrPermission
+	}
+
+	bin, err := a.recycleBin()
+	if err != nil {
+		return err
+	}
+
+	item, err := a.trashItem(name)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("restoring %s: %s", item.FileId, name)
+
+	if err := bin.RestoreFile(item.FileId); err != nil {
+		return err
+	}
+
+	a.trash.Invalidate()
+	a.quota.Invalidate()
+	a.backend.EvictCacheWithPrefix(item.ParentFileId)
+
+	dir, err := a.pathOf(item.ParentFileId, map[string]string{aliyundrive.DefaultRootFileId: "/"})
+	if err != nil {
+		return err
+	}
+
+	restored := path.Join(dir, item.Name)
+
+	a.events.publish(&Event{
+		Type:        EventMove,
+		Path:        path.Join(TrashDir, name),
+		Destination: restored,
+		FileId:      item.FileId,
+		IsDir:       item.Type == models.FileTypeFolder,
+		User:        userOf(ctx),
+	})
+
+	if restored == path.Clean(newName) {
+		return nil
+	}
+
+	return a.Rename(ctx, restored, newName)
+}
+
+// trashDir 回收站根目录
+type trashDir struct {
+	fs   *aliDriveFS
+	info *aliFileInfo
+	pos  int
+}
+
+func (t *trashDir) Close() error { return nil }
+
+func (t *trashDir) Read(p []byte) (int, error) { return 0, os.ErrInvalid }
+
+func (t *trashDir) Write(p []byte) (int, error) { return 0, os.ErrPermission }
+
+func (t *trashDir) Seek(offset int64, whence int) (int64, error) { return 0, os.ErrInvalid }
+
+func (t *trashDir) Stat() (fs.FileInfo, error) { return t.info, nil }
+
+func (t *trashDir) Readdir(count int) ([]fs.FileInfo, error) {
+	names, items, err := t.fs.trashItems()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]fs.FileInfo, 0, len(names))
+	for _, name := range names {
+		infos = append(infos, newTrashFileInfo(name, items[name]))
+	}
+
+	return readdirSlice(infos, &t.pos, count)
+}
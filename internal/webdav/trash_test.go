@@ -0,0 +1,74 @@
+package webdav
+
+import (
+	"context"
+	"github.com/jakeslee/aliyundrive"
+	"os"
+	"testing"
+)
+
+func TestTrashPath(t *testing.T) {
+	tests := []struct {
+		name string
+		rel  string
+		ok   bool
+	}{
+		{"/.trash", "", true},
+		{"/.trash/", "", true},
+		{"/.trash/a.txt", "a.txt", true},
+		{"/.trash/dir/../a.txt", "a.txt", true},
+		{"/.trashed", "", false},
+		{"/dir/.trash/a.txt", "", false},
+		{"/", "", false},
+	}
+
+	for _, tt := range tests {
+		if rel, ok := trashPath(tt.name); rel != tt.rel || ok != tt.ok {
+			t.Errorf("trashPath(%q) = %q, %v, expected %q, %v", tt.name, rel, ok, tt.rel, tt.ok)
+		}
+	}
+}
+
+func TestReservedNames(t *testing.T) {
+	fs := newTestFS(t, false)
+	ctx := context.WithValue(context.Background(), CtxSizeValue, int64(0))
+
+	// 阿里云盘中已经存在和虚拟目录同名的文件
+	for _, name := range []string{".trash", ".versions", "docs"} {
+		if _, err := fs.backend.CreateDirectory(aliyundrive.DefaultRootFileId, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := fs.OpenFile(ctx, "/", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, fi := range infos {
+		names = append(names, fi.Name())
+	}
+	if len(names) != 1 || names[0] != "docs" {
+		t.Fatalf("root listing %v", names)
+	}
+
+	for _, tt := range []struct {
+		name string
+		err  error
+	}{
+		{"/.trash", os.ErrPermission},
+		{"/.versions", os.ErrPermission},
+		{"/docs/.trash", nil},
+	} {
+		if err := fs.Mkdir(ctx, tt.name, 0755); err != tt.err {
+			t.Errorf("Mkdir(%s) = %v, expected %v", tt.name, err, tt.err)
+		}
+	}
+}
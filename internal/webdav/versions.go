@@ -0,0 +1,564 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"github.com/jakeslee/aliyundrive"
+	"github.com/jakeslee/aliyundrive-webdav/internal/api"
+	"github.com/jakeslee/aliyundrive-webdav/internal/backend"
+	"github.com/jakeslee/aliyundrive-webdav/internal/metrics"
+	"github.com/jakeslee/aliyundrive/models"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/webdav"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// VersionsDir 历史版本虚拟目录，/.versions/<path>/ 列出文件的历史版本
+	VersionsDir = "/.versions"
+
+	// versionTimeLayout 历史版本文件名中的时间格式
+	versionTimeLayout = "20060102-150405"
+
+	// revisionCacheTTL 历史版本列表缓存时间，PROPFIND 会对每个版本调用 Stat
+	revisionCacheTTL = 10 * time.Second
+)
+
+var errRestoreDirectory = errors.New("webdav: only file versions can be restored")
+
+// versionRestorer 支持恢复历史版本的文件系统
+type versionRestorer interface {
+	IsVersionPath(name string) bool
+	RestoreVersion(ctx context.Context, name, dst string) error
+}
+
+// versionsPath 判断路径是否在历史版本目录中，返回对应的真实路径
+func versionsPath(name string) (string, bool) {
+	p := path.Clean("/" + name)
+	if p == VersionsDir {
+		return "/", true
+	}
+
+	if !strings.HasPrefix(p, VersionsDir+"/") {
+		return "", false
+	}
+
+	return p[len(VersionsDir):], true
+}
+
+// versionName 历史版本的文件名，以时间开头便于排序，保留原文件的扩展名
+func versionName(file *models.File, rev *api.Revision) string {
+	return rev.UpdatedAt.UTC().Format(versionTimeLayout) + "_" + rev.RevisionId + path.Ext(file.Name)
+}
+
+type cachedRevisions struct {
+	revisions []*api.Revision
+	fetched   time.Time
+}
+
+// revisionCache 历史版本列表缓存，按 fileId 索引
+type revisionCache struct {
+	mu    sync.Mutex
+	items map[string]*cachedRevisions
+}
+
+func (c *revisionCache) Invalidate(fileId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.items, fileId)
+}
+
+// revisionStore 后端不保存历史版本时，历史版本目录不存在
+func (a *aliDriveFS) revisionStore() (backend.RevisionStore, error) {
+	store, ok := a.backend.(backend.RevisionStore)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return store, nil
+}
+
+func (a *aliDriveFS) revisions(fileId string) ([]*api.Revision, error) {
+	c := a.versions
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.items[fileId]
+	hit := ok && time.Since(cached.fetched) < revisionCacheTTL
+	metrics.ObserveCache("revisions", hit)
+
+	if hit {
+		return cached.revisions, nil
+	}
+
+	store, err := a.revisionStore()
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := store.ListRevisions(fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.items == nil {
+		c.items = make(map[string]*cachedRevisions)
+	}
+	c.items[fileId] = &cachedRevisions{revisions: revisions, fetched: time.Now()}
+
+	return revisions, nil
+}
+
+// versionNode 历史版本目录中的节点，真实的文件和目录都显示为目录，历史版本显示为文件
+type versionNode struct {
+	info     *aliFileInfo
+	target   string        // 对应的真实路径
+	file     *models.File  // 真实的文件或目录
+	revision *api.Revision // 历史版本，目录时为 nil
+}
+
+func (a *aliDriveFS) resolveVersion(rel string) (*versionNode, error) {
+	if _, err := a.revisionStore(); err != nil {
+		return nil, err
+	}
+
+	fileId, _, err := a.backend.ResolvePathToFileId(rel)
+	if err == nil {
+		file, err := a.backend.GetFile(fileId)
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Base(rel)
+		if rel == "/" {
+			name = path.Base(VersionsDir)
+		}
+
+		return &versionNode{
+			info: &aliFileInfo{
+				name:    name,
+				mode:    os.ModeDir | os.ModePerm,
+				modTime: file.UpdatedAt,
+				virtual: true,
+			},
+			target: rel,
+			file:   file,
+		}, nil
+	}
+	if err != aliyundrive.ErrPartialFoundPath {
+		return nil, err
+	}
+
+	// 路径不存在时，上一级应为文件，最后一级为历史版本
+	target := path.Dir(rel)
+
+	fileId, _, err = a.backend.ResolvePathToFileId(target)
+	if err != nil {
+		if err == aliyundrive.ErrPartialFoundPath {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+
+	file, err := a.backend.GetFile(fileId)
+	if err != nil {
+		return nil, err
+	}
+	if file.Type == models.FileTypeFolder {
+		return nil, os.ErrNotExist
+	}
+
+	revisions, err := a.revisions(fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	name := path.Base(rel)
+	for _, rev := range revisions {
+		if versionName(file, rev) == name {
+			return &versionNode{
+				info:     newVersionFileInfo(file, rev),
+				target:   target,
+				file:     file,
+				revision: rev,
+			}, nil
+		}
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// newVersionFileInfo 不保留当前文件的信息，避免返回当前版本的校验值
+func newVersionFileInfo(file *models.File, rev *api.Revision) *aliFileInfo {
+	return &aliFileInfo{
+		name:    versionName(file, rev),
+		size:    rev.Size,
+		mode:    os.ModePerm,
+		modTime: rev.UpdatedAt,
+		virtual: true,
+	}
+}
+
+func (a *aliDriveFS) statVersion(rel string) (os.FileInfo, error) {
+	node, err := a.resolveVersion(rel)
+	if err != nil {
+		return nil, err
+	}
+
+	return node.info, nil
+}
+
+// openVersion 打开历史版本目录或历史版本，只读
+func (a *aliDriveFS) openVersion(rel string, flag int) (webdav.File, error) {
+	if flag&(os.O_CREATE|os.O_WRONLY|os.O_RDWR|os.O_TRUNC) != 0 {
+		return nil, os.ErrPermission
+	}
+
+	node, err := a.resolveVersion(rel)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.revision == nil {
+		return &versionDir{fs: a, node: node}, nil
+	}
+
+	return &versionFile{fs: a, node: node}, nil
+}
+
+// IsVersionPath 判断路径是否在历史版本目录中
+func (a *aliDriveFS) IsVersionPath(name string) bool {
+	_, ok := versionsPath(name)
+	return ok
+}
+
+// RestoreVersion 恢复历史版本，目标为原文件时直接恢复，否则把历史版本复制到目标路径
+func (a *aliDriveFS) RestoreVersion(ctx context.Context, name, dst string) error {
+	rel, ok := versionsPath(name)
+	if !ok {
+		return os.ErrNotExist
+	}
+
+	dst = path.Clean("/" + dst)
+	if _, ok := versionsPath(dst); ok {
+		return os.ErrPermission
+	}
+	if _, ok := trashPath(dst); ok {
+		return os.ErrPermission
+	}
+
+	node, err := a.resolveVersion(rel)
+	if err != nil {
+		return err
+	}
+	if node.revision == nil {
+		return errRestoreDirectory
+	}
+
+	if dst != node.target {
+		return a.copyVersion(ctx, node, dst)
+	}
+
+	logrus.Infof("restoring %s to revision %s", node.target, node.revision.RevisionId)
+
+	store, err := a.revisionStore()
+	if err != nil {
+		return err
+	}
+
+	if err := store.RestoreRevision(node.file.FileId, node.revision.RevisionId); err != nil {
+		return err
+	}
+
+	a.versions.Invalidate(node.file.FileId)
+	a.quota.Invalidate()
+	a.backend.EvictCacheWithPrefix(node.file.FileId)
+	a.backend.EvictCacheWithPrefix(node.file.ParentFileId)
+
+	a.events.publish(&Event{
+		Type:   EventUpload,
+		Path:   node.target,
+		FileId: node.file.FileId,
+		Size:   node.revision.Size,
+		User:   userOf(ctx),
+	})
+
+	return nil
+}
+
+// copyVersion 把历史版本上传到新的路径
+func (a *aliDriveFS) copyVersion(ctx context.Context, node *versionNode, dst string) error {
+	src := &versionFile{fs: a, node: node}
+	defer src.Close()
+
+	ctx = context.WithValue(ctx, CtxSizeValue, node.revision.Size)
+
+	f, err := a.OpenFile(ctx, dst, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	_, copyErr := Copy(f, src)
+	closeErr := f.Close()
+
+	if copyErr != nil {
+		return copyErr
+	}
+
+	return closeErr
+}
+
+// versionDir 历史版本目录，真实目录列出子文件和子目录，真实文件列出历史版本
+type versionDir struct {
+	fs   *aliDriveFS
+	node *versionNode
+	pos  int
+}
+
+func (v *versionDir) Close() error { return nil }
+
+func (v *versionDir) Read(p []byte) (int, error) { return 0, os.ErrInvalid }
+
+func (v *versionDir) Write(p []byte) (int, error) { return 0, os.ErrPermission }
+
+func (v *versionDir) Seek(offset int64, whence int) (int64, error) { return 0, os.ErrInvalid }
+
+func (v *versionDir) Stat() (fs.FileInfo, error) { return v.node.info, nil }
+
+func (v *versionDir) Readdir(count int) ([]fs.FileInfo, error) {
+	infos, err := v.list()
+	if err != nil {
+		return nil, err
+	}
+
+	return readdirSlice(infos, &v.pos, count)
+}
+
+func (v *versionDir) list() ([]fs.FileInfo, error) {
+	var infos []fs.FileInfo
+
+	if v.node.file.Type != models.FileTypeFolder {
+		revisions, err := v.fs.revisions(v.node.file.FileId)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rev := range revisions {
+			infos = append(infos, newVersionFileInfo(v.node.file, rev))
+		}
+
+		return infos, nil
+	}
+
+	marker := ""
+
+	for {
+		files, err := v.fs.backend.GetFolderFiles(&aliyundrive.FolderFilesOptions{
+			OrderBy:        "name",
+			OrderDirection: "ASC",
+			FolderFileId:   v.node.file.FileId,
+			Marker:         marker,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range files.Items {
+			infos = append(infos, &aliFileInfo{
+				name:    item.Name,
+				mode:    os.ModeDir | os.ModePerm,
+				modTime: item.UpdatedAt,
+				virtual: true,
+			})
+		}
+
+		if files.NextMarker == "" {
+			return infos, nil
+		}
+
+		marker = files.NextMarker
+	}
+}
+
+// readdirSlice 按 os.File.Readdir 的语义分批返回 infos，pos 为已返回的数量
+func readdirSlice(infos []fs.FileInfo, pos *int, count int) ([]fs.FileInfo, error) {
+	if count <= 0 {
+		*pos = len(infos)
+		return infos, nil
+	}
+
+	if *pos >= len(infos) {
+		return nil, io.EOF
+	}
+
+	end := *pos + count
+	if end > len(infos) {
+		end = len(infos)
+	}
+
+	result := infos[*pos:end]
+	*pos = end
+
+	return result, nil
+}
+
+// versionFile 历史版本的内容，通过下载地址读取
+type versionFile struct {
+	fs     *aliDriveFS
+	node   *versionNode
+	mu     sync.Mutex
+	pos    int64
+	reader io.ReadCloser
+}
+
+func (v *versionFile) Close() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.closeReader()
+
+	return nil
+}
+
+func (v *versionFile) closeReader() {
+	if v.reader != nil {
+		_ = v.reader.Close()
+		v.reader = nil
+	}
+}
+
+func (v *versionFile) Read(p []byte) (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	size := v.node.revision.Size
+	if v.pos >= size {
+		return 0, io.EOF
+	}
+
+	if v.reader == nil {
+		reader, err := v.download(v.pos)
+		if err != nil {
+			return 0, err
+		}
+		v.reader = reader
+	}
+
+	n, err := v.reader.Read(p)
+	v.pos += int64(n)
+
+	if v.pos >= size {
+		v.closeReader()
+		return n, io.EOF
+	}
+
+	return n, err
+}
+
+func (v *versionFile) download(offset int64) (io.ReadCloser, error) {
+	store, err := v.fs.revisionStore()
+	if err != nil {
+		return nil, err
+	}
+
+	return store.DownloadRevision(v.node.file.FileId, v.node.revision.RevisionId, offset)
+}
+
+func (v *versionFile) Seek(offset int64, whence int) (int64, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	npos := v.pos
+
+	switch whence {
+	case io.SeekStart:
+		npos = offset
+	case io.SeekCurrent:
+		npos += offset
+	case io.SeekEnd:
+		npos = v.node.revision.Size + offset
+	default:
+		npos = -1
+	}
+	if npos < 0 {
+		return 0, os.ErrInvalid
+	}
+
+	if npos != v.pos {
+		v.closeReader()
+		v.pos = npos
+	}
+
+	return v.pos, nil
+}
+
+func (v *versionFile) Readdir(count int) ([]fs.FileInfo, error) { return nil, os.ErrInvalid }
+
+func (v *versionFile) Stat() (fs.FileInfo, error) { return v.node.info, nil }
+
+func (v *versionFile) Write(p []byte) (int, error) { return 0, os.ErrPermission }
+
+// handleVersionRestore 处理从历史版本目录 COPY/MOVE 到其他路径，历史版本不会被删除
+func (h *Handler) handleVersionRestore(w http.ResponseWriter, r *http.Request, src string) (int, error) {
+	vr, ok := h.FileSystem.(versionRestorer)
+	if !ok {
+		return http.StatusNotImplemented, errUnsupportedMethod
+	}
+
+	u, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil {
+		return http.StatusBadRequest, errInvalidDestination
+	}
+
+	dst, status, err := h.stripPrefix(u.Path)
+	if err != nil {
+		return status, err
+	}
+
+	// 和 webdav.Handler 一样，COPY 只确认目标的锁，MOVE 同时确认源路径的锁
+	lockSrc := ""
+	if r.Method == "MOVE" {
+		lockSrc = src
+	}
+
+	release, status, err := h.confirmLocks(r, lockSrc, dst)
+	if err != nil {
+		return status, err
+	}
+	defer release()
+
+	ctx := r.Context()
+
+	_, statErr := h.FileSystem.Stat(ctx, dst)
+	created := os.IsNotExist(statErr)
+
+	if !created && r.Header.Get("Overwrite") == "F" {
+		return http.StatusPreconditionFailed, os.ErrExist
+	}
+
+	if err := vr.RestoreVersion(ctx, src, dst); err != nil {
+		switch {
+		case os.IsNotExist(err):
+			return http.StatusNotFound, err
+		case os.IsPermission(err), err == errRestoreDirectory:
+			return http.StatusForbidden, err
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	if created {
+		return http.StatusCreated, nil
+	}
+
+	return http.StatusNoContent, nil
+}
@@ -0,0 +1,127 @@
+package webdav
+
+import (
+	"github.com/jakeslee/aliyundrive-webdav/internal/api"
+	"github.com/jakeslee/aliyundrive-webdav/internal/backend"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// revisionBackend 保存历史版本的内存后端
+type revisionBackend struct {
+	backend.Backend
+	revisions map[string][]*api.Revision
+	contents  map[string]string // revisionId 对应的内容
+	restored  []string
+}
+
+func (b *revisionBackend) ListRevisions(fileId string) ([]*api.Revision, error) {
+	return b.revisions[fileId], nil
+}
+
+func (b *revisionBackend) RestoreRevision(fileId, revisionId string) error {
+	b.restored = append(b.restored, fileId+":"+revisionId)
+	return nil
+}
+
+func (b *revisionBackend) DownloadRevision(fileId, revisionId string, offset int64) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader(b.contents[revisionId][offset:])), nil
+}
+
+func TestVersionsPath(t *testing.T) {
+	tests := []struct {
+		name, rel string
+		ok        bool
+	}{
+		{"/.versions", "/", true},
+		{"/.versions/", "/", true},
+		{"/.versions/docs/a.txt", "/docs/a.txt", true},
+		{"/.versionsx/a.txt", "", false},
+		{"/docs/.versions/a.txt", "", false},
+	}
+
+	for _, tt := range tests {
+		if rel, ok := versionsPath(tt.name); rel != tt.rel || ok != tt.ok {
+			t.Errorf("versionsPath(%q) = %q, %v, expected %q, %v", tt.name, rel, ok, tt.rel, tt.ok)
+		}
+	}
+}
+
+func TestVersions(t *testing.T) {
+	b := &revisionBackend{Backend: backend.NewMemory(), contents: map[string]string{"r1": "old", "r2": "hello"}}
+	fs := NewAliDriveFS(b, &Options{WorkDir: t.TempDir()}).(*aliDriveFS)
+	if err := fs.Mkdir(sizeContext(0), "/docs", 0755); err != nil {
+		t.Fatal(err)
+	}
+	upload(t, fs, "/docs/a.txt", "hello", nil)
+	upload(t, fs, "/docs/other.txt", "other", nil)
+
+	fileId, _, err := b.ResolvePathToFileId("/docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	b.revisions = map[string][]*api.Revision{fileId: {
+		{RevisionId: "r1", FileId: fileId, Size: 3, UpdatedAt: updated},
+		{RevisionId: "r2", FileId: fileId, Size: 5, UpdatedAt: updated.Add(time.Hour), IsLatest: true},
+	}}
+	old := "/.versions/docs/a.txt/20210102-030405_r1.txt"
+
+	h := newDriveHandler(fs, nil)
+
+	tests := []struct {
+		name, method, target string
+		header               map[string]string
+		status               int
+		contains             string
+	}{
+		{"list folder", "PROPFIND", "/.versions/docs/", map[string]string{"Depth": "1"}, http.StatusMultiStatus, "<D:href>/.versions/docs/a.txt/</D:href>"},
+		{"list revisions", "PROPFIND", "/.versions/docs/a.txt/", map[string]string{"Depth": "1"}, http.StatusMultiStatus, "20210102-040405_r2.txt"},
+		{"download", "GET", old, nil, http.StatusOK, "old"},
+		{"range", "GET", old, map[string]string{"Range": "bytes=1-"}, http.StatusPartialContent, "ld"},
+		{"missing revision", "GET", "/.versions/docs/a.txt/20210102-030405_r9.txt", nil, http.StatusNotFound, ""},
+		{"revision of folder", "GET", "/.versions/docs/x.txt", nil, http.StatusNotFound, ""},
+		// 历史版本目录只读，webdav.Handler 对 PUT、DELETE 的错误分别返回 404、405
+		{"put", "PUT", "/.versions/docs/new.txt", nil, http.StatusNotFound, ""},
+		{"delete", "DELETE", old, nil, http.StatusMethodNotAllowed, ""},
+		{"still exists", "GET", old, nil, http.StatusOK, "old"},
+		{"copy", "COPY", old, map[string]string{"Destination": "/docs/b.txt"}, http.StatusCreated, ""},
+		{"no overwrite", "COPY", old, map[string]string{"Destination": "/docs/other.txt", "Overwrite": "F"}, http.StatusPreconditionFailed, ""},
+		{"copy directory", "COPY", "/.versions/docs/a.txt", map[string]string{"Destination": "/docs/c.txt"}, http.StatusForbidden, ""},
+		{"copy into versions", "COPY", old, map[string]string{"Destination": "/.versions/docs/d.txt"}, http.StatusForbidden, ""},
+		{"restore", "MOVE", old, map[string]string{"Destination": "/docs/a.txt"}, http.StatusNoContent, ""},
+	}
+
+	for _, tt := range tests {
+		w := serve(h, "alice", tt.method, tt.target, tt.header, "")
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.contains) {
+			t.Errorf("%s: status %d, expected %d, %s", tt.name, w.Code, tt.status, w.Body.String())
+		}
+	}
+
+	if err := fs.Drain(sizeContext(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	// COPY 把历史版本复制为新文件，MOVE 到原文件时恢复历史版本
+	if w := serve(h, "alice", "GET", "/docs/b.txt", nil, ""); w.Body.String() != "old" {
+		t.Errorf("copied revision content %q", w.Body.String())
+	}
+	if len(b.restored) != 1 || b.restored[0] != fileId+":r1" {
+		t.Errorf("restored %v", b.restored)
+	}
+}
+
+func TestVersionsUnsupported(t *testing.T) {
+	fs := newTestFS(t, false)
+	upload(t, fs, "/a.txt", "hello", nil)
+
+	if _, err := fs.Stat(sizeContext(0), "/.versions/a.txt"); err == nil {
+		t.Fatalf("versions folder exists without revision support")
+	}
+}
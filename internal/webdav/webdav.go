@@ -1,6 +1,7 @@
 package webdav
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"golang.org/x/net/webdav"
@@ -8,6 +9,7 @@ import (
 	"mime"
 	"net/textproto"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -17,21 +19,88 @@ import "net/http"
 
 type Handler struct {
 	webdav.Handler
+
+	ArchiveMaxFiles int   // 打包下载的最大文件数，0 为不限制
+	ArchiveMaxSize  int64 // 打包下载的最大字节数，0 为不限制
+
+	// Authorizer 按用户检查路径权限，为空时不检查
+	Authorizer Authorizer
 }
 
 var (
-	errPrefixMismatch    = errors.New("webdav: prefix mismatch")
-	errSeeker            = errors.New("seeker can't seek")
-	errNoOverlap         = errors.New("invalid range: failed to overlap")
-	errUnsupportedMethod = errors.New("webdav: unsupported method")
+	errPrefixMismatch      = errors.New("webdav: prefix mismatch")
+	errSeeker              = errors.New("seeker can't seek")
+	errNoOverlap           = errors.New("invalid range: failed to overlap")
+	errUnsupportedMethod   = errors.New("webdav: unsupported method")
+	errInsufficientStorage = errors.New("webdav: insufficient storage")
+	errCrossOrigin         = errors.New("webdav: cross-origin request")
 )
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorize(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	status, err := http.StatusBadRequest, errUnsupportedMethod
 
 	switch r.Method {
 	case "GET", "HEAD", "POST":
 		status, err = h.handleGetHeadPost(w, r)
+	case "PROPPATCH":
+		status, err = h.handleProppatch(w, r)
+	case "REPORT":
+		status, err = h.handleReport(w, r)
+	case "SEARCH":
+		status, err = h.handleSearch(w, r)
+	case "COPY", "MOVE":
+		// 从历史版本目录复制或移动时恢复历史版本
+		if src, _, prefixErr := h.stripPrefix(r.URL.Path); prefixErr == nil {
+			if vr, ok := h.FileSystem.(versionRestorer); ok && vr.IsVersionPath(src) {
+				status, err = h.handleVersionRestore(w, r, src)
+				break
+			}
+		}
+		h.serveFiltered(w, r)
+		return
+	case "PROPFIND":
+		h.serveFiltered(w, r)
+		return
+	case "MKCOL":
+		// 文件系统的 Mkdir 会创建中间目录，按 RFC 4918 目录已存在返回 405，父目录不存在返回 409
+		if reqPath, _, prefixErr := h.stripPrefix(r.URL.Path); prefixErr == nil {
+			if _, statErr := h.FileSystem.Stat(r.Context(), reqPath); statErr == nil {
+				status, err = http.StatusMethodNotAllowed, os.ErrExist
+				break
+			}
+			if _, statErr := h.FileSystem.Stat(r.Context(), path.Dir(path.Clean(reqPath))); os.IsNotExist(statErr) {
+				status, err = http.StatusConflict, statErr
+				break
+			}
+		}
+		h.Handler.ServeHTTP(w, r)
+		return
+	case "OPTIONS":
+		w.Header().Set("DASL", "<DAV:basicsearch>")
+		h.Handler.ServeHTTP(w, r)
+		return
+	case "PUT":
+		// 剩余空间不足时拒绝上传
+		if q, ok := h.FileSystem.(quotaReporter); ok && r.ContentLength > 0 {
+			reqPath, _, _ := h.stripPrefix(r.URL.Path)
+			if available, _, qErr := q.Quota(r.Context(), reqPath); qErr == nil && r.ContentLength > available {
+				status, err = http.StatusInsufficientStorage, errInsufficientStorage
+				break
+			}
+		}
+
+		// ownCloud 客户端通过 X-OC-Mtime 传递文件修改时间
+		if modTime, ok := parseOCMtime(r.Header.Get("X-OC-Mtime")); ok {
+			r = r.WithContext(context.WithValue(r.Context(), CtxModTimeValue, modTime))
+			w.Header().Set("X-OC-MTime", "accepted")
+		}
+		h.Handler.ServeHTTP(w, r)
+		return
 	default:
 		h.Handler.ServeHTTP(w, r)
 		return
@@ -75,9 +144,23 @@ func (h *Handler) handleGetHeadPost(w http.ResponseWriter, r *http.Request) (sta
 		return http.StatusNotFound, err
 	}
 	if fi.IsDir() {
-		return http.StatusMethodNotAllowed, nil
+		if r.Method == "POST" {
+			return h.handleBrowserUpload(w, r, reqPath)
+		}
+
+		if keyword := r.URL.Query().Get("search"); keyword != "" {
+			return h.handleGetSearch(w, r, reqPath, keyword)
+		}
+
+		if format := r.URL.Query().Get("archive"); format != "" {
+			return h.serveArchive(w, r, reqPath, format)
+		}
+
+		return h.serveDirectory(w, r, reqPath, f)
 	}
 
+	setChecksumHeaders(w, fi)
+
 	ServeContent(w, r, reqPath, fi.ModTime(), f)
 	return 0, nil
 }
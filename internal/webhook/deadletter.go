@@ -0,0 +1,133 @@
+package webhook
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// deadLetter 多次发送失败的回调，每行一个 JSON 对象追加到文件
+type deadLetter struct {
+	*delivery
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// deadLetters 死信队列，file 为空时只记录日志
+type deadLetters struct {
+	sync.Mutex
+	file string
+}
+
+func (q *deadLetters) add(item *delivery) {
+	if q.file == "" {
+		return
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	line, err := json.Marshal(&deadLetter{delivery: item, FailedAt: time.Now()})
+	if err != nil {
+		logrus.Errorf("marshal webhook dead letter error %s", err)
+		return
+	}
+
+	f, err := os.OpenFile(q.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		logrus.Errorf("open webhook dead letter file error %s", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		logrus.Errorf("write webhook dead letter error %s", err)
+	}
+}
+
+func (q *deadLetters) list() ([]*deadLetter, error) {
+	f, err := os.Open(q.file)
+	if os.IsNotExist(err) {
+		return []*deadLetter{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	items := make([]*deadLetter, 0)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		item := &deadLetter{delivery: &delivery{}}
+		if err := json.Unmarshal(scanner.Bytes(), item); err != nil {
+			logrus.Warnf("skip invalid webhook dead letter %s", err)
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	return items, scanner.Err()
+}
+
+// take 取出全部死信并清空文件
+func (q *deadLetters) take() ([]*deadLetter, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	items, err := q.list()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.Remove(q.file); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// ServeDeadLetters GET 列出死信，POST 将死信重新放入发送队列
+func (d *Dispatcher) ServeDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if d.dead.file == "" {
+		http.Error(w, "webhook: dead letter file is not configured", http.StatusNotFound)
+		return
+	}
+
+	var (
+		items []*deadLetter
+		err   error
+	)
+
+	switch r.Method {
+	case http.MethodGet:
+		d.dead.Lock()
+		items, err = d.dead.list()
+		d.dead.Unlock()
+	case http.MethodPost:
+		items, err = d.dead.take()
+		for _, item := range items {
+			item.Attempts = 0
+			item.Error = ""
+			d.enqueue(item.delivery)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(items)
+}
@@ -0,0 +1,276 @@
+// Package webhook 文件变更时回调外部地址，失败按退避重试，多次失败后保存到死信队列
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	aliWebdav "github.com/jakeslee/aliyundrive-webdav/internal/webdav"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// queueSize 等待发送的回调数量，队列满时直接进入死信队列
+	queueSize = 1024
+
+	workers = 4
+
+	initialBackoff = time.Second
+	maxBackoff     = time.Minute
+
+	requestTimeout = 30 * time.Second
+
+	// closePollInterval 关闭时检查队列是否发送完成的间隔
+	closePollInterval = 100 * time.Millisecond
+
+	HeaderId        = "X-Webhook-Id"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+var (
+	errQueueFull    = errors.New("webhook: queue is full")
+	errClosed       = errors.New("webhook: dispatcher closed")
+	errUnknownEvent = errors.New("webhook: unknown event type")
+)
+
+// Payload 回调请求体，包含事件的全部字段
+type Payload struct {
+	Id string `json:"id"`
+	*aliWebdav.Event
+}
+
+// Options 回调配置，Events 为空时发送全部事件
+type Options struct {
+	URLs           []string
+	Secret         string
+	Events         []string
+	MaxAttempts    int
+	DeadLetterFile string
+}
+
+// delivery 发送到一个地址的回调
+type delivery struct {
+	URL      string   `json:"url"`
+	Payload  *Payload `json:"payload"`
+	Attempts int      `json:"attempts"`
+	Error    string   `json:"error,omitempty"`
+}
+
+type Dispatcher struct {
+	options *Options
+	events  map[aliWebdav.EventType]bool
+	queue   chan *delivery
+	client  *http.Client
+	dead    *deadLetters
+
+	// pending 队列中和正在发送的回调数
+	pending int64
+
+	mu      sync.Mutex
+	closing bool
+	retries map[*delivery]*time.Timer
+}
+
+func New(options *Options) (*Dispatcher, error) {
+	d := &Dispatcher{
+		options: options,
+		queue:   make(chan *delivery, queueSize),
+		client:  &http.Client{Timeout: requestTimeout},
+		dead:    &deadLetters{file: options.DeadLetterFile},
+		retries: make(map[*delivery]*time.Timer),
+	}
+
+	if d.options.MaxAttempts <= 0 {
+		d.options.MaxAttempts = 1
+	}
+
+	if len(options.Events) > 0 {
+		d.events = make(map[aliWebdav.EventType]bool)
+
+		for _, name := range options.Events {
+			t := aliWebdav.EventType(strings.TrimSpace(name))
+			switch t {
+			case aliWebdav.EventUpload, aliWebdav.EventMkdir, aliWebdav.EventDelete, aliWebdav.EventMove:
+				d.events[t] = true
+			default:
+				return nil, fmt.Errorf("%w %s", errUnknownEvent, name)
+			}
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+
+	return d, nil
+}
+
+// Listen 作为 EventListener 订阅文件系统的变更事件
+func (d *Dispatcher) Listen(event *aliWebdav.Event) {
+	if d.events != nil && !d.events[event.Type] {
+		return
+	}
+
+	payload := &Payload{Id: newId(), Event: event}
+
+	for _, url := range d.options.URLs {
+		d.enqueue(&delivery{URL: url, Payload: payload})
+	}
+}
+
+func (d *Dispatcher) enqueue(item *delivery) {
+	atomic.AddInt64(&d.pending, 1)
+
+	select {
+	case d.queue <- item:
+	default:
+		atomic.AddInt64(&d.pending, -1)
+		item.Error = errQueueFull.Error()
+		d.dead.add(item)
+	}
+}
+
+func (d *Dispatcher) work() {
+	for item := range d.queue {
+		d.process(item)
+		atomic.AddInt64(&d.pending, -1)
+	}
+}
+
+func (d *Dispatcher) process(item *delivery) {
+	err := d.send(item)
+	item.Attempts++
+
+	if err == nil {
+		return
+	}
+
+	item.Error = err.Error()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// 关闭时不再等待重试
+	if item.Attempts >= d.options.MaxAttempts || d.closing {
+		logrus.Errorf("webhook %s to %s failed after %d attempts, %s", item.Payload.Id, item.URL, item.Attempts, err)
+		d.dead.add(item)
+		return
+	}
+
+	backoff := initialBackoff << uint(item.Attempts-1)
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	logrus.Warnf("webhook %s to %s failed, retry in %s, %s", item.Payload.Id, item.URL, backoff, err)
+
+	d.retries[item] = time.AfterFunc(backoff, func() {
+		d.mu.Lock()
+		delete(d.retries, item)
+		d.mu.Unlock()
+
+		d.enqueue(item)
+	})
+}
+
+// Close 等待队列中的回调发送完成，等待重试和超时未发送的回调保存到死信队列
+func (d *Dispatcher) Close(ctx context.Context) {
+	d.mu.Lock()
+	d.closing = true
+	for item, timer := range d.retries {
+		if timer.Stop() {
+			d.dead.add(item)
+		}
+		delete(d.retries, item)
+	}
+	d.mu.Unlock()
+
+	ticker := time.NewTicker(closePollInterval)
+	defer ticker.Stop()
+
+	for atomic.LoadInt64(&d.pending) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			for {
+				select {
+				case item := <-d.queue:
+					item.Error = errClosed.Error()
+					d.dead.add(item)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) send(item *delivery) error {
+	body, err := json.Marshal(item.Payload)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, item.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(HeaderId, item.Payload.Id)
+	request.Header.Set(HeaderEvent, string(item.Payload.Type))
+	request.Header.Set(HeaderTimestamp, timestamp)
+
+	if d.options.Secret != "" {
+		request.Header.Set(HeaderSignature, "sha256="+Sign(d.options.Secret, timestamp, body))
+	}
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("status %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+// Sign 计算 HMAC-SHA256(secret, timestamp + "." + body)，接收方用相同方法校验
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
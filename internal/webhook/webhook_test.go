@@ -0,0 +1,285 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	aliWebdav "github.com/jakeslee/aliyundrive-webdav/internal/webdav"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver 记录收到的回调，前 failures 次返回 500
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.requests)
+}
+
+func deadItems(d *Dispatcher) ([]*deadLetter, error) {
+	d.dead.Lock()
+	defer d.dead.Unlock()
+
+	return d.dead.list()
+}
+
+func closeDispatcher(d *Dispatcher) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	d.Close(ctx)
+}
+
+func TestNewEvents(t *testing.T) {
+	for _, tt := range []struct {
+		events []string
+		ok     bool
+	}{
+		{nil, true},
+		{[]string{"upload", " delete "}, true},
+		{[]string{"upload", "rename"}, false},
+	} {
+		d, err := New(&Options{Events: tt.events})
+		if (err == nil) != tt.ok {
+			t.Errorf("New(%v) = %v", tt.events, err)
+		}
+		if d != nil {
+			closeDispatcher(d)
+		}
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	rcv := &receiver{}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+
+	d, err := New(&Options{
+		URLs:   []string{server.URL + "/a", server.URL + "/b"},
+		Secret: "secret",
+		Events: []string{"upload", "move"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.Listen(&aliWebdav.Event{Type: aliWebdav.EventUpload, Path: "/a.txt", Size: 5})
+	// 没有订阅的事件不发送
+	d.Listen(&aliWebdav.Event{Type: aliWebdav.EventDelete, Path: "/a.txt"})
+	closeDispatcher(d)
+
+	if rcv.count() != 2 {
+		t.Fatalf("%d requests, expected one per URL", rcv.count())
+	}
+
+	paths := map[string]bool{}
+	for i, r := range rcv.requests {
+		paths[r.URL.Path] = true
+
+		var payload Payload
+		if err := json.Unmarshal(rcv.bodies[i], &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Id == "" || payload.Id != r.Header.Get(HeaderId) || payload.Path != "/a.txt" || payload.Size != 5 {
+			t.Errorf("payload %+v, id header %s", payload, r.Header.Get(HeaderId))
+		}
+		if r.Header.Get(HeaderEvent) != "upload" {
+			t.Errorf("event header %s", r.Header.Get(HeaderEvent))
+		}
+		if want := "sha256=" + Sign("secret", r.Header.Get(HeaderTimestamp), rcv.bodies[i]); r.Header.Get(HeaderSignature) != want {
+			t.Errorf("signature %s, expected %s", r.Header.Get(HeaderSignature), want)
+		}
+	}
+	if !paths["/a"] || !paths["/b"] {
+		t.Errorf("delivered to %v", paths)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// 接收方用 HMAC-SHA256(secret, timestamp + "." + body) 校验
+	if got := Sign("secret", "1609459200", []byte(`{"id":"1"}`)); got != "86cf7f79442d0bb12490c65fb55cc24ad1054248522434ce4eaf37c1f61289c8" {
+		t.Fatalf("signature %s", got)
+	}
+	if Sign("secret", "1", []byte("a")) == Sign("other", "1", []byte("a")) {
+		t.Fatalf("signature does not depend on the secret")
+	}
+	if Sign("secret", "1", []byte("a")) == Sign("secret", "2", []byte("a")) {
+		t.Fatalf("signature does not depend on the timestamp")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	rcv := &receiver{failures: 1}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "dead.jsonl")
+	d, err := New(&Options{URLs: []string{server.URL}, MaxAttempts: 2, DeadLetterFile: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.Listen(&aliWebdav.Event{Type: aliWebdav.EventMkdir, Path: "/dir"})
+
+	// 第一次失败后按退避时间重试
+	deadline := time.Now().Add(5 * time.Second)
+	for rcv.count() < 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	closeDispatcher(d)
+
+	if rcv.count() != 2 {
+		t.Fatalf("%d requests, expected a retry", rcv.count())
+	}
+	if items, err := deadItems(d); err != nil || len(items) != 0 {
+		t.Fatalf("dead letters %v, %v", items, err)
+	}
+}
+
+func TestCloseRetries(t *testing.T) {
+	rcv := &receiver{failures: 1}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "dead.jsonl")
+	d, err := New(&Options{URLs: []string{server.URL}, MaxAttempts: 3, DeadLetterFile: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.Listen(&aliWebdav.Event{Type: aliWebdav.EventUpload, Path: "/a.txt"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for rcv.count() < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 关闭时不等待重试，直接保存到死信队列
+	start := time.Now()
+	deadline = start.Add(5 * time.Second)
+	for {
+		closeDispatcher(d)
+
+		items, err := deadItems(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pending retry not saved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if time.Since(start) >= initialBackoff || rcv.count() != 1 {
+		t.Fatalf("close waited for the retry, %d requests", rcv.count())
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	rcv := &receiver{failures: 1}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "dead.jsonl")
+	d, err := New(&Options{URLs: []string{server.URL}, MaxAttempts: 1, DeadLetterFile: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeDispatcher(d)
+
+	d.Listen(&aliWebdav.Event{Type: aliWebdav.EventDelete, Path: "/a.txt"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		items, err := deadItems(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) == 1 {
+			if items[0].Attempts != 1 || items[0].Error != "status 500" || items[0].Payload.Path != "/a.txt" {
+				t.Fatalf("dead letter %+v", items[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no dead letter")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	tests := []struct {
+		method string
+		status int
+		items  int
+	}{
+		{"GET", http.StatusOK, 1},
+		// POST 重新发送并清空死信
+		{"POST", http.StatusOK, 1},
+		{"GET", http.StatusOK, 0},
+		{"DELETE", http.StatusMethodNotAllowed, -1},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		d.ServeDeadLetters(w, httptest.NewRequest(tt.method, "/webhooks/dead-letters", nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d", tt.method, w.Code)
+			continue
+		}
+		if tt.items < 0 {
+			continue
+		}
+
+		var items []map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil || len(items) != tt.items {
+			t.Errorf("%s: items %s, %v", tt.method, w.Body.String(), err)
+		}
+	}
+
+	deadline = time.Now().Add(5 * time.Second)
+	for rcv.count() < 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if rcv.count() != 2 {
+		t.Fatalf("dead letter not redelivered")
+	}
+
+	// 没有配置死信文件
+	w := httptest.NewRecorder()
+	noFile, _ := New(&Options{})
+	defer closeDispatcher(noFile)
+	noFile.ServeDeadLetters(w, httptest.NewRequest("GET", "/webhooks/dead-letters", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("without dead letter file status %d", w.Code)
+	}
+}
@@ -4,74 +4,168 @@ import (
 	"context"
 	"fmt"
 	"github.com/alexflint/go-arg"
-	nested "github.com/antonfisher/nested-logrus-formatter"
 	"github.com/jakeslee/aliyundrive"
 	"github.com/jakeslee/aliyundrive-webdav/internal"
+	"github.com/jakeslee/aliyundrive-webdav/internal/access"
+	"github.com/jakeslee/aliyundrive-webdav/internal/admin"
+	"github.com/jakeslee/aliyundrive-webdav/internal/api"
+	"github.com/jakeslee/aliyundrive-webdav/internal/backend"
+	"github.com/jakeslee/aliyundrive-webdav/internal/credential"
+	"github.com/jakeslee/aliyundrive-webdav/internal/logging"
+	"github.com/jakeslee/aliyundrive-webdav/internal/metrics"
+	"github.com/jakeslee/aliyundrive-webdav/internal/qrlogin"
+	"github.com/jakeslee/aliyundrive-webdav/internal/tokenstore"
 	aliWebdav "github.com/jakeslee/aliyundrive-webdav/internal/webdav"
+	"github.com/jakeslee/aliyundrive-webdav/internal/webhook"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/webdav"
-	"io/ioutil"
+	"io"
 	"log"
+	"net"
 	"net/http"
-	_ "net/http/pprof"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
-const defaultRefreshTokenFile = "refresh_token"
+const (
+	defaultRefreshTokenFile = "refresh_token"
+	defaultMountsDir        = "mounts"
 
-func main() {
-	arg.MustParse(internal.Config)
+	defaultWebhookDeadLetterFile = "webhook_dead_letters.jsonl"
 
-	logrus.SetFormatter(&nested.Formatter{
-		HideKeys: true,
-	})
+	driveBackup   = "backup"
+	driveResource = "resource"
 
-	logrus.Infof("aliyundrive-webdav v%s", internal.Version)
+	backendAliyun = "aliyun"
+	backendMemory = "memory"
+	backendLocal  = "local"
 
-	drive := aliyundrive.NewClient(&aliyundrive.Options{
-		AutoRefresh: true,
-		UploadRate: internal.Config.UploadSpeed * 1024 * 1024,
-	})
+	// tokenRefreshInterval 定时刷新 RefreshToken 的间隔，和 aliyundrive 的 AutoRefresh 相同
+	tokenRefreshInterval = 90 * time.Minute
 
-	rtFromFile := internal.Config.RefreshToken
+	// tokenRetryInterval 刷新失败后第一次重试的间隔
+	tokenRetryInterval = time.Minute
+)
 
-	filePath := filepath.Join(internal.Config.WorkDir, defaultRefreshTokenFile)
-	fileBytes, err := ioutil.ReadFile(filePath)
-	if err == nil {
-		rtFromFile = string(fileBytes)
-	}
+var (
+	// tokenFlushers 退出时再次保存各个网盘的 RefreshToken
+	tokenFlushers []func()
 
-	cred, err := drive.AddCredential(aliyundrive.NewCredential(&aliyundrive.Credential{
-		RefreshToken: rtFromFile,
-	}).RegisterChangeEvent(func(credential *aliyundrive.Credential) {
-		logrus.Infof("backend aliyundrive user[%s@%s] is launched! credential loaded!", credential.Name, credential.UserId)
+	// tokenKey RefreshToken 缓存文件的加密密钥，为空时明文保存
+	tokenKey string
 
-		file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY, 0666)
+	// credentials 各个网盘的凭证状态
+	credentials = credential.NewMonitor()
+)
+
+func main() {
+	p := arg.MustParse(internal.Config)
+
+	if internal.Config.Config != "" {
+		c, err := internal.LoadFile(internal.Config.Config, os.Args[1:])
 		if err != nil {
-			logrus.Warnf("write refresh token cache file error, %s", err)
-			return
+			p.Fail(err.Error())
 		}
-		defer file.Close()
+		*internal.Config = *c
+	} else if err := internal.Config.Validate(); err != nil {
+		p.Fail(err.Error())
+	}
 
-		_, err = file.Write([]byte(credential.RefreshToken))
-		if err != nil {
-			logrus.Warnf("write refresh token cache file error, %s", err)
-			return
+	if key, err := tokenstore.ReadKey(internal.Config.TokenKey, internal.Config.TokenKeyFile); err != nil {
+		p.Fail(err.Error())
+	} else {
+		tokenKey = key
+	}
+
+	if err := logging.Setup(internal.Config.LogLevel, internal.Config.LogFormat); err != nil {
+		p.Fail(err.Error())
+	}
+
+	if internal.Config.Login != nil {
+		if err := runLogin(internal.Config.Login.Mount); err != nil {
+			logrus.Errorf("login error %s", err)
+			os.Exit(1)
 		}
-	}))
+		return
+	}
+
+	logrus.Infof("aliyundrive-webdav v%s", internal.Version)
+
+	var fileSystem webdav.FileSystem
+	var err error
+
+	logrus.Infof("storage backend: %s", internal.Config.Backend)
+
+	switch internal.Config.Backend {
+	case backendAliyun:
+		if len(internal.Config.Mounts) > 0 {
+			fileSystem, err = newMountFS(internal.Config.Mounts)
+		} else {
+			fileSystem, err = newDriveFS("", internal.Config.RefreshToken, "", internal.Config.WorkDir)
+		}
+	case backendMemory:
+		fileSystem = aliWebdav.NewAliDriveFS(backend.NewMemory(), newFSOptions(internal.Config.WorkDir))
+	case backendLocal:
+		var b backend.Backend
+		if b, err = backend.NewLocal(internal.Config.LocalDir); err == nil {
+			fileSystem = aliWebdav.NewAliDriveFS(b, newFSOptions(internal.Config.WorkDir))
+		}
+	default:
+		err = fmt.Errorf("unknown backend %s", internal.Config.Backend)
+	}
 
 	if err != nil {
-		logrus.Errorf("add credential error %s", err)
+		logrus.Errorf("create backend error %s", err)
 		return
 	}
 
+	// 配置已经校验过，不会返回错误
+	policy, _ := internal.Config.Policy()
+	store := access.NewStore(policy)
+
+	if internal.Config.Config != "" {
+		go watchConfig(internal.Config.Config, store)
+	}
+
 	h := &aliWebdav.Handler{
 		Handler: webdav.Handler{
-			FileSystem: aliWebdav.NewAliDriveFS(drive, cred, internal.Config.RapidUpload),
+			FileSystem: fileSystem,
 			LockSystem: webdav.NewMemLS(),
 		},
+		ArchiveMaxFiles: internal.Config.ArchiveMaxFiles,
+		ArchiveMaxSize:  int64(internal.Config.ArchiveMaxSize) * 1024 * 1024,
+		Authorizer:      store,
+	}
+
+	oc := aliWebdav.NewOwnCloud(h, internal.Config.WorkDir)
+
+	shares, err := aliWebdav.NewShares(h, internal.Config.WorkDir)
+	if err != nil {
+		logrus.Errorf("load shares error %s", err)
+		return
+	}
+
+	// 每个客户端每分钟最多尝试 5 次分享密码
+	sharePasswords := access.NewLimiters()
+	shares.AllowPassword = func(key string) bool {
+		return sharePasswords.Allow(key, access.RateLimit{Requests: 5.0 / 60, Burst: 5})
+	}
+
+	var events *aliWebdav.EventStream
+	if internal.Config.EventsBuffer > 0 {
+		source, ok := fileSystem.(aliWebdav.EventSource)
+		if ok {
+			events = aliWebdav.NewEventStream(internal.Config.EventsBuffer, func(user string) ([]string, bool) {
+				return store.Load().EventsScopes(user)
+			})
+			source.Subscribe(events.Listen)
+		}
 	}
 
 	enableAuth := false
@@ -82,8 +176,25 @@ func main() {
 
 	logrus.Infof("auth type: %s", internal.Config.AuthType)
 
-	http.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
-		logrus.Infof("request %s %s", request.Method, request.RequestURI)
+	mux := http.NewServeMux()
+
+	// ownCloud/Nextcloud 客户端在登录前探测服务状态
+	mux.Handle("/status.php", oc)
+
+	serveWebDAV := func(writer http.ResponseWriter, request *http.Request) {
+		logrus.Debugf("request %s %s", request.Method, request.RequestURI)
+
+		// 分享链接通过签名校验，不需要 Basic Auth
+		if shares.Match(request) {
+			if !allowRequest(writer, store.Load(), "", clientIP(request)) {
+				return
+			}
+
+			if checkCredential(writer, request) {
+				shares.ServeShare(writer, request)
+			}
+			return
+		}
 
 		if enableAuth {
 			username, password, ok := request.BasicAuth()
@@ -94,14 +205,18 @@ func main() {
 				return
 			}
 
-			if username != internal.Config.HttpUsername || password != internal.Config.HttpPassword {
-				logrus.Warnf("authentication error, un: %s, pwd: %s, ip: %s", username, password, request.RemoteAddr)
+			if !store.Load().Authenticate(username, password) {
+				logrus.Warnf("authentication error, un: %s, ip: %s", username, request.RemoteAddr)
 				http.Error(writer, "WebDAV: need authorized!", http.StatusUnauthorized)
 				return
 			}
-		}
 
-		writeCORSHeader(writer)
+			if !allowRequest(writer, store.Load(), username, username) {
+				return
+			}
+		} else if !allowRequest(writer, store.Load(), "", clientIP(request)) {
+			return
+		}
 
 		if requestSuffersFinderProblem(request) {
 			err := handleFinderRequest(writer, request)
@@ -112,21 +227,468 @@ func main() {
 
 		ctx := context.WithValue(request.Context(), aliWebdav.CtxSizeValue, request.ContentLength)
 
+		if username, _, ok := request.BasicAuth(); ok {
+			ctx = context.WithValue(ctx, aliWebdav.CtxUserValue, username)
+		}
+
 		ctxRequest := request.WithContext(ctx)
 
+		if shares.MatchAdmin(ctxRequest) {
+			shares.ServeAdmin(writer, ctxRequest)
+			return
+		}
+
+		if events != nil && events.Match(ctxRequest) {
+			events.ServeHTTP(writer, ctxRequest)
+			return
+		}
+
+		if !checkCredential(writer, ctxRequest) {
+			return
+		}
+
+		if oc.Match(ctxRequest) {
+			oc.ServeHTTP(writer, ctxRequest)
+			return
+		}
+
 		h.ServeHTTP(writer, ctxRequest)
-	})
+	}
+
+	// logWriters 退出时关闭的日志文件
+	var logWriters []io.Closer
+
+	rotate := &logging.RotateOptions{
+		MaxSize:    internal.Config.LogMaxSize,
+		MaxBackups: internal.Config.LogMaxBackups,
+	}
+
+	if internal.Config.AuditLog != "" {
+		source, ok := fileSystem.(aliWebdav.EventSource)
+		if ok {
+			logrus.Infof("audit log: %s", internal.Config.AuditLog)
+			w := logging.NewWriter(internal.Config.AuditLog, rotate)
+			logWriters = append(logWriters, w)
+			source.Subscribe(logging.NewAuditLog(w).Listen)
+		}
+	}
+
+	var hooks *webhook.Dispatcher
+	if len(internal.Config.Webhooks) > 0 {
+		source, ok := fileSystem.(aliWebdav.EventSource)
+		if ok {
+			hooks, err = webhook.New(&webhook.Options{
+				URLs:           internal.Config.Webhooks,
+				Secret:         internal.Config.WebhookSecret,
+				Events:         internal.Config.WebhookEvents,
+				MaxAttempts:    internal.Config.WebhookMaxAttempts,
+				DeadLetterFile: filepath.Join(internal.Config.WorkDir, defaultWebhookDeadLetterFile),
+			})
+			if err != nil {
+				p.Fail(err.Error())
+			}
+
+			logrus.Infof("webhooks: %s", strings.Join(internal.Config.Webhooks, ", "))
+			source.Subscribe(hooks.Listen)
+		}
+	}
+
+	var handler http.Handler = metrics.Middleware(http.HandlerFunc(serveWebDAV))
+	if internal.Config.AccessLog != "" {
+		logrus.Infof("access log: %s", internal.Config.AccessLog)
+		w := logging.NewWriter(internal.Config.AccessLog, rotate)
+		logWriters = append(logWriters, w)
+		handler = logging.NewAccessLog(w).Middleware(handler)
+	}
+
+	mux.Handle("/", handler)
+
+	var adminServer *http.Server
+	if internal.Config.AdminAddr != "" {
+		adminServer = serveAdmin(internal.Config.AdminAddr, fileSystem, hooks)
+	}
 
 	hosted := fmt.Sprintf("%s:%d", internal.Config.Host, internal.Config.Port)
 
-	logrus.Infof("webdav server started at %s", hosted)
-	log.Fatal(http.ListenAndServe(hosted, nil))
+	server := &http.Server{Addr: hosted, Handler: mux}
+	if events != nil {
+		server.RegisterOnShutdown(events.Close)
+	}
+
+	go func() {
+		logrus.Infof("webdav server started at %s", hosted)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	timeout := time.Duration(internal.Config.ShutdownTimeout) * time.Second
+	logrus.Infof("received %s, shutting down, timeout %s", <-quit, timeout)
+
+	// 再次收到信号时立即退出
+	go func() {
+		logrus.Warnf("received %s, exit immediately", <-quit)
+		os.Exit(1)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// 停止接收新连接，等待进行中的请求结束
+	if err := server.Shutdown(ctx); err != nil {
+		logrus.Warnf("wait for active requests error %s", err)
+	}
+
+	// 请求结束后上传和秒传仍在后台进行
+	if d, ok := fileSystem.(aliWebdav.Drainer); ok {
+		if err := d.Drain(ctx); err != nil {
+			logrus.Warnf("wait for uploads error %s", err)
+		}
+	}
+	aliWebdav.CleanRapidCache()
+
+	if hooks != nil {
+		hooks.Close(ctx)
+	}
+
+	for _, flush := range tokenFlushers {
+		flush()
+	}
+
+	if adminServer != nil {
+		_ = adminServer.Close()
+	}
+
+	for _, w := range logWriters {
+		_ = w.Close()
+	}
+
+	logrus.Infof("webdav server stopped")
+}
+
+// serveAdmin 在单独的地址上提供健康检查、监控指标和 pprof
+func serveAdmin(addr string, fileSystem webdav.FileSystem, hooks *webhook.Dispatcher) *http.Server {
+	adminServer := admin.New(&admin.Options{
+		Addr:     addr,
+		Username: internal.Config.AdminUsername,
+		Password: internal.Config.AdminPassword,
+		Pprof:    internal.Config.Pprof,
+		Ready: func() error {
+			if c, ok := fileSystem.(aliWebdav.ReadyChecker); ok {
+				return c.Ready()
+			}
+			return nil
+		},
+	})
+
+	if internal.Config.MetricsPath != "" {
+		logrus.Infof("metrics path: %s", internal.Config.MetricsPath)
+		metrics.RegisterCredentials(credentials.Metrics)
+		adminServer.Handle(internal.Config.MetricsPath, metrics.Handler())
+	}
+
+	if hooks != nil {
+		adminServer.HandleProtected("/webhooks/dead-letters", http.HandlerFunc(hooks.ServeDeadLetters))
+	}
+
+	if internal.Config.Backend == backendAliyun {
+		adminServer.HandleProtected("/credentials", credentials)
+
+		login := &qrlogin.Handler{
+			Client: qrlogin.NewClient(),
+			Prefix: "/login",
+			Save:   credentials.Replace,
+		}
+		for _, spec := range internal.Config.Mounts {
+			name, _, _ := parseMountSpec(spec)
+			login.Targets = append(login.Targets, name)
+		}
+
+		if adminServer.HandleProtected("/login", login) {
+			adminServer.HandleProtected("/login/", login)
+		}
+	}
+
+	server := &http.Server{Addr: addr, Handler: adminServer}
+
+	go func() {
+		logrus.Infof("admin server started at %s, pprof: %v", addr, internal.Config.Pprof)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	return server
+}
+
+// newDriveFS 登录阿里云盘并创建文件系统，RefreshToken 刷新结果保存到 refreshTokenFile(mount)
+// driveType 为 resource 时使用资源库，否则使用默认的备份盘
+// 登录失败时仍然创建文件系统，请求返回 503，直到通过管理端口更换 RefreshToken
+func newDriveFS(mount, refreshToken, driveType, workDir string) (webdav.FileSystem, error) {
+	// 不使用 AutoRefresh，由 refreshTokenLoop 定时刷新并记录刷新失败
+	drive := aliyundrive.NewClient(&aliyundrive.Options{
+		UploadRate: internal.Config.UploadSpeed * 1024 * 1024,
+	})
+
+	tokenFile := refreshTokenFile(mount)
+	store := tokenstore.New(tokenFile, tokenKey)
+
+	rtFromFile := strings.TrimSpace(refreshToken)
+
+	token, err := store.Load()
+	switch {
+	case err == nil:
+		rtFromFile = token
+	case os.IsNotExist(err):
+	case err == tokenstore.ErrInvalidToken:
+		logrus.Warnf("ignore invalid refresh token cache file %s", tokenFile)
+	default:
+		return nil, fmt.Errorf("read refresh token cache file %s error, %s", tokenFile, err)
+	}
+
+	var resourceDriveId string
+
+	cred := aliyundrive.NewCredential(&aliyundrive.Credential{
+		RefreshToken: rtFromFile,
+	}).RegisterChangeEvent(func(credential *aliyundrive.Credential) {
+		// 网络错误时 aliyundrive 也会触发回调，此时 Token 已被清空，由 api.Token 恢复
+		if credential.AccessToken == "" || credential.RefreshToken == "" {
+			return
+		}
+
+		logrus.Infof("backend aliyundrive user[%s@%s] is launched! credential loaded!", credential.Name, credential.UserId)
+
+		// 刷新 Token 会把 DefaultDriveId 重置为备份盘
+		if driveType == driveResource {
+			if resourceDriveId == "" {
+				info, err := api.GetUserInfo(drive, credential)
+				if err != nil {
+					logrus.Warnf("get resource drive of user[%s] error, %s", credential.Name, err)
+				} else {
+					resourceDriveId = info.ResourceDriveId
+				}
+			}
+
+			if resourceDriveId != "" {
+				credential.DefaultDriveId = resourceDriveId
+			}
+		}
+
+		saveRefreshToken(store, credential.RefreshToken)
+
+		if driveType == driveResource && resourceDriveId == "" {
+			credentials.Failure(mount, fmt.Errorf("resource drive of user[%s] not found", credential.Name))
+		} else {
+			credentials.Success(mount, credential.Name)
+		}
+	})
+
+	// 定时刷新、更换 RefreshToken 和请求发现 AccessToken 失效时的刷新都通过 auth，共用同一把锁
+	auth := api.NewToken(drive, cred)
+
+	credentials.Register(mount, func(refreshToken string) error {
+		refreshToken, err := tokenstore.Validate(refreshToken)
+		if err != nil {
+			return err
+		}
+
+		if err = auth.Refresh(refreshToken); err != nil {
+			return err
+		}
+
+		logrus.Infof("refresh token of %s replaced", mountLabel(mount))
+		return nil
+	})
+
+	delay := tokenRefreshInterval
+	if err = auth.Refresh(""); err != nil {
+		logrus.Errorf("login %s error, requests will fail until a new refresh token is supplied, %s", mountLabel(mount), err)
+		credentials.Failure(mount, err)
+		delay = tokenRetryInterval
+	} else if driveType == driveResource && resourceDriveId == "" {
+		return nil, fmt.Errorf("resource drive of user[%s] not found", cred.Name)
+	}
+
+	go refreshTokenLoop(mount, func() error { return auth.Refresh("") }, delay)
+
+	tokenFlushers = append(tokenFlushers, func() {
+		auth.Flush(func(refreshToken string) {
+			saveRefreshToken(store, refreshToken)
+		})
+	})
+
+	return aliWebdav.NewAliDriveFS(backend.NewAliyun(drive, auth), newFSOptions(workDir)), nil
+}
+
+// saveRefreshToken 保存 RefreshToken 刷新结果，下次启动时优先使用
+func saveRefreshToken(store *tokenstore.Store, token string) {
+	if err := store.Save(token); err != nil {
+		logrus.Warnf("write refresh token cache file error, %s", err)
+	}
+}
+
+// refreshTokenLoop 定时刷新 RefreshToken，失败后从 tokenRetryInterval 开始加倍重试，
+// 成功时由 RegisterChangeEvent 的回调记录
+func refreshTokenLoop(mount string, refresh func() error, delay time.Duration) {
+	retry := tokenRetryInterval
+
+	for {
+		time.Sleep(delay)
+
+		if err := refresh(); err != nil {
+			logrus.Errorf("refresh token of %s error, %s", mountLabel(mount), err)
+			credentials.Failure(mount, err)
+
+			if retry *= 2; retry > tokenRefreshInterval {
+				retry = tokenRefreshInterval
+			}
+			delay = retry
+			continue
+		}
+
+		delay, retry = tokenRefreshInterval, tokenRetryInterval
+	}
+}
+
+// mountLabel 日志中的网盘名称
+func mountLabel(mount string) string {
+	if mount == "" {
+		return "default drive"
+	}
+
+	return "mount " + mount
+}
+
+func newFSOptions(workDir string) *aliWebdav.Options {
+	return &aliWebdav.Options{
+		RapidUpload: internal.Config.RapidUpload,
+		WorkDir:     workDir,
+		HardDelete:  internal.Config.HardDelete,
+	}
+}
+
+// newMountFS 按 名称[:backup|resource]=RefreshToken 挂载多个网盘，
+// 每个挂载点使用单独的客户端、RefreshToken 文件和元信息目录
+func newMountFS(specs []string) (webdav.FileSystem, error) {
+	var mounts []*aliWebdav.Mount
+
+	for _, spec := range specs {
+		name, driveType, refreshToken := parseMountSpec(spec)
+
+		if driveType != driveBackup && driveType != driveResource {
+			return nil, fmt.Errorf("mount %s: unknown drive type %s", name, driveType)
+		}
+
+		if err := aliWebdav.ValidMountName(name); err != nil {
+			return nil, err
+		}
+
+		workDir := filepath.Join(internal.Config.WorkDir, defaultMountsDir, name)
+		if err := os.MkdirAll(workDir, 0755); err != nil {
+			return nil, err
+		}
+
+		logrus.Infof("mounting %s drive at /%s", driveType, name)
+
+		fileSystem, err := newDriveFS(name, refreshToken, driveType, workDir)
+		if err != nil {
+			return nil, fmt.Errorf("mount %s: %s", name, err)
+		}
+
+		mounts = append(mounts, &aliWebdav.Mount{
+			Name:       name,
+			FileSystem: fileSystem,
+		})
+	}
+
+	return aliWebdav.NewMountFS(mounts)
+}
+
+// checkCredential 请求路径或 Destination 所在网盘的凭证失效时返回 503，避免把后端错误返回给客户端
+func checkCredential(w http.ResponseWriter, r *http.Request) bool {
+	paths := []string{aliWebdav.DrivePath(r.URL.Path)}
+	if u, err := url.Parse(r.Header.Get("Destination")); err == nil && u.Path != "" {
+		paths = append(paths, aliWebdav.DrivePath(u.Path))
+	}
+
+	for _, p := range paths {
+		if err := credentials.Check(p); err != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(tokenRetryInterval.Seconds())))
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseMountSpec 拆分 名称[:backup|resource]=RefreshToken
+func parseMountSpec(spec string) (name, driveType, refreshToken string) {
+	name, driveType = spec, driveBackup
+	if i := strings.Index(spec, "="); i >= 0 {
+		name, refreshToken = spec[:i], spec[i+1:]
+	}
+
+	if i := strings.Index(name, ":"); i >= 0 {
+		name, driveType = name[:i], name[i+1:]
+	}
+
+	return name, driveType, refreshToken
 }
 
-func writeCORSHeader(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE,UPDATE")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
+// refreshTokenFile 网盘的 RefreshToken 缓存文件，mount 为空时是默认网盘
+func refreshTokenFile(mount string) string {
+	if mount == "" {
+		return filepath.Join(internal.Config.WorkDir, defaultRefreshTokenFile)
+	}
+
+	return filepath.Join(internal.Config.WorkDir, defaultRefreshTokenFile+"_"+mount)
+}
+
+// runLogin 在终端显示二维码，扫码登录后保存 RefreshToken，下次启动时优先使用
+func runLogin(mount string) error {
+	if mount != "" {
+		if err := aliWebdav.ValidMountName(mount); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	client := qrlogin.NewClient()
+
+	session, err := client.Generate(ctx)
+	if err != nil {
+		return err
+	}
+
+	qr, err := qrlogin.Terminal(session.Content)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("使用阿里云盘 App 扫描二维码登录：")
+	fmt.Println(qr)
+
+	token, err := client.Wait(ctx, session, func(state qrlogin.State) {
+		fmt.Println(state.String())
+	})
+	if err != nil {
+		return err
+	}
+
+	file := refreshTokenFile(mount)
+	if err = tokenstore.New(file, tokenKey).Save(token); err != nil {
+		return err
+	}
+
+	fmt.Printf("RefreshToken 已保存到 %s，重启后生效\n", file)
+
+	return nil
 }
 
 func requestSuffersFinderProblem(r *http.Request) bool {
@@ -147,3 +709,77 @@ func handleFinderRequest(w http.ResponseWriter, r *http.Request) error {
 	r.ContentLength = expectedInt
 	return nil
 }
+
+// configReloadInterval 检查配置文件是否修改的间隔
+const configReloadInterval = 2 * time.Second
+
+// watchConfig 配置文件修改或收到 SIGHUP 时重新加载用户、限流和路径规则，加载失败时继续使用原配置
+func watchConfig(path string, store *access.Store) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	// 比较修改时间和大小，编辑器保留修改时间时也能发现修改
+	stat := func() (time.Time, int64) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, 0
+		}
+		return fi.ModTime(), fi.Size()
+	}
+
+	lastTime, lastSize := stat()
+	ticker := time.NewTicker(configReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+			logrus.Infof("received SIGHUP, reload config %s", path)
+		case <-ticker.C:
+			t, size := stat()
+			if t.IsZero() || (t.Equal(lastTime) && size == lastSize) {
+				continue
+			}
+			logrus.Infof("config %s changed, reload", path)
+		}
+
+		lastTime, lastSize = stat()
+
+		c, err := internal.LoadFile(path, os.Args[1:])
+		if err != nil {
+			logrus.Errorf("reload config error %s", err)
+			continue
+		}
+
+		policy, err := c.Policy()
+		if err != nil {
+			logrus.Errorf("reload config error %s", err)
+			continue
+		}
+
+		store.Store(policy)
+		logrus.Infof("config reloaded, users: %d, rules: %d", len(c.Users), len(c.Rules))
+	}
+}
+
+// allowRequest 超过请求速率时返回 429
+func allowRequest(w http.ResponseWriter, policy *access.Policy, user, key string) bool {
+	if policy.Allow(user, key) {
+		return true
+	}
+
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "WebDAV: too many requests", http.StatusTooManyRequests)
+
+	return false
+}
+
+// clientIP 返回连接的对端地址
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}